@@ -0,0 +1,120 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"push_service/auth"
+	"push_service/models"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CreateAPIKeyHandler godoc
+// @Summary      Creates an API key
+// @Description  Creates an API key with the given scopes. The secret is only returned once.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        request  body      models.CreateAPIKeyRequest  true  "API key settings"
+// @Success      201      {object}  map[string]any     "key: auth.APIKey, secret: string"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      500      {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/keys [post]
+func CreateAPIKeyHandler(store *auth.KeyStore, ctx *gin.Context) {
+	var req models.CreateAPIKeyRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+
+	scopes := make([]auth.Scope, 0, len(req.Scopes))
+	for _, scope := range req.Scopes {
+		switch s := auth.Scope(scope); s {
+		case auth.ScopeSend, auth.ScopeRead, auth.ScopeStream, auth.ScopeAdmin:
+			scopes = append(scopes, s)
+		default:
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("unknown scope %q", scope)))
+			return
+		}
+	}
+
+	key, secret, err := store.Create(req.Name, scopes, req.RateLimit, req.Burst)
+	if err != nil {
+		log.Printf("Failed to create api key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	ctx.JSON(http.StatusCreated, gin.H{"key": key, "secret": secret})
+}
+
+// ListAPIKeysHandler godoc
+// @Summary      Lists API keys
+// @Description  Lists every API key, including revoked ones. Secrets are never returned.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}  auth.APIKey  "API keys"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/keys [get]
+func ListAPIKeysHandler(store *auth.KeyStore, ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, store.List())
+}
+
+// RotateAPIKeyHandler godoc
+// @Summary      Rotates an API key
+// @Description  Issues a new secret for the key. The old secret stops working immediately.
+// @Tags         admin
+// @Produce      json
+// @Param        id   path      string  true  "API key ID"
+// @Success      200  {object}  map[string]any     "key: auth.APIKey, secret: string"
+// @Failure      404  {object}  map[string]string  "error: api key not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/keys/{id}/rotate [post]
+func RotateAPIKeyHandler(store *auth.KeyStore, ctx *gin.Context) {
+	key, secret, err := store.Rotate(ctx.Param("id"))
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate api key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"key": key, "secret": secret})
+}
+
+// RevokeAPIKeyHandler godoc
+// @Summary      Revokes an API key
+// @Description  Revokes the key. Requests using it are rejected from now on.
+// @Tags         admin
+// @Param        id   path      string  true  "API key ID"
+// @Success      204
+// @Failure      404  {object}  map[string]string  "error: api key not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/keys/{id} [delete]
+func RevokeAPIKeyHandler(store *auth.KeyStore, ctx *gin.Context) {
+	err := store.Revoke(ctx.Param("id"))
+	if errors.Is(err, auth.ErrKeyNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to revoke api key: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,345 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"push_service/broker"
+	"push_service/models"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	DefaultBatchMaxItems = 1000
+	batchRetention       = time.Hour
+	batchPublishTimeout  = 5 * time.Minute
+
+	BatchPending    = "pending"
+	BatchQueued     = "queued"
+	BatchRejected   = "rejected"
+	BatchFailed     = "failed"
+	BatchProcessing = "processing"
+	BatchCompleted  = "completed"
+)
+
+var errBatchTooLarge = errors.New("batch exceeds the maximum number of items")
+
+// BatchStore keeps the progress of batch requests in memory so async batches
+// can be polled. Finished batches are forgotten after an hour. Batches are
+// not shared between instances, so only the instance that accepted a batch
+// can report on it.
+type BatchStore struct {
+	mu       sync.Mutex
+	batches  map[string]*models.BatchStatus
+	MaxItems int
+}
+
+// NewBatchStore reads the per-batch item limit from BATCH_MAX_ITEMS.
+func NewBatchStore() *BatchStore {
+	maxItems := DefaultBatchMaxItems
+	if raw := os.Getenv("BATCH_MAX_ITEMS"); raw != "" {
+		if value, err := strconv.Atoi(raw); err == nil && value > 0 {
+			maxItems = value
+		} else {
+			log.Printf("Ignoring invalid BATCH_MAX_ITEMS=%q", raw)
+		}
+	}
+	return &BatchStore{batches: make(map[string]*models.BatchStatus), MaxItems: maxItems}
+}
+
+func (s *BatchStore) create(total int, caller string) *models.BatchStatus {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for id, batch := range s.batches {
+		if batch.Status == BatchCompleted && now.Sub(batch.UpdatedAt) > batchRetention {
+			delete(s.batches, id)
+		}
+	}
+
+	batch := &models.BatchStatus{
+		ID:        uuid.NewString(),
+		Status:    BatchProcessing,
+		Total:     total,
+		Results:   make([]models.BatchItemResult, total),
+		Caller:    caller,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	for i := range batch.Results {
+		batch.Results[i] = models.BatchItemResult{Index: i, Status: BatchPending}
+	}
+	s.batches[batch.ID] = batch
+	return batch
+}
+
+func (s *BatchStore) setResult(id string, result models.BatchItemResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return
+	}
+
+	batch.Results[result.Index] = result
+	switch result.Status {
+	case BatchQueued:
+		batch.Queued++
+	case BatchRejected:
+		batch.Rejected++
+	case BatchFailed:
+		batch.Failed++
+	}
+	batch.UpdatedAt = time.Now()
+}
+
+// setTenants records the tenants the accepted requests were sent for.
+func (s *BatchStore) setTenants(id string, requests []*models.NotifMessageRequest) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return
+	}
+	for _, req := range requests {
+		if req != nil && req.Tenant != "" && !slices.Contains(batch.Tenants, req.Tenant) {
+			batch.Tenants = append(batch.Tenants, req.Tenant)
+		}
+	}
+}
+
+func (s *BatchStore) complete(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if batch, ok := s.batches[id]; ok {
+		batch.Status = BatchCompleted
+		batch.UpdatedAt = time.Now()
+	}
+}
+
+// Get returns a snapshot of the batch.
+func (s *BatchStore) Get(id string) (models.BatchStatus, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	batch, ok := s.batches[id]
+	if !ok {
+		return models.BatchStatus{}, false
+	}
+	snapshot := *batch
+	snapshot.Results = append([]models.BatchItemResult(nil), batch.Results...)
+	snapshot.Tenants = slices.Clone(batch.Tenants)
+	return snapshot, true
+}
+
+// BatchNotificationHandler godoc
+// @Summary      Queues a batch of push notifications
+// @Description  Accepts a JSON array or an NDJSON stream (Content-Type application/x-ndjson) of notification requests.
+// @Description  Each item is validated and published on its own; the response reports a request ID or an error per item.
+// @Description  With async=true the batch is published in the background and its progress can be polled.
+// @Tags         notifications
+// @Accept       json
+// @Accept       application/x-ndjson
+// @Produce      json
+// @Param        request  body      []models.NotifMessageRequest  true   "Notification requests"
+// @Param        async    query     bool                          false  "Publish in the background and return a batch ID"
+// @Success      200      {object}  models.BatchStatus  "Per-item results"
+// @Success      202      {object}  map[string]string   "batch_id: string, status_url: string"
+// @Failure      400      {object}  map[string]string   "error: malformed batch"
+// @Failure      413      {object}  map[string]string   "error: batch exceeds the maximum number of items"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /notifications/batch [post]
+func BatchNotificationHandler(p *models.Publisher, store *BatchStore, ctx *gin.Context) {
+	items, err := decodeBatch(ctx.Request, store.MaxItems)
+	if errors.Is(err, errBatchTooLarge) {
+		ctx.JSON(http.StatusRequestEntityTooLarge, util.ErrorResponse(fmt.Errorf("%w (%d)", err, store.MaxItems)))
+		return
+	}
+	if err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+	if len(items) == 0 {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("batch is empty")))
+		return
+	}
+
+	batch := store.create(len(items), callerOf(ctx))
+	requests := make([]*models.NotifMessageRequest, len(items))
+	for i, raw := range items {
+		var req models.NotifMessageRequest
+		if err := json.Unmarshal(raw, &req); err != nil {
+			store.setResult(batch.ID, models.BatchItemResult{Index: i, Status: BatchRejected, Error: ValidationFailed})
+			continue
+		}
+		if _, err := prepareNotification(ctx, p, &req); err != nil {
+			store.setResult(batch.ID, models.BatchItemResult{Index: i, Status: BatchRejected, Error: err.Error()})
+			continue
+		}
+		requests[i] = &req
+	}
+	store.setTenants(batch.ID, requests)
+
+	if async, _ := strconv.ParseBool(ctx.Query("async")); async {
+		go func() {
+			publishCtx, cancel := context.WithTimeout(context.Background(), batchPublishTimeout)
+			defer cancel()
+			publishBatch(publishCtx, p, store, batch.ID, requests)
+		}()
+		ctx.JSON(http.StatusAccepted, gin.H{"batch_id": batch.ID, "status_url": "/notifications/batch/" + batch.ID})
+		return
+	}
+
+	publishBatch(ctx.Request.Context(), p, store, batch.ID, requests)
+	status, _ := store.Get(batch.ID)
+	ctx.JSON(http.StatusOK, status)
+}
+
+// BatchStatusHandler godoc
+// @Summary      Gets the progress of a batch
+// @Description  Returns per-item results of a batch created with POST /notifications/batch
+// @Description  Batches are kept in memory by the instance that accepted them, so poll the same instance.
+// @Tags         notifications
+// @Produce      json
+// @Param        id   path      string  true  "Batch ID"
+// @Success      200  {object}  models.BatchStatus  "Batch progress"
+// @Failure      404  {object}  map[string]string   "error: batch not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /notifications/batch/{id} [get]
+func BatchStatusHandler(store *BatchStore, ctx *gin.Context) {
+	status, ok := store.Get(ctx.Param("id"))
+	if !ok || !mayRead(ctx, status.Caller, status.Tenants...) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(errors.New("batch not found")))
+		return
+	}
+	ctx.JSON(http.StatusOK, status)
+}
+
+// publishBatch publishes every accepted request before waiting for any
+// confirm, so the broker can acknowledge them in bulk. Nil entries were
+// rejected during validation.
+func publishBatch(ctx context.Context, p *models.Publisher, store *BatchStore, id string, requests []*models.NotifMessageRequest) {
+	defer store.complete(id)
+
+	confirmations := make([]broker.Confirmation, len(requests))
+	published := make([]bool, len(requests))
+	for i, req := range requests {
+		if req == nil {
+			continue
+		}
+		confirmation, err := publishNotification(ctx, p, *req)
+		if err != nil {
+			log.Printf("Failed to publish batch item %d: %v", i, err)
+			store.setResult(id, models.BatchItemResult{Index: i, RequestID: req.ID, Status: BatchFailed, Error: "failed to queue notification"})
+			continue
+		}
+		confirmations[i] = confirmation
+		published[i] = true
+	}
+
+	for i, req := range requests {
+		if !published[i] {
+			continue
+		}
+		if err := waitForConfirm(ctx, confirmations[i]); err != nil {
+			log.Printf("Batch item %d was not confirmed: %v", i, err)
+			markPublishFailed(p, req.ID, err)
+			store.setResult(id, models.BatchItemResult{Index: i, RequestID: req.ID, Status: BatchFailed, Error: err.Error()})
+			continue
+		}
+		store.setResult(id, models.BatchItemResult{Index: i, RequestID: req.ID, Status: BatchQueued})
+	}
+	log.Printf("Published batch %s", id)
+}
+
+// decodeBatch splits the body into raw items without decoding them, so one
+// malformed item is reported on its own instead of failing the whole batch.
+func decodeBatch(r *http.Request, maxItems int) ([]json.RawMessage, error) {
+	reader := bufio.NewReader(r.Body)
+	isNDJSON := strings.HasPrefix(r.Header.Get("Content-Type"), "application/x-ndjson")
+
+	if !isNDJSON {
+		first, err := peekNonSpace(reader)
+		if err != nil {
+			return nil, err
+		}
+		isNDJSON = first != '['
+	}
+
+	var items []json.RawMessage
+	if isNDJSON {
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			line := bytes.TrimSpace(scanner.Bytes())
+			if len(line) == 0 {
+				continue
+			}
+			if len(items) == maxItems {
+				return nil, errBatchTooLarge
+			}
+			items = append(items, json.RawMessage(append([]byte(nil), line...)))
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("could not read NDJSON body: %w", err)
+		}
+		return items, nil
+	}
+
+	decoder := json.NewDecoder(reader)
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("malformed JSON array: %w", err)
+	}
+	for decoder.More() {
+		if len(items) == maxItems {
+			return nil, errBatchTooLarge
+		}
+		var item json.RawMessage
+		if err := decoder.Decode(&item); err != nil {
+			return nil, fmt.Errorf("malformed JSON array: %w", err)
+		}
+		items = append(items, item)
+	}
+	if _, err := decoder.Token(); err != nil {
+		return nil, fmt.Errorf("malformed JSON array: %w", err)
+	}
+	return items, nil
+}
+
+func peekNonSpace(reader *bufio.Reader) (byte, error) {
+	for {
+		b, err := reader.ReadByte()
+		if errors.Is(err, io.EOF) {
+			return 0, errors.New("batch is empty")
+		}
+		if err != nil {
+			return 0, fmt.Errorf("could not read body: %w", err)
+		}
+		if !bytes.ContainsRune([]byte(" \t\r\n"), rune(b)) {
+			return b, reader.UnreadByte()
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"push_service/devices"
+	"push_service/models"
+	"push_service/util"
+	"push_service/webpush"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisterDeviceHandler godoc
+// @Summary      Registers a device
+// @Description  Stores a browser's Web Push subscription for the user, as returned by PushSubscription.toJSON().
+// @Description  Subscribing again with the same endpoint updates the existing registration.
+// @Description  Endpoints must be https URLs on public addresses, or on a host in WEBPUSH_ALLOWED_HOSTS.
+// @Description  An endpoint registered to another user is refused; that user must delete it first.
+// @Description  Users authenticated with a JWT may only use their own devices, on this and the other device routes.
+// @Tags         devices
+// @Accept       json
+// @Produce      json
+// @Param        id       path      string                true  "User ID"
+// @Param        request  body      models.DeviceRequest  true  "Subscription"
+// @Success      200      {object}  devices.Device     "Updated registration"
+// @Success      201      {object}  devices.Device     "New registration"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      403      {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      409      {object}  map[string]string  "error: device registered to another user"
+// @Failure      500      {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/devices [post]
+func RegisterDeviceHandler(store *devices.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	var req models.DeviceRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+	if err := validateDevice(store, req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	device, created, err := store.Register(devices.Device{
+		UserID:    userID,
+		Platform:  devices.Platform(req.Platform),
+		Endpoint:  req.Endpoint,
+		Keys:      devices.Keys{P256dh: req.Keys.P256dh, Auth: req.Keys.Auth},
+		UserAgent: req.UserAgent,
+	})
+	if errors.Is(err, devices.ErrDeviceTaken) {
+		ctx.JSON(http.StatusConflict, util.ErrorResponse(err))
+		return
+	}
+	if errors.Is(err, devices.ErrEndpointNotAllowed) {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to register device: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	if created {
+		ctx.JSON(http.StatusCreated, device)
+		return
+	}
+	ctx.JSON(http.StatusOK, device)
+}
+
+func validateDevice(store *devices.Store, req models.DeviceRequest) error {
+	if devices.Platform(req.Platform) != devices.PlatformWebPush {
+		return fmt.Errorf("unknown platform %q", req.Platform)
+	}
+	if err := store.CheckEndpoint(req.Endpoint); err != nil {
+		return err
+	}
+	// Encrypting an empty message checks both keys the way a push will.
+	if _, err := webpush.Encrypt(nil, req.Keys.P256dh, req.Keys.Auth); err != nil {
+		return err
+	}
+	return nil
+}
+
+// ListDevicesHandler godoc
+// @Summary      Lists a user's devices
+// @Tags         devices
+// @Produce      json
+// @Param        id        path      string  true   "User ID"
+// @Param        platform  query     string  false  "Only devices on this platform"  Enums(webpush)
+// @Success      200       {array}   devices.Device  "Devices"
+// @Failure      403       {object}  map[string]string  "error: cannot access another user's notifications"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/devices [get]
+func ListDevicesHandler(store *devices.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	ctx.JSON(http.StatusOK, store.List(userID, devices.Platform(ctx.Query("platform"))))
+}
+
+// DeleteDeviceHandler godoc
+// @Summary      Unregisters a device
+// @Description  The device receives no further notifications, for example after the browser unsubscribed.
+// @Tags         devices
+// @Param        id      path  string  true  "User ID"
+// @Param        device  path  string  true  "Device ID"
+// @Success      204
+// @Failure      403  {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      404  {object}  map[string]string  "error: device not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/devices/{device} [delete]
+func DeleteDeviceHandler(store *devices.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	err := store.Delete(userID, ctx.Param("device"))
+	if errors.Is(err, devices.ErrDeviceNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete device: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// VAPIDKeyHandler godoc
+// @Summary      Gets the VAPID public key
+// @Description  Browsers pass it to pushManager.subscribe() as the applicationServerKey.
+// @Tags         devices
+// @Produce      json
+// @Success      200  {object}  map[string]string  "public_key: base64url encoded P-256 key"
+// @Router       /webpush/vapid-public-key [get]
+func VAPIDKeyHandler(vapid *webpush.VAPID, ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"public_key": vapid.PublicKey()})
+}
@@ -0,0 +1,75 @@
+package api
+
+import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"push_service/auth"
+	"push_service/devices"
+
+	"github.com/gin-gonic/gin"
+)
+
+func subscriptionBody(t *testing.T, endpoint string) string {
+	t.Helper()
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := make([]byte, 16)
+	rand.Read(secret)
+	return fmt.Sprintf(`{"platform":"webpush","endpoint":%q,"keys":{"p256dh":%q,"auth":%q}}`, endpoint,
+		base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()), base64.RawURLEncoding.EncodeToString(secret))
+}
+
+func TestDeviceHandlersLimitJWTCallersToTheirOwnDevices(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, _ := devices.NewStore("")
+	bob, _, err := store.Register(devices.Device{UserID: "bob", Platform: devices.PlatformWebPush, Endpoint: "https://push.example.com/bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		method    string
+		path      string
+		body      string
+		want      int
+	}{
+		{"list own", jwtUser("alice", auth.ScopeStream), http.MethodGet, "/users/alice/devices", "", http.StatusOK},
+		{"list other", jwtUser("alice", auth.ScopeStream), http.MethodGet, "/users/bob/devices", "", http.StatusForbidden},
+		{"register for other", jwtUser("alice", auth.ScopeStream), http.MethodPost, "/users/bob/devices", subscriptionBody(t, "https://push.example.com/new"), http.StatusForbidden},
+		{"take over endpoint", jwtUser("alice", auth.ScopeStream), http.MethodPost, "/users/alice/devices", subscriptionBody(t, bob.Endpoint), http.StatusConflict},
+		{"internal endpoint", jwtUser("alice", auth.ScopeStream), http.MethodPost, "/users/alice/devices", subscriptionBody(t, "http://10.0.0.1/admin"), http.StatusBadRequest},
+		{"delete other", jwtUser("alice", auth.ScopeStream), http.MethodDelete, "/users/bob/devices/" + bob.ID, "", http.StatusForbidden},
+		{"register own", jwtUser("alice", auth.ScopeStream), http.MethodPost, "/users/alice/devices", subscriptionBody(t, "https://push.example.com/alice"), http.StatusCreated},
+		{"api key", &auth.Principal{Subject: "key", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeRead}}, http.MethodGet, "/users/bob/devices", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) { ctx.Set(auth.PrincipalContext, tt.principal) })
+			router.POST("/users/:id/devices", func(ctx *gin.Context) { RegisterDeviceHandler(store, ctx) })
+			router.GET("/users/:id/devices", func(ctx *gin.Context) { ListDevicesHandler(store, ctx) })
+			router.DELETE("/users/:id/devices/:device", func(ctx *gin.Context) { DeleteDeviceHandler(store, ctx) })
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body)))
+			if recorder.Code != tt.want {
+				t.Fatalf("%s %s responded %d, want %d: %s", tt.method, tt.path, recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+
+	if owned := store.List("bob", ""); len(owned) != 1 || owned[0].ID != bob.ID {
+		t.Fatalf("bob's devices after the forbidden requests: %+v", owned)
+	}
+}
@@ -9,12 +9,15 @@ import (
 
 // HealthHandler godoc
 // @Summary      Get service metrics
-// @Description  Gets message stats for the service
+// @Description  Gets message stats for the service and the circuit breaker state of the user and template services
 // @Tags         health
 // @Accept       json
 // @Produce      json
-// @Success      200  {object}  models.ConsumerMetrics  "Service metrics"
+// @Success      200  {object}  models.HealthResponse  "Service metrics"
 // @Router       /health [get] // Assuming this is your health check path
 func HealthHandler(c *models.Consumer, ctx *gin.Context) {
-	ctx.JSON(http.StatusOK, c.ConsumerMetrics)
+	ctx.JSON(http.StatusOK, models.HealthResponse{
+		ConsumerMetrics: c.Metrics.Snapshot(),
+		Upstreams:       c.Upstream.Health(),
+	})
 }
@@ -0,0 +1,181 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"push_service/inbox"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListInboxHandler godoc
+// @Summary      Lists a user's inbox
+// @Description  Returns the notifications delivered to the user, newest first, with the unread count.
+// @Description  Pass the returned next value as before to fetch the following page.
+// @Description  Users authenticated with a JWT may only use their own inbox, on this and the other inbox routes.
+// @Tags         inbox
+// @Produce      json
+// @Param        id      path      string  true   "User ID"
+// @Param        unread  query     bool    false  "Only unread notifications"
+// @Param        limit   query     int     false  "Page size, 20 by default and at most 100"
+// @Param        before  query     string  false  "Only notifications older than this one"
+// @Success      200     {object}  models.InboxPage   "Notifications"
+// @Failure      400     {object}  map[string]string  "error: invalid limit"
+// @Failure      403     {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      404     {object}  map[string]string  "error: inbox item not found"
+// @Failure      500     {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/inbox [get]
+func ListInboxHandler(store inbox.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	limit := inbox.DefaultPageSize
+	if raw := ctx.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 || value > inbox.MaxPageSize {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("limit must be between 1 and %d", inbox.MaxPageSize)))
+			return
+		}
+		limit = value
+	}
+	unreadOnly := false
+	if raw := ctx.Query("unread"); raw != "" {
+		value, err := strconv.ParseBool(raw)
+		if err != nil {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("invalid unread")))
+			return
+		}
+		unreadOnly = value
+	}
+
+	page, err := store.List(ctx.Request.Context(), userID, unreadOnly, ctx.Query("before"), limit)
+	if errors.Is(err, inbox.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to list inbox: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.JSON(http.StatusOK, page)
+}
+
+// UnreadCountHandler godoc
+// @Summary      Counts a user's unread notifications
+// @Tags         inbox
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  map[string]int     "unread: number of unread notifications"
+// @Failure      403  {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      500  {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/inbox/unread-count [get]
+func UnreadCountHandler(store inbox.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	unread, err := store.Unread(ctx.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to count unread inbox items: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"unread": unread})
+}
+
+// MarkReadHandler godoc
+// @Summary      Marks a notification read
+// @Tags         inbox
+// @Produce      json
+// @Param        id    path      string  true  "User ID"
+// @Param        item  path      string  true  "Notification ID"
+// @Success      200   {object}  models.InboxItem   "Notification"
+// @Failure      403   {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      404   {object}  map[string]string  "error: inbox item not found"
+// @Failure      500   {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/inbox/{item}/read [post]
+func MarkReadHandler(store inbox.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	item, err := store.MarkRead(ctx.Request.Context(), userID, ctx.Param("item"))
+	if errors.Is(err, inbox.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to mark inbox item read: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.JSON(http.StatusOK, item)
+}
+
+// MarkAllReadHandler godoc
+// @Summary      Marks every notification read
+// @Tags         inbox
+// @Produce      json
+// @Param        id   path      string  true  "User ID"
+// @Success      200  {object}  map[string]int     "marked: number of notifications that were unread"
+// @Failure      403  {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      500  {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/inbox/read-all [post]
+func MarkAllReadHandler(store inbox.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	marked, err := store.MarkAllRead(ctx.Request.Context(), userID)
+	if err != nil {
+		log.Printf("Failed to mark inbox read: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{"marked": marked})
+}
+
+// DeleteInboxItemHandler godoc
+// @Summary      Deletes a notification from the inbox
+// @Tags         inbox
+// @Param        id    path  string  true  "User ID"
+// @Param        item  path  string  true  "Notification ID"
+// @Success      204
+// @Failure      403  {object}  map[string]string  "error: cannot access another user's notifications"
+// @Failure      404  {object}  map[string]string  "error: inbox item not found"
+// @Failure      500  {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /users/{id}/inbox/{item} [delete]
+func DeleteInboxItemHandler(store inbox.Store, ctx *gin.Context) {
+	userID, ok := pathUser(ctx)
+	if !ok {
+		return
+	}
+	err := store.Delete(ctx.Request.Context(), userID, ctx.Param("item"))
+	if errors.Is(err, inbox.ErrItemNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete inbox item: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,66 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"push_service/auth"
+	"push_service/inbox"
+	"push_service/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestInboxHandlersLimitJWTCallersToTheirOwnInbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store, _ := inbox.NewMemoryStore("", 0)
+	for _, user := range []string{"alice", "bob"} {
+		store.Save(context.Background(), models.InboxItem{ID: user + "-1", UserID: user})
+	}
+
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		method    string
+		path      string
+		want      int
+	}{
+		{"own inbox", jwtUser("alice", auth.ScopeStream), http.MethodGet, "/users/alice/inbox", http.StatusOK},
+		{"other inbox", jwtUser("alice", auth.ScopeStream), http.MethodGet, "/users/bob/inbox", http.StatusForbidden},
+		{"other unread count", jwtUser("alice", auth.ScopeRead), http.MethodGet, "/users/bob/inbox/unread-count", http.StatusForbidden},
+		{"mark other read", jwtUser("alice", auth.ScopeSend), http.MethodPost, "/users/bob/inbox/bob-1/read", http.StatusForbidden},
+		{"mark all other read", jwtUser("alice", auth.ScopeStream), http.MethodPost, "/users/bob/inbox/read-all", http.StatusForbidden},
+		{"delete other", jwtUser("alice", auth.ScopeStream), http.MethodDelete, "/users/bob/inbox/bob-1", http.StatusForbidden},
+		{"delete own", jwtUser("alice", auth.ScopeStream), http.MethodDelete, "/users/alice/inbox/alice-1", http.StatusNoContent},
+		{"admin", jwtUser("carol", auth.ScopeAdmin), http.MethodGet, "/users/bob/inbox", http.StatusOK},
+		{"admin of a tenant", &auth.Principal{Subject: "carol", Method: auth.MethodJWT, Scopes: []auth.Scope{auth.ScopeAdmin}, Tenants: []string{"acme"}}, http.MethodGet, "/users/bob/inbox", http.StatusForbidden},
+		{"api key", &auth.Principal{Subject: "key", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeRead}}, http.MethodGet, "/users/bob/inbox", http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) { ctx.Set(auth.PrincipalContext, tt.principal) })
+			router.GET("/users/:id/inbox", func(ctx *gin.Context) { ListInboxHandler(store, ctx) })
+			router.GET("/users/:id/inbox/unread-count", func(ctx *gin.Context) { UnreadCountHandler(store, ctx) })
+			router.POST("/users/:id/inbox/read-all", func(ctx *gin.Context) { MarkAllReadHandler(store, ctx) })
+			router.POST("/users/:id/inbox/:item/read", func(ctx *gin.Context) { MarkReadHandler(store, ctx) })
+			router.DELETE("/users/:id/inbox/:item", func(ctx *gin.Context) { DeleteInboxItemHandler(store, ctx) })
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(tt.method, tt.path, nil))
+			if recorder.Code != tt.want {
+				t.Fatalf("%s %s responded %d, want %d: %s", tt.method, tt.path, recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+
+	if unread, _ := store.Unread(context.Background(), "bob"); unread != 1 {
+		t.Fatalf("bob has %d unread after the forbidden requests, want 1", unread)
+	}
+}
+
+func jwtUser(subject string, scopes ...auth.Scope) *auth.Principal {
+	return &auth.Principal{Subject: subject, Method: auth.MethodJWT, Scopes: scopes}
+}
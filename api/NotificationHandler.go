@@ -5,17 +5,21 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
 	"log"
 	"net/http"
-	"os"
-	"time"
+	"slices"
 
+	"push_service/auth"
+	"push_service/broker"
+	"push_service/locale"
 	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	"push_service/status"
 	"push_service/util"
 
 	"github.com/gin-gonic/gin"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/google/uuid"
 )
 
 const (
@@ -33,7 +37,10 @@ const (
 // @Param        request  body      models.NotifMessageRequest  true  "Notification request payload"
 // @Success      202      {object}  map[string]string        "status: queued, request_id: string"
 // @Failure      400      {object}  map[string]string        "error: validation failed or invalid notification type"
+// @Failure      403      {object}  map[string]string        "error: caller may not send for tenant"
 // @Failure      500      {object}  map[string]string        "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
 // @Router       /notification [post]
 func NotificationHandler(p *models.Publisher, ctx *gin.Context) {
 	var req models.NotifMessageRequest
@@ -43,112 +50,234 @@ func NotificationHandler(p *models.Publisher, ctx *gin.Context) {
 		return
 	}
 
-	body, err := json.Marshal(req)
-	if err != nil {
-		log.Printf("Error marshaling JSON: %v", err)
-		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New("Failed to process request")))
+	if status, err := prepareNotification(ctx, p, &req); err != nil {
+		ctx.JSON(status, util.ErrorResponse(err))
 		return
 	}
 
-	err = p.Channel.PublishWithContext(
-		context.Background(),
-		models.ExName, // exchange
-		"notifs",      // routing key
-		false,         // mandatory
-		false,         // immediate
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "application/json",
-			Body:         []byte(body),
-		})
+	confirmation, err := publishNotification(ctx.Request.Context(), p, req)
+	if err == nil {
+		err = waitForConfirm(ctx.Request.Context(), confirmation)
+	}
 	if err != nil {
 		log.Printf("Failed to publish a message: %v", err)
+		markPublishFailed(p, req.ID, err)
 		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New("Failed to queue notification")))
 		return
 	}
-	log.Printf("Received Message")
+	log.Printf("Received Message %s from caller %q", req.ID, req.Caller)
 
-	ctx.JSON(http.StatusAccepted, gin.H{"status": "queued", "request_id": req.UserID})
+	ctx.JSON(http.StatusAccepted, gin.H{"status": "queued", "request_id": req.ID})
 }
 
-func FetchUser(userId string) (*models.User, error) {
-	API_NAME := "https://user-service-yci9.onrender.com/api"
-	url := fmt.Sprintf("%s/v1/users/%s", API_NAME, userId)
-	token := os.Getenv("LOGIN_USER_TOKEN")
+// prepareNotification validates req and stamps it with a request ID and the
+// calling service. On failure it returns the HTTP status to respond with.
+func prepareNotification(ctx *gin.Context, p *models.Publisher, req *models.NotifMessageRequest) (int, error) {
+	if err := validateNotification(p, req); err != nil {
+		return http.StatusBadRequest, err
+	}
+	req.ID = uuid.NewString()
+	req.Caller = ""
 
-	client := &http.Client{
-		Timeout: 40 * time.Second,
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		// Callers limited to tenants send for one of theirs, which they
+		// only have to name when they have several.
+		if req.Tenant == "" && len(principal.Tenants) == 1 {
+			req.Tenant = principal.Tenants[0]
+		}
+		if req.Tenant == "" && len(principal.Tenants) > 1 {
+			return http.StatusBadRequest, errors.New("tenant is required for callers of several tenants")
+		}
+		if !principal.AllowsTenant(req.Tenant) {
+			return http.StatusForbidden, fmt.Errorf("caller may not send for tenant %q", req.Tenant)
+		}
+		req.Caller = principal.Subject
 	}
+	return http.StatusOK, nil
+}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not make new request: %w", err)
+// callerOf returns the subject of the authenticated caller, or "" while
+// authentication is disabled.
+func callerOf(ctx *gin.Context) string {
+	if principal, ok := auth.PrincipalFrom(ctx); ok {
+		return principal.Subject
 	}
+	return ""
+}
 
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+// mayRead reports whether the caller may read back something caller sent
+// for tenants. Admins may read anything sent for their tenants, which is
+// everything unless they are limited to some. Others get a 404 rather than
+// a 403 so IDs of other callers' requests are not confirmed.
+func mayRead(ctx *gin.Context, caller string, tenants ...string) bool {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if !ok {
+		return caller == ""
+	}
+	if principal.Subject == caller {
+		return true
+	}
+	if !principal.HasScope(auth.ScopeAdmin) {
+		return false
+	}
+	if len(principal.Tenants) == 0 {
+		return true
+	}
+	// Something sent for no tenant belongs to none of theirs.
+	if len(tenants) == 0 {
+		return false
+	}
+	for _, tenant := range tenants {
+		if !principal.AllowsTenant(tenant) {
+			return false
+		}
+	}
+	return true
+}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("could not fetch data from User service [%s]: %w", API_NAME, err)
+func validateNotification(p *models.Publisher, req *models.NotifMessageRequest) error {
+	targets := 0
+	if req.UserID != "" || (req.PushToken != nil && *req.PushToken != "") {
+		targets++
+	}
+	if req.Topic != "" {
+		if !topicNamePattern.MatchString(req.Topic) {
+			return fmt.Errorf("invalid topic name %q", req.Topic)
+		}
+		targets++
+	}
+	if req.Condition != "" {
+		if err := validateCondition(req.Condition); err != nil {
+			return err
+		}
+		targets++
 	}
 
-	defer resp.Body.Close()
+	if req.TemplateVersion < 0 || (req.TemplateVersion > 0 && req.Template == "") {
+		return errors.New("template_version requires a template and must be positive")
+	}
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("user service responded with status %d: %s", resp.StatusCode, string(body))
+	if req.Locale != "" && !locale.Valid(req.Locale) {
+		return fmt.Errorf("invalid locale %q", req.Locale)
 	}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+	if err := validateChannels(req); err != nil {
+		return err
+	}
+
+	switch req.Kind {
+	case "", models.KindNotification:
+	case models.KindData:
+		if req.Title != nil || req.Body != nil || req.Template != "" {
+			return errors.New("data messages cannot have a title, body or template")
+		}
+		if req.ImageURL != "" || req.Sound != "" || req.Badge != nil || req.ChannelID != "" {
+			return errors.New("data messages cannot have display options")
+		}
+	default:
+		return fmt.Errorf("unknown kind %q", req.Kind)
+	}
+
+	// A policy without push, such as a webhook alone, needs no device target.
+	pushes := len(req.Channels) == 0 || slices.Contains(req.Channels, models.Push)
+	switch {
+	case targets == 0 && pushes:
+		return errors.New("one of user_id, push_token, topic or condition is required")
+	case targets > 1:
+		return errors.New("user_id/push_token, topic and condition are mutually exclusive")
 	}
 
-	var user models.UserResponses
-	err = json.Unmarshal(body, &user)
+	data, err := payload.Encode(req.Variables, p.DataMode)
 	if err != nil {
-		return nil, fmt.Errorf("failed to parse user JSON: %w", err)
+		return err
+	}
+	// Long titles and bodies are truncated by the consumer, so only the
+	// data has to fit here.
+	if err := payload.CheckSize(data, "", ""); err != nil {
+		return err
 	}
 
-	log.Printf("user is %v", fmt.Sprintf("%v", user))
-	return &user.Data, nil
+	// Template defaults are validated again by the consumer once the
+	// template is known.
+	return platform.Resolve(*req, nil).Validate()
 }
 
-func FetchTemplate(template_name string) (*models.TemplateResponse, error) {
-	API_NAME := "https://template-service-mza0.onrender.com"
-	url := fmt.Sprintf("%s/templates/name/%s", API_NAME, template_name)
+// knownChannels are the channels a fallback policy may list.
+var knownChannels = []models.NotificationType{models.Push, models.Email, models.Webhook, models.APNs, models.WebPush, models.Stream, models.InApp}
 
-	client := &http.Client{
-		Timeout: 40 * time.Second,
-	}
+// userChannels can only deliver to a user_id.
+var userChannels = []models.NotificationType{models.Email, models.WebPush, models.Stream, models.InApp}
 
-	req, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, fmt.Errorf("could not make new request: %w", err)
+// validateChannels checks the fallback policy. The userChannels need a
+// user, APNs a user or a device token and webhooks a subscriber.
+func validateChannels(req *models.NotifMessageRequest) error {
+	seen := make(map[models.NotificationType]bool, len(req.Channels))
+	for _, channel := range req.Channels {
+		if !slices.Contains(knownChannels, channel) {
+			return fmt.Errorf("unknown channel %q", channel)
+		}
+		if slices.Contains(userChannels, channel) && req.UserID == "" {
+			return fmt.Errorf("channel %q requires a user_id", channel)
+		}
+		if channel == models.APNs && req.UserID == "" && req.APNsToken == "" {
+			return fmt.Errorf("channel %q requires a user_id or apns_token", channel)
+		}
+		if channel == models.Webhook && req.Subscriber == "" {
+			return fmt.Errorf("channel %q requires a subscriber", channel)
+		}
+		if seen[channel] {
+			return fmt.Errorf("channel %q is listed twice", channel)
+		}
+		seen[channel] = true
 	}
+	return nil
+}
 
-	resp, err := client.Do(req)
+// publishNotification starts tracking req and publishes it to the main
+// queue. The returned confirmation resolves once the broker has taken
+// responsibility for the message.
+func publishNotification(ctx context.Context, p *models.Publisher, req models.NotifMessageRequest) (broker.Confirmation, error) {
+	body, err := json.Marshal(models.QueuedNotification{NotifMessageRequest: req, Caller: req.Caller})
 	if err != nil {
-		return nil, fmt.Errorf("could not fetch data from Template service [%s]: %w", API_NAME, err)
+		return nil, fmt.Errorf("could not marshal notification: %w", err)
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("template service responded with status %d: %s", resp.StatusCode, string(body))
-	}
+	p.Status.Create(newStatusRecord(req, status.ModeQueued, status.Queued))
 
-	body, err := io.ReadAll(resp.Body)
+	confirmation, err := p.Broker.Publish(ctx, broker.Message{
+		ID:          req.ID,
+		ContentType: "application/json",
+		Body:        body,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		markPublishFailed(p, req.ID, err)
 	}
+	return confirmation, err
+}
 
-	var template models.TemplateResponse
-	err = json.Unmarshal(body, &template)
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse template JSON: %w", err)
+func newStatusRecord(req models.NotifMessageRequest, mode string, state status.State) status.Record {
+	return status.Record{
+		ID:       req.ID,
+		Mode:     mode,
+		State:    state,
+		UserID:   req.UserID,
+		Template: req.Template,
+		Caller:   req.Caller,
+		Tenant:   req.Tenant,
+		DryRun:   req.DryRun,
 	}
+}
+
+func markPublishFailed(p *models.Publisher, id string, err error) {
+	p.Status.Update(id, func(r *status.Record) {
+		r.State = status.Failed
+		r.Error = err.Error()
+	})
+}
 
-	log.Printf("template was retrieved successfully")
-	return &template, nil
+func waitForConfirm(ctx context.Context, confirmation broker.Confirmation) error {
+	if confirmation == nil {
+		return nil
+	}
+	return confirmation.Wait(ctx)
 }
@@ -0,0 +1,60 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"push_service/sandbox"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListSandboxMessagesHandler godoc
+// @Summary      Lists captured sandbox messages
+// @Description  Returns the messages the sandbox sender captured instead of delivering, newest first
+// @Tags         sandbox
+// @Produce      json
+// @Param        user_id   query     string  false  "Only messages for this user"
+// @Param        token     query     string  false  "Only messages for this device token"
+// @Param        template  query     string  false  "Only messages rendered from this template, including the default one"
+// @Param        topic     query     string  false  "Only messages sent to this topic"
+// @Param        limit     query     int     false  "Maximum number of messages"
+// @Success      200       {array}   sandbox.Message    "Captured messages"
+// @Failure      400       {object}  map[string]string  "error: invalid limit"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /sandbox/messages [get]
+func ListSandboxMessagesHandler(store *sandbox.Store, ctx *gin.Context) {
+	limit := 0
+	if raw := ctx.Query("limit"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value < 0 {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("invalid limit")))
+			return
+		}
+		limit = value
+	}
+
+	filter := sandbox.Filter{
+		UserID:   ctx.Query("user_id"),
+		Token:    ctx.Query("token"),
+		Template: ctx.Query("template"),
+		Topic:    ctx.Query("topic"),
+	}
+	ctx.JSON(http.StatusOK, store.List(filter, limit))
+}
+
+// ResetSandboxHandler godoc
+// @Summary      Clears captured sandbox messages
+// @Description  Drops every message the sandbox sender captured, for every team; requires the admin scope
+// @Tags         sandbox
+// @Produce      json
+// @Success      200  {object}  map[string]int  "deleted: number of messages dropped"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /sandbox/messages [delete]
+func ResetSandboxHandler(store *sandbox.Store, ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, gin.H{"deleted": store.Reset()})
+}
@@ -0,0 +1,151 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"push_service/frequency"
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+	"push_service/status"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const DefaultSyncSendTimeout = 10 * time.Second
+
+// SyncSendTimeout reads the deadline for synchronous sends from
+// SYNC_SEND_TIMEOUT (a Go duration such as "5s").
+func SyncSendTimeout() time.Duration {
+	raw := os.Getenv("SYNC_SEND_TIMEOUT")
+	if raw == "" {
+		return DefaultSyncSendTimeout
+	}
+	timeout, err := time.ParseDuration(raw)
+	if err != nil || timeout <= 0 {
+		log.Printf("Ignoring invalid SYNC_SEND_TIMEOUT=%q", raw)
+		return DefaultSyncSendTimeout
+	}
+	return timeout
+}
+
+// SendNowHandler godoc
+// @Summary      Sends a push notification immediately
+// @Description  Resolves and delivers the notification inline, bypassing the queue, for OTP-style and interactive flows.
+// @Description  Failures are classified and mapped to HTTP statuses; nothing is retried, but permanent failures fall back along the channel policy.
+// @Description  Pushes count against the frequency caps; over a cap the push is dropped rather than deferred, falling back along the policy or failing with 429.
+// @Description  With dry_run set (or in sandbox mode) FCM only validates the message, which is returned instead of delivered.
+// @Tags         notifications
+// @Accept       json
+// @Produce      json
+// @Param        request  body      models.NotifMessageRequest  true  "Notification request payload"
+// @Param        timeout  query     string                      false "Deadline such as 3s, capped at the server maximum"
+// @Success      200      {object}  map[string]string  "status: sent or validated (dry run), request_id: string, message_id: string, channel: string"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      410      {object}  map[string]string  "error: the device token is no longer registered"
+// @Failure      422      {object}  map[string]string  "error: the payload or target cannot be delivered"
+// @Failure      429      {object}  map[string]string  "error: FCM quota exceeded or a frequency cap reached"
+// @Failure      502      {object}  map[string]string  "error: FCM or an upstream service failed"
+// @Failure      504      {object}  map[string]string  "error: the deadline was exceeded"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /notification/send [post]
+func SendNowHandler(p *models.Publisher, c *models.Consumer, timeout time.Duration, ctx *gin.Context) {
+	var req models.NotifMessageRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+
+	if statusCode, err := prepareNotification(ctx, p, &req); err != nil {
+		ctx.JSON(statusCode, util.ErrorResponse(err))
+		return
+	}
+
+	if raw := ctx.Query("timeout"); raw != "" {
+		requested, err := time.ParseDuration(raw)
+		if err != nil || requested <= 0 {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("invalid timeout")))
+			return
+		}
+		timeout = min(timeout, requested)
+	}
+
+	c.Status.Create(newStatusRecord(req, status.ModeSync, status.Processing))
+	c.Status.Update(req.ID, func(r *status.Record) { r.Attempts = 1 })
+
+	sendCtx, cancel := context.WithTimeout(ctx.Request.Context(), timeout)
+	defer cancel()
+
+	// Frequency caps apply as they do to queued pushes, but a caller that
+	// is waiting cannot be deferred: a push over a cap falls back to the
+	// next channel, or fails with 429 until the cap's window reopens.
+	capMessage := sendNotification.FrequencyMessage(c, req, 0)
+	capMessage.Immediate = true
+	var reservation *frequency.Reservation
+	admit := func(through models.NotificationType) error {
+		if through != models.Push {
+			return nil
+		}
+		var err error
+		reservation, err = c.Limiter.Reserve(sendCtx, capMessage)
+		var suppressed *frequency.SuppressedError
+		if errors.As(err, &suppressed) {
+			return &sendNotification.DeliveryError{Class: sendNotification.ClassQuotaExceeded, RetryAfter: time.Until(suppressed.Until), Err: err}
+		}
+		return err
+	}
+
+	outcome, err := sendNotification.Deliver(sendCtx, c, req, 0, admit)
+	// The reservation is settled even when the caller has gone.
+	if err == nil && outcome.Channel == models.Push {
+		reservation.Sent(context.WithoutCancel(sendCtx))
+	} else {
+		reservation.Release(context.WithoutCancel(sendCtx))
+	}
+	if err != nil {
+		class := sendNotification.Classify(err)
+		log.Printf("Synchronous send %s failed (%s): %v", req.ID, class, err)
+		state := status.Failed
+		var suppressed *frequency.SuppressedError
+		if errors.As(err, &suppressed) {
+			state = status.Suppressed
+		}
+		c.Status.Update(req.ID, func(r *status.Record) {
+			r.State = state
+			r.Error = err.Error()
+			r.ErrorClass = string(class)
+		})
+
+		if retryAfter := sendNotification.RetryAfter(err); retryAfter > 0 {
+			ctx.Header("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+		ctx.JSON(class.HTTPStatus(), gin.H{"error": err.Error(), "error_class": class, "request_id": req.ID, "channel": outcome.Channel})
+		return
+	}
+
+	state := status.Sent
+	if sendNotification.IsDryRun(c, req) {
+		state = status.Validated
+	}
+	c.Status.Update(req.ID, func(r *status.Record) {
+		r.State = state
+		r.MessageID = outcome.MessageID
+	})
+
+	response := gin.H{"status": state, "request_id": req.ID, "message_id": outcome.MessageID, "channel": outcome.Channel}
+	if state == status.Validated {
+		record, _ := c.Status.Get(req.ID)
+		response["dry_run"] = true
+		response["message"] = record.Message
+	}
+	ctx.JSON(http.StatusOK, response)
+}
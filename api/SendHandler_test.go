@@ -0,0 +1,48 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"push_service/frequency"
+	"push_service/models"
+	"push_service/sandbox"
+	"push_service/status"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestSendNowCountsAgainstFrequencyCaps(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	captures := sandbox.NewStore(10)
+	c := &models.Consumer{
+		Sender: captures,
+		Status: status.NewStore(status.DefaultMaxRecords),
+		// Deferring is impossible for a caller that is waiting.
+		Limiter: frequency.NewLimiter(frequency.NewMemoryStore(), frequency.Config{UserHourly: 1, Policy: frequency.PolicyDefer, MaxDeferrals: 3}),
+	}
+	router := gin.New()
+	router.POST("/notification/send", func(ctx *gin.Context) {
+		SendNowHandler(&models.Publisher{}, c, time.Second, ctx)
+	})
+	send := func() *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		body := `{"push_token":"token-1","title":"Code","body":"123456"}`
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/notification/send", strings.NewReader(body)))
+		return recorder
+	}
+
+	if recorder := send(); recorder.Code != http.StatusOK {
+		t.Fatalf("first send responded %d: %s", recorder.Code, recorder.Body)
+	}
+	recorder := send()
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+		t.Fatalf("send over the cap responded %d with Retry-After %q: %s", recorder.Code, recorder.Header().Get("Retry-After"), recorder.Body)
+	}
+	if captured := captures.List(sandbox.Filter{}, 0); len(captured) != 1 {
+		t.Fatalf("%d messages were sent, want 1", len(captured))
+	}
+}
@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"push_service/status"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// StatusHandler godoc
+// @Summary      Gets the status of a notification
+// @Description  Returns the tracked state of a queued or synchronously sent notification.
+// @Description  Only the caller that sent it, or an admin, may read it.
+// @Description  Records are kept in memory by each instance, so they are only complete when a single instance runs the API and the workers.
+// @Tags         notifications
+// @Produce      json
+// @Param        id   path      string  true  "Request ID"
+// @Success      200  {object}  status.Record      "Notification status"
+// @Failure      404  {object}  map[string]string  "error: notification not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /notification/{id} [get]
+func StatusHandler(store *status.Store, ctx *gin.Context) {
+	record, ok := store.Get(ctx.Param("id"))
+	if !ok || !mayRead(ctx, record.Caller, record.Tenant) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(errors.New("notification not found")))
+		return
+	}
+	ctx.JSON(http.StatusOK, record)
+}
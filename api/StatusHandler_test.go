@@ -0,0 +1,80 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"push_service/auth"
+	"push_service/models"
+	"push_service/status"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestStatusIsScopedToTheCallerAndTenant(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	store := status.NewStore(status.DefaultMaxRecords)
+	store.Create(status.Record{ID: "n-1", Caller: "billing", Tenant: "acme", State: status.Queued})
+
+	admin := func(tenants ...string) *auth.Principal {
+		return &auth.Principal{Subject: "ops", Method: auth.MethodJWT, Scopes: []auth.Scope{auth.ScopeAdmin}, Tenants: tenants}
+	}
+	tests := []struct {
+		name      string
+		principal *auth.Principal
+		want      int
+	}{
+		{"sender", &auth.Principal{Subject: "billing", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeRead}}, http.StatusOK},
+		{"other caller", &auth.Principal{Subject: "orders", Method: auth.MethodAPIKey, Scopes: []auth.Scope{auth.ScopeRead}}, http.StatusNotFound},
+		{"admin", admin(), http.StatusOK},
+		{"admin of the tenant", admin("acme"), http.StatusOK},
+		{"admin of another tenant", admin("globex"), http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			router.Use(func(ctx *gin.Context) { ctx.Set(auth.PrincipalContext, tt.principal) })
+			router.GET("/notification/:id", func(ctx *gin.Context) { StatusHandler(store, ctx) })
+
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/notification/n-1", nil))
+			if recorder.Code != tt.want {
+				t.Fatalf("responded %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+		})
+	}
+}
+
+func TestTenantComesFromThePrincipal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name    string
+		tenants []string
+		asked   string
+		want    int
+		tenant  string
+	}{
+		{"only tenant", []string{"acme"}, "", http.StatusOK, "acme"},
+		{"one of several", []string{"acme", "globex"}, "globex", http.StatusOK, "globex"},
+		{"unnamed of several", []string{"acme", "globex"}, "", http.StatusBadRequest, ""},
+		{"not theirs", []string{"acme"}, "globex", http.StatusForbidden, ""},
+		{"unrestricted", nil, "globex", http.StatusOK, "globex"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, _ := gin.CreateTestContext(httptest.NewRecorder())
+			ctx.Set(auth.PrincipalContext, &auth.Principal{Subject: "billing", Method: auth.MethodJWT, Tenants: tt.tenants})
+			token, title, body := "token-1", "Hi", "There"
+			req := models.NotifMessageRequest{PushToken: &token, Title: &title, Body: &body, Tenant: tt.asked}
+
+			code, err := prepareNotification(ctx, &models.Publisher{}, &req)
+			if err != nil && code != tt.want || err == nil && tt.want != http.StatusOK {
+				t.Fatalf("prepareNotification returned %d, %v, want %d", code, err, tt.want)
+			}
+			if err == nil && req.Tenant != tt.tenant {
+				t.Fatalf("tenant is %q, want %q", req.Tenant, tt.tenant)
+			}
+		})
+	}
+}
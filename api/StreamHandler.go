@@ -0,0 +1,102 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"push_service/auth"
+	"push_service/stream"
+	"push_service/util"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// streamKeepAlive is how often an idle stream gets a comment, so proxies
+// do not close it.
+const streamKeepAlive = 20 * time.Second
+
+// StreamHandler godoc
+// @Summary      Streams notifications to a browser
+// @Description  Holds a Server-Sent Events connection that receives a "notification" event for every notification delivered over the stream channel.
+// @Description  Users authenticated with a JWT stream their own notifications; other callers name the user. EventSource clients may pass the token as access_token.
+// @Description  Delivery is best effort: events sent while the connection is down are not replayed, so clients read the inbox after reconnecting.
+// @Tags         stream
+// @Produce      text/event-stream
+// @Param        user_id       query     string  false  "User to stream for, the token's subject by default"
+// @Param        access_token  query     string  false  "Bearer token, for clients that cannot set headers"
+// @Success      200           {object}  models.StreamEvent  "Data of each notification event"
+// @Failure      400           {object}  map[string]string   "error: user_id is required"
+// @Failure      403           {object}  map[string]string   "error: cannot access another user's notifications"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /stream [get]
+func StreamHandler(hub *stream.Hub, ctx *gin.Context) {
+	userID, status, err := streamUser(ctx)
+	if err != nil {
+		ctx.JSON(status, util.ErrorResponse(err))
+		return
+	}
+
+	session := hub.Connect(userID)
+	defer hub.Disconnect(session)
+
+	ctx.Header("Cache-Control", "no-cache")
+	ctx.Header("X-Accel-Buffering", "no")
+	ctx.Render(http.StatusOK, sse.Event{Event: "ready", Data: gin.H{"session": session.ID}})
+	ctx.Writer.Flush()
+
+	keepAlive := time.NewTicker(streamKeepAlive)
+	defer keepAlive.Stop()
+
+	ctx.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-session.Events:
+			ctx.Render(-1, sse.Event{Event: "notification", Id: event.ID, Data: event})
+			return true
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			return err == nil
+		case <-ctx.Request.Context().Done():
+			return false
+		}
+	})
+}
+
+// streamUser resolves whose notifications the caller may stream.
+func streamUser(ctx *gin.Context) (string, int, error) {
+	return userFor(ctx, ctx.Query("user_id"))
+}
+
+// userFor resolves which user the caller acts for when it asked for
+// requested. JWT callers are end users, limited to themselves unless they
+// are admins; other callers must name the user. Users belong to no tenant,
+// so admins limited to tenants are limited to themselves too.
+func userFor(ctx *gin.Context, requested string) (string, int, error) {
+	principal, ok := auth.PrincipalFrom(ctx)
+	if ok && principal.Method == auth.MethodJWT {
+		if requested == "" || requested == principal.Subject {
+			return principal.Subject, 0, nil
+		}
+		if !principal.HasScope(auth.ScopeAdmin) || len(principal.Tenants) > 0 {
+			return "", http.StatusForbidden, errors.New("cannot access another user's notifications")
+		}
+	}
+	if requested == "" {
+		return "", http.StatusBadRequest, errors.New("user_id is required")
+	}
+	return requested, 0, nil
+}
+
+// pathUser is the user in the :id path, which JWT callers may only name
+// when it is themselves or they are admins.
+func pathUser(ctx *gin.Context) (string, bool) {
+	userID, status, err := userFor(ctx, ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(status, util.ErrorResponse(err))
+		return "", false
+	}
+	return userID, true
+}
@@ -0,0 +1,221 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+
+	"push_service/locale"
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+	"push_service/templates"
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListTemplatesHandler godoc
+// @Summary      Lists local templates
+// @Description  Lists every template and locale in the local template store with its latest version
+// @Tags         templates
+// @Produce      json
+// @Success      200  {array}  templates.Summary  "Templates"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates [get]
+func ListTemplatesHandler(store *templates.Store, ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, store.List())
+}
+
+// GetTemplateHandler godoc
+// @Summary      Gets a local template
+// @Description  Returns the latest or the requested version of a template from the local store
+// @Tags         templates
+// @Produce      json
+// @Param        name     path      string  true   "Template name"
+// @Param        locale   query     string  false  "Locale, en by default"
+// @Param        version  query     int     false  "Version, the latest by default"
+// @Success      200      {object}  templates.Version  "Template version"
+// @Failure      400      {object}  map[string]string  "error: invalid version"
+// @Failure      404      {object}  map[string]string  "error: template not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates/{name} [get]
+func GetTemplateHandler(store *templates.Store, ctx *gin.Context) {
+	version := 0
+	if raw := ctx.Query("version"); raw != "" {
+		value, err := strconv.Atoi(raw)
+		if err != nil || value <= 0 {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("invalid version")))
+			return
+		}
+		version = value
+	}
+
+	template, err := store.Get(ctx.Param("name"), ctx.Query("locale"), version)
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, template)
+}
+
+// ListTemplateVersionsHandler godoc
+// @Summary      Lists the versions of a local template
+// @Description  Returns every version of a template in one locale, oldest first
+// @Tags         templates
+// @Produce      json
+// @Param        name    path      string  true   "Template name"
+// @Param        locale  query     string  false  "Locale, en by default"
+// @Success      200     {array}   templates.Version  "Template versions"
+// @Failure      404     {object}  map[string]string  "error: template not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates/{name}/versions [get]
+func ListTemplateVersionsHandler(store *templates.Store, ctx *gin.Context) {
+	versions, err := store.Versions(ctx.Param("name"), ctx.Query("locale"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, versions)
+}
+
+// PutTemplateHandler godoc
+// @Summary      Stores a new version of a local template
+// @Description  Creates the template, or adds a version to it. Earlier versions stay available for pinned requests.
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string                         true  "Template name"
+// @Param        request  body      models.TemplateVersionRequest  true  "Template content"
+// @Success      201      {object}  templates.Version  "Stored version"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      500      {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates/{name} [put]
+func PutTemplateHandler(store *templates.Store, ctx *gin.Context) {
+	var req models.TemplateVersionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+	if req.Body == "" {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New("body is required")))
+		return
+	}
+	if req.Locale != "" && !locale.Valid(req.Locale) {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("invalid locale %q", req.Locale)))
+		return
+	}
+	for _, channel := range req.Channels {
+		if !slices.Contains(knownChannels, channel) {
+			ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("unknown channel %q", channel)))
+			return
+		}
+	}
+
+	version, err := store.Create(ctx.Param("name"), req.Locale, req.TemplateResponse)
+	if err != nil {
+		log.Printf("Failed to store template: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.JSON(http.StatusCreated, version)
+}
+
+// DeleteTemplateHandler godoc
+// @Summary      Deletes a local template
+// @Description  Deletes every version of a template in one locale
+// @Tags         templates
+// @Param        name    path      string  true   "Template name"
+// @Param        locale  query     string  false  "Locale, en by default"
+// @Success      204
+// @Failure      404  {object}  map[string]string  "error: template not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates/{name} [delete]
+func DeleteTemplateHandler(store *templates.Store, ctx *gin.Context) {
+	err := store.Delete(ctx.Param("name"), ctx.Query("locale"))
+	if errors.Is(err, templates.ErrTemplateNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete template: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
+
+// PreviewTemplateHandler godoc
+// @Summary      Previews a rendered template
+// @Description  Renders the template with the given variables exactly as the consumer would and returns the resulting FCM payload without sending it.
+// @Description  Problems such as unresolved placeholders or a user who cannot receive pushes are reported as warnings.
+// @Tags         templates
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string                         true  "Template name"
+// @Param        request  body      models.TemplatePreviewRequest  true  "Variables and optional user"
+// @Success      200      {object}  models.TemplatePreviewResponse  "Rendered notification"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      422      {object}  map[string]string  "error: the template cannot be rendered into a valid message"
+// @Failure      502      {object}  map[string]string  "error: the user or template service failed"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /templates/{name}/preview [post]
+func PreviewTemplateHandler(c *models.Consumer, ctx *gin.Context) {
+	var req models.TemplatePreviewRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+	if req.Locale != "" && !locale.Valid(req.Locale) {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("invalid locale %q", req.Locale)))
+		return
+	}
+
+	rendered, err := sendNotification.Preview(ctx.Request.Context(), c, models.NotifMessageRequest{
+		UserID:          req.UserID,
+		Template:        ctx.Param("name"),
+		TemplateVersion: req.TemplateVersion,
+		Locale:          req.Locale,
+		Variables:       req.Variables,
+	})
+	if err != nil {
+		class := sendNotification.Classify(err)
+		ctx.JSON(class.HTTPStatus(), gin.H{"error": err.Error(), "error_class": class})
+		return
+	}
+
+	message := rendered.Message
+	response := models.TemplatePreviewResponse{
+		Template:  ctx.Param("name"),
+		Data:      message.Data,
+		Android:   message.Android,
+		APNS:      message.APNS,
+		Webpush:   message.Webpush,
+		Message:   message,
+		Truncated: rendered.Truncated,
+		Warnings:  rendered.Warnings,
+	}
+	if rendered.Template != nil {
+		response.TemplateVersion = rendered.Template.Version
+		response.Locale = rendered.Template.Locale
+	}
+	if message.Notification != nil {
+		response.Title = message.Notification.Title
+		response.Body = message.Notification.Body
+	}
+	if response.Warnings == nil {
+		response.Warnings = []string{}
+	}
+	ctx.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,132 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"push_service/models"
+	"push_service/util"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/gin-gonic/gin"
+)
+
+// maxConditionTopics is the number of topics FCM accepts in one condition.
+const maxConditionTopics = 5
+
+var (
+	topicNamePattern      = regexp.MustCompile(`^[a-zA-Z0-9-_.~%]+$`)
+	conditionTopicPattern = regexp.MustCompile(`'([^']*)' in topics`)
+)
+
+func validateCondition(condition string) error {
+	topics := conditionTopicPattern.FindAllStringSubmatch(condition, -1)
+	if len(topics) == 0 {
+		return fmt.Errorf("condition %q does not reference any topic", condition)
+	}
+	if len(topics) > maxConditionTopics {
+		return fmt.Errorf("condition references %d topics, FCM allows at most %d", len(topics), maxConditionTopics)
+	}
+	for _, match := range topics {
+		if !topicNamePattern.MatchString(match[1]) {
+			return fmt.Errorf("invalid topic name %q in condition", match[1])
+		}
+	}
+
+	rest := conditionTopicPattern.ReplaceAllString(condition, "")
+	if strings.Trim(rest, " ()&|!") != "" {
+		return fmt.Errorf("condition %q contains unsupported expressions", condition)
+	}
+	return nil
+}
+
+// SubscribeTopicHandler godoc
+// @Summary      Subscribes device tokens to a topic
+// @Description  Subscribes up to 1000 registration tokens to an FCM topic and reports tokens that failed. In sandbox capture mode FCM is not called and every token succeeds
+// @Tags         topics
+// @Accept       json
+// @Produce      json
+// @Param        topic    path      string                           true  "Topic name"
+// @Param        request  body      models.TopicSubscriptionRequest  true  "Registration tokens"
+// @Success      200      {object}  models.TopicSubscriptionResponse  "Per-token results"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      502      {object}  map[string]string  "error: FCM request failed"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /topics/{topic}/subscribe [post]
+func SubscribeTopicHandler(c *models.Consumer, ctx *gin.Context) {
+	topicSubscription(c, ctx, (*messaging.Client).SubscribeToTopic)
+}
+
+// UnsubscribeTopicHandler godoc
+// @Summary      Unsubscribes device tokens from a topic
+// @Description  Unsubscribes up to 1000 registration tokens from an FCM topic and reports tokens that failed. In sandbox capture mode FCM is not called and every token succeeds
+// @Tags         topics
+// @Accept       json
+// @Produce      json
+// @Param        topic    path      string                           true  "Topic name"
+// @Param        request  body      models.TopicSubscriptionRequest  true  "Registration tokens"
+// @Success      200      {object}  models.TopicSubscriptionResponse  "Per-token results"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      502      {object}  map[string]string  "error: FCM request failed"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /topics/{topic}/unsubscribe [post]
+func UnsubscribeTopicHandler(c *models.Consumer, ctx *gin.Context) {
+	topicSubscription(c, ctx, (*messaging.Client).UnsubscribeFromTopic)
+}
+
+type topicManagementFunc func(client *messaging.Client, ctx context.Context, tokens []string, topic string) (*messaging.TopicManagementResponse, error)
+
+func topicSubscription(c *models.Consumer, ctx *gin.Context, manage topicManagementFunc) {
+	topic := ctx.Param("topic")
+	if !topicNamePattern.MatchString(topic) {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(fmt.Errorf("invalid topic name %q", topic)))
+		return
+	}
+
+	var req models.TopicSubscriptionRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+
+	if c.Sender != nil {
+		// Capture mode never reaches real devices, subscriptions included.
+		log.Printf("Sandbox capture: %d tokens not sent to FCM for topic %q", len(req.Tokens), topic)
+		ctx.JSON(http.StatusOK, models.TopicSubscriptionResponse{
+			Topic:        topic,
+			SuccessCount: len(req.Tokens),
+			Failures:     []models.TopicSubscriptionFailure{},
+		})
+		return
+	}
+
+	resp, err := manage(c.Client, ctx.Request.Context(), req.Tokens, topic)
+	if err != nil {
+		log.Printf("Topic management for %q failed: %v", topic, err)
+		ctx.JSON(http.StatusBadGateway, util.ErrorResponse(errors.New("FCM request failed")))
+		return
+	}
+
+	result := models.TopicSubscriptionResponse{
+		Topic:        topic,
+		SuccessCount: resp.SuccessCount,
+		FailureCount: resp.FailureCount,
+		Failures:     make([]models.TopicSubscriptionFailure, 0, len(resp.Errors)),
+	}
+	for _, info := range resp.Errors {
+		result.Failures = append(result.Failures, models.TopicSubscriptionFailure{
+			Index:  info.Index,
+			Token:  req.Tokens[info.Index],
+			Reason: info.Reason,
+		})
+	}
+	ctx.JSON(http.StatusOK, result)
+}
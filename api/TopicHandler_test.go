@@ -0,0 +1,36 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"push_service/models"
+	"push_service/sandbox"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTopicSubscriptionsStayInTheSandbox(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	// No FCM client at all: reaching it would panic.
+	c := &models.Consumer{Sender: sandbox.NewStore(10)}
+	router := gin.New()
+	router.POST("/topics/:topic/subscribe", func(ctx *gin.Context) { SubscribeTopicHandler(c, ctx) })
+	router.POST("/topics/:topic/unsubscribe", func(ctx *gin.Context) { UnsubscribeTopicHandler(c, ctx) })
+
+	for _, action := range []string{"subscribe", "unsubscribe"} {
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/topics/news/"+action, strings.NewReader(`{"tokens":["a","b"]}`)))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("%s responded %d: %s", action, recorder.Code, recorder.Body)
+		}
+		var resp models.TopicSubscriptionResponse
+		json.Unmarshal(recorder.Body.Bytes(), &resp)
+		if resp.SuccessCount != 2 || resp.Topic != "news" {
+			t.Fatalf("%s returned %+v", action, resp)
+		}
+	}
+}
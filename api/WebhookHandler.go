@@ -0,0 +1,162 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"push_service/models"
+	"push_service/util"
+	"push_service/webhook"
+
+	"github.com/gin-gonic/gin"
+)
+
+// PutWebhookHandler godoc
+// @Summary      Creates or updates a webhook subscriber
+// @Description  Sets the endpoint that notifications for the subscriber are POSTed to.
+// @Description  The URL must use https and a public address, unless its host is listed in WEBHOOK_ALLOWED_HOSTS.
+// @Description  The signing secret is generated when the subscriber is created and only returned then.
+// @Tags         admin
+// @Accept       json
+// @Produce      json
+// @Param        name     path      string                           true  "Subscriber name"
+// @Param        request  body      models.WebhookSubscriberRequest  true  "Endpoint settings"
+// @Success      200      {object}  map[string]any     "subscriber: webhook.Subscriber"
+// @Success      201      {object}  map[string]any     "subscriber: webhook.Subscriber, secret: string"
+// @Failure      400      {object}  map[string]string  "error: validation failed"
+// @Failure      500      {object}  map[string]string  "error: internal server error"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/webhooks/{name} [put]
+func PutWebhookHandler(store *webhook.Store, ctx *gin.Context) {
+	var req models.WebhookSubscriberRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		log.Println(util.ErrorResponse(err))
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(errors.New(ValidationFailed)))
+		return
+	}
+	if err := validateWebhook(store, req); err != nil {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+
+	subscriber, secret, err := store.Put(ctx.Param("name"), webhook.Subscriber{
+		URL:             req.URL,
+		TimeoutMs:       req.TimeoutMs,
+		SuccessStatuses: req.SuccessStatuses,
+	})
+	if errors.Is(err, webhook.ErrURLNotAllowed) {
+		ctx.JSON(http.StatusBadRequest, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store webhook subscriber: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	if secret == "" {
+		ctx.JSON(http.StatusOK, gin.H{"subscriber": subscriber})
+		return
+	}
+	ctx.JSON(http.StatusCreated, gin.H{"subscriber": subscriber, "secret": secret})
+}
+
+func validateWebhook(store *webhook.Store, req models.WebhookSubscriberRequest) error {
+	if err := store.CheckURL(req.URL); err != nil {
+		return err
+	}
+	if req.TimeoutMs < 0 || req.TimeoutMs > int(webhook.MaxTimeout.Milliseconds()) {
+		return fmt.Errorf("timeout_ms must be between 0 and %d", webhook.MaxTimeout.Milliseconds())
+	}
+	for _, code := range req.SuccessStatuses {
+		if code < 200 || code > 399 {
+			return fmt.Errorf("success status %d must be a 2xx or 3xx status", code)
+		}
+	}
+	return nil
+}
+
+// ListWebhooksHandler godoc
+// @Summary      Lists webhook subscribers
+// @Description  Lists every webhook subscriber. Secrets are never returned.
+// @Tags         admin
+// @Produce      json
+// @Success      200  {array}  webhook.Subscriber  "Subscribers"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/webhooks [get]
+func ListWebhooksHandler(store *webhook.Store, ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, store.List())
+}
+
+// GetWebhookHandler godoc
+// @Summary      Gets a webhook subscriber
+// @Tags         admin
+// @Produce      json
+// @Param        name  path      string  true  "Subscriber name"
+// @Success      200   {object}  webhook.Subscriber  "Subscriber"
+// @Failure      404   {object}  map[string]string   "error: webhook subscriber not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/webhooks/{name} [get]
+func GetWebhookHandler(store *webhook.Store, ctx *gin.Context) {
+	subscriber, err := store.Get(ctx.Param("name"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	ctx.JSON(http.StatusOK, subscriber)
+}
+
+// RotateWebhookSecretHandler godoc
+// @Summary      Rotates a webhook signing secret
+// @Description  Issues a new signing secret. Deliveries are signed with it from now on.
+// @Tags         admin
+// @Produce      json
+// @Param        name  path      string  true  "Subscriber name"
+// @Success      200   {object}  map[string]any     "subscriber: webhook.Subscriber, secret: string"
+// @Failure      404   {object}  map[string]string  "error: webhook subscriber not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/webhooks/{name}/rotate [post]
+func RotateWebhookSecretHandler(store *webhook.Store, ctx *gin.Context) {
+	subscriber, secret, err := store.Rotate(ctx.Param("name"))
+	if errors.Is(err, webhook.ErrSubscriberNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to rotate webhook secret: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+
+	ctx.JSON(http.StatusOK, gin.H{"subscriber": subscriber, "secret": secret})
+}
+
+// DeleteWebhookHandler godoc
+// @Summary      Deletes a webhook subscriber
+// @Description  Notifications for the subscriber fail permanently from now on.
+// @Tags         admin
+// @Param        name  path  string  true  "Subscriber name"
+// @Success      204
+// @Failure      404  {object}  map[string]string  "error: webhook subscriber not found"
+// @Security     ApiKeyAuth
+// @Security     BearerAuth
+// @Router       /admin/webhooks/{name} [delete]
+func DeleteWebhookHandler(store *webhook.Store, ctx *gin.Context) {
+	err := store.Delete(ctx.Param("name"))
+	if errors.Is(err, webhook.ErrSubscriberNotFound) {
+		ctx.JSON(http.StatusNotFound, util.ErrorResponse(err))
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to delete webhook subscriber: %v", err)
+		ctx.JSON(http.StatusInternalServerError, util.ErrorResponse(errors.New(InternalServerError)))
+		return
+	}
+	ctx.Status(http.StatusNoContent)
+}
@@ -0,0 +1,219 @@
+package apns
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	sendNotification "push_service/sendNotification"
+	"push_service/truncate"
+)
+
+// MaxPayload is the largest payload APNs accepts for a regular push.
+const MaxPayload = 4096
+
+// Reasons APNs gives for rejecting a request.
+const (
+	ReasonBadCollapseID               = "BadCollapseId"
+	ReasonBadDeviceToken              = "BadDeviceToken"
+	ReasonBadExpirationDate           = "BadExpirationDate"
+	ReasonBadMessageID                = "BadMessageId"
+	ReasonBadPriority                 = "BadPriority"
+	ReasonBadTopic                    = "BadTopic"
+	ReasonDeviceTokenNotForTopic      = "DeviceTokenNotForTopic"
+	ReasonDuplicateHeaders            = "DuplicateHeaders"
+	ReasonExpiredProviderToken        = "ExpiredProviderToken"
+	ReasonForbidden                   = "Forbidden"
+	ReasonIdleTimeout                 = "IdleTimeout"
+	ReasonInternalServerError         = "InternalServerError"
+	ReasonInvalidPushType             = "InvalidPushType"
+	ReasonInvalidProviderToken        = "InvalidProviderToken"
+	ReasonMissingDeviceToken          = "MissingDeviceToken"
+	ReasonMissingProviderToken        = "MissingProviderToken"
+	ReasonMissingTopic                = "MissingTopic"
+	ReasonPayloadEmpty                = "PayloadEmpty"
+	ReasonPayloadTooLarge             = "PayloadTooLarge"
+	ReasonServiceUnavailable          = "ServiceUnavailable"
+	ReasonShutdown                    = "Shutdown"
+	ReasonTooManyProviderTokenUpdates = "TooManyProviderTokenUpdates"
+	ReasonTooManyRequests             = "TooManyRequests"
+	ReasonTopicDisallowed             = "TopicDisallowed"
+	ReasonUnregistered                = "Unregistered"
+)
+
+// Channel delivers notifications straight to APNs, bypassing FCM. Title
+// and body are truncated to Limit, and failures are classified like FCM
+// errors so they are retried and dead-lettered the same way.
+type Channel struct {
+	Client   *Client
+	Limit    truncate.Limit
+	DataMode payload.NestedMode
+}
+
+func (c *Channel) Send(ctx context.Context, req models.NotifMessageRequest, user *models.User) (string, error) {
+	token := req.APNsToken
+	if token == "" && user != nil {
+		token = user.APNsToken
+	}
+	if token == "" {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassNoToken, Err: errors.New("no apns device token")}
+	}
+
+	body, opts, err := c.payload(req)
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidPayload, Err: err}
+	}
+
+	apnsReq := Request{
+		DeviceToken: token,
+		ID:          req.ID,
+		PushType:    "alert",
+		Priority:    10,
+		CollapseID:  opts.CollapseKey,
+		Payload:     body,
+	}
+	if req.Kind == models.KindData {
+		apnsReq.PushType = "background"
+		apnsReq.Priority = 5
+	} else if opts.Priority == platform.PriorityNormal {
+		apnsReq.Priority = 5
+	}
+	if opts.TTL != nil {
+		expiration := time.Now().Add(*opts.TTL)
+		apnsReq.Expiration = &expiration
+	}
+
+	resp, err := c.Client.Send(ctx, apnsReq)
+	if err != nil {
+		class := sendNotification.ClassUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			class = sendNotification.ClassTimeout
+		}
+		return "", &sendNotification.DeliveryError{Class: class, Err: err}
+	}
+	if resp.StatusCode == http.StatusOK {
+		return resp.APNsID, nil
+	}
+	return "", classify(resp)
+}
+
+// payload builds the JSON body: the aps dictionary plus the variables as
+// custom keys.
+func (c *Channel) payload(req models.NotifMessageRequest) ([]byte, platform.Options, error) {
+	opts := platform.Resolve(req, nil)
+
+	data, err := payload.Encode(req.Variables, c.DataMode)
+	if err != nil {
+		return nil, opts, err
+	}
+	body := make(map[string]any, len(data)+2)
+	for key, value := range data {
+		body[key] = value
+	}
+	if opts.Link != "" {
+		if _, ok := body["link"]; !ok {
+			body["link"] = opts.Link
+		}
+	}
+
+	aps := map[string]any{}
+	body["aps"] = aps
+	if req.Kind == models.KindData {
+		aps["content-available"] = 1
+		encoded, err := json.Marshal(body)
+		if err == nil && len(encoded) > MaxPayload {
+			err = fmt.Errorf("apns payload is %d bytes, the limit is %d", len(encoded), MaxPayload)
+		}
+		return encoded, opts, err
+	}
+
+	title, _ := truncate.Text(deref(req.Title), c.Limit.Title)
+	text, _ := truncate.Text(deref(req.Body), c.Limit.Body)
+	alert := map[string]string{"title": title, "body": text}
+	aps["alert"] = alert
+	if opts.Sound != "" {
+		aps["sound"] = opts.Sound
+	}
+	if opts.Badge != nil {
+		aps["badge"] = *opts.Badge
+	}
+	if opts.ClickAction != "" {
+		aps["category"] = opts.ClickAction
+	}
+	if opts.CollapseKey != "" {
+		aps["thread-id"] = opts.CollapseKey
+	}
+	if opts.ImageURL != "" {
+		aps["mutable-content"] = 1
+		body["image_url"] = opts.ImageURL
+	}
+
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, opts, err
+	}
+	// Shorten the body by however much the payload is over, rather than
+	// failing a notification whose variables fit.
+	if over := len(encoded) - MaxPayload; over > 0 && over < len(text) {
+		alert["body"], _ = truncate.Bytes(text, len(text)-over-len(truncate.Ellipsis))
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, opts, err
+		}
+	}
+	if len(encoded) > MaxPayload {
+		return nil, opts, fmt.Errorf("apns payload is %d bytes, the limit is %d", len(encoded), MaxPayload)
+	}
+	return encoded, opts, nil
+}
+
+// classify maps an APNs rejection onto the classes used for FCM. Reasons
+// not listed fall back to the status code.
+func classify(resp *Response) error {
+	err := fmt.Errorf("apns responded with status %d: %s", resp.StatusCode, resp.Reason)
+	delivery := &sendNotification.DeliveryError{Err: err}
+
+	switch resp.Reason {
+	case ReasonBadDeviceToken, ReasonUnregistered:
+		delivery.Class = sendNotification.ClassUnregistered
+	case ReasonDeviceTokenNotForTopic, ReasonTopicDisallowed:
+		delivery.Class = sendNotification.ClassSenderMismatch
+	case ReasonTooManyRequests, ReasonTooManyProviderTokenUpdates:
+		delivery.Class = sendNotification.ClassQuotaExceeded
+	case ReasonPayloadTooLarge, ReasonPayloadEmpty:
+		delivery.Class = sendNotification.ClassInvalidPayload
+	case ReasonExpiredProviderToken, ReasonIdleTimeout:
+		// The client has already dropped the expired token, so a retry
+		// signs a fresh one.
+		delivery.Class = sendNotification.ClassUnavailable
+	case ReasonInvalidProviderToken, ReasonMissingProviderToken, ReasonForbidden:
+		delivery.Class = sendNotification.ClassAuth
+	case ReasonBadCollapseID, ReasonBadExpirationDate, ReasonBadMessageID, ReasonBadPriority, ReasonBadTopic,
+		ReasonDuplicateHeaders, ReasonInvalidPushType, ReasonMissingDeviceToken, ReasonMissingTopic:
+		delivery.Class = sendNotification.ClassInvalidArgument
+	case ReasonInternalServerError:
+		delivery.Class = sendNotification.ClassInternal
+	case ReasonServiceUnavailable, ReasonShutdown:
+		delivery.Class = sendNotification.ClassUnavailable
+	default:
+		return sendNotification.ClassifyHTTP(resp.StatusCode, resp.Header, err)
+	}
+
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+		delivery.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return delivery
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
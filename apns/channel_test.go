@@ -0,0 +1,120 @@
+package apns
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+)
+
+func TestPayloadCarriesTheAlertLinkAndVariables(t *testing.T) {
+	title, body := "Hello", "You have mail"
+	channel := &Channel{}
+	encoded, _, err := channel.payload(models.NotifMessageRequest{
+		Title:     &title,
+		Body:      &body,
+		Link:      "https://example.com/inbox",
+		Variables: map[string]any{"count": "3"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded struct {
+		APS struct {
+			Alert map[string]string `json:"alert"`
+		} `json:"aps"`
+		Link  string `json:"link"`
+		Count string `json:"count"`
+	}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if decoded.APS.Alert["title"] != title || decoded.APS.Alert["body"] != body {
+		t.Fatalf("alert is %v", decoded.APS.Alert)
+	}
+	if decoded.Link != "https://example.com/inbox" || decoded.Count != "3" {
+		t.Fatalf("custom keys are link=%q count=%q", decoded.Link, decoded.Count)
+	}
+}
+
+func TestDataPayloadIsSilent(t *testing.T) {
+	channel := &Channel{}
+	encoded, _, err := channel.payload(models.NotifMessageRequest{
+		Kind:      models.KindData,
+		Variables: map[string]any{"sync": "inbox"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]any
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	aps, _ := decoded["aps"].(map[string]any)
+	if aps["content-available"] != float64(1) || aps["alert"] != nil {
+		t.Fatalf("data payload has aps %v", aps)
+	}
+}
+
+func TestPayloadShortensTheBodyToFit(t *testing.T) {
+	title, body := "Hello", strings.Repeat("a", 2*MaxPayload)
+	channel := &Channel{}
+	encoded, _, err := channel.payload(models.NotifMessageRequest{Title: &title, Body: &body})
+	if err != nil {
+		t.Fatalf("long body was not shortened: %v", err)
+	}
+	if len(encoded) > MaxPayload {
+		t.Fatalf("payload is %d bytes", len(encoded))
+	}
+
+	// Variables cannot be shortened, so a payload they overflow fails.
+	short := "Hi"
+	_, _, err = channel.payload(models.NotifMessageRequest{
+		Title:     &title,
+		Body:      &short,
+		Variables: map[string]any{"blob": strings.Repeat("b", MaxPayload)},
+	})
+	if err == nil {
+		t.Fatal("oversized variables were accepted")
+	}
+}
+
+func TestClassifyMapsReasonsToClasses(t *testing.T) {
+	tests := []struct {
+		status int
+		reason string
+		want   sendNotification.ErrorClass
+	}{
+		{http.StatusGone, ReasonUnregistered, sendNotification.ClassUnregistered},
+		{http.StatusBadRequest, ReasonBadDeviceToken, sendNotification.ClassUnregistered},
+		{http.StatusBadRequest, ReasonDeviceTokenNotForTopic, sendNotification.ClassSenderMismatch},
+		{http.StatusTooManyRequests, ReasonTooManyRequests, sendNotification.ClassQuotaExceeded},
+		{http.StatusRequestEntityTooLarge, ReasonPayloadTooLarge, sendNotification.ClassInvalidPayload},
+		{http.StatusForbidden, ReasonExpiredProviderToken, sendNotification.ClassUnavailable},
+		{http.StatusForbidden, ReasonInvalidProviderToken, sendNotification.ClassAuth},
+		{http.StatusBadRequest, ReasonBadTopic, sendNotification.ClassInvalidArgument},
+		{http.StatusServiceUnavailable, ReasonShutdown, sendNotification.ClassUnavailable},
+	}
+	for _, tt := range tests {
+		err := classify(&Response{StatusCode: tt.status, Reason: tt.reason, Header: http.Header{}})
+		var delivery *sendNotification.DeliveryError
+		if !errors.As(err, &delivery) || delivery.Class != tt.want {
+			t.Errorf("%s classified as %v, want %s", tt.reason, err, tt.want)
+		}
+	}
+}
+
+func TestClassifyKeepsRetryAfter(t *testing.T) {
+	header := http.Header{"Retry-After": []string{"30"}}
+	err := classify(&Response{StatusCode: http.StatusTooManyRequests, Reason: ReasonTooManyRequests, Header: header})
+	var delivery *sendNotification.DeliveryError
+	if !errors.As(err, &delivery) || delivery.RetryAfter != 30*time.Second {
+		t.Fatalf("classify returned %v, want a retry after 30s", err)
+	}
+}
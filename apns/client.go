@@ -0,0 +1,233 @@
+package apns
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	ProductionEndpoint = "https://api.push.apple.com"
+	SandboxEndpoint    = "https://api.sandbox.push.apple.com"
+
+	DefaultConnections = 2
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Config configures the APNs client.
+type Config struct {
+	Endpoint string
+	// Topic is the bundle ID of the app.
+	Topic  string
+	KeyID  string
+	TeamID string
+	// Key is the contents of the .p8 file.
+	Key []byte
+	// Connections is the number of HTTP/2 connections requests are spread
+	// over.
+	Connections int
+	Timeout     time.Duration
+}
+
+// ConfigFromEnv reads APNS_KEY_FILE, APNS_KEY_ID, APNS_TEAM_ID, APNS_TOPIC,
+// APNS_ENDPOINT (production by default, or the sandbox with APNS_SANDBOX),
+// APNS_CONNECTIONS and APNS_TIMEOUT. It returns nil when APNS_KEY_FILE is
+// not set, which leaves the channel disabled.
+func ConfigFromEnv() (*Config, error) {
+	path := os.Getenv("APNS_KEY_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	key, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read APNS_KEY_FILE: %w", err)
+	}
+
+	config := &Config{
+		Endpoint:    os.Getenv("APNS_ENDPOINT"),
+		Topic:       os.Getenv("APNS_TOPIC"),
+		KeyID:       os.Getenv("APNS_KEY_ID"),
+		TeamID:      os.Getenv("APNS_TEAM_ID"),
+		Key:         key,
+		Connections: DefaultConnections,
+		Timeout:     DefaultTimeout,
+	}
+	if config.Endpoint == "" {
+		config.Endpoint = ProductionEndpoint
+		if sandbox, _ := strconv.ParseBool(os.Getenv("APNS_SANDBOX")); sandbox {
+			config.Endpoint = SandboxEndpoint
+		}
+	}
+	if raw := os.Getenv("APNS_CONNECTIONS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			config.Connections = n
+		} else {
+			log.Printf("Ignoring invalid APNS_CONNECTIONS=%q", raw)
+		}
+	}
+	if raw := os.Getenv("APNS_TIMEOUT"); raw != "" {
+		if d, err := time.ParseDuration(raw); err == nil && d > 0 {
+			config.Timeout = d
+		} else {
+			log.Printf("Ignoring invalid APNS_TIMEOUT=%q", raw)
+		}
+	}
+	return config, nil
+}
+
+// Request is one notification for one device.
+type Request struct {
+	DeviceToken string
+	// ID becomes the apns-id header, a UUID APNs echoes back.
+	ID         string
+	PushType   string // alert or background
+	Priority   int    // 10 or 5
+	Expiration *time.Time
+	CollapseID string
+	Payload    []byte
+}
+
+// Response is what APNs answered. Reason is set when the request failed.
+type Response struct {
+	StatusCode int
+	Header     http.Header
+	APNsID     string
+	Reason     string
+	// Timestamp is when APNs learned the token was no longer valid, for
+	// Unregistered responses.
+	Timestamp time.Time
+}
+
+// Client sends requests to APNs over a pool of persistent HTTP/2
+// connections, authenticating with provider tokens.
+type Client struct {
+	endpoint string
+	topic    string
+	signer   *TokenSigner
+	clients  []*http.Client
+	next     atomic.Uint64
+}
+
+func NewClient(config Config) (*Client, error) {
+	if config.Topic == "" || config.KeyID == "" || config.TeamID == "" {
+		return nil, errors.New("apns needs a topic, key ID and team ID")
+	}
+	key, err := ParseKey(config.Key)
+	if err != nil {
+		return nil, err
+	}
+	endpoint, err := url.Parse(config.Endpoint)
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid apns endpoint %q", config.Endpoint)
+	}
+
+	connections := max(config.Connections, 1)
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	c := &Client{
+		endpoint: strings.TrimSuffix(config.Endpoint, "/"),
+		topic:    config.Topic,
+		signer:   &TokenSigner{KeyID: config.KeyID, TeamID: config.TeamID, Key: key},
+	}
+	for range connections {
+		c.clients = append(c.clients, &http.Client{
+			Timeout:   timeout,
+			Transport: newTransport(endpoint.Scheme == "http"),
+		})
+	}
+	return c, nil
+}
+
+// newTransport speaks only HTTP/2, over TLS or, for local stand-ins, in
+// cleartext. Idle connections are pinged so they stay usable.
+func newTransport(cleartext bool) *http.Transport {
+	protocols := new(http.Protocols)
+	if cleartext {
+		protocols.SetUnencryptedHTTP2(true)
+	} else {
+		protocols.SetHTTP2(true)
+	}
+	return &http.Transport{
+		Protocols: protocols,
+		HTTP2: &http.HTTP2Config{
+			SendPingTimeout: time.Minute,
+			PingTimeout:     15 * time.Second,
+		},
+	}
+}
+
+// Send posts req. Errors are only returned when no response was received.
+func (c *Client) Send(ctx context.Context, req Request) (*Response, error) {
+	token, err := c.signer.Token()
+	if err != nil {
+		return nil, err
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/3/device/"+url.PathEscape(req.DeviceToken), bytes.NewReader(req.Payload))
+	if err != nil {
+		return nil, fmt.Errorf("could not make apns request: %w", err)
+	}
+	httpReq.Header.Set("authorization", "bearer "+token)
+	httpReq.Header.Set("apns-topic", c.topic)
+	httpReq.Header.Set("apns-push-type", req.PushType)
+	httpReq.Header.Set("apns-priority", strconv.Itoa(req.Priority))
+	if req.ID != "" {
+		httpReq.Header.Set("apns-id", req.ID)
+	}
+	if req.Expiration != nil {
+		httpReq.Header.Set("apns-expiration", strconv.FormatInt(req.Expiration.Unix(), 10))
+	}
+	if req.CollapseID != "" {
+		httpReq.Header.Set("apns-collapse-id", req.CollapseID)
+	}
+
+	client := c.clients[c.next.Add(1)%uint64(len(c.clients))]
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("apns request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	response := &Response{StatusCode: resp.StatusCode, Header: resp.Header, APNsID: resp.Header.Get("apns-id")}
+	if resp.StatusCode == http.StatusOK {
+		io.Copy(io.Discard, resp.Body)
+		return response, nil
+	}
+
+	var body struct {
+		Reason    string `json:"reason"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(&body); err != nil {
+		log.Printf("Could not decode apns error response (%d): %v", resp.StatusCode, err)
+	}
+	response.Reason = body.Reason
+	if body.Timestamp > 0 {
+		response.Timestamp = time.UnixMilli(body.Timestamp)
+	}
+	if response.Reason == ReasonExpiredProviderToken {
+		c.signer.Invalidate(token)
+	}
+	return response, nil
+}
+
+// Close drops the pooled connections.
+func (c *Client) Close() {
+	for _, client := range c.clients {
+		client.CloseIdleConnections()
+	}
+}
@@ -0,0 +1,82 @@
+package apns
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// tokenLifetime is how long a provider token is reused. APNs rejects
+// tokens older than an hour and throttles providers that refresh them more
+// often than every 20 minutes.
+const tokenLifetime = 50 * time.Minute
+
+// ParseKey reads the ES256 private key from the contents of a .p8 file.
+func ParseKey(p8 []byte) (*ecdsa.PrivateKey, error) {
+	block, _ := pem.Decode(p8)
+	if block == nil {
+		return nil, errors.New("apns key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse apns key: %w", err)
+	}
+	ecKey, ok := key.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("apns key is not an ECDSA key")
+	}
+	return ecKey, nil
+}
+
+// TokenSigner issues the provider authentication tokens sent with every
+// request, reusing each one for tokenLifetime.
+type TokenSigner struct {
+	KeyID  string
+	TeamID string
+	Key    *ecdsa.PrivateKey
+
+	mu       sync.Mutex
+	token    string
+	issuedAt time.Time
+}
+
+// Token returns the current provider token, signing a new one when it is
+// due.
+func (s *TokenSigner) Token() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Since(s.issuedAt) < tokenLifetime {
+		return s.token, nil
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+		"iss": s.TeamID,
+		"iat": now.Unix(),
+	})
+	token.Header["kid"] = s.KeyID
+	signed, err := token.SignedString(s.Key)
+	if err != nil {
+		return "", fmt.Errorf("could not sign apns provider token: %w", err)
+	}
+	s.token, s.issuedAt = signed, now
+	return signed, nil
+}
+
+// Invalidate drops token if it is still current, so the next request signs
+// a fresh one. APNs answers ExpiredProviderToken when a token has aged out.
+func (s *TokenSigner) Invalidate(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token == token {
+		s.token = ""
+	}
+}
@@ -0,0 +1,147 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/MicahParks/keyfunc"
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	DefaultJWKSRefreshInterval = time.Hour
+	DefaultScopeClaim          = "scope"
+	DefaultTenantClaim         = "tenant"
+)
+
+// JWTConfig describes how bearer tokens issued by our other services are
+// validated and how their claims map to scopes and tenants.
+type JWTConfig struct {
+	JWKSURL         string
+	Issuer          string
+	Audience        string
+	ScopeClaim      string
+	ScopePrefix     string
+	TenantClaim     string
+	RefreshInterval time.Duration
+}
+
+// JWTConfigFromEnv reads JWKS_URL, JWT_ISSUER, JWT_AUDIENCE, JWT_SCOPE_CLAIM,
+// JWT_SCOPE_PREFIX, JWT_TENANT_CLAIM and JWKS_REFRESH_INTERVAL.
+func JWTConfigFromEnv() JWTConfig {
+	config := JWTConfig{
+		JWKSURL:         os.Getenv("JWKS_URL"),
+		Issuer:          os.Getenv("JWT_ISSUER"),
+		Audience:        os.Getenv("JWT_AUDIENCE"),
+		ScopeClaim:      os.Getenv("JWT_SCOPE_CLAIM"),
+		ScopePrefix:     os.Getenv("JWT_SCOPE_PREFIX"),
+		TenantClaim:     os.Getenv("JWT_TENANT_CLAIM"),
+		RefreshInterval: DefaultJWKSRefreshInterval,
+	}
+	if config.ScopeClaim == "" {
+		config.ScopeClaim = DefaultScopeClaim
+	}
+	if config.TenantClaim == "" {
+		config.TenantClaim = DefaultTenantClaim
+	}
+	if raw := os.Getenv("JWKS_REFRESH_INTERVAL"); raw != "" {
+		interval, err := time.ParseDuration(raw)
+		if err != nil || interval <= 0 {
+			log.Printf("Ignoring invalid JWKS_REFRESH_INTERVAL=%q", raw)
+		} else {
+			config.RefreshInterval = interval
+		}
+	}
+	return config
+}
+
+// JWTVerifier validates bearer tokens against a remote JWKS. Keys are cached
+// and refreshed in the background, and an unknown key ID triggers a
+// rate-limited refresh so rotated keys are picked up without a restart.
+type JWTVerifier struct {
+	jwks   *keyfunc.JWKS
+	config JWTConfig
+}
+
+func NewJWTVerifier(config JWTConfig) (*JWTVerifier, error) {
+	jwks, err := keyfunc.Get(config.JWKSURL, keyfunc.Options{
+		RefreshInterval:   config.RefreshInterval,
+		RefreshRateLimit:  time.Minute,
+		RefreshTimeout:    10 * time.Second,
+		RefreshUnknownKID: true,
+		RefreshErrorHandler: func(err error) {
+			log.Printf("[Auth] failed to refresh JWKS from %s: %v", config.JWKSURL, err)
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch JWKS from %s: %w", config.JWKSURL, err)
+	}
+	return &JWTVerifier{jwks: jwks, config: config}, nil
+}
+
+// Verify parses and validates token and returns the calling service.
+func (v *JWTVerifier) Verify(token string) (*Principal, error) {
+	claims := jwt.MapClaims{}
+	parsed, err := jwt.ParseWithClaims(token, claims, v.jwks.Keyfunc)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+	if !parsed.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	if v.config.Issuer != "" && !claims.VerifyIssuer(v.config.Issuer, true) {
+		return nil, errors.New("unexpected token issuer")
+	}
+	if v.config.Audience != "" && !claims.VerifyAudience(v.config.Audience, true) {
+		return nil, errors.New("unexpected token audience")
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		subject, _ = claims["azp"].(string)
+	}
+	if subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+
+	principal := &Principal{
+		Subject: subject,
+		Method:  MethodJWT,
+		Tenants: claimStrings(claims[v.config.TenantClaim]),
+	}
+	for _, value := range claimStrings(claims[v.config.ScopeClaim]) {
+		if scope, ok := strings.CutPrefix(value, v.config.ScopePrefix); ok {
+			principal.Scopes = append(principal.Scopes, Scope(scope))
+		}
+	}
+	return principal, nil
+}
+
+// Close stops the background JWKS refresh.
+func (v *JWTVerifier) Close() {
+	v.jwks.EndBackground()
+}
+
+// claimStrings accepts both the space separated form ("send read") and the
+// array form (["send", "read"]) of a claim.
+func claimStrings(claim any) []string {
+	switch value := claim.(type) {
+	case string:
+		return strings.Fields(value)
+	case []any:
+		values := make([]string, 0, len(value))
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	default:
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// jwks serves the public half of a fresh RSA key and returns a function
+// that signs claims with it.
+func jwks(t *testing.T) (string, func(jwt.MapClaims) string) {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": "test",
+			"alg": "RS256",
+			"use": "sig",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	}))
+	t.Cleanup(server.Close)
+
+	return server.URL, func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+		token.Header["kid"] = "test"
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+}
+
+func TestJWTVerifier(t *testing.T) {
+	url, sign := jwks(t)
+	verifier, err := NewJWTVerifier(JWTConfig{
+		JWKSURL:         url,
+		Issuer:          "https://issuer.example.com",
+		ScopeClaim:      DefaultScopeClaim,
+		ScopePrefix:     "push:",
+		TenantClaim:     DefaultTenantClaim,
+		RefreshInterval: time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer verifier.Close()
+
+	valid := func() jwt.MapClaims {
+		return jwt.MapClaims{
+			"sub":    "user-1",
+			"iss":    "https://issuer.example.com",
+			"exp":    time.Now().Add(time.Minute).Unix(),
+			"scope":  "push:stream push:read other:admin",
+			"tenant": []any{"acme"},
+		}
+	}
+
+	principal, err := verifier.Verify(sign(valid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if principal.Subject != "user-1" || principal.Method != MethodJWT || !principal.HasScope(ScopeStream) || principal.HasScope(ScopeAdmin) || !principal.AllowsTenant("acme") || principal.AllowsTenant("other") {
+		t.Fatalf("Verify returned %+v", principal)
+	}
+
+	for name, change := range map[string]func(jwt.MapClaims){
+		"expired":      func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() },
+		"wrong issuer": func(c jwt.MapClaims) { c["iss"] = "https://elsewhere.example.com" },
+		"no subject":   func(c jwt.MapClaims) { delete(c, "sub") },
+	} {
+		claims := valid()
+		change(claims)
+		if _, err := verifier.Verify(sign(claims)); err == nil {
+			t.Errorf("%s token was accepted", name)
+		}
+	}
+
+	a := &Authenticator{Keys: newKeyStore(t, ""), JWT: verifier}
+	recorder, seen := serve(a, http.Header{"Authorization": {"Bearer " + sign(valid())}}, ScopeStream)
+	if recorder.Code != http.StatusOK || seen == nil || seen.Subject != "user-1" {
+		t.Fatalf("bearer request responded %d with principal %+v", recorder.Code, seen)
+	}
+	if recorder, _ := serve(a, http.Header{"Authorization": {"Bearer not-a-token"}}, ScopeStream); recorder.Code != http.StatusUnauthorized {
+		t.Fatalf("invalid bearer token responded %d", recorder.Code)
+	}
+}
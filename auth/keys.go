@@ -0,0 +1,357 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"push_service/util"
+
+	"github.com/google/uuid"
+	"golang.org/x/time/rate"
+)
+
+// Scope is a permission granted to an API key.
+type Scope string
+
+const (
+	ScopeSend    Scope = "send"
+	ScopeRead    Scope = "read"
+	ScopeStream  Scope = "stream" // end users opening GET /stream
+	ScopeAdmin   Scope = "admin"
+	keyPrefix          = "pk_"
+	DefaultRate        = 10.0
+	DefaultBurst       = 20
+)
+
+var ErrKeyNotFound = errors.New("api key not found")
+
+// APIKey is a key as listed to operators. Only the SHA-256 hash of the
+// secret is kept, and never returned; the secret itself is returned once,
+// when the key is created or rotated.
+type APIKey struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Hash      string     `json:"-"`
+	Hint      string     `json:"hint"`
+	Scopes    []Scope    `json:"scopes"`
+	RateLimit float64    `json:"rate_limit"` // Requests per second
+	Burst     int        `json:"burst"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// storedKey is the persisted form of a key, which keeps the hash.
+type storedKey struct {
+	APIKey
+	Hash string `json:"hash"`
+}
+
+// Principal returns the caller identity for requests made with the key.
+func (k *APIKey) Principal() *Principal {
+	return &Principal{
+		Subject: "key:" + k.Name,
+		Method:  MethodAPIKey,
+		Scopes:  k.Scopes,
+	}
+}
+
+// KeyStore holds the API keys and their rate limiters. When path is set the
+// keys are persisted to that JSON file on every change.
+type KeyStore struct {
+	mu       sync.RWMutex
+	path     string
+	byID     map[string]*APIKey
+	byHash   map[string]*APIKey
+	limiters map[string]*rate.Limiter
+
+	defaultRate  float64
+	defaultBurst int
+}
+
+// NewKeyStoreFromEnv loads keys from API_KEYS_FILE and, if ADMIN_API_KEY is
+// set, registers it as a bootstrap admin key.
+func NewKeyStoreFromEnv() (*KeyStore, error) {
+	s := &KeyStore{
+		path:         os.Getenv("API_KEYS_FILE"),
+		byID:         make(map[string]*APIKey),
+		byHash:       make(map[string]*APIKey),
+		limiters:     make(map[string]*rate.Limiter),
+		defaultRate:  envFloat("API_KEY_RATE_LIMIT", DefaultRate),
+		defaultBurst: int(envFloat("API_KEY_BURST", DefaultBurst)),
+	}
+
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+
+	if admin := os.Getenv("ADMIN_API_KEY"); admin != "" {
+		hash := hashKey(admin)
+		if _, ok := s.byHash[hash]; !ok {
+			key := &APIKey{
+				ID:        "bootstrap-admin",
+				Name:      "bootstrap admin",
+				Hash:      hash,
+				Hint:      hint(admin),
+				Scopes:    []Scope{ScopeAdmin},
+				RateLimit: s.defaultRate,
+				Burst:     s.defaultBurst,
+				CreatedAt: time.Now(),
+			}
+			s.byID[key.ID] = key
+			s.byHash[key.Hash] = key
+		}
+	}
+	return s, nil
+}
+
+func envFloat(name string, fallback float64) float64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseFloat(raw, 64)
+	if err != nil || value <= 0 {
+		log.Printf("Ignoring invalid %s=%q", name, raw)
+		return fallback
+	}
+	return value
+}
+
+// Enabled reports whether any key exists. With no keys the endpoints stay
+// open, which keeps local development working without configuration.
+func (s *KeyStore) Enabled() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.byID) > 0
+}
+
+// Authenticate returns the active key matching secret.
+func (s *KeyStore) Authenticate(secret string) (*APIKey, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key, ok := s.byHash[hashKey(secret)]
+	if !ok || key.RevokedAt != nil {
+		return nil, ErrKeyNotFound
+	}
+	return key, nil
+}
+
+// Limiter returns the token bucket for key, creating it on first use.
+func (s *KeyStore) Limiter(key *APIKey) *rate.Limiter {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	limiter, ok := s.limiters[key.ID]
+	if !ok {
+		limiter = rate.NewLimiter(rate.Limit(key.RateLimit), key.Burst)
+		s.limiters[key.ID] = limiter
+	}
+	return limiter
+}
+
+// Create adds a key and returns it together with its secret.
+func (s *KeyStore) Create(name string, scopes []Scope, rateLimit float64, burst int) (*APIKey, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+	if rateLimit <= 0 {
+		rateLimit = s.defaultRate
+	}
+	if burst <= 0 {
+		burst = s.defaultBurst
+	}
+
+	key := &APIKey{
+		ID:        uuid.NewString(),
+		Name:      name,
+		Hash:      hashKey(secret),
+		Hint:      hint(secret),
+		Scopes:    scopes,
+		RateLimit: rateLimit,
+		Burst:     burst,
+		CreatedAt: time.Now(),
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.byID[key.ID] = key
+	s.byHash[key.Hash] = key
+	if err := s.save(); err != nil {
+		s.restoreLocked(key.ID, nil)
+		return nil, "", err
+	}
+	return key, secret, nil
+}
+
+// Rotate replaces the secret of a key, invalidating the old one immediately.
+func (s *KeyStore) Rotate(id string) (*APIKey, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return nil, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byID[id]
+	if !ok || existing.RevokedAt != nil {
+		return nil, "", ErrKeyNotFound
+	}
+
+	now := time.Now()
+	key := *existing
+	key.Hash = hashKey(secret)
+	key.Hint = hint(secret)
+	key.RotatedAt = &now
+	s.replaceLocked(&key)
+	if err := s.save(); err != nil {
+		// The old secret keeps working, since the caller never got the
+		// new one.
+		s.restoreLocked(id, existing)
+		return nil, "", err
+	}
+	return &key, secret, nil
+}
+
+// Revoke disables a key. Revoked keys are kept so they show up in listings.
+func (s *KeyStore) Revoke(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.byID[id]
+	if !ok {
+		return ErrKeyNotFound
+	}
+
+	now := time.Now()
+	key := *existing
+	key.RevokedAt = &now
+	s.replaceLocked(&key)
+	if err := s.save(); err != nil {
+		s.restoreLocked(id, existing)
+		return err
+	}
+	delete(s.limiters, key.ID)
+	return nil
+}
+
+// replaceLocked stores key in place of the key with its ID.
+func (s *KeyStore) replaceLocked(key *APIKey) {
+	if current, ok := s.byID[key.ID]; ok {
+		delete(s.byHash, current.Hash)
+	}
+	s.byID[key.ID] = key
+	s.byHash[key.Hash] = key
+}
+
+// restoreLocked puts back the key id as it was before a change that could
+// not be saved, so memory never gets ahead of the file. A nil key means
+// there was none.
+func (s *KeyStore) restoreLocked(id string, key *APIKey) {
+	if key != nil {
+		s.replaceLocked(key)
+		return
+	}
+	if current, ok := s.byID[id]; ok {
+		delete(s.byHash, current.Hash)
+		delete(s.byID, id)
+	}
+}
+
+// List returns a copy of every key.
+func (s *KeyStore) List() []APIKey {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	keys := make([]APIKey, 0, len(s.byID))
+	for _, key := range s.byID {
+		keys = append(keys, *key)
+	}
+	slices.SortFunc(keys, func(a, b APIKey) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return keys
+}
+
+func (s *KeyStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read api keys file: %w", err)
+	}
+
+	var keys []storedKey
+	if err := json.Unmarshal(data, &keys); err != nil {
+		return fmt.Errorf("failed to parse api keys file: %w", err)
+	}
+	for _, entry := range keys {
+		key := entry.APIKey
+		key.Hash = entry.Hash
+		s.byID[key.ID] = &key
+		s.byHash[key.Hash] = &key
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *KeyStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	keys := make([]storedKey, 0, len(s.byID))
+	for _, key := range s.byID {
+		if key.ID == "bootstrap-admin" {
+			continue
+		}
+		keys = append(keys, storedKey{APIKey: *key, Hash: key.Hash})
+	}
+
+	data, err := json.MarshalIndent(keys, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode api keys: %w", err)
+	}
+	if err := util.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write api keys file: %w", err)
+	}
+	return nil
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate api key: %w", err)
+	}
+	return keyPrefix + hex.EncodeToString(buf), nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// hint keeps the last characters of a secret so operators can tell keys apart.
+func hint(secret string) string {
+	if len(secret) <= 4 {
+		return secret
+	}
+	return "..." + secret[len(secret)-4:]
+}
@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newKeyStore(t *testing.T, path string) *KeyStore {
+	t.Helper()
+	t.Setenv("API_KEYS_FILE", path)
+	t.Setenv("ADMIN_API_KEY", "")
+	store, err := NewKeyStoreFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestKeyStoreLifecycle(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store := newKeyStore(t, path)
+	if store.Enabled() {
+		t.Fatal("empty store reports enabled")
+	}
+
+	key, secret, err := store.Create("billing", []Scope{ScopeSend}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(secret, keyPrefix) || key.RateLimit != DefaultRate || key.Burst != DefaultBurst {
+		t.Fatalf("created %+v with secret %q", key, secret)
+	}
+	if found, err := store.Authenticate(secret); err != nil || found.ID != key.ID {
+		t.Fatalf("Authenticate returned %v, %v", found, err)
+	}
+
+	_, rotated, err := store.Rotate(key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(secret); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("old secret still authenticates: %v", err)
+	}
+
+	// The keys survive a restart.
+	reloaded := newKeyStore(t, path)
+	if found, err := reloaded.Authenticate(rotated); err != nil || found.ID != key.ID {
+		t.Fatalf("reloaded store returned %v, %v", found, err)
+	}
+
+	if err := store.Revoke(key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Authenticate(rotated); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("revoked key still authenticates: %v", err)
+	}
+	if _, _, err := store.Rotate(key.ID); !errors.Is(err, ErrKeyNotFound) {
+		t.Fatalf("revoked key could be rotated: %v", err)
+	}
+}
+
+func TestBootstrapAdminKey(t *testing.T) {
+	t.Setenv("API_KEYS_FILE", "")
+	t.Setenv("ADMIN_API_KEY", "pk_bootstrap")
+	store, err := NewKeyStoreFromEnv()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := store.Authenticate("pk_bootstrap")
+	if err != nil || !key.Principal().HasScope(ScopeSend) {
+		t.Fatalf("bootstrap key returned %v, %v", key, err)
+	}
+}
+
+func TestAPIKeyNeverEncodesItsHash(t *testing.T) {
+	encoded, err := json.Marshal(APIKey{ID: "k", Hash: "secret-hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(encoded), "secret-hash") {
+		t.Fatalf("key encodes its hash: %s", encoded)
+	}
+}
+
+func TestKeyStoreRollsBackChangesThatCouldNotBeSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	store := newKeyStore(t, path)
+	key, secret, err := store.Create("billing", []Scope{ScopeSend}, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the file is renamed to makes every save fail.
+	os.Remove(path)
+	os.Mkdir(path, 0o700)
+
+	if _, _, err := store.Create("marketing", []Scope{ScopeSend}, 0, 0); err == nil {
+		t.Fatal("Create succeeded without saving")
+	}
+	if _, _, err := store.Rotate(key.ID); err == nil {
+		t.Fatal("Rotate succeeded without saving")
+	}
+	if err := store.Revoke(key.ID); err == nil {
+		t.Fatal("Revoke succeeded without saving")
+	}
+
+	if keys := store.List(); len(keys) != 1 || keys[0].RevokedAt != nil || keys[0].RotatedAt != nil {
+		t.Fatalf("unsaved changes are kept: %+v", keys)
+	}
+	if _, err := store.Authenticate(secret); err != nil {
+		t.Fatalf("secret stopped working after an unsaved change: %v", err)
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"errors"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"push_service/util"
+
+	"github.com/gin-gonic/gin"
+)
+
+const APIKeyHeader = "X-API-Key"
+
+// Authenticator accepts API keys and, when a verifier is configured, bearer
+// JWTs issued by our other services.
+type Authenticator struct {
+	Keys *KeyStore
+	JWT  *JWTVerifier
+}
+
+// Enabled reports whether any authentication method is configured. With none
+// the send and read endpoints stay open, which keeps local development
+// working without configuration.
+func (a *Authenticator) Enabled() bool {
+	return a.JWT != nil || a.Keys.Enabled()
+}
+
+// errAuthDisabled answers admin and end-user routes while authentication is
+// disabled. Left open, they would let anyone mint keys, point webhooks
+// anywhere or read any user's notifications.
+var errAuthDisabled = errors.New("authentication is not configured; set ADMIN_API_KEY, API_KEYS_FILE or JWKS_URL")
+
+// RequireScope rejects requests whose caller was not granted scope. API key
+// callers are also held to their key's rate limit.
+func RequireScope(a *Authenticator, scope Scope) gin.HandlerFunc {
+	return RequireAnyScope(a, scope)
+}
+
+// RequireAnyScope is RequireScope for routes that callers with any one of
+// scopes may use, such as those shared by services and end users.
+func RequireAnyScope(a *Authenticator, scopes ...Scope) gin.HandlerFunc {
+	names := make([]string, len(scopes))
+	for i, scope := range scopes {
+		names[i] = string(scope)
+	}
+	lacking := errors.New("caller lacks scope " + strings.Join(names, " or "))
+
+	return func(ctx *gin.Context) {
+		if !a.Enabled() {
+			if slices.Contains(scopes, ScopeAdmin) || slices.Contains(scopes, ScopeStream) {
+				ctx.AbortWithStatusJSON(http.StatusServiceUnavailable, util.ErrorResponse(errAuthDisabled))
+				return
+			}
+			ctx.Next()
+			return
+		}
+
+		principal, ok := a.authenticate(ctx)
+		if !ok {
+			return
+		}
+
+		if !slices.ContainsFunc(scopes, principal.HasScope) {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, util.ErrorResponse(lacking))
+			return
+		}
+
+		ctx.Set(PrincipalContext, principal)
+		ctx.Next()
+	}
+}
+
+// authenticate resolves the caller, writing the error response itself when
+// the request is rejected.
+func (a *Authenticator) authenticate(ctx *gin.Context) (*Principal, bool) {
+	if token, ok := strings.CutPrefix(ctx.GetHeader("Authorization"), "Bearer "); ok && a.JWT != nil {
+		principal, err := a.JWT.Verify(strings.TrimSpace(token))
+		if err != nil {
+			ctx.AbortWithStatusJSON(http.StatusUnauthorized, util.ErrorResponse(err))
+			return nil, false
+		}
+		return principal, true
+	}
+
+	secret := apiKeyFromRequest(ctx.Request)
+	if secret == "" {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, util.ErrorResponse(errors.New("missing credentials")))
+		return nil, false
+	}
+
+	key, err := a.Keys.Authenticate(secret)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, util.ErrorResponse(errors.New("invalid api key")))
+		return nil, false
+	}
+
+	reservation := a.Keys.Limiter(key).Reserve()
+	if delay := reservation.Delay(); delay > 0 {
+		reservation.Cancel()
+		ctx.Header("Retry-After", retryAfter(delay))
+		ctx.AbortWithStatusJSON(http.StatusTooManyRequests, util.ErrorResponse(errors.New("rate limit exceeded")))
+		return nil, false
+	}
+	return key.Principal(), true
+}
+
+// TokenFromQuery accepts a bearer token in the access_token query parameter,
+// for clients such as EventSource that cannot set headers. Put it before
+// RequireScope on the routes that need it.
+func TokenFromQuery() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if token := ctx.Query("access_token"); token != "" && ctx.GetHeader("Authorization") == "" {
+			ctx.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		ctx.Next()
+	}
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		return key
+	}
+	if value, ok := strings.CutPrefix(r.Header.Get("Authorization"), "ApiKey "); ok {
+		return strings.TrimSpace(value)
+	}
+	return ""
+}
+
+func retryAfter(delay time.Duration) string {
+	seconds := int(math.Ceil(delay.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	return strconv.Itoa(seconds)
+}
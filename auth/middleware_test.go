@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// serve runs one request through RequireAnyScope(scopes) and returns the
+// response and the principal the handler saw.
+func serve(a *Authenticator, header http.Header, scopes ...Scope) (*httptest.ResponseRecorder, *Principal) {
+	gin.SetMode(gin.TestMode)
+	var seen *Principal
+	router := gin.New()
+	router.GET("/", RequireAnyScope(a, scopes...), func(ctx *gin.Context) {
+		seen, _ = PrincipalFrom(ctx)
+		ctx.Status(http.StatusOK)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	for name, values := range header {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, req)
+	return recorder, seen
+}
+
+func TestRequireScopeFailsClosedWithoutAuthentication(t *testing.T) {
+	a := &Authenticator{Keys: newKeyStore(t, "")}
+
+	for _, scopes := range [][]Scope{{ScopeAdmin}, {ScopeStream}, {ScopeRead, ScopeStream}} {
+		if recorder, _ := serve(a, nil, scopes...); recorder.Code != http.StatusServiceUnavailable {
+			t.Errorf("%v route responded %d, want 503", scopes, recorder.Code)
+		}
+	}
+	if recorder, _ := serve(a, nil, ScopeSend); recorder.Code != http.StatusOK {
+		t.Errorf("send route responded %d, want it open for local development", recorder.Code)
+	}
+}
+
+func TestRequireScopeWithAPIKeys(t *testing.T) {
+	keys := newKeyStore(t, "")
+	a := &Authenticator{Keys: keys}
+	_, sender, _ := keys.Create("sender", []Scope{ScopeSend}, 0, 0)
+	_, reader, _ := keys.Create("reader", []Scope{ScopeRead}, 0, 0)
+	_, limited, _ := keys.Create("limited", []Scope{ScopeSend}, 0.001, 1)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		scopes []Scope
+		want   int
+	}{
+		{"missing credentials", nil, []Scope{ScopeSend}, http.StatusUnauthorized},
+		{"unknown key", http.Header{APIKeyHeader: {"pk_unknown"}}, []Scope{ScopeSend}, http.StatusUnauthorized},
+		{"lacking scope", http.Header{APIKeyHeader: {reader}}, []Scope{ScopeSend}, http.StatusForbidden},
+		{"granted scope", http.Header{APIKeyHeader: {sender}}, []Scope{ScopeSend}, http.StatusOK},
+		{"any of the scopes", http.Header{APIKeyHeader: {reader}}, []Scope{ScopeSend, ScopeRead}, http.StatusOK},
+		{"authorization header", http.Header{"Authorization": {"ApiKey " + sender}}, []Scope{ScopeSend}, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder, principal := serve(a, tt.header, tt.scopes...)
+			if recorder.Code != tt.want {
+				t.Fatalf("responded %d, want %d: %s", recorder.Code, tt.want, recorder.Body)
+			}
+			if tt.want == http.StatusOK && (principal == nil || principal.Method != MethodAPIKey) {
+				t.Fatalf("handler saw principal %+v", principal)
+			}
+		})
+	}
+
+	t.Run("rate limit", func(t *testing.T) {
+		header := http.Header{APIKeyHeader: {limited}}
+		if recorder, _ := serve(a, header, ScopeSend); recorder.Code != http.StatusOK {
+			t.Fatalf("first request responded %d", recorder.Code)
+		}
+		recorder, _ := serve(a, header, ScopeSend)
+		if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") == "" {
+			t.Fatalf("second request responded %d with Retry-After %q", recorder.Code, recorder.Header().Get("Retry-After"))
+		}
+	})
+}
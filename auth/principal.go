@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"slices"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	MethodAPIKey     = "api_key"
+	MethodJWT        = "jwt"
+	PrincipalContext = "principal"
+)
+
+// Principal is the authenticated caller of a request.
+type Principal struct {
+	Subject string   `json:"subject"`
+	Method  string   `json:"method"`
+	Scopes  []Scope  `json:"scopes"`
+	Tenants []string `json:"tenants,omitempty"`
+}
+
+// HasScope reports whether the caller was granted scope. Admin grants every scope.
+func (p *Principal) HasScope(scope Scope) bool {
+	return slices.Contains(p.Scopes, scope) || slices.Contains(p.Scopes, ScopeAdmin)
+}
+
+// AllowsTenant reports whether the caller may act for tenant. Callers without
+// tenant restrictions may act for any tenant.
+func (p *Principal) AllowsTenant(tenant string) bool {
+	return len(p.Tenants) == 0 || slices.Contains(p.Tenants, tenant)
+}
+
+// PrincipalFrom returns the caller stored on ctx by RequireScope.
+func PrincipalFrom(ctx *gin.Context) (*Principal, bool) {
+	value, ok := ctx.Get(PrincipalContext)
+	if !ok {
+		return nil, false
+	}
+	principal, ok := value.(*Principal)
+	return principal, ok
+}
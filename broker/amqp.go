@@ -0,0 +1,304 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+)
+
+// AMQP is a Broker on RabbitMQ. Retries go through a queue whose TTL
+// dead-letters them back to the main exchange, and rejected messages are
+// dead-lettered to the DLX.
+//
+// RabbitMQ only expires messages at the head of a queue, so deferrals can't
+// share one queue with per-message TTLs. They hop instead through queues
+// with fixed delays, the longest that fits the time left each time, until
+// their x-deliver-at header is reached.
+type AMQP struct {
+	conn     *amqp.Connection
+	publish  *amqp.Channel
+	consume  *amqp.Channel
+	topology Topology
+}
+
+// DialAMQP connects to url and declares topology.
+func DialAMQP(url string, topology Topology) (*AMQP, error) {
+	conn, err := amqp.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to RabbitMQ: %w", err)
+	}
+
+	b := &AMQP{conn: conn, topology: topology}
+	if err := b.setUp(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// deferTiers are the delays of the deferral queues. Shorter waits go
+// through the retry queue.
+var deferTiers = []time.Duration{time.Hour, 10 * time.Minute, time.Minute}
+
+const deliverAtHeader = "x-deliver-at"
+
+func (t Topology) deferQueue(tier time.Duration) string {
+	return fmt.Sprintf("%s.defer.%ds", t.RetryQueue, int(tier.Seconds()))
+}
+
+func (b *AMQP) setUp() error {
+	var err error
+	if b.publish, err = b.conn.Channel(); err != nil {
+		return fmt.Errorf("failed to open publisher channel: %w", err)
+	}
+	if err = b.publish.Confirm(false); err != nil {
+		return fmt.Errorf("failed to put publisher channel in confirm mode: %w", err)
+	}
+	if b.consume, err = b.conn.Channel(); err != nil {
+		return fmt.Errorf("failed to open consumer channel: %w", err)
+	}
+
+	t := b.topology
+	ch := b.consume
+	retries := t.RetryExchange != ""
+	deadLetters := t.DeadLetterExchange != ""
+	type step struct {
+		what string
+		when bool
+		run  func() error
+	}
+	steps := []step{
+		{"declare main exchange", true, func() error {
+			kind := "direct"
+			if t.Fanout {
+				kind = "fanout"
+			}
+			return ch.ExchangeDeclare(t.Exchange, kind, true, false, false, false, nil)
+		}},
+		{"declare notifs dlx", deadLetters, func() error {
+			return ch.ExchangeDeclare(t.DeadLetterExchange, "direct", true, false, false, false, nil)
+		}},
+		{"declare retry notifs exchange", retries, func() error {
+			return ch.ExchangeDeclare(t.RetryExchange, "direct", true, false, false, false, nil)
+		}},
+		{"declare main queue", true, func() error {
+			var args amqp.Table
+			if deadLetters {
+				args = amqp.Table{
+					"x-dead-letter-exchange":    t.DeadLetterExchange,
+					"x-dead-letter-routing-key": t.DeadLetterRoutingKey,
+				}
+			}
+			// An instance's fanout queue goes away with its connection.
+			_, err := ch.QueueDeclare(t.Queue, !t.Fanout, t.Fanout, t.Fanout, false, args)
+			return err
+		}},
+		{"bind main queue with main exchange", true, func() error {
+			return ch.QueueBind(t.Queue, t.RoutingKey, t.Exchange, false, nil)
+		}},
+		{"declare notifs dlq", deadLetters, func() error {
+			_, err := ch.QueueDeclare(t.DeadLetterQueue, true, false, false, false, nil)
+			return err
+		}},
+		{"bind dlq to dlx", deadLetters, func() error {
+			return ch.QueueBind(t.DeadLetterQueue, t.DeadLetterRoutingKey, t.DeadLetterExchange, false, nil)
+		}},
+		{"declare retry notifs queue", retries, func() error {
+			_, err := ch.QueueDeclare(t.RetryQueue, true, false, false, false, amqp.Table{
+				"x-dead-letter-exchange":    t.Exchange,
+				"x-message-ttl":             t.RetryDelay.Milliseconds(),
+				"x-dead-letter-routing-key": t.RoutingKey,
+			})
+			return err
+		}},
+		{"bind retry queue to retry exchange", retries, func() error {
+			return ch.QueueBind(t.RetryQueue, t.RetryRoutingKey, t.RetryExchange, false, nil)
+		}},
+	}
+	for _, tier := range deferTiers {
+		queue := t.deferQueue(tier)
+		steps = append(steps, step{"declare " + queue, retries, func() error {
+			_, err := ch.QueueDeclare(queue, true, false, false, false, amqp.Table{
+				"x-dead-letter-exchange":    t.Exchange,
+				"x-message-ttl":             tier.Milliseconds(),
+				"x-dead-letter-routing-key": t.RoutingKey,
+			})
+			if err != nil {
+				return err
+			}
+			return ch.QueueBind(queue, queue, t.RetryExchange, false, nil)
+		}})
+	}
+	for _, step := range steps {
+		if !step.when {
+			continue
+		}
+		if err := step.run(); err != nil {
+			return fmt.Errorf("failed to %s: %w", step.what, err)
+		}
+	}
+	return nil
+}
+
+func (b *AMQP) Publish(ctx context.Context, msg Message) (Confirmation, error) {
+	mode := amqp.Persistent
+	if b.topology.Fanout {
+		mode = amqp.Transient
+	}
+	confirmation, err := b.publish.PublishWithDeferredConfirmWithContext(
+		ctx,
+		b.topology.Exchange,   // exchange
+		b.topology.RoutingKey, // routing key
+		false,                 // mandatory
+		false,                 // immediate
+		amqp.Publishing{
+			DeliveryMode: mode,
+			ContentType:  msg.ContentType,
+			MessageId:    msg.ID,
+			Headers:      amqp.Table(msg.Headers),
+			Body:         msg.Body,
+		})
+	if err != nil {
+		return nil, err
+	}
+	return amqpConfirmation{confirmation}, nil
+}
+
+func (b *AMQP) Retry(ctx context.Context, msg Message) error {
+	return b.publishRetry(ctx, b.topology.RetryRoutingKey, msg)
+}
+
+func (b *AMQP) Defer(ctx context.Context, msg Message, delay time.Duration) error {
+	msg.Headers = maps.Clone(msg.Headers)
+	if msg.Headers == nil {
+		msg.Headers = make(map[string]any)
+	}
+	msg.Headers[deliverAtHeader] = time.Now().Add(delay).UnixMilli()
+	return b.hop(ctx, msg, delay)
+}
+
+// hop sends msg to the longest deferral queue that does not overshoot
+// delay, or to the retry queue when none fits.
+func (b *AMQP) hop(ctx context.Context, msg Message, delay time.Duration) error {
+	for _, tier := range deferTiers {
+		if delay >= tier {
+			return b.publishRetry(ctx, b.topology.deferQueue(tier), msg)
+		}
+	}
+	return b.publishRetry(ctx, b.topology.RetryRoutingKey, msg)
+}
+
+func (b *AMQP) publishRetry(ctx context.Context, routingKey string, msg Message) error {
+	return b.consume.PublishWithContext(
+		ctx,
+		b.topology.RetryExchange, // exchange
+		routingKey,               // routing key
+		false,                    // mandatory
+		false,                    // immediate
+		amqp.Publishing{
+			ContentType: msg.ContentType,
+			MessageId:   msg.ID,
+			Headers:     amqp.Table(msg.Headers),
+			Body:        msg.Body,
+		})
+}
+
+func (b *AMQP) Consume(prefetch int) (<-chan Delivery, error) {
+	if err := b.consume.Qos(prefetch, 0, false); err != nil {
+		return nil, fmt.Errorf("failed to set QoS: %w", err)
+	}
+
+	msgs, err := b.consume.Consume(
+		b.topology.Queue, // queue
+		"",               // consumer
+		false,            // auto-ack
+		false,            // exclusive
+		false,            // no-local
+		false,            // no-wait
+		nil,              // args
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	deliveries := make(chan Delivery)
+	go func() {
+		defer close(deliveries)
+		for d := range msgs {
+			d := d
+			if b.stillDeferred(d) {
+				continue
+			}
+			deliveries <- Delivery{
+				Message: Message{
+					ID:          d.MessageId,
+					ContentType: d.ContentType,
+					Body:        d.Body,
+					Headers:     d.Headers,
+				},
+				ack:  func() error { return d.Ack(false) },
+				nack: func(requeue bool) error { return d.Nack(false, requeue) },
+			}
+		}
+		log.Printf("Consumer channel for %s closed", b.topology.Queue)
+	}()
+	return deliveries, nil
+}
+
+// stillDeferred sends a deferred message on its next hop when it arrives
+// before its x-deliver-at time. If that fails the message is delivered
+// early, which the workers handle like any other redelivery.
+func (b *AMQP) stillDeferred(d amqp.Delivery) bool {
+	deliverAt, ok := d.Headers[deliverAtHeader].(int64)
+	if !ok {
+		return false
+	}
+	remaining := time.Until(time.UnixMilli(deliverAt))
+	if remaining <= time.Second {
+		return false
+	}
+
+	err := b.hop(context.Background(), Message{
+		ID:          d.MessageId,
+		ContentType: d.ContentType,
+		Body:        d.Body,
+		Headers:     d.Headers,
+	}, remaining)
+	if err != nil {
+		log.Printf("Could not defer %s further, delivering it early: %v", d.MessageId, err)
+		return false
+	}
+	if err := d.Ack(false); err != nil {
+		log.Printf("Failed to ack deferred message %s: %v", d.MessageId, err)
+	}
+	return true
+}
+
+func (b *AMQP) Close() error {
+	return b.conn.Close()
+}
+
+type amqpConfirmation struct {
+	confirmation *amqp.DeferredConfirmation
+}
+
+// Wait returns at once when the channel is not in confirm mode.
+func (c amqpConfirmation) Wait(ctx context.Context) error {
+	if c.confirmation == nil {
+		return nil
+	}
+
+	acked, err := c.confirmation.WaitContext(ctx)
+	if err != nil {
+		return fmt.Errorf("timed out waiting for broker confirm: %w", err)
+	}
+	if !acked {
+		return errors.New("broker rejected the message")
+	}
+	return nil
+}
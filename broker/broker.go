@@ -0,0 +1,97 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Message is a notification travelling through the broker.
+type Message struct {
+	ID          string
+	ContentType string
+	Body        []byte
+	Headers     map[string]any
+}
+
+// Delivery is a message handed to a consumer. It must be acked or nacked
+// exactly once.
+type Delivery struct {
+	Message
+	ack  func() error
+	nack func(requeue bool) error
+}
+
+func (d Delivery) Ack() error {
+	return d.ack()
+}
+
+// Nack rejects the delivery. Without requeue the message is dead-lettered.
+func (d Delivery) Nack(requeue bool) error {
+	return d.nack(requeue)
+}
+
+// Confirmation resolves once the broker has taken responsibility for a
+// published message.
+type Confirmation interface {
+	Wait(ctx context.Context) error
+}
+
+// Broker carries notifications from the API to the workers.
+type Broker interface {
+	// Publish sends msg to the main queue.
+	Publish(ctx context.Context, msg Message) (Confirmation, error)
+	// Retry redelivers msg to the main queue after the retry delay.
+	Retry(ctx context.Context, msg Message) error
+	// Defer redelivers msg to the main queue once delay has passed, which
+	// may be far longer than the retry delay.
+	Defer(ctx context.Context, msg Message, delay time.Duration) error
+	// Consume delivers messages from the main queue, with at most prefetch
+	// of them unacknowledged at a time.
+	Consume(prefetch int) (<-chan Delivery, error)
+	Close() error
+}
+
+// Topology names the exchanges and queues behind a broker. The retry and
+// dead letter parts are optional, for queues that are only published to.
+type Topology struct {
+	Exchange   string
+	RoutingKey string
+	Queue      string
+	// Fanout makes Exchange a fanout exchange and Queue a transient queue
+	// of this instance alone, so every instance receives every message.
+	Fanout bool
+
+	// Retried messages wait in RetryQueue for RetryDelay and are then
+	// dead-lettered back to Exchange.
+	RetryExchange   string
+	RetryRoutingKey string
+	RetryQueue      string
+	RetryDelay      time.Duration
+
+	DeadLetterExchange   string
+	DeadLetterRoutingKey string
+	DeadLetterQueue      string
+}
+
+// ErrClosed is returned once the broker has been closed.
+var ErrClosed = errors.New("broker is closed")
+
+// NewFromEnv picks the implementation from BROKER: amqp (the default, which
+// connects to RABBITMQ_URL) or memory.
+func NewFromEnv(topology Topology) (Broker, error) {
+	switch kind := os.Getenv("BROKER"); kind {
+	case "", "amqp":
+		url := os.Getenv("RABBITMQ_URL")
+		if url == "" {
+			return nil, errors.New("RABBITMQ_URL is not set")
+		}
+		return DialAMQP(url, topology)
+	case "memory":
+		return NewMemory(topology), nil
+	default:
+		return nil, fmt.Errorf("unknown BROKER %q, want amqp or memory", kind)
+	}
+}
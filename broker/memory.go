@@ -0,0 +1,196 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"sync"
+	"time"
+)
+
+var errSettled = errors.New("delivery was already acked or nacked")
+
+// Memory is an in-process Broker for local development and tests. It keeps
+// the AMQP semantics the workers rely on: unacked messages count against
+// prefetch, requeued messages go back to the head of the queue, rejected
+// messages are dead-lettered, and retries wait out the retry delay in FIFO
+// order like messages in a TTL queue. Nothing survives a restart.
+type Memory struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	ready  []Message
+	dead   []Message
+	delay  time.Duration
+	timers map[*time.Timer]struct{}
+	closed bool
+}
+
+func NewMemory(topology Topology) *Memory {
+	b := &Memory{delay: topology.RetryDelay, timers: make(map[*time.Timer]struct{})}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+func (b *Memory) Publish(_ context.Context, msg Message) (Confirmation, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil, ErrClosed
+	}
+	b.ready = append(b.ready, clone(msg))
+	b.cond.Signal()
+	return memoryConfirmation{}, nil
+}
+
+func (b *Memory) Retry(ctx context.Context, msg Message) error {
+	return b.Defer(ctx, msg, b.delay)
+}
+
+func (b *Memory) Defer(_ context.Context, msg Message, delay time.Duration) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
+	msg = clone(msg)
+	var timer *time.Timer
+	timer = time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		delete(b.timers, timer)
+		if b.closed {
+			return
+		}
+		b.ready = append(b.ready, msg)
+		b.cond.Signal()
+	})
+	b.timers[timer] = struct{}{}
+	return nil
+}
+
+func (b *Memory) Consume(prefetch int) (<-chan Delivery, error) {
+	if prefetch <= 0 {
+		prefetch = 1
+	}
+
+	b.mu.Lock()
+	closed := b.closed
+	b.mu.Unlock()
+	if closed {
+		return nil, ErrClosed
+	}
+
+	deliveries := make(chan Delivery)
+	unacked := make(chan struct{}, prefetch)
+	go func() {
+		defer close(deliveries)
+		for {
+			unacked <- struct{}{}
+			msg, ok := b.next()
+			if !ok {
+				return
+			}
+			deliveries <- b.delivery(msg, unacked)
+		}
+	}()
+	return deliveries, nil
+}
+
+// next blocks until a message is ready or the broker is closed.
+func (b *Memory) next() (Message, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.ready) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return Message{}, false
+	}
+
+	msg := b.ready[0]
+	b.ready = b.ready[1:]
+	return msg, true
+}
+
+func (b *Memory) delivery(msg Message, unacked chan struct{}) Delivery {
+	var once sync.Once
+	settle := func(fn func()) error {
+		settled := false
+		once.Do(func() {
+			settled = true
+			fn()
+			<-unacked
+		})
+		if !settled {
+			return errSettled
+		}
+		return nil
+	}
+
+	return Delivery{
+		Message: msg,
+		ack: func() error {
+			return settle(func() {})
+		},
+		nack: func(requeue bool) error {
+			return settle(func() {
+				b.mu.Lock()
+				defer b.mu.Unlock()
+
+				if requeue {
+					b.ready = append([]Message{msg}, b.ready...)
+					b.cond.Signal()
+				} else {
+					b.dead = append(b.dead, msg)
+				}
+			})
+		},
+	}
+}
+
+// DeadLetters returns the messages that were rejected without requeue.
+func (b *Memory) DeadLetters() []Message {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]Message(nil), b.dead...)
+}
+
+// Pending returns the number of messages waiting in the main queue, the
+// retry delay or a deferral.
+func (b *Memory) Pending() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.ready) + len(b.timers)
+}
+
+func (b *Memory) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for timer := range b.timers {
+		timer.Stop()
+	}
+	b.timers = nil
+	b.cond.Broadcast()
+	return nil
+}
+
+// clone copies the headers so publishers and consumers can't change a
+// message behind the broker's back, as they couldn't over the wire.
+func clone(msg Message) Message {
+	msg.Headers = maps.Clone(msg.Headers)
+	msg.Body = append([]byte(nil), msg.Body...)
+	return msg
+}
+
+type memoryConfirmation struct{}
+
+func (memoryConfirmation) Wait(context.Context) error {
+	return nil
+}
@@ -0,0 +1,126 @@
+package broker
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// receive waits for the next delivery.
+func receive(t *testing.T, deliveries <-chan Delivery) Delivery {
+	t.Helper()
+	select {
+	case d, ok := <-deliveries:
+		if !ok {
+			t.Fatal("deliveries closed")
+		}
+		return d
+	case <-time.After(time.Second):
+		t.Fatal("nothing was delivered")
+	}
+	return Delivery{}
+}
+
+// idle fails if anything is delivered for a while.
+func idle(t *testing.T, deliveries <-chan Delivery) {
+	t.Helper()
+	select {
+	case d := <-deliveries:
+		t.Fatalf("%s was delivered", d.ID)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestMemoryHoldsBackMessagesOverThePrefetch(t *testing.T) {
+	b := NewMemory(Topology{})
+	defer b.Close()
+	for _, id := range []string{"a", "b"} {
+		b.Publish(context.Background(), Message{ID: id})
+	}
+	deliveries, err := b.Consume(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := receive(t, deliveries)
+	if first.ID != "a" {
+		t.Fatalf("%s was delivered first", first.ID)
+	}
+	idle(t, deliveries)
+
+	first.Ack()
+	if second := receive(t, deliveries); second.ID != "b" {
+		t.Fatalf("%s was delivered second", second.ID)
+	}
+	if err := first.Ack(); err == nil {
+		t.Fatal("a settled delivery was acked again")
+	}
+}
+
+func TestMemoryRequeuesAndDeadLetters(t *testing.T) {
+	b := NewMemory(Topology{})
+	defer b.Close()
+	for _, id := range []string{"a", "b"} {
+		b.Publish(context.Background(), Message{ID: id})
+	}
+	deliveries, _ := b.Consume(1)
+
+	// A requeued message goes back to the head of the queue.
+	receive(t, deliveries).Nack(true)
+	rejected := receive(t, deliveries)
+	if rejected.ID != "a" {
+		t.Fatalf("%s was delivered after a requeue, want a", rejected.ID)
+	}
+	rejected.Nack(false)
+	receive(t, deliveries).Ack()
+
+	dead := b.DeadLetters()
+	if len(dead) != 1 || dead[0].ID != "a" {
+		t.Fatalf("dead letters are %+v, want a", dead)
+	}
+	if pending := b.Pending(); pending != 0 {
+		t.Fatalf("%d messages pending", pending)
+	}
+}
+
+func TestMemoryRetriesAfterTheDelay(t *testing.T) {
+	b := NewMemory(Topology{RetryDelay: 100 * time.Millisecond})
+	defer b.Close()
+	deliveries, _ := b.Consume(1)
+
+	headers := map[string]any{"x-retry-count": int64(2)}
+	if err := b.Retry(context.Background(), Message{ID: "a", Headers: headers}); err != nil {
+		t.Fatal(err)
+	}
+	// The broker keeps its own copy, as it would over the wire.
+	headers["x-retry-count"] = int64(5)
+	if pending := b.Pending(); pending != 1 {
+		t.Fatalf("%d messages pending during the delay, want 1", pending)
+	}
+	idle(t, deliveries)
+
+	retried := receive(t, deliveries)
+	if retried.ID != "a" || retried.Headers["x-retry-count"] != int64(2) {
+		t.Fatalf("retried %s with headers %v", retried.ID, retried.Headers)
+	}
+}
+
+func TestMemoryStopsOnClose(t *testing.T) {
+	b := NewMemory(Topology{})
+	deliveries, _ := b.Consume(1)
+	b.Defer(context.Background(), Message{ID: "a"}, time.Hour)
+	b.Close()
+
+	select {
+	case _, ok := <-deliveries:
+		if ok {
+			t.Fatal("a message was delivered after Close")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("deliveries stayed open after Close")
+	}
+	if _, err := b.Publish(context.Background(), Message{ID: "b"}); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Publish after Close returned %v", err)
+	}
+}
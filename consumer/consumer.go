@@ -3,23 +3,26 @@ package consumer
 import (
 	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"log"
 	"os"
+	"time"
 
+	"push_service/broker"
+	"push_service/frequency"
 	"push_service/models"
 	sendNotification "push_service/sendNotification"
-	"push_service/util"
+	"push_service/status"
 
 	firebase "firebase.google.com/go/v4"
-	amqp "github.com/rabbitmq/amqp091-go"
+	"firebase.google.com/go/v4/messaging"
 	"google.golang.org/api/option"
 )
 
-func StartConsumer(ch *amqp.Channel, c *models.Consumer) {
-	SetUp(c, ch)
-
+func StartConsumer(c *models.Consumer) {
 	for r := 0; r < c.WorkerCount; r++ {
-		if c.Channel != nil {
+		if c.Broker != nil {
 			go NewWorker(c, r)
 		} else {
 			log.Fatal("Couldn't launch workers")
@@ -31,122 +34,8 @@ func StartConsumer(ch *amqp.Channel, c *models.Consumer) {
 	<-forever
 }
 
-func SetUp(c *models.Consumer, ch *amqp.Channel) {
-	c.Channel = ch
-	// Declare the notification dlx
-	err := ch.ExchangeDeclare(
-		models.DlxName, // name
-		"direct",       // type (direct, fanout, topic, etc.)
-		true,           // durable
-		false,          // auto-deleted
-		false,          // internal
-		false,          // no-wait
-		nil,            // arguments
-	)
-	util.FailOnError(err, "Failed to declare notifs dlx")
-
-	err = ch.ExchangeDeclare(
-		models.RetryExName, // name
-		"direct",           // type (direct, fanout, topic, etc.)
-		true,               // durable
-		false,              // auto-deleted
-		false,              // internal
-		false,              // no-wait
-		nil,                // arguments
-	)
-	util.FailOnError(err, "Failed to declare retry notifs exchange")
-
-	// Configure channel
-	err = ch.Qos(
-		c.PrefetchCount, // prefetch count
-		0,               // prefetch size
-		false,           // global
-	)
-	util.FailOnError(err, "Failed to set QoS")
-
-	// Declare arguments for queue
-	args := amqp.Table{
-		"x-dead-letter-exchange":    models.DlxName,
-		"x-dead-letter-routing-key": models.DlqRoutingKey,
-	}
-
-	_, err = ch.QueueDeclare(
-		c.QueueName, // name
-		true,        // durable
-		false,       // auto-deleted
-		false,       // exclusive
-		false,       // no-wait
-		args,        // arguments
-	)
-	util.FailOnError(err, "Failed to declare main queue")
-
-	err = ch.QueueBind(
-		c.QueueName,       // queue name
-		models.RoutingKey, // routing key
-		models.ExName,     // exchange
-		false,
-		nil)
-	util.FailOnError(err, "Failed to bind main queue with main exchange")
-
-	dlqName := "my-app-dlq"
-
-	_, err = ch.QueueDeclare(
-		dlqName, // name
-		true,    // durable
-		false,   // auto-deleted
-		false,   // exclusive
-		false,   // no-wait
-		nil,     // arguments
-	)
-	util.FailOnError(err, "Failed to declare notifs dlq")
-
-	err = ch.QueueBind(
-		dlqName,              // queue name
-		models.DlqRoutingKey, // routing key
-		models.DlxName,       // exchange name
-		false,                // no-wait
-		nil,                  // arguments
-	)
-	util.FailOnError(err, "Failed to bind dlq to dlx")
-
-	args = amqp.Table{
-		"x-dead-letter-exchange":    models.ExName,
-		"x-message-ttl":             models.RetryDelayMs,
-		"x-dead-letter-routing-key": models.RoutingKey,
-	}
-
-	_, err = ch.QueueDeclare(
-		models.RetryQueueName, // name
-		true,                  // durable
-		false,                 // auto-deleted
-		false,                 // exclusive
-		false,                 // no-wait
-		args,                  // arguments
-	)
-	util.FailOnError(err, "Failed to declare retry notifs queue")
-
-	err = ch.QueueBind(
-		models.RetryQueueName,       // queue name
-		models.RetryQueueRoutingKey, // routing key
-		models.RetryExName,          // exchange name
-		false,                       // no-wait
-		nil,                         // arguments
-	)
-	util.FailOnError(err, "Failed to bind retry_notifs queue to retry_notifs exchange")
-
-	setUpFirebaseClient(c)
-}
-
 func NewWorker(c *models.Consumer, id int) {
-	msgs, err := c.Channel.Consume(
-		c.QueueName, // queue
-		"",          // consumer
-		false,       // auto-ack
-		false,       // exclusive
-		false,       // no-local
-		false,       // no-wait
-		nil,         // args
-	)
+	msgs, err := c.Broker.Consume(c.PrefetchCount)
 	if err != nil {
 		log.Printf("Worker %d Failed to register as a consumer: %s", id, err)
 		return
@@ -154,7 +43,7 @@ func NewWorker(c *models.Consumer, id int) {
 
 	go func() {
 		for d := range msgs {
-			c.ConsumerMetrics.MessagesProcessed += 1
+			c.Metrics.Processed.Add(1)
 
 			var headerRetryCount int64 = 1
 			if val, ok := d.Headers["x-retry-count"]; ok {
@@ -164,50 +53,114 @@ func NewWorker(c *models.Consumer, id int) {
 			}
 			log.Printf("Worker %d Received a message (Attempt %d)", id, headerRetryCount)
 
-			var notifMessageRequest models.NotifMessageRequest
-			err := json.Unmarshal(d.Body, &notifMessageRequest)
+			var queued models.QueuedNotification
+			err := json.Unmarshal(d.Body, &queued)
 			if err != nil {
 				log.Printf("Worker %d FAILED to unmarshal JSON: %v. Sending to DLX.", id, err)
-				d.Nack(false, false)
+				d.Nack(false)
+				continue
+			}
+			notifMessageRequest := queued.NotifMessageRequest
+			notifMessageRequest.Caller = queued.Caller
+
+			if notifMessageRequest.Caller != "" {
+				log.Printf("Worker %d processing message %s queued by %q", id, notifMessageRequest.ID, notifMessageRequest.Caller)
+			}
+
+			var deferrals int64
+			if val, ok := d.Headers["x-deferral-count"]; ok {
+				if count, ok := val.(int64); ok {
+					deferrals = count
+				}
+			}
+
+			// x-channel is the position in the fallback chain a retry
+			// resumes from.
+			var channel int64
+			if val, ok := d.Headers["x-channel"]; ok {
+				if index, ok := val.(int64); ok {
+					channel = index
+				}
+			}
+
+			c.Status.Update(notifMessageRequest.ID, func(r *status.Record) {
+				r.State = status.Processing
+				r.Attempts = int(headerRetryCount)
+			})
+
+			dryRun := sendNotification.IsDryRun(c, notifMessageRequest)
+			capMessage := sendNotification.FrequencyMessage(c, notifMessageRequest, deferrals)
+			// Frequency caps only apply to push; a suppressed push falls
+			// back to the next channel. The place a push reserves under
+			// the caps is given back unless the push is what got through.
+			var reservation *frequency.Reservation
+			admit := func(through models.NotificationType) error {
+				if through != models.Push {
+					return nil
+				}
+				var err error
+				reservation, err = c.Limiter.Reserve(context.Background(), capMessage)
+				return err
+			}
+
+			outcome, err := sendNotification.Deliver(context.Background(), c, notifMessageRequest, int(channel), admit)
+			if err == nil && outcome.Channel == models.Push {
+				reservation.Sent(context.Background())
+			} else {
+				reservation.Release(context.Background())
+			}
+			var suppressed *frequency.SuppressedError
+			if errors.As(err, &suppressed) {
+				handleSuppressed(c, id, d, notifMessageRequest.ID, headerRetryCount, deferrals, int64(outcome.Index), suppressed)
 				continue
 			}
 
-			err = sendNotification.SendNotification(context.Background(), c, notifMessageRequest)
 			if err != nil {
 				log.Printf("Worker failed: %v", err)
-				if headerRetryCount < models.MaxRetries {
+				retryable := !sendNotification.IsPermanent(err) && headerRetryCount < models.MaxRetries
+				recordFailure(c, notifMessageRequest.ID, err, retryable)
+				if sendNotification.IsPermanent(err) {
+					log.Printf("Permanent failure, sending to DLX without retrying")
+					d.Nack(false)
+					c.Metrics.Failed.Add(1)
+				} else if headerRetryCount < models.MaxRetries {
 					log.Println("Started retrying")
-					d.Ack(false)
-
-					err = c.Channel.Publish(
-						models.RetryExName,          // exchange
-						models.RetryQueueRoutingKey, // routing key (use original)
-						false,                       // mandatory
-						false,                       // immediate
-						amqp.Publishing{
-							ContentType: d.ContentType,
-							Body:        d.Body,
-							Headers: amqp.Table{
-								"x-retry-count": headerRetryCount + 1,
-							},
+					d.Ack()
+
+					err = c.Broker.Retry(context.Background(), broker.Message{
+						ID:          d.ID,
+						ContentType: d.ContentType,
+						Body:        d.Body,
+						Headers: map[string]any{
+							"x-retry-count": headerRetryCount + 1,
+							"x-channel":     int64(outcome.Index),
 						},
-					)
-					c.ConsumerMetrics.MessagesRetried += 1
+					})
+					c.Metrics.Retried.Add(1)
 					log.Println("Finished publishing retry")
 					if err != nil {
 						log.Printf("Error publishing to retry exchange: %s", err)
 					}
 				} else {
 					log.Printf("Max retries (%d) exceeded. Sending to DLX.", models.MaxRetries)
-					d.Nack(false, false)
-					c.ConsumerMetrics.MessagesFailed += 1
+					d.Nack(false)
+					c.Metrics.Failed.Add(1)
 					log.Printf("Sent to DLX successfully")
 				}
 			} else {
-				if ackErr := d.Ack(false); ackErr != nil {
+				c.Status.Update(notifMessageRequest.ID, func(r *status.Record) {
+					r.State = status.Sent
+					if dryRun {
+						r.State = status.Validated
+					}
+					r.MessageID = outcome.MessageID
+					r.Error = ""
+					r.ErrorClass = ""
+				})
+				if ackErr := d.Ack(); ackErr != nil {
 					log.Printf(" [Worker %d] Failed to ack: %v", id, ackErr)
 				} else {
-					c.ConsumerMetrics.MessagesSucceeded += 1
+					c.Metrics.Succeeded.Add(1)
 					log.Printf(" [Worker %d] Message acknowledged", id)
 				}
 			}
@@ -215,24 +168,94 @@ func NewWorker(c *models.Consumer, id int) {
 	}()
 }
 
-func setUpFirebaseClient(c *models.Consumer) {
-	ctx := context.Background()
+func recordFailure(c *models.Consumer, requestID string, err error, retryable bool) {
+	c.Status.Update(requestID, func(r *status.Record) {
+		r.State = status.DeadLettered
+		if retryable {
+			r.State = status.Retrying
+		}
+		r.Error = err.Error()
+		r.ErrorClass = string(sendNotification.Classify(err))
+	})
+}
 
-	serviceAccountJSON := os.Getenv("GOOGLE_SERVICE_ACCOUNT")
-	opt := option.WithCredentialsJSON([]byte(serviceAccountJSON))
+// handleSuppressed drops a message that is over a frequency cap, or defers
+// it until the cap's window reopens without using up a retry.
+func handleSuppressed(c *models.Consumer, id int, d broker.Delivery, requestID string, retryCount, deferrals, channel int64, suppressed *frequency.SuppressedError) {
+	c.Status.Update(requestID, func(r *status.Record) {
+		r.State = status.Deferred
+		if suppressed.Action == frequency.PolicyDrop {
+			r.State = status.Suppressed
+		}
+		r.Error = suppressed.Reason
+	})
 
-	config := firebase.Config{
-		ProjectID: "pushservice-8f271",
+	if suppressed.Action == frequency.PolicyDrop {
+		log.Printf("Worker %d dropped message: %s", id, suppressed.Reason)
+		if err := d.Ack(); err != nil {
+			log.Printf(" [Worker %d] Failed to ack: %v", id, err)
+		}
+		c.Metrics.Suppressed.Add(1)
+		return
 	}
 
-	app, err := firebase.NewApp(ctx, &config, opt)
+	log.Printf("Worker %d deferred message until %s (%s, deferral %d): %s", id, suppressed.Until.Format(time.RFC3339), suppressed.Action, deferrals+1, suppressed.Reason)
+	err := c.Broker.Defer(context.Background(), broker.Message{
+		ID:          d.ID,
+		ContentType: d.ContentType,
+		Body:        d.Body,
+		Headers: map[string]any{
+			"x-retry-count":    retryCount,
+			"x-deferral-count": deferrals + 1,
+			"x-channel":        channel,
+		},
+	}, time.Until(suppressed.Until))
 	if err != nil {
-		log.Fatalf("Error initializing app: %v\n", err)
+		log.Printf("Error publishing deferred message to retry exchange: %s", err)
+		d.Nack(true)
+		return
+	}
+
+	if err := d.Ack(); err != nil {
+		log.Printf(" [Worker %d] Failed to ack: %v", id, err)
 	}
+	c.Metrics.Deferred.Add(1)
+}
 
-	client, err := app.Messaging(ctx)
+// SetUpFirebaseClient creates the FCM client shared by the workers and the
+// topic management endpoints. FCM_ENDPOINT points it at another FCM v1
+// server, such as the fake-fcm subcommand of fakeServices.
+func SetUpFirebaseClient(c *models.Consumer) {
+	client, err := NewFirebaseClient(context.Background(), os.Getenv("FCM_ENDPOINT"), os.Getenv("GOOGLE_SERVICE_ACCOUNT"))
 	if err != nil {
 		log.Fatalf("Error getting Messaging client: %v\n", err)
 	}
 	c.Client = client
 }
+
+// NewFirebaseClient creates an FCM client. A non-empty endpoint replaces the
+// FCM API URL; without a service account the requests to it are then sent
+// unauthenticated.
+func NewFirebaseClient(ctx context.Context, endpoint, serviceAccountJSON string) (*messaging.Client, error) {
+	opts := []option.ClientOption{option.WithCredentialsJSON([]byte(serviceAccountJSON))}
+
+	if endpoint != "" {
+		log.Printf("Sending FCM requests to %s", endpoint)
+		opts = []option.ClientOption{option.WithEndpoint(endpoint)}
+		if serviceAccountJSON != "" {
+			opts = append(opts, option.WithCredentialsJSON([]byte(serviceAccountJSON)))
+		} else {
+			opts = append(opts, option.WithoutAuthentication())
+		}
+	}
+
+	config := firebase.Config{
+		ProjectID: "pushservice-8f271",
+	}
+
+	app, err := firebase.NewApp(ctx, &config, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("error initializing app: %w", err)
+	}
+	return app.Messaging(ctx)
+}
@@ -0,0 +1,122 @@
+package consumer
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"push_service/broker"
+	"push_service/frequency"
+	"push_service/models"
+	"push_service/sandbox"
+	sendNotification "push_service/sendNotification"
+	"push_service/status"
+)
+
+// failingChannel fails every send with class.
+type failingChannel struct {
+	class sendNotification.ErrorClass
+}
+
+func (f failingChannel) Send(context.Context, models.NotifMessageRequest, *models.User) (string, error) {
+	return "", &sendNotification.DeliveryError{Class: f.class, Err: errors.New("webhook failed")}
+}
+
+// startWorker runs one worker for c on a broker whose retries wait an hour,
+// so a retried message stays pending.
+func startWorker(t *testing.T, c *models.Consumer) *broker.Memory {
+	t.Helper()
+	b := broker.NewMemory(broker.Topology{RetryDelay: time.Hour})
+	t.Cleanup(func() { b.Close() })
+	c.Broker = b
+	c.PrefetchCount = 1
+	c.Status = status.NewStore(status.DefaultMaxRecords)
+	NewWorker(c, 0)
+	return b
+}
+
+func publish(t *testing.T, c *models.Consumer, req models.NotifMessageRequest) {
+	t.Helper()
+	title, body := "Shipped", "On its way"
+	req.Title, req.Body = &title, &body
+	c.Status.Create(status.Record{ID: req.ID, State: status.Queued})
+	encoded, _ := json.Marshal(models.QueuedNotification{NotifMessageRequest: req})
+	if _, err := c.Broker.Publish(context.Background(), broker.Message{ID: req.ID, Body: encoded}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually polls done, since the worker records the state of a message
+// before it settles it.
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting until %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func stateOf(c *models.Consumer, id string) status.State {
+	record, _ := c.Status.Get(id)
+	return record.State
+}
+
+func TestTransientFailuresAreRetried(t *testing.T) {
+	c := &models.Consumer{Channels: map[models.NotificationType]models.Channel{
+		models.Webhook: failingChannel{sendNotification.ClassUnavailable},
+	}}
+	b := startWorker(t, c)
+
+	publish(t, c, models.NotifMessageRequest{ID: "n-1", Channels: []models.NotificationType{models.Webhook}})
+	eventually(t, "the message waits for a retry", func() bool {
+		return b.Pending() == 1 && c.Metrics.Retried.Load() == 1
+	})
+	record, _ := c.Status.Get("n-1")
+	if record.State != status.Retrying || record.ErrorClass != string(sendNotification.ClassUnavailable) {
+		t.Fatalf("recorded %q with class %q", record.State, record.ErrorClass)
+	}
+}
+
+func TestPermanentFailuresAreDeadLettered(t *testing.T) {
+	c := &models.Consumer{Channels: map[models.NotificationType]models.Channel{
+		models.Webhook: failingChannel{sendNotification.ClassInvalidArgument},
+	}}
+	b := startWorker(t, c)
+
+	publish(t, c, models.NotifMessageRequest{ID: "n-1", Channels: []models.NotificationType{models.Webhook}})
+	eventually(t, "the message is dead-lettered", func() bool {
+		return len(b.DeadLetters()) == 1 && c.Metrics.Failed.Load() == 1
+	})
+	if state := stateOf(c, "n-1"); state != status.DeadLettered || b.Pending() != 0 {
+		t.Fatalf("recorded %q with %d pending after a permanent failure", state, b.Pending())
+	}
+}
+
+func TestPushesOverACapAreDeferred(t *testing.T) {
+	captures := sandbox.NewStore(10)
+	c := &models.Consumer{
+		Sender:  captures,
+		Limiter: frequency.NewLimiter(frequency.NewMemoryStore(), frequency.Config{UserHourly: 1, Policy: frequency.PolicyDefer, MaxDeferrals: 3}),
+	}
+	b := startWorker(t, c)
+	token := "token-1"
+
+	publish(t, c, models.NotifMessageRequest{ID: "n-1", PushToken: &token})
+	eventually(t, "the first push is sent", func() bool { return c.Metrics.Succeeded.Load() == 1 })
+	publish(t, c, models.NotifMessageRequest{ID: "n-2", PushToken: &token})
+	eventually(t, "the second push waits for the window", func() bool {
+		return b.Pending() == 1 && c.Metrics.Deferred.Load() == 1
+	})
+
+	if state := stateOf(c, "n-2"); state != status.Deferred {
+		t.Fatalf("second push is %q", state)
+	}
+	if captured := captures.List(sandbox.Filter{}, 0); len(captured) != 1 {
+		t.Fatalf("%d pushes were sent, want 1", len(captured))
+	}
+}
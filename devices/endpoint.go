@@ -0,0 +1,76 @@
+package devices
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+
+	"push_service/util"
+)
+
+var ErrEndpointNotAllowed = errors.New("push endpoint not allowed")
+
+// AllowedHostsFromEnv reads WEBPUSH_ALLOWED_HOSTS, a comma separated list
+// of push service host names, such as fcm.googleapis.com.
+func AllowedHostsFromEnv() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("WEBPUSH_ALLOWED_HOSTS"), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if strings.ContainsAny(host, "/:@") {
+			log.Printf("Ignoring invalid WEBPUSH_ALLOWED_HOSTS entry %q", host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// CheckEndpoint reports whether notifications may be pushed to the
+// subscription endpoint raw. Endpoints come from browsers, so without an
+// allowlist only https URLs on public addresses are accepted. With one, the
+// host must be on it and may then use plain http.
+func (s *Store) CheckEndpoint(raw string) error {
+	endpoint, err := url.Parse(raw)
+	if err != nil || (endpoint.Scheme != "https" && endpoint.Scheme != "http") || endpoint.Hostname() == "" {
+		return fmt.Errorf("%w: %q must be an absolute https URL", ErrEndpointNotAllowed, raw)
+	}
+	if endpoint.User != nil {
+		return fmt.Errorf("%w: %q must not contain credentials", ErrEndpointNotAllowed, raw)
+	}
+
+	host := strings.ToLower(endpoint.Hostname())
+	if len(s.AllowedHosts) > 0 {
+		if !slices.Contains(s.AllowedHosts, host) {
+			return fmt.Errorf("%w: %s is not in WEBPUSH_ALLOWED_HOSTS", ErrEndpointNotAllowed, host)
+		}
+		return nil
+	}
+	if endpoint.Scheme != "https" {
+		return fmt.Errorf("%w: %q must use https", ErrEndpointNotAllowed, raw)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !util.PublicAddr(addr) {
+		return fmt.Errorf("%w: %s is not a public address", ErrEndpointNotAllowed, host)
+	}
+	return nil
+}
+
+// DialControl refuses connections to non-public addresses unless there is
+// an allowlist, which catches host names that resolve to internal ones.
+func (s *Store) DialControl(network, address string, conn syscall.RawConn) error {
+	if len(s.AllowedHosts) > 0 {
+		return nil
+	}
+	if err := util.DialPublic(network, address, conn); err != nil {
+		return fmt.Errorf("%w: %v", ErrEndpointNotAllowed, err)
+	}
+	return nil
+}
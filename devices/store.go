@@ -0,0 +1,222 @@
+package devices
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"push_service/util"
+
+	"github.com/google/uuid"
+)
+
+// Platform is the kind of device a registration delivers to.
+type Platform string
+
+const PlatformWebPush Platform = "webpush"
+
+var (
+	ErrDeviceNotFound = errors.New("device not found")
+	ErrDeviceTaken    = errors.New("device registered to another user")
+)
+
+// Keys are the browser's public key and authentication secret of a Web
+// Push subscription, base64url encoded as the Push API returns them.
+type Keys struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
+}
+
+// Device is a registration of one of a user's devices.
+type Device struct {
+	ID       string   `json:"id"`
+	UserID   string   `json:"user_id"`
+	Platform Platform `json:"platform"`
+	// Endpoint is the push service URL of a Web Push subscription.
+	Endpoint  string    `json:"endpoint"`
+	Keys      Keys      `json:"keys"`
+	UserAgent string    `json:"user_agent,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store is the device registry. When path is set the devices are persisted
+// to that JSON file on every change.
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	devices map[string]*Device // by ID
+	// AllowedHosts, when set, are the only push service hosts endpoints
+	// may use. See CheckEndpoint.
+	AllowedHosts []string
+}
+
+// NewStoreFromEnv loads devices from DEVICE_REGISTRY_FILE, allowing the
+// push service hosts in WEBPUSH_ALLOWED_HOSTS.
+func NewStoreFromEnv() (*Store, error) {
+	s, err := NewStore(os.Getenv("DEVICE_REGISTRY_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	s.AllowedHosts = AllowedHostsFromEnv()
+	return s, nil
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, devices: make(map[string]*Device)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Register adds device for its user. A browser that subscribes again with
+// the same endpoint updates its existing registration, which is returned
+// with created false. An endpoint registered to another user is refused
+// with ErrDeviceTaken, and endpoints that CheckEndpoint refuses are never
+// stored.
+func (s *Store) Register(device Device) (Device, bool, error) {
+	if err := s.CheckEndpoint(device.Endpoint); err != nil {
+		return Device{}, false, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, existing := range s.devices {
+		if existing.Platform == device.Platform && existing.Endpoint == device.Endpoint {
+			if existing.UserID != device.UserID {
+				return Device{}, false, fmt.Errorf("%w: %s", ErrDeviceTaken, existing.ID)
+			}
+			updated := *existing
+			updated.Keys, updated.UserAgent = device.Keys, device.UserAgent
+			updated.UpdatedAt = now
+			s.devices[updated.ID] = &updated
+			if err := s.save(); err != nil {
+				s.restoreLocked(updated.ID, existing)
+				return Device{}, false, err
+			}
+			return updated, false, nil
+		}
+	}
+
+	device.ID = uuid.NewString()
+	device.CreatedAt, device.UpdatedAt = now, now
+	s.devices[device.ID] = &device
+	if err := s.save(); err != nil {
+		s.restoreLocked(device.ID, nil)
+		return Device{}, false, err
+	}
+	return device, true, nil
+}
+
+// List returns the devices of userID on platform, or on every platform when
+// it is empty, oldest first.
+func (s *Store) List(userID string, platform Platform) []Device {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	devices := []Device{}
+	for _, device := range s.devices {
+		if device.UserID == userID && (platform == "" || device.Platform == platform) {
+			devices = append(devices, *device)
+		}
+	}
+	slices.SortFunc(devices, func(a, b Device) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	return devices
+}
+
+// Delete removes the device id of userID.
+func (s *Store) Delete(userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok || device.UserID != userID {
+		return fmt.Errorf("%w: %s", ErrDeviceNotFound, id)
+	}
+	delete(s.devices, id)
+	if err := s.save(); err != nil {
+		s.restoreLocked(id, device)
+		return err
+	}
+	return nil
+}
+
+// Expire removes the device id after its push service reported it gone.
+// Unknown IDs are ignored, since concurrent deliveries may both expire it.
+func (s *Store) Expire(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	device, ok := s.devices[id]
+	if !ok {
+		return nil
+	}
+	delete(s.devices, id)
+	if err := s.save(); err != nil {
+		s.restoreLocked(id, device)
+		return err
+	}
+	return nil
+}
+
+// restoreLocked puts back the device id as it was before a change that
+// could not be saved, so memory never gets ahead of the file. A nil device
+// means there was none.
+func (s *Store) restoreLocked(id string, device *Device) {
+	if device == nil {
+		delete(s.devices, id)
+		return
+	}
+	s.devices[id] = device
+}
+
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read device registry file: %w", err)
+	}
+
+	var devices []Device
+	if err := json.Unmarshal(data, &devices); err != nil {
+		return fmt.Errorf("failed to parse device registry file: %w", err)
+	}
+	for _, device := range devices {
+		s.devices[device.ID] = &device
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	devices := make([]Device, 0, len(s.devices))
+	for _, device := range s.devices {
+		devices = append(devices, *device)
+	}
+
+	data, err := json.MarshalIndent(devices, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode devices: %w", err)
+	}
+	if err := util.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write device registry file: %w", err)
+	}
+	return nil
+}
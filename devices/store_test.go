@@ -0,0 +1,70 @@
+package devices
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRegisterKeepsEndpointsWithTheirUser(t *testing.T) {
+	store, _ := NewStore("")
+	device := Device{UserID: "bob", Platform: PlatformWebPush, Endpoint: "https://push.example.com/1", Keys: Keys{P256dh: "a", Auth: "b"}}
+	first, created, err := store.Register(device)
+	if err != nil || !created {
+		t.Fatalf("Register = %v, %v", created, err)
+	}
+
+	device.Keys.Auth = "c"
+	again, created, err := store.Register(device)
+	if err != nil || created || again.ID != first.ID || again.Keys.Auth != "c" {
+		t.Fatalf("re-registering updated %+v, %v, %v", again, created, err)
+	}
+
+	device.UserID = "alice"
+	if _, _, err := store.Register(device); !errors.Is(err, ErrDeviceTaken) {
+		t.Fatalf("registering bob's endpoint for alice = %v", err)
+	}
+	if err := store.Delete("alice", first.ID); !errors.Is(err, ErrDeviceNotFound) {
+		t.Fatalf("alice deleted bob's device: %v", err)
+	}
+	if owned := store.List("bob", PlatformWebPush); len(owned) != 1 {
+		t.Fatalf("bob has %d devices", len(owned))
+	}
+}
+
+func TestStoreRollsBackChangesThatCouldNotBeSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "devices.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	device := Device{UserID: "bob", Platform: PlatformWebPush, Endpoint: "https://push.example.com/1", Keys: Keys{P256dh: "a", Auth: "b"}}
+	saved, _, err := store.Register(device)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the file is renamed to makes every save fail.
+	os.Remove(path)
+	os.Mkdir(path, 0o700)
+
+	device.Keys.Auth = "c"
+	if _, _, err := store.Register(device); err == nil {
+		t.Fatal("update succeeded without saving")
+	}
+	other := Device{UserID: "bob", Platform: PlatformWebPush, Endpoint: "https://push.example.com/2"}
+	if _, _, err := store.Register(other); err == nil {
+		t.Fatal("registration succeeded without saving")
+	}
+	if err := store.Delete("bob", saved.ID); err == nil {
+		t.Fatal("Delete succeeded without saving")
+	}
+	if err := store.Expire(saved.ID); err == nil {
+		t.Fatal("Expire succeeded without saving")
+	}
+
+	owned := store.List("bob", "")
+	if len(owned) != 1 || owned[0].Keys.Auth != "b" {
+		t.Fatalf("unsaved changes are kept: %+v", owned)
+	}
+}
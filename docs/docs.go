@@ -24,32 +24,46 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/health": {
+        "/admin/keys": {
             "get": {
-                "description": "Gets message stats for the service",
-                "consumes": [
-                    "application/json"
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
                 ],
+                "description": "Lists every API key, including revoked ones. Secrets are never returned.",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "health"
+                    "admin"
                 ],
-                "summary": "Get service metrics",
+                "summary": "Lists API keys",
                 "responses": {
                     "200": {
-                        "description": "Service metrics",
+                        "description": "API keys",
                         "schema": {
-                            "$ref": "#/definitions/models.ConsumerMetrics"
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/auth.APIKey"
+                            }
                         }
                     }
                 }
-            }
-        },
-        "/notification": {
+            },
             "post": {
-                "description": "Accepts a push notification payload and sends it to the RabbitMQ queue",
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates an API key with the given scopes. The secret is only returned once.",
                 "consumes": [
                     "application/json"
                 ],
@@ -57,32 +71,266 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "notifications"
+                    "admin"
                 ],
-                "summary": "Queues a push notification",
+                "summary": "Creates an API key",
                 "parameters": [
                     {
-                        "description": "Notification request payload",
+                        "description": "API key settings",
                         "name": "request",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/models.NotifMessageRequest"
+                            "$ref": "#/definitions/models.CreateAPIKeyRequest"
                         }
                     }
                 ],
                 "responses": {
-                    "202": {
-                        "description": "status: queued, request_id: string",
+                    "201": {
+                        "description": "key: auth.APIKey, secret: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/{id}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Revokes the key. Requests using it are rejected from now on.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Revokes an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "error: api key not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/keys/{id}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Issues a new secret for the key. The old secret stops working immediately.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Rotates an API key",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "API key ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "key: auth.APIKey, secret: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "error: api key not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists every webhook subscriber. Secrets are never returned.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Lists webhook subscribers",
+                "responses": {
+                    "200": {
+                        "description": "Subscribers",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/webhook.Subscriber"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/admin/webhooks/{name}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Gets a webhook subscriber",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscriber name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Subscriber",
+                        "schema": {
+                            "$ref": "#/definitions/webhook.Subscriber"
+                        }
+                    },
+                    "404": {
+                        "description": "error: webhook subscriber not found",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
                                 "type": "string"
                             }
                         }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Sets the endpoint that notifications for the subscriber are POSTed to.\nThe URL must use https and a public address, unless its host is listed in WEBHOOK_ALLOWED_HOSTS.\nThe signing secret is generated when the subscriber is created and only returned then.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Creates or updates a webhook subscriber",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscriber name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Endpoint settings",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.WebhookSubscriberRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "subscriber: webhook.Subscriber",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "201": {
+                        "description": "subscriber: webhook.Subscriber, secret: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     },
                     "400": {
-                        "description": "error: validation failed or invalid notification type",
+                        "description": "error: validation failed",
                         "schema": {
                             "type": "object",
                             "additionalProperties": {
@@ -100,33 +348,2636 @@ const docTemplate = `{
                         }
                     }
                 }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Notifications for the subscriber fail permanently from now on.",
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Deletes a webhook subscriber",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscriber name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "error: webhook subscriber not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
             }
-        }
-    },
-    "definitions": {
-        "models.ConsumerMetrics": {
-            "type": "object",
-            "properties": {
-                "messages_failed": {
-                    "description": "Total messages that failed processing",
-                    "type": "integer"
-                },
-                "messages_processed": {
-                    "description": "Total messages consumed from the queue",
-                    "type": "integer"
-                },
-                "messages_retried": {
-                    "description": "Total messages that were retried",
-                    "type": "integer"
-                },
-                "messages_succeeded": {
-                    "description": "Total messages successfully processed",
-                    "type": "integer"
+        },
+        "/admin/webhooks/{name}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Issues a new signing secret. Deliveries are signed with it from now on.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "admin"
+                ],
+                "summary": "Rotates a webhook signing secret",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Subscriber name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "subscriber: webhook.Subscriber, secret: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "error: webhook subscriber not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
                 }
             }
         },
-        "models.NotifMessageRequest": {
-            "type": "object"
+        "/health": {
+            "get": {
+                "description": "Gets message stats for the service and the circuit breaker state of the user and template services",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Get service metrics",
+                "responses": {
+                    "200": {
+                        "description": "Service metrics",
+                        "schema": {
+                            "$ref": "#/definitions/models.HealthResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/notification": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Accepts a push notification payload and sends it to the RabbitMQ queue",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notifications"
+                ],
+                "summary": "Queues a push notification",
+                "parameters": [
+                    {
+                        "description": "Notification request payload",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.NotifMessageRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "202": {
+                        "description": "status: queued, request_id: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed or invalid notification type",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: caller may not send for tenant",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/notification/send": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Resolves and delivers the notification inline, bypassing the queue, for OTP-style and interactive flows.\nFailures are classified and mapped to HTTP statuses; nothing is retried, but permanent failures fall back along the channel policy.\nPushes count against the frequency caps; over a cap the push is dropped rather than deferred, falling back along the policy or failing with 429.\nWith dry_run set (or in sandbox mode) FCM only validates the message, which is returned instead of delivered.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notifications"
+                ],
+                "summary": "Sends a push notification immediately",
+                "parameters": [
+                    {
+                        "description": "Notification request payload",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.NotifMessageRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Deadline such as 3s, capped at the server maximum",
+                        "name": "timeout",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "status: sent or validated (dry run), request_id: string, message_id: string, channel: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "410": {
+                        "description": "error: the device token is no longer registered",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "error: the payload or target cannot be delivered",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "429": {
+                        "description": "error: FCM quota exceeded or a frequency cap reached",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "error: FCM or an upstream service failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "504": {
+                        "description": "error: the deadline was exceeded",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/notification/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the tracked state of a queued or synchronously sent notification.\nOnly the caller that sent it, or an admin, may read it.\nRecords are kept in memory by each instance, so they are only complete when a single instance runs the API and the workers.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notifications"
+                ],
+                "summary": "Gets the status of a notification",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Request ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Notification status",
+                        "schema": {
+                            "$ref": "#/definitions/status.Record"
+                        }
+                    },
+                    "404": {
+                        "description": "error: notification not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/notifications/batch": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Accepts a JSON array or an NDJSON stream (Content-Type application/x-ndjson) of notification requests.\nEach item is validated and published on its own; the response reports a request ID or an error per item.\nWith async=true the batch is published in the background and its progress can be polled.",
+                "consumes": [
+                    "application/json",
+                    "application/x-ndjson"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notifications"
+                ],
+                "summary": "Queues a batch of push notifications",
+                "parameters": [
+                    {
+                        "description": "Notification requests",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/models.NotifMessageRequest"
+                            }
+                        }
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Publish in the background and return a batch ID",
+                        "name": "async",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-item results",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchStatus"
+                        }
+                    },
+                    "202": {
+                        "description": "batch_id: string, status_url: string",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error: malformed batch",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "413": {
+                        "description": "error: batch exceeds the maximum number of items",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/notifications/batch/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns per-item results of a batch created with POST /notifications/batch\nBatches are kept in memory by the instance that accepted them, so poll the same instance.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "notifications"
+                ],
+                "summary": "Gets the progress of a batch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Batch progress",
+                        "schema": {
+                            "$ref": "#/definitions/models.BatchStatus"
+                        }
+                    },
+                    "404": {
+                        "description": "error: batch not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/sandbox/messages": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the messages the sandbox sender captured instead of delivering, newest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sandbox"
+                ],
+                "summary": "Lists captured sandbox messages",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Only messages for this user",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages for this device token",
+                        "name": "token",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages rendered from this template, including the default one",
+                        "name": "template",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only messages sent to this topic",
+                        "name": "topic",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of messages",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Captured messages",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/sandbox.Message"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "error: invalid limit",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Drops every message the sandbox sender captured, for every team; requires the admin scope",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "sandbox"
+                ],
+                "summary": "Clears captured sandbox messages",
+                "responses": {
+                    "200": {
+                        "description": "deleted: number of messages dropped",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/stream": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Holds a Server-Sent Events connection that receives a \"notification\" event for every notification delivered over the stream channel.\nUsers authenticated with a JWT stream their own notifications; other callers name the user. EventSource clients may pass the token as access_token.\nDelivery is best effort: events sent while the connection is down are not replayed, so clients read the inbox after reconnecting.",
+                "produces": [
+                    "text/event-stream"
+                ],
+                "tags": [
+                    "stream"
+                ],
+                "summary": "Streams notifications to a browser",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User to stream for, the token's subject by default",
+                        "name": "user_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Bearer token, for clients that cannot set headers",
+                        "name": "access_token",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Data of each notification event",
+                        "schema": {
+                            "$ref": "#/definitions/models.StreamEvent"
+                        }
+                    },
+                    "400": {
+                        "description": "error: user_id is required",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/templates": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Lists every template and locale in the local template store with its latest version",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Lists local templates",
+                "responses": {
+                    "200": {
+                        "description": "Templates",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/templates.Summary"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/templates/{name}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the latest or the requested version of a template from the local store",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Gets a local template",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Template name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Locale, en by default",
+                        "name": "locale",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Version, the latest by default",
+                        "name": "version",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Template version",
+                        "schema": {
+                            "$ref": "#/definitions/templates.Version"
+                        }
+                    },
+                    "400": {
+                        "description": "error: invalid version",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: template not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "put": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Creates the template, or adds a version to it. Earlier versions stay available for pinned requests.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Stores a new version of a local template",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Template name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Template content",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TemplateVersionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Stored version",
+                        "schema": {
+                            "$ref": "#/definitions/templates.Version"
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Deletes every version of a template in one locale",
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Deletes a local template",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Template name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Locale, en by default",
+                        "name": "locale",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "error: template not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/templates/{name}/preview": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Renders the template with the given variables exactly as the consumer would and returns the resulting FCM payload without sending it.\nProblems such as unresolved placeholders or a user who cannot receive pushes are reported as warnings.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Previews a rendered template",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Template name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Variables and optional user",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TemplatePreviewRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Rendered notification",
+                        "schema": {
+                            "$ref": "#/definitions/models.TemplatePreviewResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "422": {
+                        "description": "error: the template cannot be rendered into a valid message",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "error: the user or template service failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/templates/{name}/versions": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns every version of a template in one locale, oldest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "templates"
+                ],
+                "summary": "Lists the versions of a local template",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Template name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Locale, en by default",
+                        "name": "locale",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Template versions",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/templates.Version"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: template not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/topics/{topic}/subscribe": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Subscribes up to 1000 registration tokens to an FCM topic and reports tokens that failed. In sandbox capture mode FCM is not called and every token succeeds",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "topics"
+                ],
+                "summary": "Subscribes device tokens to a topic",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Topic name",
+                        "name": "topic",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Registration tokens",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TopicSubscriptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-token results",
+                        "schema": {
+                            "$ref": "#/definitions/models.TopicSubscriptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "error: FCM request failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/topics/{topic}/unsubscribe": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Unsubscribes up to 1000 registration tokens from an FCM topic and reports tokens that failed. In sandbox capture mode FCM is not called and every token succeeds",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "topics"
+                ],
+                "summary": "Unsubscribes device tokens from a topic",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Topic name",
+                        "name": "topic",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Registration tokens",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.TopicSubscriptionRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Per-token results",
+                        "schema": {
+                            "$ref": "#/definitions/models.TopicSubscriptionResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "502": {
+                        "description": "error: FCM request failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/devices": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "devices"
+                ],
+                "summary": "Lists a user's devices",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "webpush"
+                        ],
+                        "type": "string",
+                        "description": "Only devices on this platform",
+                        "name": "platform",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Devices",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/devices.Device"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Stores a browser's Web Push subscription for the user, as returned by PushSubscription.toJSON().\nSubscribing again with the same endpoint updates the existing registration.\nEndpoints must be https URLs on public addresses, or on a host in WEBPUSH_ALLOWED_HOSTS.\nAn endpoint registered to another user is refused; that user must delete it first.\nUsers authenticated with a JWT may only use their own devices, on this and the other device routes.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "devices"
+                ],
+                "summary": "Registers a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Subscription",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.DeviceRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated registration",
+                        "schema": {
+                            "$ref": "#/definitions/devices.Device"
+                        }
+                    },
+                    "201": {
+                        "description": "New registration",
+                        "schema": {
+                            "$ref": "#/definitions/devices.Device"
+                        }
+                    },
+                    "400": {
+                        "description": "error: validation failed",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "409": {
+                        "description": "error: device registered to another user",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/devices/{device}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "The device receives no further notifications, for example after the browser unsubscribed.",
+                "tags": [
+                    "devices"
+                ],
+                "summary": "Unregisters a device",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Device ID",
+                        "name": "device",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: device not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/inbox": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Returns the notifications delivered to the user, newest first, with the unread count.\nPass the returned next value as before to fetch the following page.\nUsers authenticated with a JWT may only use their own inbox, on this and the other inbox routes.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Lists a user's inbox",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Only unread notifications",
+                        "name": "unread",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Page size, 20 by default and at most 100",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only notifications older than this one",
+                        "name": "before",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Notifications",
+                        "schema": {
+                            "$ref": "#/definitions/models.InboxPage"
+                        }
+                    },
+                    "400": {
+                        "description": "error: invalid limit",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: inbox item not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/inbox/read-all": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Marks every notification read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "marked: number of notifications that were unread",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/inbox/unread-count": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Counts a user's unread notifications",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "unread: number of unread notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "integer"
+                            }
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/inbox/{item}": {
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Deletes a notification from the inbox",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Notification ID",
+                        "name": "item",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: inbox item not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/users/{id}/inbox/{item}/read": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    },
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "inbox"
+                ],
+                "summary": "Marks a notification read",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "User ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Notification ID",
+                        "name": "item",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Notification",
+                        "schema": {
+                            "$ref": "#/definitions/models.InboxItem"
+                        }
+                    },
+                    "403": {
+                        "description": "error: cannot access another user's notifications",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "error: inbox item not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "error: internal server error",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/webpush/vapid-public-key": {
+            "get": {
+                "description": "Browsers pass it to pushManager.subscribe() as the applicationServerKey.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "devices"
+                ],
+                "summary": "Gets the VAPID public key",
+                "responses": {
+                    "200": {
+                        "description": "public_key: base64url encoded P-256 key",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "auth.APIKey": {
+            "type": "object",
+            "properties": {
+                "burst": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "hint": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "rate_limit": {
+                    "description": "Requests per second",
+                    "type": "number"
+                },
+                "revoked_at": {
+                    "type": "string"
+                },
+                "rotated_at": {
+                    "type": "string"
+                },
+                "scopes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/auth.Scope"
+                    }
+                }
+            }
+        },
+        "auth.Scope": {
+            "type": "string",
+            "enum": [
+                "send",
+                "read",
+                "stream",
+                "admin"
+            ],
+            "x-enum-comments": {
+                "ScopeStream": "end users opening GET /stream"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "end users opening GET /stream",
+                ""
+            ],
+            "x-enum-varnames": [
+                "ScopeSend",
+                "ScopeRead",
+                "ScopeStream",
+                "ScopeAdmin"
+            ]
+        },
+        "devices.Device": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "endpoint": {
+                    "description": "Endpoint is the push service URL of a Web Push subscription.",
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "keys": {
+                    "$ref": "#/definitions/devices.Keys"
+                },
+                "platform": {
+                    "$ref": "#/definitions/devices.Platform"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_agent": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "devices.Keys": {
+            "type": "object",
+            "properties": {
+                "auth": {
+                    "type": "string"
+                },
+                "p256dh": {
+                    "type": "string"
+                }
+            }
+        },
+        "devices.Platform": {
+            "type": "string",
+            "enum": [
+                "webpush"
+            ],
+            "x-enum-varnames": [
+                "PlatformWebPush"
+            ]
+        },
+        "models.BatchItemResult": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "index": {
+                    "type": "integer"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "description": "pending, queued, rejected or failed",
+                    "type": "string",
+                    "example": "queued"
+                }
+            }
+        },
+        "models.BatchStatus": {
+            "type": "object",
+            "properties": {
+                "caller": {
+                    "description": "Caller is the service that sent the batch, the only one that may read\nit back besides admins.",
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "queued": {
+                    "type": "integer"
+                },
+                "rejected": {
+                    "type": "integer"
+                },
+                "results": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.BatchItemResult"
+                    }
+                },
+                "status": {
+                    "description": "processing or completed",
+                    "type": "string",
+                    "example": "completed"
+                },
+                "tenants": {
+                    "description": "Tenants are those the accepted items were sent for. Admins limited to\ntenants may only read batches for theirs.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.CreateAPIKeyRequest": {
+            "type": "object",
+            "required": [
+                "name",
+                "scopes"
+            ],
+            "properties": {
+                "burst": {
+                    "type": "integer",
+                    "example": 20
+                },
+                "name": {
+                    "type": "string",
+                    "example": "campaign-service"
+                },
+                "rate_limit": {
+                    "type": "number",
+                    "example": 10
+                },
+                "scopes": {
+                    "type": "array",
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    },
+                    "example": [
+                        "send",
+                        "read"
+                    ]
+                }
+            }
+        },
+        "models.DeviceRequest": {
+            "type": "object",
+            "required": [
+                "endpoint",
+                "platform"
+            ],
+            "properties": {
+                "endpoint": {
+                    "type": "string",
+                    "example": "https://fcm.googleapis.com/fcm/send/dpH5lCsTSSM:APA91bH..."
+                },
+                "keys": {
+                    "type": "object",
+                    "required": [
+                        "auth",
+                        "p256dh"
+                    ],
+                    "properties": {
+                        "auth": {
+                            "type": "string",
+                            "example": "tBHItJI5svbpez7KI4CCXg"
+                        },
+                        "p256dh": {
+                            "type": "string",
+                            "example": "BNcRdreALRFXTkOOUHK1EtK2wtaz5Ry4YfYCA_0QTpQtUbVlUls0VJXg7A8u-Ts1XbjhazAkj7I99e8QcYP7DkM"
+                        }
+                    }
+                },
+                "platform": {
+                    "type": "string",
+                    "enum": [
+                        "webpush"
+                    ]
+                },
+                "user_agent": {
+                    "type": "string",
+                    "example": "Mozilla/5.0 (X11; Linux x86_64) Firefox/131.0"
+                }
+            }
+        },
+        "models.HealthResponse": {
+            "type": "object",
+            "properties": {
+                "messages_deferred": {
+                    "description": "Total messages re-queued by a frequency cap",
+                    "type": "integer"
+                },
+                "messages_failed": {
+                    "description": "Total messages that failed processing",
+                    "type": "integer"
+                },
+                "messages_processed": {
+                    "description": "Total messages consumed from the queue",
+                    "type": "integer"
+                },
+                "messages_retried": {
+                    "description": "Total messages that were retried",
+                    "type": "integer"
+                },
+                "messages_succeeded": {
+                    "description": "Total messages successfully processed",
+                    "type": "integer"
+                },
+                "messages_suppressed": {
+                    "description": "Total messages dropped by a frequency cap",
+                    "type": "integer"
+                },
+                "upstreams": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.UpstreamHealth"
+                    }
+                }
+            }
+        },
+        "models.InboxItem": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "channel": {
+                    "$ref": "#/definitions/models.NotificationType"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "description": "ID of the notification",
+                    "type": "string"
+                },
+                "image": {
+                    "type": "string"
+                },
+                "link": {
+                    "type": "string"
+                },
+                "read_at": {
+                    "type": "string"
+                },
+                "template": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.InboxPage": {
+            "type": "object",
+            "properties": {
+                "items": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.InboxItem"
+                    }
+                },
+                "next": {
+                    "description": "Next is the before cursor of the following page, empty on the last.",
+                    "type": "string"
+                },
+                "unread": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.MessageKind": {
+            "type": "string",
+            "enum": [
+                "notification",
+                "data"
+            ],
+            "x-enum-varnames": [
+                "KindNotification",
+                "KindData"
+            ]
+        },
+        "models.NotifMessageRequest": {
+            "type": "object"
+        },
+        "models.NotificationType": {
+            "type": "string",
+            "enum": [
+                "email",
+                "push",
+                "webhook",
+                "apns",
+                "webpush",
+                "stream",
+                "inapp"
+            ],
+            "x-enum-varnames": [
+                "Email",
+                "Push",
+                "Webhook",
+                "APNs",
+                "WebPush",
+                "Stream",
+                "InApp"
+            ]
+        },
+        "models.StreamEvent": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "id": {
+                    "type": "string"
+                },
+                "image": {
+                    "type": "string"
+                },
+                "link": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TemplatePreviewRequest": {
+            "type": "object",
+            "properties": {
+                "locale": {
+                    "type": "string",
+                    "example": "pt-BR"
+                },
+                "template_version": {
+                    "type": "integer",
+                    "example": 3
+                },
+                "user_id": {
+                    "description": "UserID fills user.* variables and checks the user can receive pushes.",
+                    "type": "string",
+                    "example": "29293-2828"
+                },
+                "variables": {
+                    "type": "object"
+                }
+            }
+        },
+        "models.TemplatePreviewResponse": {
+            "type": "object",
+            "properties": {
+                "android": {
+                    "type": "object"
+                },
+                "apns": {
+                    "type": "object"
+                },
+                "body": {
+                    "type": "string"
+                },
+                "data": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "locale": {
+                    "type": "string"
+                },
+                "message": {
+                    "type": "object"
+                },
+                "template": {
+                    "type": "string"
+                },
+                "template_version": {
+                    "type": "integer"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "truncated": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "warnings": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "webpush": {
+                    "type": "object"
+                }
+            }
+        },
+        "models.TemplateResponse": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "channel_id": {
+                    "type": "string"
+                },
+                "channels": {
+                    "description": "Channels is the fallback policy for requests that do not set one.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.NotificationType"
+                    }
+                },
+                "click_action": {
+                    "type": "string"
+                },
+                "image_url": {
+                    "description": "Optional per-template defaults, overridden by the request.",
+                    "type": "string"
+                },
+                "link": {
+                    "type": "string"
+                },
+                "locale": {
+                    "description": "Locale is the variant that was served, which may be a fallback of\nthe locale that was asked for.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "sound": {
+                    "type": "string"
+                },
+                "title": {
+                    "description": "Title is shown as the notification title; the name is used when unset.",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "integer"
+                },
+                "version": {
+                    "description": "Version is set when the template was served from the local store.",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.TemplateVersionRequest": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "channel_id": {
+                    "type": "string"
+                },
+                "channels": {
+                    "description": "Channels is the fallback policy for requests that do not set one.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.NotificationType"
+                    }
+                },
+                "click_action": {
+                    "type": "string"
+                },
+                "image_url": {
+                    "description": "Optional per-template defaults, overridden by the request.",
+                    "type": "string"
+                },
+                "link": {
+                    "type": "string"
+                },
+                "locale": {
+                    "description": "Locale is the variant that was served, which may be a fallback of\nthe locale that was asked for.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "string"
+                },
+                "sound": {
+                    "type": "string"
+                },
+                "title": {
+                    "description": "Title is shown as the notification title; the name is used when unset.",
+                    "type": "string"
+                },
+                "ttl": {
+                    "type": "integer"
+                },
+                "version": {
+                    "description": "Version is set when the template was served from the local store.",
+                    "type": "integer"
+                }
+            }
+        },
+        "models.TopicSubscriptionFailure": {
+            "type": "object",
+            "properties": {
+                "index": {
+                    "type": "integer"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.TopicSubscriptionRequest": {
+            "type": "object",
+            "required": [
+                "tokens"
+            ],
+            "properties": {
+                "tokens": {
+                    "type": "array",
+                    "maxItems": 1000,
+                    "minItems": 1,
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.TopicSubscriptionResponse": {
+            "type": "object",
+            "properties": {
+                "failure_count": {
+                    "type": "integer"
+                },
+                "failures": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.TopicSubscriptionFailure"
+                    }
+                },
+                "success_count": {
+                    "type": "integer"
+                },
+                "topic": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.UpstreamHealth": {
+            "type": "object",
+            "properties": {
+                "consecutive_failures": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "opened_at": {
+                    "type": "string"
+                },
+                "state": {
+                    "type": "string",
+                    "enum": [
+                        "closed",
+                        "open",
+                        "half_open"
+                    ]
+                }
+            }
+        },
+        "models.WebhookSubscriberRequest": {
+            "type": "object",
+            "required": [
+                "url"
+            ],
+            "properties": {
+                "success_statuses": {
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    },
+                    "example": [
+                        200,
+                        202
+                    ]
+                },
+                "timeout_ms": {
+                    "type": "integer",
+                    "example": 3000
+                },
+                "url": {
+                    "type": "string",
+                    "example": "https://billing.internal/hooks/notifications"
+                }
+            }
+        },
+        "sandbox.Message": {
+            "type": "object",
+            "properties": {
+                "body": {
+                    "type": "string"
+                },
+                "caller": {
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "Channel is the fallback channel it went out on. Empty means push.",
+                    "type": "string"
+                },
+                "condition": {
+                    "type": "string"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "message": {
+                    "type": "object"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "request_id": {
+                    "type": "string"
+                },
+                "sent_at": {
+                    "type": "string"
+                },
+                "template": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "token": {
+                    "type": "string"
+                },
+                "topic": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "status.ChannelAttempt": {
+            "type": "object",
+            "properties": {
+                "at": {
+                    "type": "string"
+                },
+                "channel": {
+                    "type": "string"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "error_class": {
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "state": {
+                    "description": "sent, validated, suppressed or failed",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/status.State"
+                        }
+                    ]
+                }
+            }
+        },
+        "status.Record": {
+            "type": "object",
+            "properties": {
+                "attempts": {
+                    "type": "integer"
+                },
+                "caller": {
+                    "type": "string"
+                },
+                "channel": {
+                    "description": "Channel is the channel being tried, or the one that delivered.",
+                    "type": "string"
+                },
+                "channels": {
+                    "description": "Channels is every channel attempt in the fallback chain, in order.",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/status.ChannelAttempt"
+                    }
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "dry_run": {
+                    "type": "boolean"
+                },
+                "error": {
+                    "type": "string"
+                },
+                "error_class": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "message": {
+                    "description": "Message is the FCM message a dry run would have sent.",
+                    "type": "object"
+                },
+                "message_id": {
+                    "description": "FCM message ID once sent",
+                    "type": "string"
+                },
+                "mode": {
+                    "description": "queued or sync",
+                    "type": "string"
+                },
+                "state": {
+                    "$ref": "#/definitions/status.State"
+                },
+                "template": {
+                    "type": "string"
+                },
+                "tenant": {
+                    "type": "string"
+                },
+                "truncated": {
+                    "description": "Truncated lists the fields shortened to fit platform limits, such as\napns.body.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "status.State": {
+            "type": "string",
+            "enum": [
+                "queued",
+                "processing",
+                "retrying",
+                "deferred",
+                "suppressed",
+                "sent",
+                "validated",
+                "failed",
+                "dead_lettered"
+            ],
+            "x-enum-comments": {
+                "Validated": "dry run accepted by FCM"
+            },
+            "x-enum-descriptions": [
+                "",
+                "",
+                "",
+                "",
+                "",
+                "",
+                "dry run accepted by FCM",
+                "",
+                ""
+            ],
+            "x-enum-varnames": [
+                "Queued",
+                "Processing",
+                "Retrying",
+                "Deferred",
+                "Suppressed",
+                "Sent",
+                "Validated",
+                "Failed",
+                "DeadLettered"
+            ]
+        },
+        "templates.Source": {
+            "type": "string",
+            "enum": [
+                "local",
+                "remote"
+            ],
+            "x-enum-comments": {
+                "SourceRemote": "snapshot of the template service"
+            },
+            "x-enum-descriptions": [
+                "",
+                "snapshot of the template service"
+            ],
+            "x-enum-varnames": [
+                "SourceLocal",
+                "SourceRemote"
+            ]
+        },
+        "templates.Summary": {
+            "type": "object",
+            "properties": {
+                "latest_version": {
+                    "type": "integer"
+                },
+                "locale": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "source": {
+                    "$ref": "#/definitions/templates.Source"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "templates.Version": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "locale": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "source": {
+                    "$ref": "#/definitions/templates.Source"
+                },
+                "template": {
+                    "$ref": "#/definitions/models.TemplateResponse"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "webhook.Subscriber": {
+            "type": "object",
+            "properties": {
+                "created_at": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "secret_hint": {
+                    "type": "string"
+                },
+                "success_statuses": {
+                    "description": "SuccessStatuses are the responses that count as delivered. Any 2xx\nwhen empty.",
+                    "type": "array",
+                    "items": {
+                        "type": "integer"
+                    }
+                },
+                "timeout_ms": {
+                    "description": "TimeoutMs bounds each delivery; DefaultTimeout when 0.",
+                    "type": "integer"
+                },
+                "updated_at": {
+                    "type": "string"
+                },
+                "url": {
+                    "type": "string"
+                }
+            }
+        }
+    },
+    "securityDefinitions": {
+        "ApiKeyAuth": {
+            "type": "apiKey",
+            "name": "X-API-Key",
+            "in": "header"
+        },
+        "BearerAuth": {
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
         }
     }
 }`
@@ -0,0 +1,362 @@
+package e2e
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"time"
+
+	"push_service/api"
+	"push_service/apns"
+	"push_service/broker"
+	"push_service/consumer"
+	"push_service/devices"
+	"push_service/email"
+	"push_service/fakeapns"
+	"push_service/fakefcm"
+	"push_service/fakeupstream"
+	"push_service/fakewebpush"
+	"push_service/inbox"
+	"push_service/models"
+	"push_service/status"
+	"push_service/stream"
+	"push_service/templates"
+	"push_service/truncate"
+	"push_service/upstream"
+	"push_service/webhook"
+	"push_service/webpush"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Harness runs the API and the workers in-process against a broker and
+// fake FCM, APNs, user and template services.
+type Harness struct {
+	FCM     *fakefcm.Server
+	APNs    *fakeapns.Server
+	WebPush *fakewebpush.Server
+	Devices *devices.Store
+	Streams *stream.Hub
+	Inbox   *inbox.MemoryStore
+	// URL serves the API for clients that need a real connection, such as
+	// streams.
+	URL       string
+	Upstream  *fakeupstream.Server
+	Templates *templates.Store
+	Publisher *models.Publisher
+	Consumer  *models.Consumer
+	// Memory is set when the harness runs on the in-memory broker.
+	Memory *broker.Memory
+	// RetryDelay is how long a retried message waits before redelivery.
+	RetryDelay time.Duration
+	Webhooks   *WebhookReceiver
+	// WebhookURL is the base URL of the receiver, to which WebhookPath and
+	// the subscriber name are appended.
+	WebhookURL string
+
+	router  *gin.Engine
+	servers []*httptest.Server
+	broker  broker.Broker
+	email   *broker.Memory
+	stream  *broker.Memory
+
+	mu     sync.Mutex
+	emails []models.EmailRequest
+}
+
+// NewHarness starts the fakes, points the upstream clients at them and
+// starts the workers on b, whose retries wait retryDelay.
+func NewHarness(b broker.Broker, retryDelay time.Duration) (*Harness, error) {
+	h := &Harness{
+		FCM:        fakefcm.NewServer(),
+		APNs:       fakeapns.NewServer(),
+		Upstream:   fakeupstream.NewServer(),
+		RetryDelay: retryDelay,
+		broker:     b,
+	}
+	h.Memory, _ = b.(*broker.Memory)
+	// The harness stands in for the email service, whatever b is.
+	h.email = broker.NewMemory(models.EmailTopology())
+	h.stream = broker.NewMemory(models.StreamTopology("e2e"))
+	h.Streams = stream.NewHub()
+	relay := stream.NewRelay(h.Streams, h.stream, "e2e")
+	go relay.Run(context.Background())
+
+	subscribers, _ := webhook.NewStore("")
+	// The receiver is a plain http server on the loopback address.
+	subscribers.AllowedHosts = []string{"127.0.0.1"}
+	h.Webhooks = NewWebhookReceiver(subscribers)
+
+	fcmServer := httptest.NewServer(h.FCM)
+	upstreamServer := httptest.NewServer(h.Upstream)
+	webhookServer := httptest.NewServer(h.Webhooks)
+	h.servers = []*httptest.Server{fcmServer, upstreamServer, webhookServer}
+	h.WebhookURL = webhookServer.URL
+
+	apnsClient, err := h.startAPNs()
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	h.Devices, _ = devices.NewStore("")
+	// The fake push service is a plain http server on the loopback address.
+	h.Devices.AllowedHosts = []string{"127.0.0.1"}
+	h.WebPush = fakewebpush.NewServer("")
+	webPushServer := httptest.NewServer(h.WebPush)
+	h.servers = append(h.servers, webPushServer)
+	h.WebPush.BaseURL = webPushServer.URL
+	vapidKey, _, err := webpush.GenerateVAPIDKey()
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("could not generate VAPID key: %w", err)
+	}
+	vapid, err := webpush.NewVAPID(vapidKey, "mailto:e2e@example.com")
+	if err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	// Every lookup reaches the fakes, and outages end as soon as they do.
+	services := upstream.NewServices(upstream.Config{
+		UserServiceURL:     upstreamServer.URL + fakeupstream.UserPath,
+		TemplateServiceURL: upstreamServer.URL,
+		Timeout:            5 * time.Second,
+		BreakerThreshold:   5,
+		BreakerCooldown:    100 * time.Millisecond,
+	})
+
+	h.Templates, _ = templates.NewStore("")
+	h.Inbox, _ = inbox.NewMemoryStore("", 0)
+
+	client, err := consumer.NewFirebaseClient(context.Background(), fcmServer.URL+"/v1", "")
+	if err != nil {
+		h.Close()
+		return nil, fmt.Errorf("could not create FCM client: %w", err)
+	}
+
+	statuses := status.NewStore(status.DefaultMaxRecords)
+	h.Publisher = &models.Publisher{Broker: b, Status: statuses}
+	h.Consumer = &models.Consumer{
+		Broker:        b,
+		PrefetchCount: 1,
+		WorkerCount:   2,
+		Client:        client,
+		Status:        statuses,
+		Limits:        truncate.DefaultLimits(),
+		Upstream:      services,
+		Templates:     &templates.Resolver{Store: h.Templates, Mode: templates.ModeReadThrough, Upstream: services},
+		Inbox:         h.Inbox,
+		Channels: map[models.NotificationType]models.Channel{
+			models.Email:   &email.Channel{Broker: h.email},
+			models.Webhook: webhook.NewChannel(subscribers),
+			models.APNs:    &apns.Channel{Client: apnsClient, Limit: truncate.DefaultLimits().APNS},
+			models.WebPush: &webpush.Channel{
+				Devices: h.Devices,
+				VAPID:   vapid,
+				HTTP:    webpush.NewHTTPClient(h.Devices),
+				Limit:   truncate.DefaultLimits().Webpush,
+			},
+			models.Stream: &stream.Channel{Relay: relay},
+			models.InApp:  inbox.Channel{},
+		},
+	}
+	for id := 0; id < h.Consumer.WorkerCount; id++ {
+		consumer.NewWorker(h.Consumer, id)
+	}
+	if err := h.receiveEmails(); err != nil {
+		h.Close()
+		return nil, err
+	}
+
+	gin.SetMode(gin.ReleaseMode)
+	h.router = gin.New()
+	h.router.POST("/notification", func(ctx *gin.Context) {
+		api.NotificationHandler(h.Publisher, ctx)
+	})
+	h.router.GET("/stream", func(ctx *gin.Context) {
+		api.StreamHandler(h.Streams, ctx)
+	})
+	apiServer := httptest.NewServer(h.router)
+	h.servers = append(h.servers, apiServer)
+	h.URL = apiServer.URL
+	return h, nil
+}
+
+// Subscribe creates a browser subscription at the fake push service and
+// registers it for userID.
+func (h *Harness) Subscribe(userID string) (fakewebpush.Subscription, error) {
+	subscription, err := h.WebPush.Subscribe()
+	if err != nil {
+		return subscription, err
+	}
+	_, _, err = h.Devices.Register(devices.Device{
+		UserID:   userID,
+		Platform: devices.PlatformWebPush,
+		Endpoint: subscription.Endpoint,
+		Keys:     subscription.Keys,
+	})
+	return subscription, err
+}
+
+// startAPNs serves the fake APNs over cleartext HTTP/2 and returns a client
+// signing provider tokens with a key the fake verifies.
+func (h *Harness) startAPNs() (*apns.Client, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate APNs key: %w", err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("could not encode APNs key: %w", err)
+	}
+	h.APNs.Key = &key.PublicKey
+
+	server := httptest.NewUnstartedServer(h.APNs)
+	server.Config.Protocols = new(http.Protocols)
+	server.Config.Protocols.SetUnencryptedHTTP2(true)
+	server.Start()
+	h.servers = append(h.servers, server)
+
+	client, err := apns.NewClient(apns.Config{
+		Endpoint:    server.URL,
+		Topic:       APNsTopic,
+		KeyID:       "E2EKEY1234",
+		TeamID:      "E2ETEAM123",
+		Key:         pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}),
+		Connections: 2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("could not create APNs client: %w", err)
+	}
+	return client, nil
+}
+
+// APNsTopic is the bundle ID the harness sends APNs pushes for.
+const APNsTopic = "com.example.e2e"
+
+func (h *Harness) Close() {
+	h.broker.Close()
+	h.email.Close()
+	h.stream.Close()
+	for _, server := range h.servers {
+		server.Close()
+	}
+}
+
+// Notify posts req to POST /notification and returns the request ID.
+func (h *Harness) Notify(req models.NotifMessageRequest) (string, error) {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return "", err
+	}
+
+	recorder := httptest.NewRecorder()
+	h.router.ServeHTTP(recorder, httptest.NewRequest(http.MethodPost, "/notification", bytes.NewReader(body)))
+	if recorder.Code != http.StatusAccepted {
+		return "", fmt.Errorf("POST /notification returned %d: %s", recorder.Code, recorder.Body.String())
+	}
+
+	var response struct {
+		RequestID string `json:"request_id"`
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		return "", fmt.Errorf("could not decode response: %w", err)
+	}
+	return response.RequestID, nil
+}
+
+// WaitFor polls the status of id until it reaches one of states.
+func (h *Harness) WaitFor(id string, timeout time.Duration, states ...status.State) (status.Record, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		record, ok := h.Consumer.Status.Get(id)
+		if ok && slices.Contains(states, record.State) {
+			return record, nil
+		}
+		if time.Now().After(deadline) {
+			return record, fmt.Errorf("notification %s is %q after %s, want one of %v", id, record.State, timeout, states)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// Delivered returns the notifications FCM received for token.
+func (h *Harness) Delivered(token string) []Notification {
+	var delivered []Notification
+	for _, request := range h.FCM.Requests() {
+		if request.Token != token || request.Outcome != fakefcm.Success {
+			continue
+		}
+		var message struct {
+			Notification Notification `json:"notification"`
+		}
+		if err := json.Unmarshal(request.Message, &message); err == nil {
+			delivered = append(delivered, message.Notification)
+		}
+	}
+	return delivered
+}
+
+func (h *Harness) receiveEmails() error {
+	deliveries, err := h.email.Consume(1)
+	if err != nil {
+		return fmt.Errorf("could not consume the email queue: %w", err)
+	}
+	go func() {
+		for d := range deliveries {
+			var req models.EmailRequest
+			if err := json.Unmarshal(d.Body, &req); err == nil {
+				h.mu.Lock()
+				h.emails = append(h.emails, req)
+				h.mu.Unlock()
+			}
+			d.Ack()
+		}
+	}()
+	return nil
+}
+
+// Emails returns what the email service received for userID.
+func (h *Harness) Emails(userID string) []models.EmailRequest {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var emails []models.EmailRequest
+	for _, req := range h.emails {
+		if req.UserID == userID {
+			emails = append(emails, req)
+		}
+	}
+	return emails
+}
+
+// DeadLettered reports whether id reached the dead letter queue. Only the
+// in-memory broker can tell, so on RabbitMQ it always reports true.
+func (h *Harness) DeadLettered(id string) bool {
+	if h.Memory == nil {
+		return true
+	}
+	for _, msg := range h.Memory.DeadLetters() {
+		if msg.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+// Notification is the visible part of a message FCM received.
+type Notification struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+}
@@ -0,0 +1,860 @@
+package e2e
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"push_service/apns"
+	"push_service/devices"
+	"push_service/fakeapns"
+	"push_service/fakefcm"
+	"push_service/fakewebpush"
+	"push_service/inbox"
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+	"push_service/status"
+	"push_service/webhook"
+)
+
+// retryTimeout leaves room for every retry to sit out the retry delay.
+func retryTimeout(h *Harness) time.Duration {
+	return time.Duration(models.MaxRetries+1)*h.RetryDelay + 10*time.Second
+}
+
+// Scenario is one end-to-end check. Scenarios run one at a time and each
+// uses its own users and tokens.
+type Scenario struct {
+	Name string
+	Run  func(h *Harness) error
+}
+
+var Scenarios = []Scenario{
+	{Name: "renders the requested template", Run: rendersTemplate},
+	{Name: "retries a transient FCM failure", Run: retriesTransientFailure},
+	{Name: "dead-letters after the last retry", Run: deadLettersAfterRetries},
+	{Name: "dead-letters an unregistered token without retrying", Run: deadLettersUnregistered},
+	{Name: "respects the user's push preference", Run: respectsPushPreference},
+	{Name: "retries a missing template until dead-lettered", Run: retriesMissingTemplate},
+	{Name: "uses the last known good template during an outage", Run: usesLastKnownGoodTemplate},
+	{Name: "renders a pinned template version", Run: rendersPinnedVersion},
+	{Name: "renders the user's locale with fallback", Run: rendersUserLocale},
+	{Name: "truncates a long body to the platform limits", Run: truncatesLongBody},
+	{Name: "falls back to email when push is disabled", Run: fallsBackToEmail},
+	{Name: "follows the template's fallback policy", Run: followsTemplatePolicy},
+	{Name: "delivers a signed webhook", Run: deliversWebhook},
+	{Name: "retries a failing webhook", Run: retriesWebhook},
+	{Name: "delivers directly over APNs", Run: deliversAPNs},
+	{Name: "falls back to FCM after an unregistered APNs token", Run: fallsBackFromAPNs},
+	{Name: "retries when APNs is throttling", Run: retriesAPNsThrottling},
+	{Name: "delivers encrypted web pushes to every browser", Run: deliversWebPush},
+	{Name: "removes an expired web push subscription", Run: expiresWebPush},
+	{Name: "streams to a connected browser", Run: streamsToBrowser},
+	{Name: "falls back when no stream is open", Run: fallsBackWithoutStream},
+	{Name: "keeps delivered notifications in the inbox", Run: keepsInbox},
+	{Name: "delivers in-app only notifications", Run: deliversInApp},
+	{Name: "sets the push badge to the unread count", Run: setsInboxBadge},
+}
+
+func seedUser(h *Harness, id string, push bool) string {
+	token := "token-" + id
+	h.Upstream.PutUser(id, models.User{
+		Name:        id,
+		Email:       id + "@example.com",
+		PushToken:   token,
+		Preferences: models.Preferences{Email: true, Push: push},
+	})
+	return token
+}
+
+func rendersTemplate(h *Harness) error {
+	token := seedUser(h, "render", true)
+	h.Upstream.PutTemplate(models.TemplateResponse{Name: "order_shipped", Body: "Hi {{name}}, order {{ order }} has shipped"})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:    "render",
+		Template:  "order_shipped",
+		Variables: map[string]any{"name": "Ada", "order": 42},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	delivered := h.Delivered(token)
+	if len(delivered) != 1 {
+		return fmt.Errorf("FCM received %d notifications, want 1", len(delivered))
+	}
+	if want := "Hi Ada, order 42 has shipped"; delivered[0].Body != want {
+		return fmt.Errorf("body = %q, want %q", delivered[0].Body, want)
+	}
+	return nil
+}
+
+func retriesTransientFailure(h *Harness) error {
+	token := seedUser(h, "transient", true)
+	h.FCM.Enqueue(fakefcm.Response{Outcome: fakefcm.Internal})
+
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "transient", Title: ptr("Hello"), Body: ptr("World")})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, retryTimeout(h), status.Sent, status.DeadLettered)
+	if err != nil {
+		return err
+	}
+	if record.State != status.Sent || record.Attempts != 2 {
+		return fmt.Errorf("got %q after %d attempts, want sent after 2", record.State, record.Attempts)
+	}
+	if len(h.Delivered(token)) != 1 {
+		return fmt.Errorf("FCM did not receive the retried notification")
+	}
+	return nil
+}
+
+func deadLettersAfterRetries(h *Harness) error {
+	token := seedUser(h, "exhausted", true)
+	h.FCM.SetToken(token, fakefcm.Response{Outcome: fakefcm.Internal})
+
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "exhausted", Title: ptr("Hello"), Body: ptr("World")})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, retryTimeout(h), status.DeadLettered)
+	if err != nil {
+		return err
+	}
+	if record.Attempts != models.MaxRetries || record.ErrorClass != string(sendNotification.ClassInternal) {
+		return fmt.Errorf("got %d attempts and class %q, want %d and %q", record.Attempts, record.ErrorClass, models.MaxRetries, sendNotification.ClassInternal)
+	}
+	if !h.DeadLettered(id) {
+		return fmt.Errorf("notification %s is not in the dead letter queue", id)
+	}
+	return nil
+}
+
+func deadLettersUnregistered(h *Harness) error {
+	token := seedUser(h, "unregistered", true)
+	h.FCM.SetToken(token, fakefcm.Response{Outcome: fakefcm.Unregistered})
+
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "unregistered", Title: ptr("Hello"), Body: ptr("World")})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.DeadLettered)
+	if err != nil {
+		return err
+	}
+	if record.Attempts != 1 || record.ErrorClass != string(sendNotification.ClassUnregistered) {
+		return fmt.Errorf("got %d attempts and class %q, want 1 and %q", record.Attempts, record.ErrorClass, sendNotification.ClassUnregistered)
+	}
+	if !h.DeadLettered(id) {
+		return fmt.Errorf("notification %s is not in the dead letter queue", id)
+	}
+	return nil
+}
+
+func respectsPushPreference(h *Harness) error {
+	token := seedUser(h, "opted-out", false)
+
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "opted-out", Title: ptr("Hello"), Body: ptr("World")})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.DeadLettered, status.Sent)
+	if err != nil {
+		return err
+	}
+	if record.ErrorClass != string(sendNotification.ClassOptedOut) {
+		return fmt.Errorf("got %q with class %q, want class %q", record.State, record.ErrorClass, sendNotification.ClassOptedOut)
+	}
+	if len(h.Delivered(token)) != 0 {
+		return fmt.Errorf("FCM received a notification for an opted-out user")
+	}
+	return nil
+}
+
+func retriesMissingTemplate(h *Harness) error {
+	seedUser(h, "no-template", true)
+
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "no-template", Template: "does_not_exist"})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, retryTimeout(h), status.DeadLettered)
+	if err != nil {
+		return err
+	}
+	if record.Attempts != models.MaxRetries || record.ErrorClass != string(sendNotification.ClassUpstream) {
+		return fmt.Errorf("got %d attempts and class %q, want %d and %q", record.Attempts, record.ErrorClass, models.MaxRetries, sendNotification.ClassUpstream)
+	}
+	return nil
+}
+
+func usesLastKnownGoodTemplate(h *Harness) error {
+	token := "token-outage"
+	h.Upstream.PutTemplate(models.TemplateResponse{Name: "outage", Body: "Hello {{name}}"})
+	req := models.NotifMessageRequest{PushToken: &token, Template: "outage", Variables: map[string]any{"name": "Ada"}}
+
+	id, err := h.Notify(req)
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	h.Upstream.SetFailing(true)
+	defer h.Upstream.SetFailing(false)
+
+	id, err = h.Notify(req)
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	delivered := h.Delivered(token)
+	if len(delivered) != 2 || delivered[1].Body != "Hello Ada" {
+		return fmt.Errorf("FCM received %+v, want two notifications saying %q", delivered, "Hello Ada")
+	}
+	return nil
+}
+
+func rendersPinnedVersion(h *Harness) error {
+	token := "token-pinned"
+	h.Templates.Create("pinned", "", models.TemplateResponse{Title: "Hi", Body: "first {{name}}"})
+	h.Templates.Create("pinned", "", models.TemplateResponse{Title: "Hi", Body: "second {{name}}"})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		PushToken:       &token,
+		Template:        "pinned",
+		TemplateVersion: 1,
+		Variables:       map[string]any{"name": "Ada"},
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	delivered := h.Delivered(token)
+	if len(delivered) != 1 || delivered[0].Title != "Hi" || delivered[0].Body != "first Ada" {
+		return fmt.Errorf("FCM received %+v, want version 1 rendered", delivered)
+	}
+	return nil
+}
+
+func rendersUserLocale(h *Harness) error {
+	token := "token-localized"
+	h.Upstream.PutUser("localized", models.User{
+		Name:        "localized",
+		PushToken:   token,
+		Locale:      "pt-BR",
+		Preferences: models.Preferences{Push: true},
+	})
+	h.Upstream.PutTemplate(models.TemplateResponse{Name: "receipt", Title: "Receipt", Body: "You paid {{total|number}}"})
+	h.Templates.Create("receipt", "pt", models.TemplateResponse{Title: "Recibo", Body: "Você pagou {{total|number}} em {{paid_at|date}}"})
+
+	variables := map[string]any{"total": 1234.5, "paid_at": "2026-03-04T10:00:00Z"}
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "localized", Template: "receipt", Variables: variables})
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	id, err = h.Notify(models.NotifMessageRequest{UserID: "localized", Template: "receipt", Locale: "fr", Variables: variables})
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	delivered := h.Delivered(token)
+	if len(delivered) != 2 || delivered[0].Body != "Você pagou 1.234,5 em 04/03/2026" || delivered[1].Body != "You paid 1,234.5" {
+		return fmt.Errorf("FCM received %+v, want the pt variant for pt-BR and the en template for fr", delivered)
+	}
+	return nil
+}
+
+func truncatesLongBody(h *Harness) error {
+	token := "token-truncate"
+	body := strings.Repeat("Your order has shipped. ", 20)
+
+	id, err := h.Notify(models.NotifMessageRequest{PushToken: &token, Title: ptr("Order update"), Body: &body})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	want := []string{"android.body", "apns.body", "webpush.body"}
+	if !slices.Equal(record.Truncated, want) {
+		return fmt.Errorf("status record lists %v as truncated, want %v", record.Truncated, want)
+	}
+	delivered := h.Delivered(token)
+	if len(delivered) != 1 || !strings.HasSuffix(delivered[0].Body, "shipped…") || utf8.RuneCountInString(delivered[0].Body) > 240 {
+		return fmt.Errorf("FCM received %+v, want the body cut at a word within 240 characters", delivered)
+	}
+	return nil
+}
+
+func fallsBackToEmail(h *Harness) error {
+	seedUser(h, "fallback", false)
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:    "fallback",
+		Template:  "order_shipped",
+		Variables: map[string]any{"order": 42},
+		Channels:  []models.NotificationType{models.Push, models.Email},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if err := checkChain(record, "push:failed", "email:sent"); err != nil {
+		return err
+	}
+	emails := h.Emails("fallback")
+	if len(emails) != 1 || emails[0].ID != id || emails[0].Email != "fallback@example.com" {
+		return fmt.Errorf("email service received %+v, want one email to fallback@example.com", emails)
+	}
+	return nil
+}
+
+func followsTemplatePolicy(h *Harness) error {
+	h.Upstream.PutUser("no-device", models.User{
+		Name:        "no-device",
+		Email:       "no-device@example.com",
+		Preferences: models.Preferences{Email: true, Push: true},
+	})
+	h.Templates.Create("password_reset", "", models.TemplateResponse{
+		Body:     "Your reset code is {{code}}",
+		Channels: []models.NotificationType{models.Push, models.Email},
+	})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:    "no-device",
+		Template:  "password_reset",
+		Variables: map[string]any{"code": "123456"},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if err := checkChain(record, "push:failed", "email:sent"); err != nil {
+		return err
+	}
+	if record.Channels[0].ErrorClass != string(sendNotification.ClassNoToken) {
+		return fmt.Errorf("push failed with %q, want %q", record.Channels[0].ErrorClass, sendNotification.ClassNoToken)
+	}
+	if emails := h.Emails("no-device"); len(emails) != 1 {
+		return fmt.Errorf("email service received %d emails, want 1", len(emails))
+	}
+	return nil
+}
+
+func subscribe(h *Harness, name string) error {
+	_, _, err := h.Webhooks.Store.Put(name, webhook.Subscriber{URL: h.WebhookURL + WebhookPath + name, TimeoutMs: 2000})
+	return err
+}
+
+func deliversWebhook(h *Harness) error {
+	if err := subscribe(h, "billing"); err != nil {
+		return err
+	}
+	h.Upstream.PutTemplate(models.TemplateResponse{Name: "invoice_paid", Title: "Paid", Body: "Invoice {{invoice}} was paid"})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		Template:   "invoice_paid",
+		Variables:  map[string]any{"invoice": "INV-7"},
+		Channels:   []models.NotificationType{models.Webhook},
+		Subscriber: "billing",
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	received := h.Webhooks.Received("billing")
+	if len(received) != 1 || received[0].ID != id || received[0].Body != "Invoice INV-7 was paid" {
+		return fmt.Errorf("subscriber received %+v, want the rendered notification %s", received, id)
+	}
+	if h.Webhooks.Rejected() != 0 {
+		return fmt.Errorf("%d deliveries had an invalid signature", h.Webhooks.Rejected())
+	}
+	return checkChain(record, "webhook:sent")
+}
+
+func retriesWebhook(h *Harness) error {
+	if err := subscribe(h, "flaky"); err != nil {
+		return err
+	}
+	h.Webhooks.Respond("flaky", http.StatusServiceUnavailable)
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		Title:      ptr("Ping"),
+		Body:       ptr("pong"),
+		Channels:   []models.NotificationType{models.Webhook},
+		Subscriber: "flaky",
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, retryTimeout(h), status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if record.Attempts != 2 || len(h.Webhooks.Received("flaky")) != 1 {
+		return fmt.Errorf("delivered after %d attempts with %d received, want 2 attempts and 1 received", record.Attempts, len(h.Webhooks.Received("flaky")))
+	}
+	return checkChain(record, "webhook:failed", "webhook:sent")
+}
+
+func seedAPNsUser(h *Harness, id string) string {
+	token := seedUser(h, id, true)
+	user := models.User{
+		Name:        id,
+		Email:       id + "@example.com",
+		PushToken:   token,
+		APNsToken:   "apns-" + id,
+		Preferences: models.Preferences{Email: true, Push: true},
+	}
+	h.Upstream.PutUser(id, user)
+	return user.APNsToken
+}
+
+func deliversAPNs(h *Harness) error {
+	token := seedAPNsUser(h, "apns")
+	h.Upstream.PutTemplate(models.TemplateResponse{Name: "order_shipped", Body: "Hi {{name}}, order {{ order }} has shipped"})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:      "apns",
+		Template:    "order_shipped",
+		Variables:   map[string]any{"name": "Ada", "order": 42},
+		Badge:       intPtr(3),
+		CollapseKey: "order-42",
+		Channels:    []models.NotificationType{models.APNs},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+	if err := checkChain(record, "apns:sent"); err != nil {
+		return err
+	}
+
+	requests := apnsRequests(h, token)
+	if len(requests) != 1 {
+		return fmt.Errorf("APNs received %d requests for %s, want 1", len(requests), token)
+	}
+	got := requests[0]
+	if got.Proto != "HTTP/2.0" || got.Topic != APNsTopic || got.PushType != "alert" || got.CollapseID != "order-42" {
+		return fmt.Errorf("APNs request was %s with topic %q, push type %q and collapse ID %q", got.Proto, got.Topic, got.PushType, got.CollapseID)
+	}
+	var payload struct {
+		Aps struct {
+			Alert struct{ Body string }
+			Badge int
+		}
+		Order string
+	}
+	if err := json.Unmarshal(got.Payload, &payload); err != nil {
+		return fmt.Errorf("could not decode APNs payload: %w", err)
+	}
+	if payload.Aps.Alert.Body != "Hi Ada, order 42 has shipped" || payload.Aps.Badge != 3 || payload.Order != "42" {
+		return fmt.Errorf("APNs payload was %s", got.Payload)
+	}
+	if record.Channels[0].MessageID != got.APNsID {
+		return fmt.Errorf("message ID is %q, want the apns-id %q", record.Channels[0].MessageID, got.APNsID)
+	}
+	return nil
+}
+
+func fallsBackFromAPNs(h *Harness) error {
+	token := seedAPNsUser(h, "apns-stale")
+	h.APNs.SetToken(token, fakeapns.Response{Reason: apns.ReasonBadDeviceToken})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:   "apns-stale",
+		Title:    ptr("Hello"),
+		Body:     ptr("World"),
+		Channels: []models.NotificationType{models.APNs, models.Push},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if err := checkChain(record, "apns:failed", "push:sent"); err != nil {
+		return err
+	}
+	if record.Channels[0].ErrorClass != string(sendNotification.ClassUnregistered) {
+		return fmt.Errorf("APNs failed with %q, want %q", record.Channels[0].ErrorClass, sendNotification.ClassUnregistered)
+	}
+	return nil
+}
+
+func retriesAPNsThrottling(h *Harness) error {
+	token := seedAPNsUser(h, "apns-throttled")
+	h.APNs.Enqueue(fakeapns.Response{Reason: apns.ReasonTooManyRequests, RetryAfter: 1})
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:   "apns-throttled",
+		Title:    ptr("Hello"),
+		Body:     ptr("World"),
+		Channels: []models.NotificationType{models.APNs},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, retryTimeout(h), status.Sent)
+	if err != nil {
+		return err
+	}
+	if err := checkChain(record, "apns:failed", "apns:sent"); err != nil {
+		return err
+	}
+	if record.Channels[0].ErrorClass != string(sendNotification.ClassQuotaExceeded) || len(apnsRequests(h, token)) != 2 {
+		return fmt.Errorf("APNs failed with %q after %d requests, want %q and 2", record.Channels[0].ErrorClass, len(apnsRequests(h, token)), sendNotification.ClassQuotaExceeded)
+	}
+	return nil
+}
+
+func apnsRequests(h *Harness, token string) []fakeapns.Request {
+	var requests []fakeapns.Request
+	for _, request := range h.APNs.Requests() {
+		if request.Token == token {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func deliversWebPush(h *Harness) error {
+	seedUser(h, "browser", true)
+	var subscriptions []string
+	for range 2 {
+		subscription, err := h.Subscribe("browser")
+		if err != nil {
+			return err
+		}
+		subscriptions = append(subscriptions, subscription.ID)
+	}
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:      "browser",
+		Title:       ptr("Order shipped"),
+		Body:        ptr("It is on its way"),
+		Link:        "https://app.example.com/orders/42",
+		CollapseKey: "order-42",
+		Variables:   map[string]any{"order": 42},
+		Channels:    []models.NotificationType{models.WebPush},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+	if err := checkChain(record, "webpush:sent"); err != nil {
+		return err
+	}
+
+	for _, subscription := range subscriptions {
+		requests := webPushRequests(h, subscription)
+		if len(requests) != 1 || requests[0].Status != http.StatusCreated {
+			return fmt.Errorf("subscription %s received %+v, want one accepted push", subscription, requests)
+		}
+		got := requests[0]
+		if got.Topic != "order-42" || got.Urgency != "high" || got.Subject != "mailto:e2e@example.com" {
+			return fmt.Errorf("push had topic %q, urgency %q and VAPID subject %q", got.Topic, got.Urgency, got.Subject)
+		}
+		var payload models.WebPushPayload
+		if err := json.Unmarshal(got.Payload, &payload); err != nil {
+			return fmt.Errorf("could not decode decrypted payload: %w", err)
+		}
+		if payload.ID != id || payload.Title != "Order shipped" || payload.Link != "https://app.example.com/orders/42" || payload.Data["order"] != "42" {
+			return fmt.Errorf("browser decrypted %s", got.Payload)
+		}
+	}
+	return nil
+}
+
+func expiresWebPush(h *Harness) error {
+	seedUser(h, "revoked", true)
+	subscription, err := h.Subscribe("revoked")
+	if err != nil {
+		return err
+	}
+	h.WebPush.Expire(subscription.ID)
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:   "revoked",
+		Title:    ptr("Hello"),
+		Body:     ptr("World"),
+		Channels: []models.NotificationType{models.WebPush, models.Email},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if err := checkChain(record, "webpush:failed", "email:sent"); err != nil {
+		return err
+	}
+	if record.Channels[0].ErrorClass != string(sendNotification.ClassUnregistered) {
+		return fmt.Errorf("web push failed with %q, want %q", record.Channels[0].ErrorClass, sendNotification.ClassUnregistered)
+	}
+	if remaining := h.Devices.List("revoked", devices.PlatformWebPush); len(remaining) != 0 {
+		return fmt.Errorf("expired subscription is still registered: %+v", remaining)
+	}
+	return nil
+}
+
+func webPushRequests(h *Harness, subscription string) []fakewebpush.Request {
+	var requests []fakewebpush.Request
+	for _, request := range h.WebPush.Requests() {
+		if request.Subscription == subscription {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func streamsToBrowser(h *Harness) error {
+	seedUser(h, "live", true)
+	client, err := h.OpenStream("live")
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:    "live",
+		Title:     ptr("New message"),
+		Body:      ptr("Ada replied"),
+		Variables: map[string]any{"thread": 7},
+		Channels:  []models.NotificationType{models.Stream, models.Push},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+	if err := checkChain(record, "stream:sent"); err != nil {
+		return err
+	}
+
+	event, err := client.Next(5 * time.Second)
+	if err != nil {
+		return err
+	}
+	var got models.StreamEvent
+	if err := json.Unmarshal([]byte(event.Data), &got); err != nil {
+		return fmt.Errorf("could not decode stream event: %w", err)
+	}
+	if event.Name != "notification" || event.ID != id || got.Body != "Ada replied" || got.Data["thread"] != "7" {
+		return fmt.Errorf("stream received %+v", event)
+	}
+	return nil
+}
+
+func fallsBackWithoutStream(h *Harness) error {
+	seedUser(h, "offline", true)
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:   "offline",
+		Title:    ptr("Hello"),
+		Body:     ptr("World"),
+		Channels: []models.NotificationType{models.Stream, models.Email},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+
+	if err := checkChain(record, "stream:failed", "email:sent"); err != nil {
+		return err
+	}
+	if record.Channels[0].ErrorClass != string(sendNotification.ClassNoRecipient) {
+		return fmt.Errorf("stream failed with %q, want %q", record.Channels[0].ErrorClass, sendNotification.ClassNoRecipient)
+	}
+	return nil
+}
+
+func keepsInbox(h *Harness) error {
+	seedUser(h, "inbox", true)
+
+	var ids []string
+	for _, body := range []string{"First", "Second"} {
+		id, err := h.Notify(models.NotifMessageRequest{UserID: "inbox", Title: ptr("Hello"), Body: ptr(body)})
+		if err != nil {
+			return err
+		}
+		if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+
+	page, err := h.Inbox.List(context.Background(), "inbox", false, "", inbox.DefaultPageSize)
+	if err != nil {
+		return err
+	}
+	if len(page.Items) != 2 || page.Items[0].ID != ids[1] || page.Items[0].Body != "Second" || page.Items[0].Channel != models.Push || page.Unread != 2 {
+		return fmt.Errorf("inbox holds %+v, want both notifications newest first", page)
+	}
+
+	if _, err := h.Inbox.MarkRead(context.Background(), "inbox", ids[0]); err != nil {
+		return err
+	}
+	page, err = h.Inbox.List(context.Background(), "inbox", true, "", inbox.DefaultPageSize)
+	if err != nil {
+		return err
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != ids[1] || page.Unread != 1 {
+		return fmt.Errorf("unread inbox holds %+v, want only the second notification", page)
+	}
+	return nil
+}
+
+func deliversInApp(h *Harness) error {
+	token := seedUser(h, "in-app", true)
+
+	id, err := h.Notify(models.NotifMessageRequest{
+		UserID:   "in-app",
+		Title:    ptr("Weekly summary"),
+		Body:     ptr("You have 3 new followers"),
+		Channels: []models.NotificationType{models.InApp},
+	})
+	if err != nil {
+		return err
+	}
+	record, err := h.WaitFor(id, 10*time.Second, status.Sent)
+	if err != nil {
+		return err
+	}
+	if err := checkChain(record, "inapp:sent"); err != nil {
+		return err
+	}
+
+	page, err := h.Inbox.List(context.Background(), "in-app", false, "", inbox.DefaultPageSize)
+	if err != nil {
+		return err
+	}
+	if len(page.Items) != 1 || page.Items[0].ID != id || page.Items[0].Channel != models.InApp {
+		return fmt.Errorf("inbox holds %+v, want the in-app notification", page.Items)
+	}
+	if delivered := h.Delivered(token); len(delivered) != 0 {
+		return fmt.Errorf("FCM received %d notifications, want none", len(delivered))
+	}
+	return nil
+}
+
+func setsInboxBadge(h *Harness) error {
+	token := seedUser(h, "badge", true)
+	h.Consumer.InboxBadge = true
+	defer func() { h.Consumer.InboxBadge = false }()
+
+	if err := h.Inbox.Save(context.Background(), models.InboxItem{ID: "earlier", UserID: "badge", Body: "Earlier", CreatedAt: time.Now()}); err != nil {
+		return err
+	}
+	id, err := h.Notify(models.NotifMessageRequest{UserID: "badge", Title: ptr("Hello"), Body: ptr("World")})
+	if err != nil {
+		return err
+	}
+	if _, err := h.WaitFor(id, 10*time.Second, status.Sent); err != nil {
+		return err
+	}
+
+	var badges []int
+	for _, request := range h.FCM.Requests() {
+		if request.Token != token {
+			continue
+		}
+		var message struct {
+			APNS struct {
+				Payload struct {
+					APS struct {
+						Badge int `json:"badge"`
+					} `json:"aps"`
+				} `json:"payload"`
+			} `json:"apns"`
+		}
+		if err := json.Unmarshal(request.Message, &message); err != nil {
+			return fmt.Errorf("could not decode FCM message: %w", err)
+		}
+		badges = append(badges, message.APNS.Payload.APS.Badge)
+	}
+	if !slices.Equal(badges, []int{2}) {
+		return fmt.Errorf("FCM received badges %v, want [2]", badges)
+	}
+	if unread, _ := h.Inbox.Unread(context.Background(), "badge"); unread != 2 {
+		return fmt.Errorf("inbox has %d unread, want 2", unread)
+	}
+	return nil
+}
+
+// checkChain compares the channel attempts on record with want, written as
+// channel:state pairs.
+func checkChain(record status.Record, want ...string) error {
+	var got []string
+	for _, attempt := range record.Channels {
+		got = append(got, attempt.Channel+":"+string(attempt.State))
+	}
+	last, _, _ := strings.Cut(want[len(want)-1], ":")
+	if !slices.Equal(got, want) || record.Channel != last {
+		return fmt.Errorf("channel chain is %v ending on %q, want %v", got, record.Channel, want)
+	}
+	return nil
+}
+
+func ptr(s string) *string {
+	return &s
+}
+
+func intPtr(n int) *int {
+	return &n
+}
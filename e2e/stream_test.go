@@ -0,0 +1,94 @@
+package e2e
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is one Server-Sent Event.
+type Event struct {
+	Name string
+	ID   string
+	Data string
+}
+
+// StreamClient reads GET /stream like an EventSource would.
+type StreamClient struct {
+	events chan Event
+	cancel context.CancelFunc
+}
+
+// OpenStream connects to GET /stream for userID and waits for the ready
+// event.
+func (h *Harness) OpenStream(userID string) (*StreamClient, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, h.URL+"/stream?user_id="+userID, nil)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		cancel()
+		return nil, fmt.Errorf("GET /stream returned %d", resp.StatusCode)
+	}
+
+	client := &StreamClient{events: make(chan Event, 16), cancel: cancel}
+	go func() {
+		defer resp.Body.Close()
+		defer close(client.events)
+
+		var event Event
+		scanner := bufio.NewScanner(resp.Body)
+		for scanner.Scan() {
+			line := scanner.Text()
+			field, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch {
+			case line == "":
+				if event.Name != "" || event.Data != "" {
+					client.events <- event
+				}
+				event = Event{}
+			case field == "event":
+				event.Name = value
+			case field == "id":
+				event.ID = value
+			case field == "data":
+				event.Data += value
+			}
+		}
+	}()
+
+	if event, err := client.Next(5 * time.Second); err != nil || event.Name != "ready" {
+		client.Close()
+		return nil, fmt.Errorf("stream did not start with a ready event: %+v %v", event, err)
+	}
+	return client, nil
+}
+
+// Next waits for the next event.
+func (c *StreamClient) Next(timeout time.Duration) (Event, error) {
+	select {
+	case event, ok := <-c.events:
+		if !ok {
+			return Event{}, fmt.Errorf("stream closed")
+		}
+		return event, nil
+	case <-time.After(timeout):
+		return Event{}, fmt.Errorf("no event after %s", timeout)
+	}
+}
+
+func (c *StreamClient) Close() {
+	c.cancel()
+}
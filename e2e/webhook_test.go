@@ -0,0 +1,88 @@
+package e2e
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"push_service/models"
+	"push_service/webhook"
+)
+
+// WebhookPath is where the receiver accepts deliveries, followed by the
+// subscriber name.
+const WebhookPath = "/hooks/"
+
+// WebhookReceiver stands in for webhook subscribers. It checks every
+// signature against the subscriber's secret and answers with the scripted
+// statuses, then 200.
+type WebhookReceiver struct {
+	Store *webhook.Store
+
+	mu        sync.Mutex
+	responses map[string][]int
+	received  map[string][]models.WebhookEnvelope
+	rejected  int
+}
+
+func NewWebhookReceiver(store *webhook.Store) *WebhookReceiver {
+	return &WebhookReceiver{
+		Store:     store,
+		responses: make(map[string][]int),
+		received:  make(map[string][]models.WebhookEnvelope),
+	}
+}
+
+// Respond queues statuses for the next deliveries to name.
+func (r *WebhookReceiver) Respond(name string, statuses ...int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses[name] = append(r.responses[name], statuses...)
+}
+
+// Received returns the envelopes name accepted with a valid signature.
+func (r *WebhookReceiver) Received(name string) []models.WebhookEnvelope {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]models.WebhookEnvelope(nil), r.received[name]...)
+}
+
+// Rejected counts deliveries with a missing or invalid signature.
+func (r *WebhookReceiver) Rejected() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rejected
+}
+
+func (r *WebhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	name := strings.TrimPrefix(req.URL.Path, WebhookPath)
+	body, _ := io.ReadAll(req.Body)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	subscriber, err := r.Store.Get(name)
+	if err == nil {
+		err = webhook.Verify(subscriber.Secret, req.Header.Get(webhook.HeaderTimestamp), req.Header.Get(webhook.HeaderSignature), body, time.Minute)
+	}
+	if err != nil {
+		r.rejected++
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	status := http.StatusOK
+	if queued := r.responses[name]; len(queued) > 0 {
+		status, r.responses[name] = queued[0], queued[1:]
+	}
+	if status < 300 {
+		var envelope models.WebhookEnvelope
+		if json.Unmarshal(body, &envelope) == nil {
+			r.received[name] = append(r.received[name], envelope)
+		}
+	}
+	w.WriteHeader(status)
+}
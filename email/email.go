@@ -0,0 +1,59 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"push_service/broker"
+	"push_service/models"
+)
+
+// Channel hands notifications to the email service by publishing them to
+// its queue. The email service renders and sends the email itself.
+type Channel struct {
+	Broker broker.Broker
+}
+
+// Send publishes req for user and returns the ID the email service will
+// know it by, which is the notification ID.
+func (c *Channel) Send(ctx context.Context, req models.NotifMessageRequest, user *models.User) (string, error) {
+	if user == nil || user.Email == "" {
+		return "", errors.New("user has no email address")
+	}
+
+	body, err := json.Marshal(models.EmailRequest{
+		ID:        req.ID,
+		UserID:    req.UserID,
+		Email:     user.Email,
+		Template:  req.Template,
+		Variables: req.Variables,
+		Locale:    req.Locale,
+		Title:     deref(req.Title),
+		Body:      deref(req.Body),
+	})
+	if err != nil {
+		return "", fmt.Errorf("could not marshal email request: %w", err)
+	}
+
+	confirmation, err := c.Broker.Publish(ctx, broker.Message{
+		ID:          req.ID,
+		ContentType: "application/json",
+		Body:        body,
+	})
+	if err == nil {
+		err = confirmation.Wait(ctx)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not queue email: %w", err)
+	}
+	return req.ID, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
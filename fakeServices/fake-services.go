@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"push_service/fakeapns"
+	"push_service/fakefcm"
+	"push_service/fakeupstream"
+	"push_service/fakewebpush"
+)
+
+// Serves one of the fakes the service can be pointed at during local
+// development, for example:
+//
+//	go run ./fakeServices fake-fcm -addr :9099
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: fakeServices fake-fcm|fake-apns|fake-webpush|fake-upstream [flags]")
+		os.Exit(2)
+	}
+
+	switch os.Args[1] {
+	case "fake-fcm":
+		log.Fatal(fakefcm.Run(os.Args[2:]))
+	case "fake-apns":
+		log.Fatal(fakeapns.Run(os.Args[2:]))
+	case "fake-webpush":
+		log.Fatal(fakewebpush.Run(os.Args[2:]))
+	case "fake-upstream":
+		log.Fatal(fakeupstream.Run(os.Args[2:]))
+	default:
+		log.Fatalf("unknown fake %q", os.Args[1])
+	}
+}
@@ -0,0 +1,62 @@
+package fakeapns
+
+import (
+	"crypto/ecdsa"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"push_service/apns"
+)
+
+// Run serves a fake APNs server over cleartext HTTP/2 until it fails. It
+// backs the fake-apns subcommand of fakeServices:
+//
+//	go run ./fakeServices fake-apns -addr :9097 -reason TooManyRequests -retry-after 30
+func Run(args []string) error {
+	flags := flag.NewFlagSet("fake-apns", flag.ContinueOnError)
+	addr := flags.String("addr", ":9097", "address to listen on")
+	reason := flags.String("reason", "", "default reason to reject pushes with, such as BadDeviceToken or TooManyRequests; empty accepts them")
+	retryAfter := flags.Int("retry-after", 0, "Retry-After header on errors, in seconds")
+	latency := flags.Duration("latency", 0, "delay before every response")
+	keyFile := flags.String("key", "", "the .p8 key provider tokens must be signed with; without it signatures are not checked")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := NewServer()
+	if *keyFile != "" {
+		key, err := publicKey(*keyFile)
+		if err != nil {
+			return err
+		}
+		server.Key = key
+	}
+	server.SetScript(Script{Default: Response{
+		Reason:     *reason,
+		RetryAfter: *retryAfter,
+		LatencyMs:  int(*latency / time.Millisecond),
+	}})
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+	httpServer := &http.Server{Addr: *addr, Handler: server, Protocols: protocols}
+
+	log.Printf("Fake APNs listening on %s, set APNS_ENDPOINT=http://localhost%s", *addr, *addr)
+	return httpServer.ListenAndServe()
+}
+
+func publicKey(path string) (*ecdsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %w", err)
+	}
+	key, err := apns.ParseKey(data)
+	if err != nil {
+		return nil, err
+	}
+	return &key.PublicKey, nil
+}
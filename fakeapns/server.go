@@ -0,0 +1,291 @@
+package fakeapns
+
+import (
+	"crypto/ecdsa"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// Response scripts how the server answers a request. An empty reason is a
+// success; otherwise it is one of the reasons APNs documents, such as
+// BadDeviceToken or TooManyRequests.
+type Response struct {
+	Reason string `json:"reason,omitempty"`
+	// RetryAfter is sent as the Retry-After header, in seconds.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// LatencyMs delays the response.
+	LatencyMs int `json:"latency_ms,omitempty"`
+}
+
+// Script replaces the server's behaviour. Queued responses are used first,
+// one per request, then token responses, then the default.
+type Script struct {
+	Default Response            `json:"default"`
+	Tokens  map[string]Response `json:"tokens,omitempty"`
+	Queue   []Response          `json:"queue,omitempty"`
+}
+
+// Request is a push the server received.
+type Request struct {
+	Token      string          `json:"token"`
+	Proto      string          `json:"proto"`
+	Topic      string          `json:"topic"`
+	PushType   string          `json:"push_type"`
+	Priority   string          `json:"priority"`
+	Expiration string          `json:"expiration,omitempty"`
+	CollapseID string          `json:"collapse_id,omitempty"`
+	APNsID     string          `json:"apns_id"`
+	KeyID      string          `json:"key_id"`
+	TeamID     string          `json:"team_id"`
+	Payload    json.RawMessage `json:"payload"`
+	Reason     string          `json:"reason,omitempty"`
+	ReceivedAt time.Time       `json:"received_at"`
+}
+
+// Server is a fake of the APNs provider API. It only speaks HTTP/2, like
+// APNs, so serve it over TLS or as h2c and point APNS_ENDPOINT at it.
+//
+// When Key is set, provider tokens must be signed with its private half;
+// otherwise any well-formed ES256 token is accepted.
+//
+// Besides POST /3/device/{token} it serves PUT /_fake/script to replace the
+// script and GET and DELETE /_fake/requests to inspect and clear received
+// requests.
+type Server struct {
+	Key *ecdsa.PublicKey
+
+	mu       sync.Mutex
+	script   Script
+	requests []Request
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// SetScript replaces the script.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script = script
+}
+
+// Enqueue adds one-off responses used before anything else.
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script.Queue = append(s.script.Queue, responses...)
+}
+
+// SetToken scripts the response for a single device token.
+func (s *Server) SetToken(token string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.script.Tokens == nil {
+		s.script.Tokens = make(map[string]Response)
+	}
+	s.script.Tokens[token] = response
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset clears the received requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/_fake/script" && r.Method == http.MethodPut:
+		var script Script
+		if err := json.NewDecoder(r.Body).Decode(&script); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.SetScript(script)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Requests())
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, "/3/device/"):
+		s.push(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request) {
+	if r.ProtoMajor != 2 {
+		http.Error(w, "APNs requires HTTP/2", http.StatusHTTPVersionNotSupported)
+		return
+	}
+
+	apnsID := r.Header.Get("apns-id")
+	if apnsID == "" {
+		apnsID = strings.ToUpper(uuid.NewString())
+	}
+	w.Header().Set("apns-id", apnsID)
+
+	req := Request{
+		Token:      strings.TrimPrefix(r.URL.Path, "/3/device/"),
+		Proto:      r.Proto,
+		Topic:      r.Header.Get("apns-topic"),
+		PushType:   r.Header.Get("apns-push-type"),
+		Priority:   r.Header.Get("apns-priority"),
+		Expiration: r.Header.Get("apns-expiration"),
+		CollapseID: r.Header.Get("apns-collapse-id"),
+		APNsID:     apnsID,
+		ReceivedAt: time.Now(),
+	}
+	body, _ := io.ReadAll(io.LimitReader(r.Body, 64<<10))
+
+	response, reason := s.check(r, &req, body)
+	if reason == "" {
+		response = s.next(req.Token)
+		reason = response.Reason
+	}
+	if json.Valid(body) {
+		req.Payload = body
+	}
+	req.Reason = reason
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	if response.LatencyMs > 0 {
+		select {
+		case <-time.After(time.Duration(response.LatencyMs) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if reason == "" {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if response.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+	errorBody := map[string]any{"reason": reason}
+	if reason == "Unregistered" {
+		errorBody["timestamp"] = time.Now().UnixMilli()
+	}
+	writeJSON(w, statuses[reason], errorBody)
+}
+
+// check validates what APNs itself would reject before looking at the
+// script: the provider token, the required headers and the payload.
+func (s *Server) check(r *http.Request, req *Request, body []byte) (Response, string) {
+	bearer, ok := strings.CutPrefix(r.Header.Get("authorization"), "bearer ")
+	if !ok || bearer == "" {
+		return Response{}, "MissingProviderToken"
+	}
+	var token *jwt.Token
+	var err error
+	if s.Key != nil {
+		token, err = jwt.Parse(bearer, func(*jwt.Token) (any, error) { return s.Key, nil },
+			jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()}))
+	} else {
+		token, _, err = jwt.NewParser().ParseUnverified(bearer, jwt.MapClaims{})
+	}
+	if err != nil || token == nil {
+		return Response{}, "InvalidProviderToken"
+	}
+	req.KeyID, _ = token.Header["kid"].(string)
+	if claims, ok := token.Claims.(jwt.MapClaims); ok {
+		req.TeamID, _ = claims["iss"].(string)
+	}
+	if req.KeyID == "" || req.TeamID == "" {
+		return Response{}, "InvalidProviderToken"
+	}
+
+	switch {
+	case req.Token == "":
+		return Response{}, "MissingDeviceToken"
+	case req.Topic == "":
+		return Response{}, "MissingTopic"
+	case req.PushType != "alert" && req.PushType != "background":
+		return Response{}, "InvalidPushType"
+	case req.Priority != "" && req.Priority != "5" && req.Priority != "10":
+		return Response{}, "BadPriority"
+	case len(req.CollapseID) > 64:
+		return Response{}, "BadCollapseId"
+	case len(body) == 0:
+		return Response{}, "PayloadEmpty"
+	case len(body) > 4096:
+		return Response{}, "PayloadTooLarge"
+	}
+	return Response{}, ""
+}
+
+func (s *Server) next(token string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.script.Queue) > 0 {
+		response := s.script.Queue[0]
+		s.script.Queue = s.script.Queue[1:]
+		return response
+	}
+	if response, ok := s.script.Tokens[token]; ok {
+		return response
+	}
+	return s.script.Default
+}
+
+// statuses are the HTTP statuses APNs sends with each reason.
+var statuses = map[string]int{
+	"BadCollapseId":               http.StatusBadRequest,
+	"BadDeviceToken":              http.StatusBadRequest,
+	"BadExpirationDate":           http.StatusBadRequest,
+	"BadMessageId":                http.StatusBadRequest,
+	"BadPriority":                 http.StatusBadRequest,
+	"BadTopic":                    http.StatusBadRequest,
+	"DeviceTokenNotForTopic":      http.StatusBadRequest,
+	"DuplicateHeaders":            http.StatusBadRequest,
+	"IdleTimeout":                 http.StatusBadRequest,
+	"InvalidPushType":             http.StatusBadRequest,
+	"MissingDeviceToken":          http.StatusBadRequest,
+	"MissingTopic":                http.StatusBadRequest,
+	"PayloadEmpty":                http.StatusBadRequest,
+	"TopicDisallowed":             http.StatusBadRequest,
+	"ExpiredProviderToken":        http.StatusForbidden,
+	"Forbidden":                   http.StatusForbidden,
+	"InvalidProviderToken":        http.StatusForbidden,
+	"MissingProviderToken":        http.StatusForbidden,
+	"Unregistered":                http.StatusGone,
+	"PayloadTooLarge":             http.StatusRequestEntityTooLarge,
+	"TooManyProviderTokenUpdates": http.StatusTooManyRequests,
+	"TooManyRequests":             http.StatusTooManyRequests,
+	"InternalServerError":         http.StatusInternalServerError,
+	"ServiceUnavailable":          http.StatusServiceUnavailable,
+	"Shutdown":                    http.StatusServiceUnavailable,
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	if status == 0 {
+		status = http.StatusBadRequest
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
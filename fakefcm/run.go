@@ -0,0 +1,33 @@
+package fakefcm
+
+import (
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Run serves a fake FCM server until it fails. It backs the fake-fcm
+// subcommand:
+//
+//	go run ./fakeServices fake-fcm -addr :9099 -outcome quota_exceeded -retry-after 30
+func Run(args []string) error {
+	flags := flag.NewFlagSet("fake-fcm", flag.ContinueOnError)
+	addr := flags.String("addr", ":9099", "address to listen on")
+	outcome := flags.String("outcome", string(Success), "default outcome: success, unregistered, invalid_argument, quota_exceeded, unavailable or internal")
+	retryAfter := flags.Int("retry-after", 0, "Retry-After header on errors, in seconds")
+	latency := flags.Duration("latency", 0, "delay before every response")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := NewServer()
+	server.SetScript(Script{Default: Response{
+		Outcome:    Outcome(*outcome),
+		RetryAfter: *retryAfter,
+		LatencyMs:  int(*latency / time.Millisecond),
+	}})
+
+	log.Printf("Fake FCM listening on %s, set FCM_ENDPOINT=http://localhost%s/v1", *addr, *addr)
+	return http.ListenAndServe(*addr, server)
+}
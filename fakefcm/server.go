@@ -0,0 +1,240 @@
+package fakefcm
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcome is a scripted result of a messages:send call.
+type Outcome string
+
+const (
+	Success         Outcome = "success"
+	Unregistered    Outcome = "unregistered"
+	InvalidArgument Outcome = "invalid_argument"
+	QuotaExceeded   Outcome = "quota_exceeded"
+	Unavailable     Outcome = "unavailable"
+	Internal        Outcome = "internal"
+)
+
+// Response scripts how the server answers a request.
+type Response struct {
+	Outcome Outcome `json:"outcome"`
+	// RetryAfter is sent as the Retry-After header, in seconds.
+	RetryAfter int `json:"retry_after,omitempty"`
+	// LatencyMs delays the response.
+	LatencyMs int `json:"latency_ms,omitempty"`
+}
+
+// Script replaces the server's behaviour. Queued responses are used first,
+// one per request, then token responses, then the default.
+type Script struct {
+	Default Response            `json:"default"`
+	Tokens  map[string]Response `json:"tokens,omitempty"`
+	Queue   []Response          `json:"queue,omitempty"`
+}
+
+// Request is a messages:send call the server received.
+type Request struct {
+	Project      string          `json:"project"`
+	ValidateOnly bool            `json:"validate_only"`
+	Message      json.RawMessage `json:"message"`
+	Token        string          `json:"token,omitempty"`
+	Outcome      Outcome         `json:"outcome"`
+	ReceivedAt   time.Time       `json:"received_at"`
+}
+
+// Server is a fake of the FCM HTTP v1 messages:send API. Point the Firebase
+// client at it with option.WithEndpoint(url + "/v1"), or FCM_ENDPOINT.
+//
+// The Firebase client retries 503 responses itself (up to four times,
+// honouring Retry-After), so each attempt consumes a queued response.
+//
+// Besides the FCM API it serves PUT /_fake/script to replace the script and
+// GET and DELETE /_fake/requests to inspect and clear received requests.
+type Server struct {
+	mu       sync.Mutex
+	script   Script
+	requests []Request
+}
+
+func NewServer() *Server {
+	return &Server{script: Script{Default: Response{Outcome: Success}}}
+}
+
+// SetScript replaces the script.
+func (s *Server) SetScript(script Script) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if script.Default.Outcome == "" {
+		script.Default.Outcome = Success
+	}
+	s.script = script
+}
+
+// Enqueue adds one-off responses used before anything else.
+func (s *Server) Enqueue(responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.script.Queue = append(s.script.Queue, responses...)
+}
+
+// SetToken scripts the response for a single registration token.
+func (s *Server) SetToken(token string, response Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.script.Tokens == nil {
+		s.script.Tokens = make(map[string]Response)
+	}
+	s.script.Tokens[token] = response
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset clears the received requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/_fake/script" && r.Method == http.MethodPut:
+		var script Script
+		if err := json.NewDecoder(r.Body).Decode(&script); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.SetScript(script)
+		w.WriteHeader(http.StatusNoContent)
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Requests())
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasSuffix(r.URL.Path, "/messages:send") && r.Method == http.MethodPost:
+		s.send(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) send(w http.ResponseWriter, r *http.Request) {
+	// /v1/projects/{project}/messages:send
+	project := strings.TrimSuffix(r.URL.Path, "/messages:send")
+	project = project[strings.LastIndex(project, "/")+1:]
+
+	var body struct {
+		ValidateOnly bool            `json:"validate_only"`
+		Message      json.RawMessage `json:"message"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, Response{Outcome: InvalidArgument}, "malformed request body")
+		return
+	}
+	var target struct {
+		Token string `json:"token"`
+	}
+	_ = json.Unmarshal(body.Message, &target)
+
+	response := s.next(target.Token)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, Request{
+		Project:      project,
+		ValidateOnly: body.ValidateOnly,
+		Message:      body.Message,
+		Token:        target.Token,
+		Outcome:      response.Outcome,
+		ReceivedAt:   time.Now(),
+	})
+	s.mu.Unlock()
+
+	if response.LatencyMs > 0 {
+		select {
+		case <-time.After(time.Duration(response.LatencyMs) * time.Millisecond):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if response.Outcome == Success {
+		name := fmt.Sprintf("projects/%s/messages/%s", project, uuid.NewString())
+		if body.ValidateOnly {
+			name = fmt.Sprintf("projects/%s/messages/fake_message_id", project)
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"name": name})
+		return
+	}
+	writeError(w, response, "scripted "+string(response.Outcome))
+}
+
+func (s *Server) next(token string) Response {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.script.Queue) > 0 {
+		response := s.script.Queue[0]
+		s.script.Queue = s.script.Queue[1:]
+		return response
+	}
+	if response, ok := s.script.Tokens[token]; ok {
+		return response
+	}
+	return s.script.Default
+}
+
+// errorFormats maps outcomes to the HTTP status, google.rpc status and FCM
+// error code the real API returns.
+var errorFormats = map[Outcome]struct {
+	httpStatus int
+	status     string
+	fcmCode    string
+}{
+	Unregistered:    {http.StatusNotFound, "NOT_FOUND", "UNREGISTERED"},
+	InvalidArgument: {http.StatusBadRequest, "INVALID_ARGUMENT", "INVALID_ARGUMENT"},
+	QuotaExceeded:   {http.StatusTooManyRequests, "RESOURCE_EXHAUSTED", "QUOTA_EXCEEDED"},
+	Unavailable:     {http.StatusServiceUnavailable, "UNAVAILABLE", "UNAVAILABLE"},
+	Internal:        {http.StatusInternalServerError, "INTERNAL", "INTERNAL"},
+}
+
+func writeError(w http.ResponseWriter, response Response, message string) {
+	format, ok := errorFormats[response.Outcome]
+	if !ok {
+		format = errorFormats[Internal]
+	}
+	if response.RetryAfter > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+	}
+
+	writeJSON(w, format.httpStatus, map[string]any{
+		"error": map[string]any{
+			"code":    format.httpStatus,
+			"message": message,
+			"status":  format.status,
+			"details": []map[string]string{{
+				"@type":     "type.googleapis.com/google.firebase.fcm.v1.FcmError",
+				"errorCode": format.fcmCode,
+			}},
+		},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,157 @@
+package fakeupstream
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+
+	"push_service/models"
+)
+
+const (
+	// UserPath is where the user service API lives, so the server URL plus
+	// UserPath can be used as USER_SERVICE_URL.
+	UserPath = "/api"
+	// TemplatePath prefixes the template service API; the server URL itself
+	// is the TEMPLATE_SERVICE_URL.
+	TemplatePath = "/templates/name/"
+
+	usersPrefix = UserPath + "/v1/users/"
+)
+
+// Server fakes the user and template services in memory. Records can be
+// seeded from Go or over HTTP with PUT on the same paths they are read from.
+// Templates carry an ETag and honour If-None-Match.
+type Server struct {
+	mu        sync.RWMutex
+	users     map[string]models.User
+	templates map[string]models.TemplateResponse
+	failing   bool
+}
+
+func NewServer() *Server {
+	return &Server{
+		users:     make(map[string]models.User),
+		templates: make(map[string]models.TemplateResponse),
+	}
+}
+
+func (s *Server) PutUser(id string, user models.User) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.users[id] = user
+}
+
+func (s *Server) PutTemplate(template models.TemplateResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[template.Name] = template
+}
+
+// SetFailing makes every request fail with 503, to simulate an outage.
+func (s *Server) SetFailing(failing bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failing = failing
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	failing := s.failing
+	s.mu.RUnlock()
+	if failing {
+		http.Error(w, "service unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch {
+	case strings.HasPrefix(r.URL.Path, usersPrefix):
+		s.serveUser(w, r, strings.TrimPrefix(r.URL.Path, usersPrefix))
+	case strings.HasPrefix(r.URL.Path, TemplatePath):
+		s.serveTemplate(w, r, strings.TrimPrefix(r.URL.Path, TemplatePath))
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) serveUser(w http.ResponseWriter, r *http.Request, id string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		user, ok := s.users[id]
+		s.mu.RUnlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]any{"success": false, "message": "user not found"})
+			return
+		}
+		writeJSON(w, http.StatusOK, models.UserResponses{Success: true, Data: user})
+	case http.MethodPut:
+		var user models.User
+		if err := json.NewDecoder(r.Body).Decode(&user); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.PutUser(id, user)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) serveTemplate(w http.ResponseWriter, r *http.Request, name string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.mu.RLock()
+		template, ok := s.templates[name]
+		s.mu.RUnlock()
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": "template not found"})
+			return
+		}
+		body, _ := json.Marshal(template)
+		etag := fmt.Sprintf(`"%x"`, sha256.Sum256(body))
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		writeJSON(w, http.StatusOK, template)
+	case http.MethodPut:
+		var template models.TemplateResponse
+		if err := json.NewDecoder(r.Body).Decode(&template); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		template.Name = name
+		s.PutTemplate(template)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// Run serves a fake user and template service. It backs the fake-upstream
+// subcommand:
+//
+//	go run ./fakeServices fake-upstream -addr :9098
+func Run(args []string) error {
+	flags := flag.NewFlagSet("fake-upstream", flag.ContinueOnError)
+	addr := flags.String("addr", ":9098", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	log.Printf("Fake user and template services listening on %s, set USER_SERVICE_URL=http://localhost%s%s and TEMPLATE_SERVICE_URL=http://localhost%s", *addr, *addr, UserPath, *addr)
+	return http.ListenAndServe(*addr, NewServer())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
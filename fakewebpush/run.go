@@ -0,0 +1,26 @@
+package fakewebpush
+
+import (
+	"flag"
+	"log"
+	"net/http"
+)
+
+// Run serves a fake push service until it fails. It backs the fake-webpush
+// subcommand:
+//
+//	go run ./fakeServices fake-webpush -addr :9098
+//
+// POST /_fake/subscriptions returns a subscription to register with
+// POST /users/{id}/devices.
+func Run(args []string) error {
+	flags := flag.NewFlagSet("fake-webpush", flag.ContinueOnError)
+	addr := flags.String("addr", ":9098", "address to listen on")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	server := NewServer("http://localhost" + *addr)
+	log.Printf("Fake push service listening on %s, POST /_fake/subscriptions for a subscription to register", *addr)
+	return http.ListenAndServe(*addr, server)
+}
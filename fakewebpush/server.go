@@ -0,0 +1,278 @@
+package fakewebpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"push_service/devices"
+	"push_service/webpush"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+)
+
+// PushPath is where subscription endpoints live: PushPath + ID.
+const PushPath = "/push/"
+
+// Subscription is what a browser would hand its application after
+// subscribing, ready to register in the device registry.
+type Subscription struct {
+	ID       string       `json:"id"`
+	Endpoint string       `json:"endpoint"`
+	Keys     devices.Keys `json:"keys"`
+}
+
+// Request is a push the server received, with its payload decrypted.
+type Request struct {
+	Subscription string          `json:"subscription"`
+	TTL          string          `json:"ttl"`
+	Urgency      string          `json:"urgency,omitempty"`
+	Topic        string          `json:"topic,omitempty"`
+	Subject      string          `json:"subject,omitempty"`
+	Payload      json.RawMessage `json:"payload,omitempty"`
+	Status       int             `json:"status"`
+	Error        string          `json:"error,omitempty"`
+	ReceivedAt   time.Time       `json:"received_at"`
+}
+
+type subscription struct {
+	key    *ecdh.PrivateKey
+	auth   []byte
+	status int // scripted response; 0 accepts
+}
+
+// Server is a fake push service. It issues subscriptions, checks the VAPID
+// authorization (RFC 8292) and decrypts the payload (RFC 8291) of every
+// push as a browser would, and records the result.
+//
+// Besides POST PushPath + {id} it serves POST /_fake/subscriptions to
+// create a subscription and GET and DELETE /_fake/requests to inspect and
+// clear received requests.
+type Server struct {
+	// BaseURL is the origin endpoints are built from.
+	BaseURL string
+
+	mu            sync.Mutex
+	subscriptions map[string]*subscription
+	requests      []Request
+}
+
+func NewServer(baseURL string) *Server {
+	return &Server{BaseURL: baseURL, subscriptions: make(map[string]*subscription)}
+}
+
+// Subscribe creates a subscription, generating the browser's keys.
+func (s *Server) Subscribe() (Subscription, error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return Subscription{}, err
+	}
+	auth := make([]byte, 16)
+	if _, err := rand.Read(auth); err != nil {
+		return Subscription{}, err
+	}
+
+	id := uuid.NewString()
+	s.mu.Lock()
+	s.subscriptions[id] = &subscription{key: key, auth: auth}
+	s.mu.Unlock()
+
+	return Subscription{
+		ID:       id,
+		Endpoint: strings.TrimSuffix(s.BaseURL, "/") + PushPath + id,
+		Keys: devices.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(key.PublicKey().Bytes()),
+			Auth:   base64.RawURLEncoding.EncodeToString(auth),
+		},
+	}, nil
+}
+
+// Expire makes pushes to subscription id fail with 410 Gone, as when the
+// user revoked the permission.
+func (s *Server) Expire(id string) {
+	s.SetStatus(id, http.StatusGone)
+}
+
+// SetStatus scripts the response to pushes to subscription id. 0 accepts
+// them again.
+func (s *Server) SetStatus(id string, status int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sub, ok := s.subscriptions[id]; ok {
+		sub.status = status
+	}
+}
+
+// Requests returns the requests received so far.
+func (s *Server) Requests() []Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Request(nil), s.requests...)
+}
+
+// Reset clears the received requests.
+func (s *Server) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = nil
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/_fake/subscriptions" && r.Method == http.MethodPost:
+		subscription, err := s.Subscribe()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusCreated, subscription)
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodGet:
+		writeJSON(w, http.StatusOK, s.Requests())
+	case r.URL.Path == "/_fake/requests" && r.Method == http.MethodDelete:
+		s.Reset()
+		w.WriteHeader(http.StatusNoContent)
+	case strings.HasPrefix(r.URL.Path, PushPath) && r.Method == http.MethodPost:
+		s.push(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) push(w http.ResponseWriter, r *http.Request) {
+	req := Request{
+		Subscription: strings.TrimPrefix(r.URL.Path, PushPath),
+		TTL:          r.Header.Get("TTL"),
+		Urgency:      r.Header.Get("Urgency"),
+		Topic:        r.Header.Get("Topic"),
+		ReceivedAt:   time.Now(),
+	}
+	req.Status, req.Payload, req.Subject, req.Error = s.accept(r, req)
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	s.mu.Unlock()
+
+	if req.Status != http.StatusCreated {
+		http.Error(w, req.Error, req.Status)
+		return
+	}
+	w.Header().Set("Location", strings.TrimSuffix(s.BaseURL, "/")+"/messages/"+uuid.NewString())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// accept checks a push the way a push service and the receiving browser
+// would, returning the status to answer with.
+func (s *Server) accept(r *http.Request, req Request) (int, json.RawMessage, string, string) {
+	s.mu.Lock()
+	sub, ok := s.subscriptions[req.Subscription]
+	var scripted int
+	if ok {
+		scripted = sub.status
+	}
+	s.mu.Unlock()
+	if !ok {
+		return http.StatusNotFound, nil, "", "no such subscription"
+	}
+
+	subject, err := verifyVAPID(r)
+	if err != nil {
+		return http.StatusUnauthorized, nil, "", err.Error()
+	}
+	if r.Header.Get("Content-Encoding") != "aes128gcm" {
+		return http.StatusBadRequest, nil, subject, "Content-Encoding must be aes128gcm"
+	}
+	if ttl, err := strconv.Atoi(req.TTL); err != nil || ttl < 0 {
+		return http.StatusBadRequest, nil, subject, "missing or invalid TTL header"
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, 8192))
+	if err != nil {
+		return http.StatusBadRequest, nil, subject, err.Error()
+	}
+	if len(body) > 4096 {
+		return http.StatusRequestEntityTooLarge, nil, subject, "payload exceeds 4096 bytes"
+	}
+	if scripted != 0 {
+		return scripted, nil, subject, "scripted " + http.StatusText(scripted)
+	}
+
+	plaintext, err := webpush.Decrypt(body, sub.key, sub.auth)
+	if err != nil {
+		return http.StatusBadRequest, nil, subject, err.Error()
+	}
+	if !json.Valid(plaintext) {
+		plaintext, _ = json.Marshal(string(plaintext))
+	}
+	return http.StatusCreated, plaintext, subject, ""
+}
+
+// verifyVAPID checks the "vapid t=<jwt>, k=<key>" authorization: the token
+// must be signed with the key, be scoped to this origin, expire within 24
+// hours and name a contact. It returns the contact.
+func verifyVAPID(r *http.Request) (string, error) {
+	scheme, params, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "vapid") {
+		return "", errors.New("missing vapid authorization")
+	}
+	var token, key string
+	for _, param := range strings.Split(params, ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		switch name {
+		case "t":
+			token = value
+		case "k":
+			key = value
+		}
+	}
+
+	raw, err := webpush.DecodeKey(key)
+	if err != nil || len(raw) != 65 || raw[0] != 4 {
+		return "", errors.New("invalid vapid public key")
+	}
+	public := &ecdsa.PublicKey{
+		Curve: elliptic.P256(),
+		X:     new(big.Int).SetBytes(raw[1:33]),
+		Y:     new(big.Int).SetBytes(raw[33:]),
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, func(*jwt.Token) (any, error) { return public, nil },
+		jwt.WithValidMethods([]string{jwt.SigningMethodES256.Alg()})); err != nil {
+		return "", fmt.Errorf("invalid vapid token: %w", err)
+	}
+
+	origin := "http://" + r.Host
+	if r.TLS != nil {
+		origin = "https://" + r.Host
+	}
+	if aud, _ := claims["aud"].(string); aud != origin {
+		return "", fmt.Errorf("vapid audience %q is not %q", aud, origin)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok || time.Until(time.Unix(int64(exp), 0)) > 24*time.Hour {
+		return "", errors.New("vapid token must expire within 24 hours")
+	}
+	subject, _ := claims["sub"].(string)
+	if !strings.HasPrefix(subject, "mailto:") && !strings.HasPrefix(subject, "https:") {
+		return "", errors.New("vapid subject must be a mailto: or https: URI")
+	}
+	return subject, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,250 @@
+package frequency
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Policy decides what happens to a message that is over a cap.
+type Policy string
+
+const (
+	// PolicyDrop acknowledges the message without sending it.
+	PolicyDrop Policy = "drop"
+	// PolicyDefer re-queues the message until the full cap's window
+	// reopens.
+	PolicyDefer Policy = "defer"
+	// PolicyCollapse defers the message, but only the newest deferred
+	// message per user and template is eventually sent.
+	PolicyCollapse Policy = "collapse"
+
+	// DefaultMaxDeferrals is how many windows a message may wait out
+	// before it is dropped.
+	DefaultMaxDeferrals = 3
+)
+
+// Config holds the caps. A zero cap disables that check.
+type Config struct {
+	UserHourly    int64
+	UserDaily     int64
+	TemplateDaily int64
+	Policy        Policy
+	MaxDeferrals  int64
+}
+
+// ConfigFromEnv reads the caps from FREQ_CAP_USER_HOURLY, FREQ_CAP_USER_DAILY,
+// FREQ_CAP_TEMPLATE_DAILY, FREQ_CAP_POLICY and FREQ_CAP_MAX_DEFERRALS.
+func ConfigFromEnv() Config {
+	config := Config{
+		UserHourly:    envInt("FREQ_CAP_USER_HOURLY", 0),
+		UserDaily:     envInt("FREQ_CAP_USER_DAILY", 0),
+		TemplateDaily: envInt("FREQ_CAP_TEMPLATE_DAILY", 0),
+		Policy:        Policy(os.Getenv("FREQ_CAP_POLICY")),
+		MaxDeferrals:  envInt("FREQ_CAP_MAX_DEFERRALS", DefaultMaxDeferrals),
+	}
+
+	switch config.Policy {
+	case PolicyDrop, PolicyDefer, PolicyCollapse:
+	case "":
+		config.Policy = PolicyDrop
+	default:
+		log.Printf("Unknown FREQ_CAP_POLICY %q, falling back to %q", config.Policy, PolicyDrop)
+		config.Policy = PolicyDrop
+	}
+	return config
+}
+
+func envInt(name string, fallback int64) int64 {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil || value < 0 {
+		log.Printf("Ignoring invalid %s=%q", name, raw)
+		return fallback
+	}
+	return value
+}
+
+// SuppressedError is returned by Reserve when a message must not be sent now.
+type SuppressedError struct {
+	Action Policy
+	Reason string
+	// Until is when the full cap's window reopens, which deferred messages
+	// wait for.
+	Until time.Time
+}
+
+func (e *SuppressedError) Error() string {
+	return fmt.Sprintf("suppressed by frequency cap (%s): %s", e.Action, e.Reason)
+}
+
+// Message identifies the message being checked.
+type Message struct {
+	ID       string
+	UserKey  string
+	Template string
+	// Deferrals is how many times the message has already been deferred.
+	Deferrals int64
+	// Immediate messages cannot wait for a window to reopen, such as
+	// synchronous sends, so a full cap drops them whatever the policy.
+	Immediate bool
+}
+
+type Limiter struct {
+	store  Store
+	config Config
+}
+
+func NewLimiter(store Store, config Config) *Limiter {
+	return &Limiter{store: store, config: config}
+}
+
+func (l *Limiter) Enabled() bool {
+	return l != nil && (l.config.UserHourly > 0 || l.config.UserDaily > 0 || l.config.TemplateDaily > 0)
+}
+
+type window struct {
+	name   string
+	key    string
+	limit  int64
+	period time.Duration
+	// reopens is when the next window starts.
+	reopens time.Time
+}
+
+// windows returns the caps that apply to msg at now. Their keys share the
+// user's hash tag so a Redis cluster can reserve them in one script.
+func (l *Limiter) windows(msg Message, now time.Time) []window {
+	hour := now.Truncate(time.Hour)
+	day := now.UTC().Truncate(24 * time.Hour)
+
+	var windows []window
+	if l.config.UserHourly > 0 {
+		windows = append(windows, window{
+			name:    "per-user hourly",
+			key:     fmt.Sprintf("freq:{%s}:hour:%d", msg.UserKey, hour.Unix()),
+			limit:   l.config.UserHourly,
+			period:  time.Hour,
+			reopens: hour.Add(time.Hour),
+		})
+	}
+	if l.config.UserDaily > 0 {
+		windows = append(windows, window{
+			name:    "per-user daily",
+			key:     fmt.Sprintf("freq:{%s}:day:%d", msg.UserKey, day.Unix()),
+			limit:   l.config.UserDaily,
+			period:  24 * time.Hour,
+			reopens: day.Add(24 * time.Hour),
+		})
+	}
+	if l.config.TemplateDaily > 0 && msg.Template != "" {
+		windows = append(windows, window{
+			name:    "per-template daily",
+			key:     fmt.Sprintf("freq:{%s}:template:%s:day:%d", msg.UserKey, msg.Template, day.Unix()),
+			limit:   l.config.TemplateDaily,
+			period:  24 * time.Hour,
+			reopens: day.Add(24 * time.Hour),
+		})
+	}
+	return windows
+}
+
+func collapseKey(msg Message) string {
+	return fmt.Sprintf("freq:{%s}:collapse:%s", msg.UserKey, msg.Template)
+}
+
+// Reservation holds a message's place under the caps. Release it when the
+// message ends up not being sent; a nil Reservation holds nothing.
+type Reservation struct {
+	limiter *Limiter
+	msg     Message
+	keys    []string
+}
+
+// Reserve counts msg against every cap in one atomic step, or returns a
+// *SuppressedError if any cap is full. Store failures are logged and the
+// message is allowed, so an unavailable store never blocks delivery.
+// Messages without a user key, such as topic broadcasts, are not capped.
+func (l *Limiter) Reserve(ctx context.Context, msg Message) (*Reservation, error) {
+	if !l.Enabled() || msg.UserKey == "" {
+		return nil, nil
+	}
+
+	if l.config.Policy == PolicyCollapse && msg.Deferrals > 0 {
+		latest, err := l.store.Get(ctx, collapseKey(msg))
+		if err != nil {
+			log.Printf("[Frequency] could not read collapse marker: %v", err)
+		} else if latest != "" && latest != msg.ID {
+			return nil, &SuppressedError{Action: PolicyDrop, Reason: fmt.Sprintf("collapsed into newer message %s", latest)}
+		}
+	}
+
+	windows := l.windows(msg, time.Now())
+	counters := make([]Counter, len(windows))
+	keys := make([]string, len(windows))
+	for i, w := range windows {
+		counters[i] = Counter{Key: w.key, Limit: w.limit, TTL: w.period}
+		keys[i] = w.key
+	}
+
+	full, err := l.store.Reserve(ctx, counters)
+	if err != nil {
+		log.Printf("[Frequency] could not reserve a place under the caps: %v", err)
+		return nil, nil
+	}
+	if full < 0 {
+		return &Reservation{limiter: l, msg: msg, keys: keys}, nil
+	}
+
+	w := windows[full]
+	reason := fmt.Sprintf("%s cap of %d reached for %s", w.name, w.limit, msg.UserKey)
+	action := l.config.Policy
+	if action != PolicyDrop && msg.Immediate {
+		action = PolicyDrop
+	} else if action != PolicyDrop && msg.Deferrals >= l.config.MaxDeferrals {
+		action = PolicyDrop
+		reason = fmt.Sprintf("%s after %d deferrals", reason, msg.Deferrals)
+		// A dropped message must not keep the marker it left while
+		// deferred.
+		if l.config.Policy == PolicyCollapse {
+			if err := l.store.Delete(ctx, collapseKey(msg), msg.ID); err != nil {
+				log.Printf("[Frequency] could not clear collapse marker: %v", err)
+			}
+		}
+	}
+	if action == PolicyCollapse {
+		if err := l.store.Set(ctx, collapseKey(msg), msg.ID, w.period); err != nil {
+			log.Printf("[Frequency] could not write collapse marker: %v", err)
+		}
+	}
+	return nil, &SuppressedError{Action: action, Reason: reason, Until: w.reopens}
+}
+
+// Release gives the reserved place back, for a message that was not sent
+// after all.
+func (r *Reservation) Release(ctx context.Context) {
+	if r == nil {
+		return
+	}
+	if err := r.limiter.store.Release(ctx, r.keys); err != nil {
+		log.Printf("[Frequency] could not release %s: %v", r.msg.ID, err)
+	}
+}
+
+// Sent keeps the reserved place for a delivered message, clearing the
+// collapse marker it may have left while deferred unless a newer message
+// has replaced it.
+func (r *Reservation) Sent(ctx context.Context) {
+	if r == nil || r.limiter.config.Policy != PolicyCollapse || r.msg.Deferrals == 0 {
+		return
+	}
+	if err := r.limiter.store.Delete(ctx, collapseKey(r.msg), r.msg.ID); err != nil {
+		log.Printf("[Frequency] could not clear collapse marker: %v", err)
+	}
+}
@@ -0,0 +1,119 @@
+package frequency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLimiterSuppressesOverTheCap(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), Config{UserHourly: 2, Policy: PolicyDefer, MaxDeferrals: 1})
+	msg := Message{ID: "m", UserKey: "u1"}
+
+	for i := 0; i < 2; i++ {
+		if _, err := limiter.Reserve(ctx, msg); err != nil {
+			t.Fatalf("message %d suppressed: %v", i+1, err)
+		}
+	}
+
+	_, err := limiter.Reserve(ctx, msg)
+	var suppressed *SuppressedError
+	if !errors.As(err, &suppressed) || suppressed.Action != PolicyDefer {
+		t.Fatalf("third message returned %v, want it deferred", err)
+	}
+	if want := time.Now().Truncate(time.Hour).Add(time.Hour); !suppressed.Until.Equal(want) {
+		t.Fatalf("deferred until %s, want the next hour %s", suppressed.Until, want)
+	}
+
+	msg.Deferrals = 1
+	if _, err := limiter.Reserve(ctx, msg); !errors.As(err, &suppressed) || suppressed.Action != PolicyDrop {
+		t.Fatalf("message deferred once already returned %v, want it dropped", err)
+	}
+
+	if _, err := limiter.Reserve(ctx, Message{ID: "other", UserKey: "u2"}); err != nil {
+		t.Fatalf("another user was suppressed: %v", err)
+	}
+}
+
+func TestLimiterReleasesUnsentMessages(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), Config{UserDaily: 1, TemplateDaily: 1, Policy: PolicyDrop})
+	msg := Message{ID: "m", UserKey: "u1", Template: "welcome"}
+
+	reservation, err := limiter.Reserve(ctx, msg)
+	if err != nil || reservation == nil {
+		t.Fatalf("Reserve returned %v, %v", reservation, err)
+	}
+	reservation.Release(ctx)
+
+	if _, err := limiter.Reserve(ctx, msg); err != nil {
+		t.Fatalf("message after a release was suppressed: %v", err)
+	}
+	if _, err := limiter.Reserve(ctx, msg); err == nil {
+		t.Fatal("message over the daily cap was allowed")
+	}
+}
+
+func TestLimiterCollapsesDeferredMessages(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), Config{UserHourly: 1, Policy: PolicyCollapse, MaxDeferrals: 3})
+	limiter.Reserve(ctx, Message{ID: "sent", UserKey: "u1", Template: "digest"})
+
+	for _, id := range []string{"older", "newer"} {
+		_, err := limiter.Reserve(ctx, Message{ID: id, UserKey: "u1", Template: "digest"})
+		var suppressed *SuppressedError
+		if !errors.As(err, &suppressed) || suppressed.Action != PolicyCollapse {
+			t.Fatalf("%s returned %v, want it collapsed", id, err)
+		}
+	}
+
+	// Once the window reopens only the newest deferred message is sent.
+	_, err := limiter.Reserve(ctx, Message{ID: "older", UserKey: "u1", Template: "digest", Deferrals: 1})
+	var suppressed *SuppressedError
+	if !errors.As(err, &suppressed) || suppressed.Action != PolicyDrop {
+		t.Fatalf("older message returned %v, want it dropped", err)
+	}
+}
+
+func TestLimiterIgnoresUncappedMessages(t *testing.T) {
+	ctx := context.Background()
+	limiter := NewLimiter(NewMemoryStore(), Config{UserHourly: 1})
+	for i := 0; i < 3; i++ {
+		// Topic broadcasts have no user to count against.
+		if reservation, err := limiter.Reserve(ctx, Message{ID: "topic"}); reservation != nil || err != nil {
+			t.Fatalf("broadcast returned %v, %v", reservation, err)
+		}
+	}
+
+	var disabled *Limiter
+	if disabled.Enabled() {
+		t.Fatal("nil limiter reports enabled")
+	}
+	var reservation *Reservation
+	reservation.Release(context.Background())
+	reservation.Sent(context.Background())
+}
+
+func TestLimiterClearsTheMarkerOfDroppedMessages(t *testing.T) {
+	ctx := context.Background()
+	store := NewMemoryStore()
+	limiter := NewLimiter(store, Config{UserHourly: 1, Policy: PolicyCollapse, MaxDeferrals: 1})
+	msg := Message{ID: "digest", UserKey: "u1", Template: "digest"}
+	limiter.Reserve(ctx, Message{ID: "sent", UserKey: "u1", Template: "digest"})
+
+	limiter.Reserve(ctx, msg)
+	if marker, _ := store.Get(ctx, collapseKey(msg)); marker != msg.ID {
+		t.Fatalf("deferred message left marker %q", marker)
+	}
+
+	msg.Deferrals = 1
+	var suppressed *SuppressedError
+	if _, err := limiter.Reserve(ctx, msg); !errors.As(err, &suppressed) || suppressed.Action != PolicyDrop {
+		t.Fatalf("message over its deferrals returned %v, want it dropped", err)
+	}
+	if marker, _ := store.Get(ctx, collapseKey(msg)); marker != "" {
+		t.Fatalf("dropped message kept marker %q", marker)
+	}
+}
@@ -0,0 +1,110 @@
+package frequency
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore is a Store backed by Redis, so caps are shared by every
+// instance of the service.
+type RedisStore struct {
+	client *redis.Client
+}
+
+func NewRedisStore(redisURL string) (*RedisStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse REDIS_URL: %w", err)
+	}
+	return &RedisStore{client: redis.NewClient(opts)}, nil
+}
+
+// reserveScript checks every counter against its limit and only then
+// increments them all, so concurrent workers and instances cannot overshoot
+// a cap. KEYS are the counters; ARGV holds their limits followed by their
+// TTLs in milliseconds.
+var reserveScript = redis.NewScript(`
+local n = #KEYS
+for i = 1, n do
+	local count = tonumber(redis.call('GET', KEYS[i]) or '0')
+	if count >= tonumber(ARGV[i]) then
+		return i - 1
+	end
+end
+for i = 1, n do
+	if redis.call('INCR', KEYS[i]) == 1 then
+		redis.call('PEXPIRE', KEYS[i], ARGV[n + i])
+	end
+end
+return -1
+`)
+
+// releaseScript decrements counters that still exist, so an expired window
+// is not brought back as a negative count.
+var releaseScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if tonumber(redis.call('GET', KEYS[i]) or '0') > 0 then
+		redis.call('DECR', KEYS[i])
+	end
+end
+return 0
+`)
+
+// deleteScript removes KEYS[1] only if it still holds ARGV[1].
+var deleteScript = redis.NewScript(`
+if redis.call('GET', KEYS[1]) == ARGV[1] then
+	redis.call('DEL', KEYS[1])
+end
+return 0
+`)
+
+func (s *RedisStore) Reserve(ctx context.Context, counters []Counter) (int, error) {
+	keys := make([]string, len(counters))
+	args := make([]any, 2*len(counters))
+	for i, counter := range counters {
+		keys[i] = counter.Key
+		args[i] = counter.Limit
+		args[len(counters)+i] = counter.TTL.Milliseconds()
+	}
+	full, err := reserveScript.Run(ctx, s.client, keys, args...).Int()
+	if err != nil {
+		return 0, fmt.Errorf("could not reserve %v: %w", keys, err)
+	}
+	return full, nil
+}
+
+func (s *RedisStore) Release(ctx context.Context, keys []string) error {
+	if err := releaseScript.Run(ctx, s.client, keys).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("could not release %v: %w", keys, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	if err := s.client.Set(ctx, key, value, ttl).Err(); err != nil {
+		return fmt.Errorf("could not set %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) Get(ctx context.Context, key string) (string, error) {
+	value, err := s.client.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not read %s: %w", key, err)
+	}
+	return value, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, key, value string) error {
+	if err := deleteScript.Run(ctx, s.client, []string{key}, value).Err(); err != nil && !errors.Is(err, redis.Nil) {
+		return fmt.Errorf("could not delete %s: %w", key, err)
+	}
+	return nil
+}
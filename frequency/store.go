@@ -0,0 +1,159 @@
+package frequency
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Store keeps the counters and markers used to enforce frequency caps.
+// Implementations must be safe for concurrent use by the consumer workers.
+type Store interface {
+	// Reserve increments every counter in one atomic step, but only if
+	// each is below its limit. It returns the index of the first counter
+	// that is full, or -1 once all were incremented. New counters expire
+	// after their ttl.
+	Reserve(ctx context.Context, counters []Counter) (int, error)
+	// Release decrements counters taken by Reserve.
+	Release(ctx context.Context, keys []string) error
+	// Set stores value under key until ttl elapses.
+	Set(ctx context.Context, key, value string, ttl time.Duration) error
+	// Get returns the value stored under key, or "" if it does not exist.
+	Get(ctx context.Context, key string) (string, error)
+	// Delete removes key if it still holds value, so a marker that has
+	// since been replaced is kept.
+	Delete(ctx context.Context, key, value string) error
+}
+
+// Counter is a capped count kept under Key.
+type Counter struct {
+	Key   string
+	Limit int64
+	TTL   time.Duration
+}
+
+// NewStoreFromEnv builds the store selected by FREQ_CAP_STORE ("memory" or "redis").
+func NewStoreFromEnv() (Store, error) {
+	switch backend := os.Getenv("FREQ_CAP_STORE"); backend {
+	case "", "memory":
+		return NewMemoryStore(), nil
+	case "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is not set")
+		}
+		return NewRedisStore(redisURL)
+	default:
+		return nil, fmt.Errorf("unknown frequency cap store %q", backend)
+	}
+}
+
+type memoryEntry struct {
+	count     int64
+	value     string
+	expiresAt time.Time
+}
+
+// MemoryStore is an in-process Store. Counters are lost on restart and are
+// not shared between instances.
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]*memoryEntry
+	lastGC  time.Time
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: make(map[string]*memoryEntry)}
+}
+
+func (s *MemoryStore) Reserve(ctx context.Context, counters []Counter) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	s.collect(now)
+
+	for i, counter := range counters {
+		if entry := s.lookup(counter.Key, now); entry != nil && entry.count >= counter.Limit {
+			return i, nil
+		}
+	}
+	for _, counter := range counters {
+		entry := s.lookup(counter.Key, now)
+		if entry == nil {
+			entry = &memoryEntry{expiresAt: now.Add(counter.TTL)}
+			s.entries[counter.Key] = entry
+		}
+		entry.count++
+	}
+	return -1, nil
+}
+
+func (s *MemoryStore) Release(ctx context.Context, keys []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		if entry := s.lookup(key, now); entry != nil && entry.count > 0 {
+			entry.count--
+		}
+	}
+	return nil
+}
+
+func (s *MemoryStore) Set(ctx context.Context, key, value string, ttl time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.entries[key] = &memoryEntry{value: value, expiresAt: time.Now().Add(ttl)}
+	return nil
+}
+
+func (s *MemoryStore) Get(ctx context.Context, key string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.lookup(key, time.Now()); entry != nil {
+		return entry.value, nil
+	}
+	return "", nil
+}
+
+func (s *MemoryStore) Delete(ctx context.Context, key, value string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry := s.lookup(key, time.Now()); entry != nil && entry.value == value {
+		delete(s.entries, key)
+	}
+	return nil
+}
+
+func (s *MemoryStore) lookup(key string, now time.Time) *memoryEntry {
+	entry, ok := s.entries[key]
+	if !ok {
+		return nil
+	}
+	if now.After(entry.expiresAt) {
+		delete(s.entries, key)
+		return nil
+	}
+	return entry
+}
+
+// collect drops expired entries at most once a minute so the map does not
+// grow with every user ever seen.
+func (s *MemoryStore) collect(now time.Time) {
+	if now.Sub(s.lastGC) < time.Minute {
+		return
+	}
+	s.lastGC = now
+	for key, entry := range s.entries {
+		if now.After(entry.expiresAt) {
+			delete(s.entries, key)
+		}
+	}
+}
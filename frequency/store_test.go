@@ -0,0 +1,128 @@
+package frequency
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore(), func(d time.Duration) { time.Sleep(d) })
+}
+
+func TestRedisStore(t *testing.T) {
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://" + server.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore(t, store, server.FastForward)
+}
+
+// testStore runs the behaviour every Store must share. wait lets time pass
+// for the store.
+func testStore(t *testing.T, store Store, wait func(time.Duration)) {
+	ctx := context.Background()
+	counters := func(prefix string, limits ...int64) []Counter {
+		var counters []Counter
+		for i, limit := range limits {
+			counters = append(counters, Counter{Key: prefix + string(rune('a'+i)), Limit: limit, TTL: time.Hour})
+		}
+		return counters
+	}
+
+	t.Run("reserves every counter or none", func(t *testing.T) {
+		both := counters("all:", 3, 1)
+		if full, err := store.Reserve(ctx, both); err != nil || full != -1 {
+			t.Fatalf("first Reserve returned %d, %v", full, err)
+		}
+		if full, _ := store.Reserve(ctx, both); full != 1 {
+			t.Fatalf("second Reserve returned %d, want the second counter full", full)
+		}
+		// Only the first reservation counted against the first counter.
+		first := both[:1]
+		for i := 0; i < 2; i++ {
+			if full, _ := store.Reserve(ctx, first); full != -1 {
+				t.Fatalf("reservation %d of the first counter returned %d", i+2, full)
+			}
+		}
+		if full, _ := store.Reserve(ctx, first); full != 0 {
+			t.Fatalf("fourth reservation of the first counter returned %d", full)
+		}
+	})
+
+	t.Run("releases a place", func(t *testing.T) {
+		one := counters("release:", 1)
+		store.Reserve(ctx, one)
+		if err := store.Release(ctx, []string{one[0].Key}); err != nil {
+			t.Fatal(err)
+		}
+		if full, _ := store.Reserve(ctx, one); full != -1 {
+			t.Fatalf("Reserve after Release returned %d", full)
+		}
+		// Releasing more than was reserved never frees extra places.
+		store.Release(ctx, []string{one[0].Key})
+		store.Release(ctx, []string{one[0].Key})
+		store.Reserve(ctx, one)
+		if full, _ := store.Reserve(ctx, one); full != 0 {
+			t.Fatalf("Reserve after releasing twice returned %d", full)
+		}
+	})
+
+	t.Run("never overshoots under contention", func(t *testing.T) {
+		capped := counters("race:", 5)
+		var (
+			wg       sync.WaitGroup
+			mu       sync.Mutex
+			admitted int
+		)
+		for i := 0; i < 50; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if full, err := store.Reserve(ctx, capped); err == nil && full < 0 {
+					mu.Lock()
+					admitted++
+					mu.Unlock()
+				}
+			}()
+		}
+		wg.Wait()
+		if admitted != 5 {
+			t.Fatalf("%d reservations admitted under a cap of 5", admitted)
+		}
+	})
+
+	t.Run("counters expire", func(t *testing.T) {
+		short := []Counter{{Key: "expire:a", Limit: 1, TTL: 50 * time.Millisecond}}
+		store.Reserve(ctx, short)
+		if full, _ := store.Reserve(ctx, short); full != 0 {
+			t.Fatalf("Reserve before expiry returned %d", full)
+		}
+		wait(100 * time.Millisecond)
+		if full, _ := store.Reserve(ctx, short); full != -1 {
+			t.Fatalf("Reserve after expiry returned %d", full)
+		}
+	})
+
+	t.Run("sets and deletes values", func(t *testing.T) {
+		if err := store.Set(ctx, "value", "m1", time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		if value, _ := store.Get(ctx, "value"); value != "m1" {
+			t.Fatalf("Get returned %q", value)
+		}
+		// Only the value the caller saw is deleted.
+		store.Delete(ctx, "value", "m2")
+		if value, _ := store.Get(ctx, "value"); value != "m1" {
+			t.Fatalf("Delete of another value left %q", value)
+		}
+		store.Delete(ctx, "value", "m1")
+		if value, err := store.Get(ctx, "value"); value != "" || err != nil {
+			t.Fatalf("Get after Delete returned %q, %v", value, err)
+		}
+	})
+}
@@ -0,0 +1,15 @@
+package inbox
+
+import (
+	"context"
+
+	"push_service/models"
+)
+
+// Channel delivers in-app only: the notification is saved to the user's
+// inbox like every delivered notification, and sent nowhere else.
+type Channel struct{}
+
+func (Channel) Send(_ context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	return req.ID, nil
+}
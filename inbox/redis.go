@@ -0,0 +1,233 @@
+package inbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"push_service/models"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// RedisStore is a Store backed by Redis, so every instance of the service
+// sees the same inboxes. Each user has three keys: the items as JSON by ID,
+// their IDs ordered by arrival, and the time each read item was read.
+type RedisStore struct {
+	client   *redis.Client
+	maxItems int
+}
+
+func NewRedisStore(redisURL string, maxItems int) (*RedisStore, error) {
+	opts, err := redis.ParseURL(redisURL)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse REDIS_URL: %w", err)
+	}
+	if maxItems <= 0 {
+		maxItems = DefaultMaxItems
+	}
+	return &RedisStore{client: redis.NewClient(opts), maxItems: maxItems}, nil
+}
+
+// keys returns the item, order and read keys of userID. They share a hash
+// tag so the scripts also work on a cluster.
+func keys(userID string) []string {
+	prefix := "inbox:{" + userID + "}:"
+	return []string{prefix + "items", prefix + "order", prefix + "read"}
+}
+
+// saveScript adds an item unless its ID is already there, then drops the
+// oldest items beyond the limit. The order is a sequence rather than the
+// creation time so items stay in the order they arrived. ARGV are the ID,
+// the JSON and the limit.
+var saveScript = redis.NewScript(`
+if redis.call('HSETNX', KEYS[1], ARGV[1], ARGV[2]) == 0 then
+	return 0
+end
+local last = redis.call('ZREVRANGE', KEYS[2], 0, 0, 'WITHSCORES')
+local seq = 1
+if #last > 0 then
+	seq = tonumber(last[2]) + 1
+end
+redis.call('ZADD', KEYS[2], seq, ARGV[1])
+local over = redis.call('ZCARD', KEYS[2]) - tonumber(ARGV[3])
+if over > 0 then
+	local dropped = redis.call('ZRANGE', KEYS[2], 0, over - 1)
+	redis.call('HDEL', KEYS[1], unpack(dropped))
+	redis.call('HDEL', KEYS[3], unpack(dropped))
+	redis.call('ZREMRANGEBYRANK', KEYS[2], 0, over - 1)
+end
+return 1
+`)
+
+// listScript returns the unread count, the next cursor and then the JSON
+// and read time of each item on the page, or false if before is unknown.
+// ARGV are before, the limit and whether only unread items are wanted.
+var listScript = redis.NewScript(`
+local stop = redis.call('ZCARD', KEYS[2])
+if ARGV[1] ~= '' then
+	stop = redis.call('ZRANK', KEYS[2], ARGV[1])
+	if not stop then
+		return false
+	end
+end
+local limit = tonumber(ARGV[2])
+local page = {redis.call('ZCARD', KEYS[2]) - redis.call('HLEN', KEYS[3]), ''}
+if stop == 0 then
+	return page
+end
+local ids = redis.call('ZRANGE', KEYS[2], 0, stop - 1)
+local count, last = 0, ''
+for i = #ids, 1, -1 do
+	local readAt = redis.call('HGET', KEYS[3], ids[i])
+	if ARGV[3] ~= '1' or not readAt then
+		if count == limit then
+			page[2] = last
+			break
+		end
+		count, last = count + 1, ids[i]
+		page[#page + 1] = redis.call('HGET', KEYS[1], ids[i])
+		page[#page + 1] = readAt or ''
+	end
+end
+return page
+`)
+
+// markReadScript records when an item was first read and returns its JSON
+// and read time, or false if there is no such item. ARGV are the ID and
+// the time.
+var markReadScript = redis.NewScript(`
+local item = redis.call('HGET', KEYS[1], ARGV[1])
+if not item then
+	return false
+end
+redis.call('HSETNX', KEYS[3], ARGV[1], ARGV[2])
+return {item, redis.call('HGET', KEYS[3], ARGV[1])}
+`)
+
+// markAllReadScript marks every unread item read at ARGV[1] and returns how
+// many there were.
+var markAllReadScript = redis.NewScript(`
+local marked = 0
+for _, id in ipairs(redis.call('ZRANGE', KEYS[2], 0, -1)) do
+	if redis.call('HSETNX', KEYS[3], id, ARGV[1]) == 1 then
+		marked = marked + 1
+	end
+end
+return marked
+`)
+
+// deleteScript removes the item ARGV[1] and returns 0 if there was none.
+var deleteScript = redis.NewScript(`
+if redis.call('HDEL', KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+redis.call('ZREM', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[3], ARGV[1])
+return 1
+`)
+
+func (s *RedisStore) Save(ctx context.Context, item models.InboxItem) error {
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	// Read times are kept apart, so new items are always unread.
+	item.ReadAt = nil
+	encoded, err := json.Marshal(item)
+	if err != nil {
+		return fmt.Errorf("could not encode inbox item %s: %w", item.ID, err)
+	}
+	if err := saveScript.Run(ctx, s.client, keys(item.UserID), item.ID, encoded, s.maxItems).Err(); err != nil {
+		return fmt.Errorf("could not save inbox item %s: %w", item.ID, err)
+	}
+	return nil
+}
+
+func (s *RedisStore) List(ctx context.Context, userID string, unreadOnly bool, before string, limit int) (models.InboxPage, error) {
+	only := "0"
+	if unreadOnly {
+		only = "1"
+	}
+	reply, err := listScript.Run(ctx, s.client, keys(userID), before, limit, only).Slice()
+	if errors.Is(err, redis.Nil) {
+		return models.InboxPage{}, fmt.Errorf("%w: %s", ErrItemNotFound, before)
+	}
+	if err != nil {
+		return models.InboxPage{}, fmt.Errorf("could not list the inbox of %s: %w", userID, err)
+	}
+
+	unread, _ := reply[0].(int64)
+	page := models.InboxPage{Items: []models.InboxItem{}, Unread: int(unread)}
+	for i := 2; i+1 < len(reply); i += 2 {
+		item, err := decode(reply[i], reply[i+1])
+		if err != nil {
+			return models.InboxPage{}, err
+		}
+		page.Items = append(page.Items, item)
+	}
+	page.Next, _ = reply[1].(string)
+	return page, nil
+}
+
+func (s *RedisStore) Unread(ctx context.Context, userID string) (int, error) {
+	k := keys(userID)
+	pipe := s.client.TxPipeline()
+	total := pipe.ZCard(ctx, k[1])
+	read := pipe.HLen(ctx, k[2])
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, fmt.Errorf("could not count the unread items of %s: %w", userID, err)
+	}
+	return int(total.Val() - read.Val()), nil
+}
+
+func (s *RedisStore) MarkRead(ctx context.Context, userID, id string) (models.InboxItem, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	reply, err := markReadScript.Run(ctx, s.client, keys(userID), id, now).Slice()
+	if errors.Is(err, redis.Nil) {
+		return models.InboxItem{}, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+	if err != nil {
+		return models.InboxItem{}, fmt.Errorf("could not mark inbox item %s read: %w", id, err)
+	}
+	return decode(reply[0], reply[1])
+}
+
+func (s *RedisStore) MarkAllRead(ctx context.Context, userID string) (int, error) {
+	now := time.Now().UTC().Format(time.RFC3339Nano)
+	marked, err := markAllReadScript.Run(ctx, s.client, keys(userID), now).Int()
+	if err != nil {
+		return 0, fmt.Errorf("could not mark the inbox of %s read: %w", userID, err)
+	}
+	return marked, nil
+}
+
+func (s *RedisStore) Delete(ctx context.Context, userID, id string) error {
+	deleted, err := deleteScript.Run(ctx, s.client, keys(userID), id).Int()
+	if err != nil {
+		return fmt.Errorf("could not delete inbox item %s: %w", id, err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+	return nil
+}
+
+// decode parses an item and the time it was read, which is empty while it
+// is unread.
+func decode(encoded, readAt any) (models.InboxItem, error) {
+	raw, _ := encoded.(string)
+	var item models.InboxItem
+	if err := json.Unmarshal([]byte(raw), &item); err != nil {
+		return models.InboxItem{}, fmt.Errorf("could not decode inbox item: %w", err)
+	}
+	if value, _ := readAt.(string); value != "" {
+		read, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return models.InboxItem{}, fmt.Errorf("could not decode read time of inbox item %s: %w", item.ID, err)
+		}
+		item.ReadAt = &read
+	}
+	return item, nil
+}
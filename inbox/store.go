@@ -0,0 +1,298 @@
+package inbox
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"push_service/models"
+	"push_service/util"
+)
+
+const (
+	// DefaultMaxItems is how many notifications each inbox keeps; older
+	// ones are dropped.
+	DefaultMaxItems = 500
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+var ErrItemNotFound = errors.New("inbox item not found")
+
+// Store holds every user's inbox, oldest item first. Implementations must be
+// safe for concurrent use by the consumer workers and the API.
+type Store interface {
+	// Save adds item to its user's inbox. Saving a notification again, as
+	// a redelivery does, keeps the first copy.
+	Save(ctx context.Context, item models.InboxItem) error
+	// List returns up to limit items of userID older than the item before,
+	// newest first, and the cursor of the next page.
+	List(ctx context.Context, userID string, unreadOnly bool, before string, limit int) (models.InboxPage, error)
+	// Unread counts the unread items of userID.
+	Unread(ctx context.Context, userID string) (int, error)
+	// MarkRead marks item id of userID read, if it is not already.
+	MarkRead(ctx context.Context, userID, id string) (models.InboxItem, error)
+	// MarkAllRead marks every item of userID read and returns how many
+	// were unread.
+	MarkAllRead(ctx context.Context, userID string) (int, error)
+	Delete(ctx context.Context, userID, id string) error
+}
+
+// NewStoreFromEnv builds the store selected by INBOX_STORE ("memory" or
+// "redis"), keeping INBOX_MAX_ITEMS per user. The memory store is loaded
+// from INBOX_FILE.
+func NewStoreFromEnv() (Store, error) {
+	maxItems := DefaultMaxItems
+	if raw := os.Getenv("INBOX_MAX_ITEMS"); raw != "" {
+		if n, err := strconv.Atoi(raw); err == nil && n > 0 {
+			maxItems = n
+		} else {
+			log.Printf("Ignoring invalid INBOX_MAX_ITEMS=%q", raw)
+		}
+	}
+
+	switch backend := os.Getenv("INBOX_STORE"); backend {
+	case "", "memory":
+		return NewMemoryStore(os.Getenv("INBOX_FILE"), maxItems)
+	case "redis":
+		redisURL := os.Getenv("REDIS_URL")
+		if redisURL == "" {
+			return nil, fmt.Errorf("REDIS_URL is not set")
+		}
+		return NewRedisStore(redisURL, maxItems)
+	default:
+		return nil, fmt.Errorf("unknown inbox store %q", backend)
+	}
+}
+
+// MemoryStore is an in-process Store for development and single instances.
+// When path is set the items are persisted to that JSON file on every
+// change; use the Redis store to share inboxes between instances.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	path     string
+	maxItems int
+	items    map[string][]*models.InboxItem // by user ID, oldest first
+}
+
+// BadgeFromEnv reports whether INBOX_BADGE asks for pushes to carry the
+// user's unread count as their badge.
+func BadgeFromEnv() bool {
+	raw := os.Getenv("INBOX_BADGE")
+	if raw == "" {
+		return false
+	}
+	badge, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid INBOX_BADGE=%q", raw)
+		return false
+	}
+	return badge
+}
+
+func NewMemoryStore(path string, maxItems int) (*MemoryStore, error) {
+	if maxItems <= 0 {
+		maxItems = DefaultMaxItems
+	}
+	s := &MemoryStore{path: path, maxItems: maxItems, items: make(map[string][]*models.InboxItem)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *MemoryStore) Save(_ context.Context, item models.InboxItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.items[item.UserID]
+	if slices.ContainsFunc(items, func(existing *models.InboxItem) bool { return existing.ID == item.ID }) {
+		return nil
+	}
+	if item.CreatedAt.IsZero() {
+		item.CreatedAt = time.Now()
+	}
+	previous := items
+	items = append(slices.Clip(items), &item)
+	if over := len(items) - s.maxItems; over > 0 {
+		items = items[over:]
+	}
+	s.items[item.UserID] = items
+	if err := s.save(); err != nil {
+		s.restoreLocked(item.UserID, previous)
+		return err
+	}
+	return nil
+}
+
+func (s *MemoryStore) List(_ context.Context, userID string, unreadOnly bool, before string, limit int) (models.InboxPage, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	items := s.items[userID]
+	end := len(items)
+	if before != "" {
+		end = slices.IndexFunc(items, func(item *models.InboxItem) bool { return item.ID == before })
+		if end < 0 {
+			return models.InboxPage{}, fmt.Errorf("%w: %s", ErrItemNotFound, before)
+		}
+	}
+
+	page := models.InboxPage{Items: []models.InboxItem{}, Unread: unread(items)}
+	for i := end - 1; i >= 0; i-- {
+		if unreadOnly && items[i].ReadAt != nil {
+			continue
+		}
+		if len(page.Items) == limit {
+			page.Next = page.Items[len(page.Items)-1].ID
+			break
+		}
+		page.Items = append(page.Items, *items[i])
+	}
+	return page, nil
+}
+
+func (s *MemoryStore) Unread(_ context.Context, userID string) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return unread(s.items[userID]), nil
+}
+
+func unread(items []*models.InboxItem) int {
+	count := 0
+	for _, item := range items {
+		if item.ReadAt == nil {
+			count++
+		}
+	}
+	return count
+}
+
+func (s *MemoryStore) MarkRead(_ context.Context, userID, id string) (models.InboxItem, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range s.items[userID] {
+		if item.ID != id {
+			continue
+		}
+		if item.ReadAt == nil {
+			now := time.Now()
+			item.ReadAt = &now
+			if err := s.save(); err != nil {
+				item.ReadAt = nil
+				return models.InboxItem{}, err
+			}
+		}
+		return *item, nil
+	}
+	return models.InboxItem{}, fmt.Errorf("%w: %s", ErrItemNotFound, id)
+}
+
+func (s *MemoryStore) MarkAllRead(_ context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var marked []*models.InboxItem
+	for _, item := range s.items[userID] {
+		if item.ReadAt == nil {
+			item.ReadAt = &now
+			marked = append(marked, item)
+		}
+	}
+	if len(marked) == 0 {
+		return 0, nil
+	}
+	if err := s.save(); err != nil {
+		for _, item := range marked {
+			item.ReadAt = nil
+		}
+		return 0, err
+	}
+	return len(marked), nil
+}
+
+func (s *MemoryStore) Delete(_ context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	items := s.items[userID]
+	i := slices.IndexFunc(items, func(item *models.InboxItem) bool { return item.ID == id })
+	if i < 0 {
+		return fmt.Errorf("%w: %s", ErrItemNotFound, id)
+	}
+	s.restoreLocked(userID, slices.Concat(items[:i], items[i+1:]))
+	if err := s.save(); err != nil {
+		s.restoreLocked(userID, items)
+		return err
+	}
+	return nil
+}
+
+// restoreLocked sets the items of userID, dropping the user once they have
+// none. Changes that could not be saved are undone with it, so memory never
+// gets ahead of the file.
+func (s *MemoryStore) restoreLocked(userID string, items []*models.InboxItem) {
+	if len(items) == 0 {
+		delete(s.items, userID)
+		return
+	}
+	s.items[userID] = items
+}
+
+func (s *MemoryStore) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read inbox file: %w", err)
+	}
+
+	var items []models.InboxItem
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("failed to parse inbox file: %w", err)
+	}
+	for _, item := range items {
+		s.items[item.UserID] = append(s.items[item.UserID], &item)
+	}
+	for _, userItems := range s.items {
+		slices.SortStableFunc(userItems, func(a, b *models.InboxItem) int { return a.CreatedAt.Compare(b.CreatedAt) })
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *MemoryStore) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var items []models.InboxItem
+	for _, userItems := range s.items {
+		for _, item := range userItems {
+			items = append(items, *item)
+		}
+	}
+
+	data, err := json.MarshalIndent(items, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode inbox: %w", err)
+	}
+	if err := util.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write inbox file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package inbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"push_service/models"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func newRedisStore(t *testing.T, maxItems int) *RedisStore {
+	t.Helper()
+	server := miniredis.RunT(t)
+	store, err := NewRedisStore("redis://"+server.Addr(), maxItems)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestRedisStore(t *testing.T) {
+	testStore(t, func(t *testing.T, maxItems int) Store { return newRedisStore(t, maxItems) })
+}
+
+func TestRedisStoreIsSharedByInstances(t *testing.T) {
+	ctx := context.Background()
+	server := miniredis.RunT(t)
+	first, _ := NewRedisStore("redis://"+server.Addr(), 0)
+	second, _ := NewRedisStore("redis://"+server.Addr(), 0)
+
+	if err := first.Save(ctx, models.InboxItem{ID: "a", UserID: "u1", Body: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := second.MarkRead(ctx, "u1", "a"); err != nil {
+		t.Fatalf("item saved by one instance is unknown to another: %v", err)
+	}
+	if unread, _ := first.Unread(ctx, "u1"); unread != 0 {
+		t.Fatalf("read on one instance, still %d unread on another", unread)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, func(t *testing.T, maxItems int) Store {
+		store, err := NewMemoryStore("", maxItems)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return store
+	})
+}
+
+// testStore runs the behaviour every Store must share.
+func testStore(t *testing.T, newStore func(t *testing.T, maxItems int) Store) {
+	ctx := context.Background()
+	save := func(t *testing.T, store Store, ids ...string) {
+		t.Helper()
+		for i, id := range ids {
+			item := models.InboxItem{ID: id, UserID: "u1", Body: "body " + id, CreatedAt: time.Unix(int64(i), 0)}
+			if err := store.Save(ctx, item); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	listIDs := func(page models.InboxPage) []string {
+		ids := []string{}
+		for _, item := range page.Items {
+			ids = append(ids, item.ID)
+		}
+		return ids
+	}
+
+	t.Run("pages newest first", func(t *testing.T) {
+		store := newStore(t, 0)
+		save(t, store, "a", "b", "c", "d", "e")
+
+		page, err := store.List(ctx, "u1", false, "", 2)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%v %s %d", listIDs(page), page.Next, page.Unread); got != "[e d] d 5" {
+			t.Fatalf("first page is %s", got)
+		}
+		page, _ = store.List(ctx, "u1", false, page.Next, 2)
+		page, _ = store.List(ctx, "u1", false, page.Next, 2)
+		if got := fmt.Sprintf("%v %s", listIDs(page), page.Next); got != "[a] " {
+			t.Fatalf("last page is %s", got)
+		}
+		if _, err := store.List(ctx, "u1", false, "missing", 2); !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("unknown cursor returned %v", err)
+		}
+		if page, _ := store.List(ctx, "nobody", false, "", 2); len(page.Items) != 0 || page.Unread != 0 {
+			t.Fatalf("empty inbox returned %+v", page)
+		}
+	})
+
+	t.Run("keeps the first copy", func(t *testing.T) {
+		store := newStore(t, 0)
+		save(t, store, "a")
+		if err := store.Save(ctx, models.InboxItem{ID: "a", UserID: "u1", Body: "again"}); err != nil {
+			t.Fatal(err)
+		}
+		page, _ := store.List(ctx, "u1", false, "", 10)
+		if len(page.Items) != 1 || page.Items[0].Body != "body a" {
+			t.Fatalf("inbox holds %+v", page.Items)
+		}
+	})
+
+	t.Run("drops the oldest beyond the limit", func(t *testing.T) {
+		store := newStore(t, 3)
+		save(t, store, "a", "b", "c", "d")
+		if _, err := store.MarkRead(ctx, "u1", "a"); !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("dropped item could be marked read: %v", err)
+		}
+		page, _ := store.List(ctx, "u1", false, "", 10)
+		if got := fmt.Sprintf("%v %d", listIDs(page), page.Unread); got != "[d c b] 3" {
+			t.Fatalf("inbox is %s", got)
+		}
+	})
+
+	t.Run("marks read", func(t *testing.T) {
+		store := newStore(t, 0)
+		save(t, store, "a", "b", "c")
+
+		item, err := store.MarkRead(ctx, "u1", "b")
+		if err != nil || item.ReadAt == nil || item.Body != "body b" {
+			t.Fatalf("MarkRead returned %+v, %v", item, err)
+		}
+		again, _ := store.MarkRead(ctx, "u1", "b")
+		if !again.ReadAt.Equal(*item.ReadAt) {
+			t.Fatalf("marking read again moved the read time from %s to %s", item.ReadAt, again.ReadAt)
+		}
+		if unread, _ := store.Unread(ctx, "u1"); unread != 2 {
+			t.Fatalf("unread is %d, want 2", unread)
+		}
+
+		page, _ := store.List(ctx, "u1", true, "", 1)
+		if got := fmt.Sprintf("%v %s", listIDs(page), page.Next); got != "[c] c" {
+			t.Fatalf("first unread page is %s", got)
+		}
+		page, _ = store.List(ctx, "u1", true, page.Next, 1)
+		if got := fmt.Sprintf("%v %s", listIDs(page), page.Next); got != "[a] " {
+			t.Fatalf("second unread page is %s", got)
+		}
+
+		marked, err := store.MarkAllRead(ctx, "u1")
+		if err != nil || marked != 2 {
+			t.Fatalf("MarkAllRead returned %d, %v", marked, err)
+		}
+		if unread, _ := store.Unread(ctx, "u1"); unread != 0 {
+			t.Fatalf("unread is %d after marking all read", unread)
+		}
+	})
+
+	t.Run("deletes", func(t *testing.T) {
+		store := newStore(t, 0)
+		save(t, store, "a", "b")
+		if err := store.Delete(ctx, "u1", "a"); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Delete(ctx, "u1", "a"); !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("deleting again returned %v", err)
+		}
+		if err := store.Delete(ctx, "u2", "b"); !errors.Is(err, ErrItemNotFound) {
+			t.Fatalf("deleting another user's item returned %v", err)
+		}
+		page, _ := store.List(ctx, "u1", false, "", 10)
+		if got := fmt.Sprintf("%v %d", listIDs(page), page.Unread); got != "[b] 1" {
+			t.Fatalf("inbox is %s", got)
+		}
+	})
+}
@@ -0,0 +1,155 @@
+package locale
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/number"
+)
+
+// Default ends every fallback chain.
+const Default = "en"
+
+var tagPattern = regexp.MustCompile(`^[a-zA-Z]{2,3}([-_][a-zA-Z0-9]{2,8})*$`)
+
+// Valid reports whether tag looks like a BCP 47 language tag.
+func Valid(tag string) bool {
+	return tagPattern.MatchString(tag)
+}
+
+// Normalize canonicalises the case of tag: pt_br becomes pt-BR and
+// zh-hant-tw becomes zh-Hant-TW.
+func Normalize(tag string) string {
+	parts := strings.FieldsFunc(tag, func(r rune) bool { return r == '-' || r == '_' })
+	for i, part := range parts {
+		switch {
+		case i == 0:
+			parts[i] = strings.ToLower(part)
+		case len(part) == 2:
+			parts[i] = strings.ToUpper(part)
+		case len(part) == 4:
+			parts[i] = strings.ToUpper(part[:1]) + strings.ToLower(part[1:])
+		default:
+			parts[i] = strings.ToLower(part)
+		}
+	}
+	return strings.Join(parts, "-")
+}
+
+// Chain returns the locales to try for tag, most specific first and ending
+// with Default: pt-BR gives pt-BR, pt, en.
+func Chain(tag string) []string {
+	var chain []string
+	if Valid(tag) {
+		parts := strings.Split(Normalize(tag), "-")
+		for i := len(parts); i > 0; i-- {
+			chain = append(chain, strings.Join(parts[:i], "-"))
+		}
+	}
+	if len(chain) == 0 || chain[len(chain)-1] != Default {
+		chain = append(chain, Default)
+	}
+	return chain
+}
+
+// Language returns the language subtag of tag.
+func Language(tag string) string {
+	language, _, _ := strings.Cut(Normalize(tag), "-")
+	return language
+}
+
+// FormatNumber formats value with the digit grouping and decimal separator
+// of tag, such as 1,234.5 in en and 1.234,5 in pt-BR.
+func FormatNumber(tag string, value float64) string {
+	return message.NewPrinter(parse(tag)).Sprint(number.Decimal(value))
+}
+
+// ParseNumber accepts JSON numbers and numeric strings.
+func ParseNumber(value any) (float64, error) {
+	switch v := value.(type) {
+	case float64:
+		return v, nil
+	case int:
+		return float64(v), nil
+	case int64:
+		return float64(v), nil
+	case json.Number:
+		return v.Float64()
+	default:
+		return strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(v)), 64)
+	}
+}
+
+// dateLayouts are numeric date layouts by locale, looked up along the
+// fallback chain. ISO 8601 is used for anything not listed.
+var dateLayouts = map[string]string{
+	"en":    "01/02/2006",
+	"en-GB": "02/01/2006",
+	"en-AU": "02/01/2006",
+	"en-IN": "02/01/2006",
+	"de":    "02.01.2006",
+	"fr":    "02/01/2006",
+	"es":    "02/01/2006",
+	"it":    "02/01/2006",
+	"pt":    "02/01/2006",
+	"nl":    "02-01-2006",
+	"pl":    "02.01.2006",
+	"ru":    "02.01.2006",
+	"tr":    "02.01.2006",
+	"ja":    "2006/01/02",
+	"zh":    "2006/01/02",
+	"ko":    "2006. 01. 02.",
+	"yo":    "02/01/2006",
+}
+
+// timeLayouts are clock layouts for locales that use a 12-hour clock.
+var timeLayouts = map[string]string{
+	"en":    "3:04 PM",
+	"en-GB": "15:04",
+	"ko":    "PM 3:04",
+}
+
+// FormatDate formats t as a date, with the time of day when withTime is set.
+func FormatDate(tag string, t time.Time, withTime bool) string {
+	layout := lookup(dateLayouts, tag, "2006-01-02")
+	if withTime {
+		layout += " " + lookup(timeLayouts, tag, "15:04")
+	}
+	return t.Format(layout)
+}
+
+func lookup(layouts map[string]string, tag, fallback string) string {
+	chain := Chain(tag)
+	for _, candidate := range chain[:len(chain)-1] {
+		if layout, ok := layouts[candidate]; ok {
+			return layout
+		}
+	}
+	if Language(tag) == Default || tag == "" {
+		return layouts[Default]
+	}
+	return fallback
+}
+
+func parse(tag string) language.Tag {
+	parsed, err := language.Parse(Normalize(tag))
+	if err != nil {
+		return language.English
+	}
+	return parsed
+}
+
+// ParseDate accepts RFC 3339 timestamps and plain dates.
+func ParseDate(value any) (time.Time, error) {
+	text := strings.TrimSpace(fmt.Sprint(value))
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.DateOnly, text)
+}
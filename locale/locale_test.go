@@ -0,0 +1,86 @@
+package locale
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	for in, want := range map[string]string{
+		"pt_br":      "pt-BR",
+		"EN":         "en",
+		"zh-hant-tw": "zh-Hant-TW",
+		"es-419":     "es-419",
+	} {
+		if got := Normalize(in); got != want {
+			t.Errorf("Normalize(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestChain(t *testing.T) {
+	tests := map[string][]string{
+		"pt_BR":      {"pt-BR", "pt", "en"},
+		"zh-Hant-TW": {"zh-Hant-TW", "zh-Hant", "zh", "en"},
+		"en-GB":      {"en-GB", "en"},
+		"":           {"en"},
+		"not a tag":  {"en"},
+	}
+	for in, want := range tests {
+		if got := Chain(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("Chain(%q) = %v, want %v", in, got, want)
+		}
+	}
+}
+
+func TestFormatNumber(t *testing.T) {
+	for tag, want := range map[string]string{"en": "1,234.5", "pt-BR": "1.234,5", "de": "1.234,5", "bogus tag": "1,234.5"} {
+		if got := FormatNumber(tag, 1234.5); got != want {
+			t.Errorf("FormatNumber(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestFormatDate(t *testing.T) {
+	at := time.Date(2024, time.March, 7, 15, 4, 0, 0, time.UTC)
+	tests := []struct {
+		tag      string
+		withTime bool
+		want     string
+	}{
+		{"en", false, "03/07/2024"},
+		{"en-US", true, "03/07/2024 3:04 PM"},
+		{"en-GB", true, "07/03/2024 15:04"},
+		{"pt-BR", false, "07/03/2024"},
+		{"de-AT", true, "07.03.2024 15:04"},
+		{"sv", false, "2024-03-07"},
+		{"", false, "03/07/2024"},
+	}
+	for _, tt := range tests {
+		if got := FormatDate(tt.tag, at, tt.withTime); got != tt.want {
+			t.Errorf("FormatDate(%q, %v) = %q, want %q", tt.tag, tt.withTime, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	for _, value := range []any{float64(2.5), 2.5, "2.5", " 2.5 "} {
+		if got, err := ParseNumber(value); err != nil || got != 2.5 {
+			t.Errorf("ParseNumber(%v) = %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseNumber("two"); err == nil {
+		t.Error("ParseNumber accepted a word")
+	}
+
+	for _, value := range []string{"2024-03-07", "2024-03-07T00:00:00Z"} {
+		got, err := ParseDate(value)
+		if err != nil || !got.Equal(time.Date(2024, time.March, 7, 0, 0, 0, 0, time.UTC)) {
+			t.Errorf("ParseDate(%q) = %v, %v", value, got, err)
+		}
+	}
+	if _, err := ParseDate("07/03/2024"); err == nil {
+		t.Error("ParseDate accepted a localised date")
+	}
+}
@@ -1,20 +1,34 @@
 package main
 
 import (
-	// "context"
-
+	"context"
 	"log"
-	"os"
 	"push_service/api"
+	"push_service/apns"
+	"push_service/auth"
+	"push_service/broker"
 	"push_service/consumer"
+	"push_service/devices"
 	_ "push_service/docs"
+	"push_service/email"
+	"push_service/frequency"
+	"push_service/inbox"
 	"push_service/models"
+	"push_service/payload"
+	"push_service/sandbox"
+	sendNotification "push_service/sendNotification"
+	"push_service/status"
+	"push_service/stream"
+	"push_service/templates"
+	"push_service/truncate"
+	"push_service/upstream"
 	"push_service/util"
+	"push_service/webhook"
+	"push_service/webpush"
 
 	"github.com/gin-gonic/gin"
 	_ "github.com/jackc/pgx/v5/stdlib" // PostgreSQL driver
 	"github.com/joho/godotenv"
-	amqp "github.com/rabbitmq/amqp091-go" // RabbitMQ client
 
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
@@ -32,53 +46,127 @@ import (
 // @license.name  Apache 2.0
 // @license.url   http://www.apache.org/licenses/LICENSE-2.0.html
 
+// @securityDefinitions.apikey  ApiKeyAuth
+// @in                          header
+// @name                        X-API-Key
+
+// @securityDefinitions.apikey  BearerAuth
+// @in                          header
+// @name                        Authorization
+
 func main() {
 	err := godotenv.Load(".env")
 	if err != nil {
 		log.Println("Note: .env file not found, reading from system environment")
 	}
 
-	amqpURL := os.Getenv("RABBITMQ_URL")
-	if amqpURL == "" {
-		log.Fatal("RABBITMQ_URL is not set")
-	}
+	b, err := broker.NewFromEnv(models.PushTopology())
+	util.FailOnError(err, "Failed to set up message broker")
+	defer b.Close()
 
-	conn, err := amqp.Dial(amqpURL)
-	util.FailOnError(err, "Failed to connect to RabbitMQ")
-	defer conn.Close()
-
-	pubChannel, err := conn.Channel()
-	util.FailOnError(err, "Failed to open publisher channel")
-	defer pubChannel.Close()
-
-	conChannel, err := conn.Channel()
-	util.FailOnError(err, "Failed to open consumer channel")
-	defer conChannel.Close()
-
-	err = conChannel.ExchangeDeclare(
-		models.ExName,
-		"direct",
-		true,  // durable
-		false, // auto-deleted
-		false, // internal
-		false, // no-wait
-		nil,   // arguments
-	)
-	util.FailOnError(err, "Failed to declare main exchange")
+	dataMode := payload.NestedModeFromEnv()
+	statuses := status.NewStoreFromEnv()
 
 	p := models.Publisher{
-		Channel: pubChannel,
+		Broker:   b,
+		DataMode: dataMode,
+		Status:   statuses,
 	}
 
 	c := models.Consumer{
-		QueueName:     models.PushQueueName,
-		RetryQueue:    "retry_notif",
+		Broker:        b,
 		PrefetchCount: 1,
 		WorkerCount:   5,
+		DataMode:      dataMode,
+		Status:        statuses,
+		Limits:        truncate.LimitsFromEnv(),
+		Sandbox:       sendNotification.SandboxFromEnv(),
 	}
 
+	services := upstream.NewServicesFromEnv()
+	c.Upstream = services
+
+	capStore, err := frequency.NewStoreFromEnv()
+	util.FailOnError(err, "Failed to set up frequency cap store")
+	c.Limiter = frequency.NewLimiter(capStore, frequency.ConfigFromEnv())
+
+	templateStore, err := templates.NewStoreFromEnv()
+	util.FailOnError(err, "Failed to load local templates")
+	c.Templates = templates.NewResolverFromEnv(templateStore, services)
+
+	emailBroker, err := broker.NewFromEnv(models.EmailTopology())
+	util.FailOnError(err, "Failed to set up the email queue")
+	defer emailBroker.Close()
+	webhooks, err := webhook.NewStoreFromEnv()
+	util.FailOnError(err, "Failed to load webhook subscribers")
+
+	c.Channels = map[models.NotificationType]models.Channel{
+		models.Email:   &email.Channel{Broker: emailBroker},
+		models.Webhook: webhook.NewChannel(webhooks),
+	}
+
+	apnsConfig, err := apns.ConfigFromEnv()
+	util.FailOnError(err, "Failed to read the APNs configuration")
+	if apnsConfig != nil {
+		apnsClient, err := apns.NewClient(*apnsConfig)
+		util.FailOnError(err, "Failed to set up the APNs client")
+		defer apnsClient.Close()
+		c.Channels[models.APNs] = &apns.Channel{Client: apnsClient, Limit: c.Limits.APNS, DataMode: dataMode}
+	}
+
+	registry, err := devices.NewStoreFromEnv()
+	util.FailOnError(err, "Failed to load the device registry")
+	webPush, err := webpush.NewChannelFromEnv(registry)
+	util.FailOnError(err, "Failed to set up Web Push")
+	if webPush != nil {
+		webPush.Limit, webPush.DataMode = c.Limits.Webpush, dataMode
+		c.Channels[models.WebPush] = webPush
+	}
+
+	inboxes, err := inbox.NewStoreFromEnv()
+	util.FailOnError(err, "Failed to load inboxes")
+	c.Inbox = inboxes
+	c.InboxBadge = inbox.BadgeFromEnv()
+	c.Channels[models.InApp] = inbox.Channel{}
+
+	instance := stream.InstanceFromEnv()
+	streamBroker, err := broker.NewFromEnv(models.StreamTopology(instance))
+	util.FailOnError(err, "Failed to set up the stream exchange")
+	defer streamBroker.Close()
+	hub := stream.NewHub()
+	relay := stream.NewRelay(hub, streamBroker, instance)
+	go func() {
+		if err := relay.Run(context.Background()); err != nil {
+			log.Printf("[Main] Stream relay stopped: %v", err)
+		}
+	}()
+	c.Channels[models.Stream] = &stream.Channel{Relay: relay, DataMode: dataMode}
+
+	captures := sandbox.NewStoreFromEnv()
+	if captures != nil {
+		c.Sender = captures
+		for channel := range c.Channels {
+			c.Channels[channel] = captures.Channel(channel)
+		}
+	}
+
+	consumer.SetUpFirebaseClient(&c)
+
 	log.Println("[Main] Starting background consumer workers...")
-	go consumer.StartConsumer(conChannel, &c)
+	go consumer.StartConsumer(&c)
+
+	keys, err := auth.NewKeyStoreFromEnv()
+	util.FailOnError(err, "Failed to load API keys")
+	authn := &auth.Authenticator{Keys: keys}
+
+	if jwtConfig := auth.JWTConfigFromEnv(); jwtConfig.JWKSURL != "" {
+		authn.JWT, err = auth.NewJWTVerifier(jwtConfig)
+		util.FailOnError(err, "Failed to set up JWT verification")
+		defer authn.JWT.Close()
+	}
+	if !authn.Enabled() {
+		log.Println("[Main] No API keys or JWKS configured: send and read endpoints are unauthenticated, admin and stream endpoints are disabled")
+	}
 
 	router := gin.Default()
 
@@ -89,10 +177,144 @@ func main() {
 		api.HealthHandler(&c, ctx)
 	})
 
-	router.POST("/notification", func(ctx *gin.Context) {
+	router.POST("/notification", auth.RequireScope(authn, auth.ScopeSend), func(ctx *gin.Context) {
 		api.NotificationHandler(&p, ctx)
 	})
 
+	syncTimeout := api.SyncSendTimeout()
+
+	router.POST("/notification/send", auth.RequireScope(authn, auth.ScopeSend), func(ctx *gin.Context) {
+		api.SendNowHandler(&p, &c, syncTimeout, ctx)
+	})
+
+	router.GET("/notification/:id", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.StatusHandler(statuses, ctx)
+	})
+
+	router.POST("/topics/:topic/subscribe", auth.RequireScope(authn, auth.ScopeSend), func(ctx *gin.Context) {
+		api.SubscribeTopicHandler(&c, ctx)
+	})
+
+	router.POST("/topics/:topic/unsubscribe", auth.RequireScope(authn, auth.ScopeSend), func(ctx *gin.Context) {
+		api.UnsubscribeTopicHandler(&c, ctx)
+	})
+
+	batches := api.NewBatchStore()
+
+	router.POST("/notifications/batch", auth.RequireScope(authn, auth.ScopeSend), func(ctx *gin.Context) {
+		api.BatchNotificationHandler(&p, batches, ctx)
+	})
+
+	router.GET("/notifications/batch/:id", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.BatchStatusHandler(batches, ctx)
+	})
+
+	if captures != nil {
+		router.GET("/sandbox/messages", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+			api.ListSandboxMessagesHandler(captures, ctx)
+		})
+
+		router.DELETE("/sandbox/messages", auth.RequireScope(authn, auth.ScopeAdmin), func(ctx *gin.Context) {
+			api.ResetSandboxHandler(captures, ctx)
+		})
+	}
+
+	router.GET("/templates", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.ListTemplatesHandler(templateStore, ctx)
+	})
+
+	router.GET("/templates/:name", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.GetTemplateHandler(templateStore, ctx)
+	})
+
+	router.GET("/templates/:name/versions", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.ListTemplateVersionsHandler(templateStore, ctx)
+	})
+
+	router.POST("/templates/:name/preview", auth.RequireScope(authn, auth.ScopeRead), func(ctx *gin.Context) {
+		api.PreviewTemplateHandler(&c, ctx)
+	})
+
+	router.PUT("/templates/:name", auth.RequireScope(authn, auth.ScopeAdmin), func(ctx *gin.Context) {
+		api.PutTemplateHandler(templateStore, ctx)
+	})
+
+	router.DELETE("/templates/:name", auth.RequireScope(authn, auth.ScopeAdmin), func(ctx *gin.Context) {
+		api.DeleteTemplateHandler(templateStore, ctx)
+	})
+
+	router.GET("/stream", auth.TokenFromQuery(), auth.RequireScope(authn, auth.ScopeStream), func(ctx *gin.Context) {
+		api.StreamHandler(hub, ctx)
+	})
+
+	router.GET("/users/:id/inbox", auth.RequireAnyScope(authn, auth.ScopeRead, auth.ScopeStream), func(ctx *gin.Context) {
+		api.ListInboxHandler(inboxes, ctx)
+	})
+
+	router.GET("/users/:id/inbox/unread-count", auth.RequireAnyScope(authn, auth.ScopeRead, auth.ScopeStream), func(ctx *gin.Context) {
+		api.UnreadCountHandler(inboxes, ctx)
+	})
+
+	router.POST("/users/:id/inbox/read-all", auth.RequireAnyScope(authn, auth.ScopeSend, auth.ScopeStream), func(ctx *gin.Context) {
+		api.MarkAllReadHandler(inboxes, ctx)
+	})
+
+	router.POST("/users/:id/inbox/:item/read", auth.RequireAnyScope(authn, auth.ScopeSend, auth.ScopeStream), func(ctx *gin.Context) {
+		api.MarkReadHandler(inboxes, ctx)
+	})
+
+	router.DELETE("/users/:id/inbox/:item", auth.RequireAnyScope(authn, auth.ScopeSend, auth.ScopeStream), func(ctx *gin.Context) {
+		api.DeleteInboxItemHandler(inboxes, ctx)
+	})
+
+	router.POST("/users/:id/devices", auth.RequireAnyScope(authn, auth.ScopeSend, auth.ScopeStream), func(ctx *gin.Context) {
+		api.RegisterDeviceHandler(registry, ctx)
+	})
+
+	router.GET("/users/:id/devices", auth.RequireAnyScope(authn, auth.ScopeRead, auth.ScopeStream), func(ctx *gin.Context) {
+		api.ListDevicesHandler(registry, ctx)
+	})
+
+	router.DELETE("/users/:id/devices/:device", auth.RequireAnyScope(authn, auth.ScopeSend, auth.ScopeStream), func(ctx *gin.Context) {
+		api.DeleteDeviceHandler(registry, ctx)
+	})
+
+	if webPush != nil {
+		// Public, since browsers need it before they can subscribe.
+		router.GET("/webpush/vapid-public-key", func(ctx *gin.Context) {
+			api.VAPIDKeyHandler(webPush.VAPID, ctx)
+		})
+	}
+
+	admin := router.Group("/admin", auth.RequireScope(authn, auth.ScopeAdmin))
+	admin.GET("/keys", func(ctx *gin.Context) {
+		api.ListAPIKeysHandler(keys, ctx)
+	})
+	admin.POST("/keys", func(ctx *gin.Context) {
+		api.CreateAPIKeyHandler(keys, ctx)
+	})
+	admin.POST("/keys/:id/rotate", func(ctx *gin.Context) {
+		api.RotateAPIKeyHandler(keys, ctx)
+	})
+	admin.DELETE("/keys/:id", func(ctx *gin.Context) {
+		api.RevokeAPIKeyHandler(keys, ctx)
+	})
+	admin.GET("/webhooks", func(ctx *gin.Context) {
+		api.ListWebhooksHandler(webhooks, ctx)
+	})
+	admin.GET("/webhooks/:name", func(ctx *gin.Context) {
+		api.GetWebhookHandler(webhooks, ctx)
+	})
+	admin.PUT("/webhooks/:name", func(ctx *gin.Context) {
+		api.PutWebhookHandler(webhooks, ctx)
+	})
+	admin.POST("/webhooks/:name/rotate", func(ctx *gin.Context) {
+		api.RotateWebhookSecretHandler(webhooks, ctx)
+	})
+	admin.DELETE("/webhooks/:name", func(ctx *gin.Context) {
+		api.DeleteWebhookHandler(webhooks, ctx)
+	})
+
 	log.Println("API Starting API server on :8080")
 	router.Run(":8080")
 }
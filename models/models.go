@@ -1,13 +1,27 @@
 package models
 
 import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"push_service/broker"
+	"push_service/frequency"
+	"push_service/payload"
+	"push_service/status"
+	"push_service/truncate"
+
 	"firebase.google.com/go/v4/messaging"
-	amqp "github.com/rabbitmq/amqp091-go"
 )
 
 const (
 	Email                NotificationType = "email"
 	Push                 NotificationType = "push"
+	Webhook              NotificationType = "webhook"
+	APNs                 NotificationType = "apns"
+	WebPush              NotificationType = "webpush"
+	Stream               NotificationType = "stream"
+	InApp                NotificationType = "inapp"
 	MaxRetries                            = 2
 	RetryDelayMs                          = int64(5000)
 	RetryExName                           = "retry-notifs_ex"
@@ -19,30 +33,144 @@ const (
 	DlqRoutingKey                         = "failed-messages"
 	DlxName                               = "push_notifs_dlx"
 	DlqName                               = "push_notifs_dlq"
+	EmailExName                           = "email_notifs"
+	EmailRoutingKey                       = "email"
+	EmailQueueName                        = "email.queue"
+	StreamExName                          = "stream_notifs"
 	Token                                 = "e2SUbDFyiaLMoIjmSe6bDl:APA91bEYcdOP4yPHLdZdS9ZdHz0wvfZRDZVqXsV1nkLQzm5FmUfJ8yUOKyJYvF8ZTq5wgA4jc800KEUcbQjZRVlMDHVwC8cSX574yZyDqVt5iEVegavJ-YU"
 )
 
+// PushTopology is the broker layout of the push queue.
+func PushTopology() broker.Topology {
+	return broker.Topology{
+		Exchange:             ExName,
+		RoutingKey:           RoutingKey,
+		Queue:                PushQueueName,
+		RetryExchange:        RetryExName,
+		RetryRoutingKey:      RetryQueueRoutingKey,
+		RetryQueue:           RetryQueueName,
+		RetryDelay:           time.Duration(RetryDelayMs) * time.Millisecond,
+		DeadLetterExchange:   DlxName,
+		DeadLetterRoutingKey: DlqRoutingKey,
+		DeadLetterQueue:      "my-app-dlq",
+	}
+}
+
+// EmailTopology is the queue of the email service, which push notifications
+// fall back to. This service only publishes to it.
+func EmailTopology() broker.Topology {
+	return broker.Topology{
+		Exchange:   EmailExName,
+		RoutingKey: EmailRoutingKey,
+		Queue:      EmailQueueName,
+	}
+}
+
+// StreamTopology fans stream events out to every instance, each reading
+// them from a queue of its own.
+func StreamTopology(instance string) broker.Topology {
+	return broker.Topology{
+		Exchange: StreamExName,
+		Queue:    StreamExName + "." + instance,
+		Fanout:   true,
+	}
+}
+
+// MessageKind selects between a visible notification and a data-only
+// (silent) push that wakes the app for background work.
+type MessageKind string
+
+const (
+	KindNotification MessageKind = "notification"
+	KindData         MessageKind = "data"
+)
+
+// TemplateSource looks templates up by name, locale and version, where
+// version 0 is the latest.
+type TemplateSource interface {
+	FetchTemplate(ctx context.Context, name, locale string, version int) (*TemplateResponse, error)
+}
+
+// Upstream looks users and templates up in the user and template services.
+type Upstream interface {
+	FetchUser(ctx context.Context, userID string) (*User, error)
+	FetchTemplate(ctx context.Context, name string) (*TemplateResponse, error)
+	Health() []UpstreamHealth
+}
+
+// Sender delivers a resolved FCM message in place of the Firebase client,
+// for example to capture it in sandbox environments.
+type Sender interface {
+	Send(ctx context.Context, req NotifMessageRequest, message *messaging.Message) (string, error)
+}
+
 // NotificationType defines the type of notification.
 // @Enum
 type NotificationType string
 
+// Channel delivers a notification over something other than FCM. user is
+// nil when the request was not addressed to a user.
+type Channel interface {
+	Send(ctx context.Context, req NotifMessageRequest, user *User) (string, error)
+}
+
+// Inbox keeps the notifications shown in each user's notification center.
+type Inbox interface {
+	Save(ctx context.Context, item InboxItem) error
+	Unread(ctx context.Context, userID string) (int, error)
+}
+
 type Publisher struct {
-	Channel *amqp.Channel
+	Broker   broker.Broker
+	DataMode payload.NestedMode
+	Status   *status.Store
 }
 
 type Consumer struct {
-	Connection *amqp.Connection
-	Channel    *amqp.Channel
-	QueueName  string
-	RetryQueue string
+	Broker broker.Broker
 
-	ConsumerMetrics ConsumerMetrics
+	Metrics Metrics
 
 	PrefetchCount int
 	WorkerCount   int
 	RetryCount    int64
 
-	Client *messaging.Client
+	Client   *messaging.Client
+	Limiter  *frequency.Limiter
+	DataMode payload.NestedMode
+	Status   *status.Store
+	// Limits caps the title and body shown on each platform.
+	Limits truncate.Limits
+	// Sandbox turns every send into a dry run.
+	Sandbox bool
+	// Sender, when set, receives every message instead of FCM.
+	Sender Sender
+	// Upstream is the user and template service client.
+	Upstream Upstream
+	// Templates, when set, replaces the template service client.
+	Templates TemplateSource
+	// Channels are the fallback channels other than push, such as email.
+	Channels map[NotificationType]Channel
+	// Inbox, when set, receives every notification that was delivered.
+	Inbox Inbox
+	// InboxBadge sets the badge of notifications without one to the
+	// user's unread count.
+	InboxBadge bool
+}
+
+// HealthResponse is the consumer metrics plus the state of the upstream
+// services.
+type HealthResponse struct {
+	ConsumerMetrics
+	Upstreams []UpstreamHealth `json:"upstreams"`
+}
+
+// UpstreamHealth is the circuit breaker state of an upstream service.
+type UpstreamHealth struct {
+	Name     string     `json:"name"`
+	State    string     `json:"state" enums:"closed,open,half_open"`
+	Failures int        `json:"consecutive_failures"`
+	OpenedAt *time.Time `json:"opened_at,omitempty"`
 }
 
 // ConsumerMetrics holds the aggregated metrics for the consumer service.
@@ -51,6 +179,32 @@ type ConsumerMetrics struct {
 	MessagesSucceeded int `json:"messages_succeeded"` // Total messages successfully processed
 	MessagesFailed    int `json:"messages_failed"`    // Total messages that failed processing
 	MessagesRetried   int `json:"messages_retried"`   // Total messages that were retried
+
+	MessagesSuppressed int `json:"messages_suppressed"` // Total messages dropped by a frequency cap
+	MessagesDeferred   int `json:"messages_deferred"`   // Total messages re-queued by a frequency cap
+}
+
+// Metrics counts what the workers did. Every worker updates them at once,
+// so the counters are atomic.
+type Metrics struct {
+	Processed  atomic.Int64
+	Succeeded  atomic.Int64
+	Failed     atomic.Int64
+	Retried    atomic.Int64
+	Suppressed atomic.Int64
+	Deferred   atomic.Int64
+}
+
+// Snapshot returns the current counts.
+func (m *Metrics) Snapshot() ConsumerMetrics {
+	return ConsumerMetrics{
+		MessagesProcessed:  int(m.Processed.Load()),
+		MessagesSucceeded:  int(m.Succeeded.Load()),
+		MessagesFailed:     int(m.Failed.Load()),
+		MessagesRetried:    int(m.Retried.Load()),
+		MessagesSuppressed: int(m.Suppressed.Load()),
+		MessagesDeferred:   int(m.Deferred.Load()),
+	}
 }
 
 type UserResponses struct {
@@ -61,6 +215,25 @@ type UserResponses struct {
 type TemplateResponse struct {
 	Name string `json:"name"`
 	Body string `json:"body"`
+	// Title is shown as the notification title; the name is used when unset.
+	Title string `json:"title,omitempty"`
+	// Version is set when the template was served from the local store.
+	Version int `json:"version,omitempty"`
+	// Locale is the variant that was served, which may be a fallback of
+	// the locale that was asked for.
+	Locale string `json:"locale,omitempty"`
+
+	// Optional per-template defaults, overridden by the request.
+	ImageURL    string `json:"image_url,omitempty"`
+	ClickAction string `json:"click_action,omitempty"`
+	Link        string `json:"link,omitempty"`
+	Sound       string `json:"sound,omitempty"`
+	ChannelID   string `json:"channel_id,omitempty"`
+	Priority    string `json:"priority,omitempty"`
+	TTL         *int   `json:"ttl,omitempty"`
+
+	// Channels is the fallback policy for requests that do not set one.
+	Channels []NotificationType `json:"channels,omitempty"`
 }
 
 type Variable struct {
@@ -71,6 +244,8 @@ type User struct {
 	Name        string      `json:"name"`
 	Email       string      `json:"email"`
 	PushToken   string      `json:"push_token"`
+	APNsToken   string      `json:"apns_token,omitempty"`
+	Locale      string      `json:"locale,omitempty"`
 	Preferences Preferences `json:"preferences"`
 }
 
@@ -80,10 +255,241 @@ type Preferences struct {
 }
 
 type NotifMessageRequest struct {
+	ID        string         `json:"id" swaggerignore:"true"`
 	UserID    string         `json:"user_id" example:"29293-2828"`
+	Kind      MessageKind    `json:"kind,omitempty" enums:"notification,data"`
 	Template  string         `json:"template" example:"welcome_email"`
 	Variables map[string]any `json:"variables" swaggertype:"object" example:"name:John Doe"`
 	PushToken *string        `json:"push_token"`
-	Title     *string        `json:"title"`
-	Body      *string        `json:"body"`
+	// APNsToken is the device token the apns channel sends to directly,
+	// instead of the user's.
+	APNsToken string  `json:"apns_token,omitempty" example:"740f4707bebcf74f9b7c25d48e3358945f6aa01da5ddb387462c7eaf61bb78ad"`
+	Topic     string  `json:"topic,omitempty" example:"sports"`
+	Condition string  `json:"condition,omitempty" example:"'sports' in topics && 'news' in topics"`
+	Title     *string `json:"title"`
+	Body      *string `json:"body"`
+	// Tenant is who the notification is sent for. Callers limited to
+	// tenants must name one of theirs, or get their only one; it scopes
+	// who may read the notification's status.
+	Tenant string `json:"tenant,omitempty" example:"acme"`
+	// Locale overrides the user's locale, such as pt-BR. Templates fall
+	// back from pt-BR to pt to en.
+	Locale string `json:"locale,omitempty" example:"pt-BR"`
+
+	ImageURL    string `json:"image_url,omitempty" example:"https://cdn.example.com/promo.png"`
+	ClickAction string `json:"click_action,omitempty" example:"OPEN_ORDER"`
+	Link        string `json:"link,omitempty" example:"https://app.example.com/orders/42"`
+	Sound       string `json:"sound,omitempty" example:"default"`
+	Badge       *int   `json:"badge,omitempty" example:"3"`
+	ChannelID   string `json:"channel_id,omitempty" example:"orders"`
+	CollapseKey string `json:"collapse_key,omitempty" example:"order-42"`
+	Priority    string `json:"priority,omitempty" enums:"high,normal"`
+	TTL         *int   `json:"ttl,omitempty" example:"3600"` // Seconds
+
+	// TemplateVersion pins the request to a version in the local template
+	// store instead of the latest one.
+	TemplateVersion int `json:"template_version,omitempty" example:"3"`
+
+	// DryRun resolves, renders and validates the message with FCM without
+	// delivering it.
+	DryRun bool `json:"dry_run,omitempty"`
+
+	// Channels are tried in order until one delivers: after a permanent
+	// failure or suppression on one channel the next one the user allows is
+	// used. Defaults to the template's policy, then push alone.
+	Channels []NotificationType `json:"channels,omitempty" example:"push,email"`
+	// Subscriber names the webhook subscriber the webhook channel delivers to.
+	Subscriber string `json:"subscriber,omitempty" example:"billing"`
+
+	// Caller is the authenticated service that sent the request. It is
+	// never read from the request body.
+	Caller string `json:"-"`
+}
+
+// QueuedNotification is a request as it travels through the queue, where
+// the caller that sent it goes along.
+type QueuedNotification struct {
+	NotifMessageRequest
+	Caller string `json:"caller,omitempty"`
+}
+
+// EmailRequest is what the email service receives when a notification falls
+// back to email.
+type EmailRequest struct {
+	ID        string         `json:"id"` // ID of the original notification
+	UserID    string         `json:"user_id"`
+	Email     string         `json:"email"`
+	Template  string         `json:"template"`
+	Variables map[string]any `json:"variables"`
+	Locale    string         `json:"locale,omitempty"`
+	Title     string         `json:"title,omitempty"`
+	Body      string         `json:"body,omitempty"`
+}
+
+const WebhookTypeNotification = "notification"
+
+// WebhookEnvelope is the signed JSON body POSTed to webhook subscribers.
+// Title and body are rendered from the template when one is used.
+type WebhookEnvelope struct {
+	ID         string         `json:"id"` // ID of the notification, stable across retries
+	Type       string         `json:"type" example:"notification"`
+	Subscriber string         `json:"subscriber"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UserID     string         `json:"user_id,omitempty"`
+	Template   string         `json:"template,omitempty"`
+	Locale     string         `json:"locale,omitempty"`
+	Title      string         `json:"title,omitempty"`
+	Body       string         `json:"body,omitempty"`
+	Variables  map[string]any `json:"variables,omitempty"`
+}
+
+// WebhookSubscriberRequest is the body of PUT /admin/webhooks/{name}.
+type WebhookSubscriberRequest struct {
+	URL             string `json:"url" binding:"required" example:"https://billing.internal/hooks/notifications"`
+	TimeoutMs       int    `json:"timeout_ms,omitempty" example:"3000"`
+	SuccessStatuses []int  `json:"success_statuses,omitempty" example:"200,202"`
+}
+
+// WebPushPayload is the JSON the service worker receives in its push
+// event, decrypted by the browser.
+type WebPushPayload struct {
+	ID    string            `json:"id"`
+	Title string            `json:"title,omitempty"`
+	Body  string            `json:"body,omitempty"`
+	Image string            `json:"image,omitempty"`
+	Link  string            `json:"link,omitempty"`
+	Tag   string            `json:"tag,omitempty"`
+	Badge *int              `json:"badge,omitempty"`
+	Data  map[string]string `json:"data,omitempty"`
+}
+
+// InboxItem is a notification in a user's inbox.
+type InboxItem struct {
+	ID        string            `json:"id"` // ID of the notification
+	UserID    string            `json:"user_id"`
+	Title     string            `json:"title,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Image     string            `json:"image,omitempty"`
+	Link      string            `json:"link,omitempty"`
+	Template  string            `json:"template,omitempty"`
+	Channel   NotificationType  `json:"channel"`
+	Data      map[string]string `json:"data,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	ReadAt    *time.Time        `json:"read_at,omitempty"`
+}
+
+// InboxPage is one page of GET /users/{id}/inbox, newest first.
+type InboxPage struct {
+	Items  []InboxItem `json:"items"`
+	Unread int         `json:"unread"`
+	// Next is the before cursor of the following page, empty on the last.
+	Next string `json:"next,omitempty"`
+}
+
+// StreamEvent is the data of a notification event on GET /stream.
+type StreamEvent struct {
+	ID        string            `json:"id"`
+	UserID    string            `json:"user_id"`
+	Title     string            `json:"title,omitempty"`
+	Body      string            `json:"body,omitempty"`
+	Image     string            `json:"image,omitempty"`
+	Link      string            `json:"link,omitempty"`
+	Data      map[string]string `json:"data,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+}
+
+// DeviceRequest is the body of POST /users/{id}/devices: a browser's
+// PushSubscription as serialised by toJSON().
+type DeviceRequest struct {
+	Platform string `json:"platform" binding:"required" enums:"webpush"`
+	Endpoint string `json:"endpoint" binding:"required" example:"https://fcm.googleapis.com/fcm/send/dpH5lCsTSSM:APA91bH..."`
+	Keys     struct {
+		P256dh string `json:"p256dh" binding:"required" example:"BNcRdreALRFXTkOOUHK1EtK2wtaz5Ry4YfYCA_0QTpQtUbVlUls0VJXg7A8u-Ts1XbjhazAkj7I99e8QcYP7DkM"`
+		Auth   string `json:"auth" binding:"required" example:"tBHItJI5svbpez7KI4CCXg"`
+	} `json:"keys"`
+	UserAgent string `json:"user_agent,omitempty" example:"Mozilla/5.0 (X11; Linux x86_64) Firefox/131.0"`
+}
+
+type CreateAPIKeyRequest struct {
+	Name      string   `json:"name" binding:"required" example:"campaign-service"`
+	Scopes    []string `json:"scopes" binding:"required,min=1" example:"send,read"`
+	RateLimit float64  `json:"rate_limit" example:"10"`
+	Burst     int      `json:"burst" example:"20"`
+}
+
+// TemplateVersionRequest is the body of PUT /templates/{name}. The name
+// comes from the path.
+type TemplateVersionRequest struct {
+	Locale string `json:"locale,omitempty" example:"en"`
+	TemplateResponse
+}
+
+// TemplatePreviewRequest is the body of POST /templates/{name}/preview.
+type TemplatePreviewRequest struct {
+	Variables map[string]any `json:"variables" swaggertype:"object"`
+	// UserID fills user.* variables and checks the user can receive pushes.
+	UserID          string `json:"user_id,omitempty" example:"29293-2828"`
+	TemplateVersion int    `json:"template_version,omitempty" example:"3"`
+	Locale          string `json:"locale,omitempty" example:"pt-BR"`
+}
+
+// TemplatePreviewResponse is exactly what the consumer would send.
+type TemplatePreviewResponse struct {
+	Template        string                   `json:"template"`
+	TemplateVersion int                      `json:"template_version,omitempty"`
+	Locale          string                   `json:"locale,omitempty"`
+	Title           string                   `json:"title"`
+	Body            string                   `json:"body"`
+	Data            map[string]string        `json:"data,omitempty"`
+	Android         *messaging.AndroidConfig `json:"android,omitempty" swaggertype:"object"`
+	APNS            *messaging.APNSConfig    `json:"apns,omitempty" swaggertype:"object"`
+	Webpush         *messaging.WebpushConfig `json:"webpush,omitempty" swaggertype:"object"`
+	Message         *messaging.Message       `json:"message" swaggertype:"object"`
+	Truncated       []string                 `json:"truncated,omitempty"`
+	Warnings        []string                 `json:"warnings"`
+}
+
+// BatchItemResult reports what happened to one item of a batch request.
+type BatchItemResult struct {
+	Index     int    `json:"index"`
+	RequestID string `json:"request_id,omitempty"`
+	Status    string `json:"status" example:"queued"` // pending, queued, rejected or failed
+	Error     string `json:"error,omitempty"`
+}
+
+// BatchStatus is the progress of a batch request.
+type BatchStatus struct {
+	ID       string            `json:"id"`
+	Status   string            `json:"status" example:"completed"` // processing or completed
+	Total    int               `json:"total"`
+	Queued   int               `json:"queued"`
+	Rejected int               `json:"rejected"`
+	Failed   int               `json:"failed"`
+	Results  []BatchItemResult `json:"results"`
+	// Caller is the service that sent the batch, the only one that may read
+	// it back besides admins.
+	Caller string `json:"caller,omitempty"`
+	// Tenants are those the accepted items were sent for. Admins limited to
+	// tenants may only read batches for theirs.
+	Tenants   []string  `json:"tenants,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+type TopicSubscriptionRequest struct {
+	Tokens []string `json:"tokens" binding:"required,min=1,max=1000"`
+}
+
+// TopicSubscriptionFailure is a token FCM could not (un)subscribe.
+type TopicSubscriptionFailure struct {
+	Index  int    `json:"index"`
+	Token  string `json:"token"`
+	Reason string `json:"reason"`
+}
+
+type TopicSubscriptionResponse struct {
+	Topic        string                     `json:"topic"`
+	SuccessCount int                        `json:"success_count"`
+	FailureCount int                        `json:"failure_count"`
+	Failures     []TopicSubscriptionFailure `json:"failures"`
 }
@@ -0,0 +1,167 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NestedMode controls how maps and arrays inside the variables are encoded,
+// since FCM data payloads only carry string values.
+type NestedMode string
+
+const (
+	// NestedJSON encodes nested values as a JSON string under their key.
+	NestedJSON NestedMode = "json"
+	// NestedFlatten spreads nested values over dotted keys, e.g. "order.id"
+	// or "items.0.sku".
+	NestedFlatten NestedMode = "flatten"
+
+	// MaxSize is the FCM limit for the data and notification payload.
+	MaxSize = 4096
+)
+
+var (
+	ErrReservedKey  = errors.New("reserved data key")
+	ErrTooLarge     = errors.New("payload exceeds FCM size limit")
+	ErrDuplicateKey = errors.New("duplicate data key")
+
+	reservedKeys     = []string{"from", "notification", "message_type"}
+	reservedPrefixes = []string{"google", "gcm"}
+)
+
+// NestedModeFromEnv reads DATA_NESTED_MODE, defaulting to NestedJSON.
+func NestedModeFromEnv() NestedMode {
+	switch mode := NestedMode(os.Getenv("DATA_NESTED_MODE")); mode {
+	case NestedJSON, NestedFlatten:
+		return mode
+	case "":
+		return NestedJSON
+	default:
+		log.Printf("Unknown DATA_NESTED_MODE %q, falling back to %q", mode, NestedJSON)
+		return NestedJSON
+	}
+}
+
+// Encode maps variables onto the string map FCM expects. Strings are kept as
+// they are, numbers use their shortest exact form, booleans become
+// "true"/"false" and nulls are dropped.
+func Encode(variables map[string]any, mode NestedMode) (map[string]string, error) {
+	if variables == nil {
+		return nil, nil
+	}
+
+	result := make(map[string]string, len(variables))
+	for key, value := range variables {
+		if err := encodeValue(result, key, value, mode); err != nil {
+			return nil, err
+		}
+	}
+
+	for key := range result {
+		if err := checkKey(key); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+func encodeValue(result map[string]string, key string, value any, mode NestedMode) error {
+	switch v := value.(type) {
+	case nil:
+	case string:
+		return set(result, key, v)
+	case bool:
+		return set(result, key, strconv.FormatBool(v))
+	case float64:
+		return set(result, key, strconv.FormatFloat(v, 'f', -1, 64))
+	case json.Number:
+		return set(result, key, v.String())
+	case int:
+		return set(result, key, strconv.Itoa(v))
+	case int64:
+		return set(result, key, strconv.FormatInt(v, 10))
+	case map[string]any:
+		if mode != NestedFlatten {
+			return encodeJSON(result, key, v)
+		}
+		for child, childValue := range v {
+			if err := encodeValue(result, key+"."+child, childValue, mode); err != nil {
+				return err
+			}
+		}
+	case []any:
+		if mode != NestedFlatten {
+			return encodeJSON(result, key, v)
+		}
+		for i, childValue := range v {
+			if err := encodeValue(result, key+"."+strconv.Itoa(i), childValue, mode); err != nil {
+				return err
+			}
+		}
+	default:
+		return encodeJSON(result, key, v)
+	}
+	return nil
+}
+
+func encodeJSON(result map[string]string, key string, value any) error {
+	encoded, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Errorf("could not encode variable %q: %w", key, err)
+	}
+	return set(result, key, string(encoded))
+}
+
+// set adds key to result. Flattening can produce a key that is already
+// set, such as "order.id" from both {"order.id": 1} and {"order": {"id": 2}},
+// and one of the values would otherwise be lost.
+func set(result map[string]string, key, value string) error {
+	if _, ok := result[key]; ok {
+		return fmt.Errorf("%w %q: set both directly and by a nested value", ErrDuplicateKey, key)
+	}
+	result[key] = value
+	return nil
+}
+
+func checkKey(key string) error {
+	lower := strings.ToLower(key)
+	for _, reserved := range reservedKeys {
+		if lower == reserved {
+			return fmt.Errorf("%w %q", ErrReservedKey, key)
+		}
+	}
+	for _, prefix := range reservedPrefixes {
+		if strings.HasPrefix(lower, prefix) {
+			return fmt.Errorf("%w %q: keys starting with %q are reserved by FCM", ErrReservedKey, key, prefix)
+		}
+	}
+	return nil
+}
+
+// CheckSize returns ErrTooLarge when the data map together with the
+// notification title and body is over MaxSize bytes.
+func CheckSize(data map[string]string, title, body string) error {
+	size := len(title) + len(body)
+	for key, value := range data {
+		size += len(key) + len(value)
+	}
+	if size <= MaxSize {
+		return nil
+	}
+
+	largest := make([]string, 0, len(data))
+	for key := range data {
+		largest = append(largest, key)
+	}
+	sort.Slice(largest, func(i, j int) bool { return len(data[largest[i]]) > len(data[largest[j]]) })
+	if len(largest) > 3 {
+		largest = largest[:3]
+	}
+	return fmt.Errorf("%w: %d bytes, limit is %d (largest keys: %s)", ErrTooLarge, size, MaxSize, strings.Join(largest, ", "))
+}
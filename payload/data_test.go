@@ -0,0 +1,95 @@
+package payload
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestEncode(t *testing.T) {
+	variables := map[string]any{
+		"name":   "Ada",
+		"count":  float64(3),
+		"price":  1.5,
+		"big":    json.Number("12345678901234567890"),
+		"active": true,
+		"gone":   nil,
+		"order":  map[string]any{"id": "o-1", "items": []any{map[string]any{"sku": "s-1"}}},
+	}
+
+	tests := []struct {
+		mode NestedMode
+		want map[string]string
+	}{
+		{NestedJSON, map[string]string{
+			"name": "Ada", "count": "3", "price": "1.5", "big": "12345678901234567890", "active": "true",
+			"order": `{"id":"o-1","items":[{"sku":"s-1"}]}`,
+		}},
+		{NestedFlatten, map[string]string{
+			"name": "Ada", "count": "3", "price": "1.5", "big": "12345678901234567890", "active": "true",
+			"order.id": "o-1", "order.items.0.sku": "s-1",
+		}},
+	}
+	for _, tt := range tests {
+		got, err := Encode(variables, tt.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.mode, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: Encode = %v, want %v", tt.mode, got, tt.want)
+		}
+	}
+
+	if got, err := Encode(nil, NestedJSON); got != nil || err != nil {
+		t.Errorf("Encode(nil) = %v, %v", got, err)
+	}
+}
+
+func TestEncodeRejectsReservedKeys(t *testing.T) {
+	for _, variables := range []map[string]any{
+		{"from": "x"},
+		{"Notification": "x"},
+		{"google.sent_time": "x"},
+		{"gcm": map[string]any{"x": 1}},
+	} {
+		if _, err := Encode(variables, NestedFlatten); !errors.Is(err, ErrReservedKey) {
+			t.Errorf("Encode(%v) = %v, want ErrReservedKey", variables, err)
+		}
+	}
+}
+
+func TestEncodeRejectsFlattenedDuplicates(t *testing.T) {
+	variables := map[string]any{
+		"order.id": "o-1",
+		"order":    map[string]any{"id": "o-2"},
+	}
+	if _, err := Encode(variables, NestedFlatten); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("Encode = %v, want ErrDuplicateKey", err)
+	}
+	if _, err := Encode(variables, NestedJSON); err != nil {
+		t.Fatalf("JSON mode keeps both keys apart: %v", err)
+	}
+}
+
+func TestCheckSize(t *testing.T) {
+	data := map[string]string{"small": "x", "large": strings.Repeat("x", MaxSize-20)}
+	if err := CheckSize(data, "title", "body"); err != nil {
+		t.Fatalf("payload under the limit: %v", err)
+	}
+
+	err := CheckSize(data, "title", strings.Repeat("x", 20))
+	if !errors.Is(err, ErrTooLarge) || !strings.Contains(err.Error(), "large, small") {
+		t.Fatalf("payload over the limit: %v", err)
+	}
+}
+
+func TestNestedModeFromEnv(t *testing.T) {
+	for value, want := range map[string]NestedMode{"": NestedJSON, "flatten": NestedFlatten, "yaml": NestedJSON} {
+		t.Setenv("DATA_NESTED_MODE", value)
+		if got := NestedModeFromEnv(); got != want {
+			t.Errorf("DATA_NESTED_MODE=%q gave %q, want %q", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,262 @@
+package platform
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"push_service/models"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+
+	DefaultPriority = PriorityHigh
+	DefaultSound    = "default"
+
+	// MaxTTL is the longest time FCM keeps an undelivered message.
+	MaxTTL = 28 * 24 * time.Hour
+	// maxAPNSCollapseID is the size limit of the apns-collapse-id header.
+	maxAPNSCollapseID = 64
+	// maxWebpushTopic is the size limit of the Web Push Topic header.
+	maxWebpushTopic = 32
+	maxChannelID    = 255
+)
+
+// Options are the presentation and delivery settings mapped into the
+// Android, APNs and Web Push sections of an FCM message.
+type Options struct {
+	ImageURL    string
+	ClickAction string
+	Link        string
+	Sound       string
+	Badge       *int
+	ChannelID   string
+	CollapseKey string
+	Priority    string
+	TTL         *time.Duration
+}
+
+// Resolve merges the request with the template defaults. Values on the
+// request win; anything still unset falls back to the service defaults.
+// Data-only messages default to normal priority and never play a sound.
+func Resolve(req models.NotifMessageRequest, template *models.TemplateResponse) Options {
+	req = WithTemplate(req, template)
+	opts := Options{
+		ImageURL:    req.ImageURL,
+		ClickAction: req.ClickAction,
+		Link:        req.Link,
+		Sound:       req.Sound,
+		Badge:       req.Badge,
+		ChannelID:   req.ChannelID,
+		CollapseKey: req.CollapseKey,
+		Priority:    req.Priority,
+		TTL:         seconds(req.TTL),
+	}
+
+	if req.Kind == models.KindData {
+		opts.Sound = ""
+		opts.Priority = firstNonEmpty(opts.Priority, PriorityNormal)
+		return opts
+	}
+
+	opts.Sound = firstNonEmpty(opts.Sound, DefaultSound)
+	opts.Priority = firstNonEmpty(opts.Priority, DefaultPriority)
+	return opts
+}
+
+// WithTemplate returns req with the options it leaves unset taken from the
+// template, so a channel that resolves its options from the request alone
+// still gets the template's defaults.
+func WithTemplate(req models.NotifMessageRequest, template *models.TemplateResponse) models.NotifMessageRequest {
+	if template == nil {
+		return req
+	}
+	req.ImageURL = firstNonEmpty(req.ImageURL, template.ImageURL)
+	req.ClickAction = firstNonEmpty(req.ClickAction, template.ClickAction)
+	req.Link = firstNonEmpty(req.Link, template.Link)
+	req.Sound = firstNonEmpty(req.Sound, template.Sound)
+	req.ChannelID = firstNonEmpty(req.ChannelID, template.ChannelID)
+	req.Priority = firstNonEmpty(req.Priority, template.Priority)
+	if req.TTL == nil {
+		req.TTL = template.TTL
+	}
+	return req
+}
+
+// Validate checks the options against the limits FCM, APNs and Web Push
+// enforce, so bad requests are rejected before they are queued.
+func (o Options) Validate() error {
+	var errs []error
+
+	if o.ImageURL != "" {
+		if err := validateURL(o.ImageURL, true); err != nil {
+			errs = append(errs, fmt.Errorf("image_url: %w", err))
+		}
+	}
+	if o.Link != "" {
+		if err := validateURL(o.Link, false); err != nil {
+			errs = append(errs, fmt.Errorf("link: %w", err))
+		}
+	}
+	if o.Badge != nil && *o.Badge < 0 {
+		errs = append(errs, errors.New("badge must not be negative"))
+	}
+	if len(o.ChannelID) > maxChannelID {
+		errs = append(errs, fmt.Errorf("channel_id must be at most %d characters", maxChannelID))
+	}
+	if len(o.CollapseKey) > maxAPNSCollapseID {
+		errs = append(errs, fmt.Errorf("collapse_key must be at most %d bytes", maxAPNSCollapseID))
+	}
+	switch o.Priority {
+	case "", PriorityHigh, PriorityNormal:
+	default:
+		errs = append(errs, fmt.Errorf("priority must be %q or %q", PriorityHigh, PriorityNormal))
+	}
+	if o.TTL != nil && (*o.TTL < 0 || *o.TTL > MaxTTL) {
+		errs = append(errs, fmt.Errorf("ttl must be between 0 and %d seconds", int(MaxTTL.Seconds())))
+	}
+	return errors.Join(errs...)
+}
+
+// Apply maps the options onto message. It expects message.Notification to be
+// set already.
+func (o Options) Apply(message *messaging.Message) {
+	if message.Notification != nil {
+		message.Notification.ImageURL = o.ImageURL
+	}
+
+	if o.Link != "" {
+		if message.Data == nil {
+			message.Data = make(map[string]string)
+		}
+		if _, ok := message.Data["link"]; !ok {
+			message.Data["link"] = o.Link
+		}
+	}
+
+	message.Android = &messaging.AndroidConfig{
+		CollapseKey: o.CollapseKey,
+		Priority:    o.Priority,
+		TTL:         o.TTL,
+		Notification: &messaging.AndroidNotification{
+			Sound:             o.Sound,
+			ChannelID:         o.ChannelID,
+			ClickAction:       o.ClickAction,
+			ImageURL:          o.ImageURL,
+			NotificationCount: o.Badge,
+		},
+	}
+
+	apnsHeaders := map[string]string{"apns-priority": "10"}
+	if o.Priority == PriorityNormal {
+		apnsHeaders["apns-priority"] = "5"
+	}
+	if o.CollapseKey != "" {
+		apnsHeaders["apns-collapse-id"] = o.CollapseKey
+	}
+	if o.TTL != nil {
+		apnsHeaders["apns-expiration"] = strconv.FormatInt(time.Now().Add(*o.TTL).Unix(), 10)
+	}
+	message.APNS = &messaging.APNSConfig{
+		Headers: apnsHeaders,
+		Payload: &messaging.APNSPayload{
+			Aps: &messaging.Aps{
+				Badge:          o.Badge,
+				Sound:          o.Sound,
+				Category:       o.ClickAction,
+				MutableContent: o.ImageURL != "",
+			},
+		},
+	}
+	if o.ImageURL != "" {
+		message.APNS.FCMOptions = &messaging.APNSFCMOptions{ImageURL: o.ImageURL}
+	}
+
+	webpushHeaders := map[string]string{"Urgency": o.Priority}
+	if o.TTL != nil {
+		webpushHeaders["TTL"] = strconv.Itoa(int(o.TTL.Seconds()))
+	}
+	if o.CollapseKey != "" && len(o.CollapseKey) <= maxWebpushTopic {
+		webpushHeaders["Topic"] = o.CollapseKey
+	}
+	message.Webpush = &messaging.WebpushConfig{
+		Headers: webpushHeaders,
+		Notification: &messaging.WebpushNotification{
+			Image: o.ImageURL,
+			Tag:   o.CollapseKey,
+		},
+	}
+	if link, err := url.Parse(o.Link); err == nil && link.Scheme == "https" {
+		message.Webpush.FCMOptions = &messaging.WebpushFCMOptions{Link: o.Link}
+	}
+}
+
+// ApplyData maps the options onto a data-only message. APNs only delivers
+// background pushes with content-available set, apns-push-type background and
+// priority 5; Android wakes the app immediately only for high priority.
+func (o Options) ApplyData(message *messaging.Message) {
+	message.Notification = nil
+
+	message.Android = &messaging.AndroidConfig{
+		CollapseKey: o.CollapseKey,
+		Priority:    o.Priority,
+		TTL:         o.TTL,
+	}
+
+	apnsHeaders := map[string]string{
+		"apns-push-type": "background",
+		"apns-priority":  "5",
+	}
+	if o.CollapseKey != "" {
+		apnsHeaders["apns-collapse-id"] = o.CollapseKey
+	}
+	if o.TTL != nil {
+		apnsHeaders["apns-expiration"] = strconv.FormatInt(time.Now().Add(*o.TTL).Unix(), 10)
+	}
+	message.APNS = &messaging.APNSConfig{
+		Headers: apnsHeaders,
+		Payload: &messaging.APNSPayload{
+			Aps: &messaging.Aps{ContentAvailable: true},
+		},
+	}
+
+	webpushHeaders := map[string]string{"Urgency": o.Priority}
+	if o.TTL != nil {
+		webpushHeaders["TTL"] = strconv.Itoa(int(o.TTL.Seconds()))
+	}
+	message.Webpush = &messaging.WebpushConfig{Headers: webpushHeaders}
+}
+
+func validateURL(raw string, requireHTTPS bool) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Scheme == "" {
+		return fmt.Errorf("%q is not an absolute URL", raw)
+	}
+	if requireHTTPS && parsed.Scheme != "https" {
+		return fmt.Errorf("%q must use https", raw)
+	}
+	return nil
+}
+
+func seconds(value *int) *time.Duration {
+	if value == nil {
+		return nil
+	}
+	d := time.Duration(*value) * time.Second
+	return &d
+}
+
+func firstNonEmpty(values ...string) string {
+	for _, value := range values {
+		if value != "" {
+			return value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,174 @@
+package sandbox
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"push_service/models"
+
+	"firebase.google.com/go/v4/messaging"
+	"github.com/google/uuid"
+)
+
+const DefaultMaxMessages = 1000
+
+// Message is a notification the service would have delivered.
+type Message struct {
+	MessageID string `json:"message_id"`
+	RequestID string `json:"request_id"`
+	// Channel is the fallback channel it went out on. Empty means push.
+	Channel   string             `json:"channel,omitempty"`
+	UserID    string             `json:"user_id,omitempty"`
+	Token     string             `json:"token,omitempty"`
+	Topic     string             `json:"topic,omitempty"`
+	Condition string             `json:"condition,omitempty"`
+	Template  string             `json:"template,omitempty"`
+	Caller    string             `json:"caller,omitempty"`
+	DryRun    bool               `json:"dry_run,omitempty"`
+	Title     string             `json:"title,omitempty"`
+	Body      string             `json:"body,omitempty"`
+	Message   *messaging.Message `json:"message,omitempty" swaggertype:"object"`
+	SentAt    time.Time          `json:"sent_at"`
+}
+
+// Filter selects captured messages. Empty fields match everything.
+type Filter struct {
+	UserID   string
+	Token    string
+	Template string
+	Topic    string
+}
+
+func (f Filter) matches(m Message) bool {
+	return (f.UserID == "" || f.UserID == m.UserID) &&
+		(f.Token == "" || f.Token == m.Token) &&
+		(f.Template == "" || f.Template == m.Template) &&
+		(f.Topic == "" || f.Topic == m.Topic)
+}
+
+// Store is a models.Sender that captures messages in memory instead of
+// sending them to FCM. Once it holds max messages the oldest are dropped.
+type Store struct {
+	mu       sync.RWMutex
+	messages []Message
+	max      int
+}
+
+// NewStoreFromEnv returns nil unless SANDBOX_CAPTURE is true. The capacity
+// is read from SANDBOX_MAX_MESSAGES.
+func NewStoreFromEnv() *Store {
+	capture, _ := strconv.ParseBool(os.Getenv("SANDBOX_CAPTURE"))
+	if !capture {
+		return nil
+	}
+
+	max := DefaultMaxMessages
+	if value, err := strconv.Atoi(os.Getenv("SANDBOX_MAX_MESSAGES")); err == nil && value > 0 {
+		max = value
+	}
+	log.Printf("Sandbox capture: notifications are stored (up to %d) and never reach FCM or any other channel", max)
+	return NewStore(max)
+}
+
+func NewStore(max int) *Store {
+	return &Store{max: max}
+}
+
+// Send captures message and returns a fake FCM message ID.
+func (s *Store) Send(_ context.Context, req models.NotifMessageRequest, message *messaging.Message) (string, error) {
+	captured := Message{
+		MessageID: "projects/sandbox/messages/" + uuid.NewString(),
+		RequestID: req.ID,
+		UserID:    req.UserID,
+		Token:     message.Token,
+		Topic:     message.Topic,
+		Condition: message.Condition,
+		Template:  req.Template,
+		Caller:    req.Caller,
+		DryRun:    req.DryRun,
+		Message:   message,
+		SentAt:    time.Now(),
+	}
+
+	s.capture(captured)
+	return captured.MessageID, nil
+}
+
+// Channel returns a models.Channel that captures what would have been sent
+// over channel, so no fallback channel reaches real users either.
+func (s *Store) Channel(channel models.NotificationType) models.Channel {
+	return channelCapture{store: s, channel: channel}
+}
+
+type channelCapture struct {
+	store   *Store
+	channel models.NotificationType
+}
+
+func (c channelCapture) Send(_ context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	captured := Message{
+		MessageID: "projects/sandbox/" + string(c.channel) + "/" + uuid.NewString(),
+		RequestID: req.ID,
+		Channel:   string(c.channel),
+		UserID:    req.UserID,
+		Template:  req.Template,
+		Caller:    req.Caller,
+		DryRun:    req.DryRun,
+		SentAt:    time.Now(),
+	}
+	if req.PushToken != nil {
+		captured.Token = *req.PushToken
+	}
+	if req.Title != nil {
+		captured.Title = *req.Title
+	}
+	if req.Body != nil {
+		captured.Body = *req.Body
+	}
+
+	c.store.capture(captured)
+	return captured.MessageID, nil
+}
+
+func (s *Store) capture(captured Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.messages = append(s.messages, captured)
+	if overflow := len(s.messages) - s.max; overflow > 0 {
+		s.messages = append([]Message(nil), s.messages[overflow:]...)
+	}
+}
+
+// List returns the captured messages matching filter, newest first. A limit
+// of 0 returns all of them.
+func (s *Store) List(filter Filter, limit int) []Message {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	matched := []Message{}
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if !filter.matches(s.messages[i]) {
+			continue
+		}
+		matched = append(matched, s.messages[i])
+		if limit > 0 && len(matched) == limit {
+			break
+		}
+	}
+	return matched
+}
+
+// Reset drops every captured message and returns how many there were.
+func (s *Store) Reset() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	count := len(s.messages)
+	s.messages = nil
+	return count
+}
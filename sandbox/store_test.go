@@ -0,0 +1,72 @@
+package sandbox
+
+import (
+	"context"
+	"testing"
+
+	"push_service/models"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+func TestStoreKeepsTheNewestMessages(t *testing.T) {
+	store := NewStore(2)
+	for _, id := range []string{"n-1", "n-2", "n-3"} {
+		if _, err := store.Send(context.Background(), models.NotifMessageRequest{ID: id}, &messaging.Message{Token: "token-1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	captured := store.List(Filter{}, 0)
+	if len(captured) != 2 || captured[0].RequestID != "n-3" || captured[1].RequestID != "n-2" {
+		t.Fatalf("captured %+v, want n-3 then n-2", captured)
+	}
+	if limited := store.List(Filter{}, 1); len(limited) != 1 || limited[0].RequestID != "n-3" {
+		t.Fatalf("List with a limit of 1 returned %+v", limited)
+	}
+
+	if dropped := store.Reset(); dropped != 2 {
+		t.Fatalf("Reset dropped %d messages, want 2", dropped)
+	}
+	if captured := store.List(Filter{}, 0); len(captured) != 0 {
+		t.Fatalf("%d messages survived Reset", len(captured))
+	}
+}
+
+func TestStoreFiltersAcrossChannels(t *testing.T) {
+	store := NewStore(DefaultMaxMessages)
+	title, token := "Shipped", "token-1"
+	store.Send(context.Background(), models.NotifMessageRequest{ID: "n-1", UserID: "u-1", Template: "welcome"}, &messaging.Message{Token: token})
+	email := store.Channel(models.Email)
+	if _, err := email.Send(context.Background(), models.NotifMessageRequest{ID: "n-2", UserID: "u-1", Template: "order_shipped", Title: &title, PushToken: &token}, nil); err != nil {
+		t.Fatal(err)
+	}
+	store.Send(context.Background(), models.NotifMessageRequest{ID: "n-3", Template: "news"}, &messaging.Message{Topic: "sports"})
+
+	tests := []struct {
+		filter Filter
+		want   []string
+	}{
+		{Filter{UserID: "u-1"}, []string{"n-2", "n-1"}},
+		{Filter{Token: token}, []string{"n-2", "n-1"}},
+		{Filter{Template: "order_shipped"}, []string{"n-2"}},
+		{Filter{Topic: "sports"}, []string{"n-3"}},
+		{Filter{UserID: "u-1", Template: "news"}, nil},
+	}
+	for _, tt := range tests {
+		captured := store.List(tt.filter, 0)
+		if len(captured) != len(tt.want) {
+			t.Fatalf("%+v matched %d messages, want %v", tt.filter, len(captured), tt.want)
+		}
+		for i, id := range tt.want {
+			if captured[i].RequestID != id {
+				t.Fatalf("%+v matched %s at %d, want %s", tt.filter, captured[i].RequestID, i, id)
+			}
+		}
+	}
+
+	shipped := store.List(Filter{Template: "order_shipped"}, 0)[0]
+	if shipped.Channel != string(models.Email) || shipped.Title != title {
+		t.Fatalf("email capture is %+v", shipped)
+	}
+}
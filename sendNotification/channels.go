@@ -0,0 +1,279 @@
+package sendNotification
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"push_service/frequency"
+	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	"push_service/status"
+)
+
+// DefaultChannels is the policy of requests and templates that set none.
+var DefaultChannels = []models.NotificationType{models.Push}
+
+// Outcome is where a notification got to in its fallback chain.
+type Outcome struct {
+	MessageID string
+	Channel   models.NotificationType
+	// Index is the position of Channel in the policy, which a retry
+	// resumes from.
+	Index int
+}
+
+// Policy returns the channels to try for req, in order: the request's own,
+// else its template's, else push alone.
+func Policy(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest) ([]models.NotificationType, error) {
+	if len(req.Channels) > 0 {
+		return req.Channels, nil
+	}
+	if req.Template == "" || req.Kind == models.KindData {
+		return DefaultChannels, nil
+	}
+
+	template, err := fetchTemplate(ctx, c, req, requestLocale(req, nil))
+	if err != nil {
+		return nil, templateError(err)
+	}
+	if len(template.Channels) > 0 {
+		return template.Channels, nil
+	}
+	return DefaultChannels, nil
+}
+
+// FrequencyMessage identifies req to the frequency caps, which count it
+// against its user, or its token when it names no user. Dry runs reach
+// nobody, so they neither count against nor are held back by the caps.
+func FrequencyMessage(c *models.Consumer, req models.NotifMessageRequest, deferrals int64) frequency.Message {
+	userKey := req.UserID
+	if userKey == "" && req.PushToken != nil {
+		userKey = *req.PushToken
+	}
+	if IsDryRun(c, req) {
+		userKey = ""
+	}
+
+	return frequency.Message{
+		ID:        req.ID,
+		UserKey:   userKey,
+		Template:  req.Template,
+		Deferrals: deferrals,
+	}
+}
+
+// Deliver sends req over the channels of its policy, starting with the one
+// at index from. A suppression or permanent failure moves on to the next
+// channel; any other error stops the chain so the message can be retried on
+// the channel that failed. admit, when set, is asked before each channel and
+// may suppress it with a *frequency.SuppressedError. Every attempt is added
+// to the status record, and the delivered notification to the user's inbox.
+func Deliver(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, from int, admit func(models.NotificationType) error) (Outcome, error) {
+	policy, err := Policy(ctx, c, req)
+	if err != nil {
+		return Outcome{Index: from}, err
+	}
+	from = min(max(from, 0), len(policy)-1)
+
+	if c.Inbox != nil && c.InboxBadge && req.Badge == nil && req.UserID != "" && req.Kind != models.KindData {
+		if unread, err := c.Inbox.Unread(ctx, req.UserID); err == nil {
+			// This notification counts too, since it is saved once delivered.
+			badge := unread + 1
+			req.Badge = &badge
+		} else {
+			log.Printf("Notification %s sent without a badge: %v", req.ID, err)
+		}
+	}
+
+	var user *models.User
+	for i := from; ; i++ {
+		outcome := Outcome{Channel: policy[i], Index: i}
+		last := i == len(policy)-1
+		c.Status.Update(req.ID, func(r *status.Record) {
+			r.Channel = string(outcome.Channel)
+		})
+
+		if admit != nil {
+			if err := admit(outcome.Channel); err != nil {
+				var suppressed *frequency.SuppressedError
+				if last || !errors.As(err, &suppressed) || suppressed.Action != frequency.PolicyDrop {
+					return outcome, err
+				}
+				log.Printf("Notification %s suppressed on %s, falling back to %s: %s", req.ID, outcome.Channel, policy[i+1], suppressed.Reason)
+				recordAttempt(c, req.ID, outcome, status.Suppressed, err)
+				continue
+			}
+		}
+
+		outcome.MessageID, err = sendOver(ctx, c, req, outcome.Channel, &user)
+		if err == nil {
+			state := status.Sent
+			if IsDryRun(c, req) {
+				state = status.Validated
+			}
+			recordAttempt(c, req.ID, outcome, state, nil)
+			// The inbox is kept even if the caller stops waiting now.
+			saveToInbox(context.WithoutCancel(ctx), c, req, outcome.Channel, user)
+			return outcome, nil
+		}
+
+		recordAttempt(c, req.ID, outcome, status.Failed, err)
+		if last || !IsPermanent(err) {
+			return outcome, err
+		}
+		log.Printf("Notification %s failed permanently on %s, falling back to %s: %v", req.ID, outcome.Channel, policy[i+1], err)
+	}
+}
+
+// sendOver delivers req over one channel. Other channels get the user, when
+// the request names one, which is looked up once and kept in user.
+func sendOver(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, channel models.NotificationType, user **models.User) (string, error) {
+	if channel == models.Push {
+		return SendNotification(ctx, c, req)
+	}
+
+	sender := c.Channels[channel]
+	if sender == nil {
+		return "", classified(ClassNoChannel, fmt.Errorf("channel %q is not configured", channel))
+	}
+
+	if *user == nil && req.UserID != "" {
+		fetched, err := c.Upstream.FetchUser(ctx, req.UserID)
+		if err != nil {
+			return "", upstreamError("user", err)
+		}
+		*user = fetched
+	}
+	if err := allowed(channel, *user); err != nil {
+		return "", err
+	}
+
+	req, err := withContent(ctx, c, req, *user)
+	if err != nil {
+		return "", err
+	}
+
+	if IsDryRun(c, req) {
+		log.Printf("Dry run of %s on %s: not sent", req.ID, channel)
+		return "", nil
+	}
+	return sender.Send(ctx, req, *user)
+}
+
+// withContent fills in the title and body from the template, so channels
+// other than push receive the same text a push would show, along with the
+// template's image, link, sound, priority and TTL. The template that was
+// used is recorded, including the default one.
+func withContent(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, user *models.User) (models.NotifMessageRequest, error) {
+	if req.Kind == models.KindData || (req.Title != nil && req.Body != nil) {
+		return req, nil
+	}
+
+	title, body, template, warnings, err := resolveNotificationContent(ctx, c, req, user)
+	if err != nil {
+		return req, fmt.Errorf("could not resolve notification content: %w", err)
+	}
+	for _, warning := range warnings {
+		log.Printf("Notification %s: %s", req.ID, warning)
+	}
+	req.Title, req.Body = &title, &body
+	if template != nil {
+		req.Template = templateName(req)
+		req = platform.WithTemplate(req, template)
+	}
+	return req, nil
+}
+
+// allowed checks the user's preferences and addresses for channel.
+func allowed(channel models.NotificationType, user *models.User) error {
+	switch channel {
+	case models.Email:
+		if user == nil {
+			return classified(ClassNoRecipient, errors.New("email needs a user_id"))
+		}
+		if !user.Preferences.Email {
+			return classified(ClassOptedOut, errors.New("user has disabled email notifications"))
+		}
+		if user.Email == "" {
+			return classified(ClassNoRecipient, errors.New("user has no email address"))
+		}
+	case models.Stream, models.InApp:
+		if user == nil {
+			return classified(ClassNoRecipient, fmt.Errorf("%s needs a user_id", channel))
+		}
+	case models.WebPush:
+		if user == nil {
+			return classified(ClassNoRecipient, errors.New("web push needs a user_id"))
+		}
+		if !user.Preferences.Push {
+			return classified(ClassOptedOut, errors.New("user has disabled push notifications"))
+		}
+	case models.APNs:
+		// The token may come from the request, which the channel checks.
+		if user != nil && !user.Preferences.Push {
+			return classified(ClassOptedOut, errors.New("user has disabled push notifications"))
+		}
+	}
+	return nil
+}
+
+// saveToInbox adds a delivered notification to the user's inbox. Failing
+// to is only logged, since the notification did reach the user. Nothing is
+// saved in capture mode, where no notification reaches the user at all.
+func saveToInbox(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, channel models.NotificationType, user *models.User) {
+	if c.Inbox == nil || c.Sender != nil || req.UserID == "" || req.Kind == models.KindData || IsDryRun(c, req) {
+		return
+	}
+
+	if user == nil {
+		fetched, err := c.Upstream.FetchUser(ctx, req.UserID)
+		if err != nil {
+			log.Printf("Notification %s not saved to the inbox: couldn't fetch user: %v", req.ID, err)
+			return
+		}
+		user = fetched
+	}
+	title, body, template, _, err := resolveNotificationContent(ctx, c, req, user)
+	if err != nil {
+		log.Printf("Notification %s not saved to the inbox: %v", req.ID, err)
+		return
+	}
+	data, _ := payload.Encode(req.Variables, c.DataMode)
+	opts := platform.Resolve(req, template)
+
+	err = c.Inbox.Save(ctx, models.InboxItem{
+		ID:        req.ID,
+		UserID:    req.UserID,
+		Title:     title,
+		Body:      body,
+		Image:     opts.ImageURL,
+		Link:      opts.Link,
+		Template:  req.Template,
+		Channel:   channel,
+		Data:      data,
+		CreatedAt: time.Now(),
+	})
+	if err != nil {
+		log.Printf("Failed to save notification %s to the inbox: %v", req.ID, err)
+	}
+}
+
+func recordAttempt(c *models.Consumer, requestID string, outcome Outcome, state status.State, err error) {
+	attempt := status.ChannelAttempt{
+		Channel:   string(outcome.Channel),
+		State:     state,
+		MessageID: outcome.MessageID,
+		At:        time.Now(),
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+		attempt.ErrorClass = string(Classify(err))
+	}
+	c.Status.Update(requestID, func(r *status.Record) {
+		r.Channels = append(r.Channels, attempt)
+	})
+}
@@ -0,0 +1,210 @@
+package sendNotification
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"push_service/inbox"
+	"push_service/models"
+	"push_service/sandbox"
+	"push_service/status"
+	"push_service/upstream"
+)
+
+// fakeUpstream serves users and templates from maps.
+type fakeUpstream struct {
+	users     map[string]models.User
+	templates map[string]models.TemplateResponse
+}
+
+func (f *fakeUpstream) FetchUser(_ context.Context, userID string) (*models.User, error) {
+	user, ok := f.users[userID]
+	if !ok {
+		return nil, upstream.ErrNotFound
+	}
+	return &user, nil
+}
+
+func (f *fakeUpstream) FetchTemplate(_ context.Context, name string) (*models.TemplateResponse, error) {
+	template, ok := f.templates[name]
+	if !ok {
+		return nil, upstream.ErrNotFound
+	}
+	return &template, nil
+}
+
+func (f *fakeUpstream) Health() []models.UpstreamHealth {
+	return nil
+}
+
+// newConsumer returns a consumer whose upstream knows user u-1 and the
+// default template, with an in-memory inbox.
+func newConsumer(t *testing.T) *models.Consumer {
+	t.Helper()
+	inboxes, err := inbox.NewMemoryStore("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &models.Consumer{
+		Status: status.NewStore(status.DefaultMaxRecords),
+		Inbox:  inboxes,
+		Upstream: &fakeUpstream{
+			users: map[string]models.User{
+				"u-1": {Name: "Ada", Email: "ada@example.com", PushToken: "token-1", Preferences: models.Preferences{Email: true, Push: true}},
+			},
+			templates: map[string]models.TemplateResponse{
+				DefaultTemplate: {Name: DefaultTemplate, Title: "Welcome", Body: "Hi {{name}}"},
+			},
+		},
+		Channels: map[models.NotificationType]models.Channel{},
+	}
+}
+
+func TestCaptureModeLeavesInboxesAlone(t *testing.T) {
+	for _, channel := range []models.NotificationType{models.Push, models.Email} {
+		t.Run(string(channel), func(t *testing.T) {
+			c := newConsumer(t)
+			captures := sandbox.NewStore(10)
+			c.Sender = captures
+			c.Channels[models.Email] = captures.Channel(models.Email)
+
+			req := models.NotifMessageRequest{
+				ID:        "n-1",
+				UserID:    "u-1",
+				Variables: map[string]any{"name": "Ada"},
+				Channels:  []models.NotificationType{channel},
+			}
+			if _, err := Deliver(context.Background(), c, req, 0, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			captured := captures.List(sandbox.Filter{Template: DefaultTemplate}, 0)
+			if len(captured) != 1 {
+				t.Fatalf("captured %d messages under the default template, want 1", len(captured))
+			}
+			if unread, _ := c.Inbox.Unread(context.Background(), "u-1"); unread != 0 {
+				t.Fatalf("captured notification reached the real inbox (%d unread)", unread)
+			}
+		})
+	}
+}
+
+// recorder is a channel that keeps the requests it was asked to send.
+type recorder struct {
+	sent []models.NotifMessageRequest
+}
+
+func (r *recorder) Send(_ context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	r.sent = append(r.sent, req)
+	return req.ID, nil
+}
+
+func TestChannelsReceiveTheTemplateDefaults(t *testing.T) {
+	c := newConsumer(t)
+	ttl := 600
+	c.Upstream.(*fakeUpstream).templates["order_shipped"] = models.TemplateResponse{
+		Name:     "order_shipped",
+		Title:    "Shipped",
+		Body:     "On its way",
+		ImageURL: "https://cdn.example.com/box.png",
+		Link:     "https://app.example.com/orders",
+		Priority: "normal",
+		TTL:      &ttl,
+	}
+	channel := &recorder{}
+	c.Channels[models.APNs] = channel
+
+	req := models.NotifMessageRequest{
+		ID:       "n-1",
+		UserID:   "u-1",
+		Template: "order_shipped",
+		Link:     "https://app.example.com/orders/42",
+		Channels: []models.NotificationType{models.APNs},
+	}
+	if _, err := Deliver(context.Background(), c, req, 0, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(channel.sent) != 1 {
+		t.Fatalf("channel was asked to send %d messages, want 1", len(channel.sent))
+	}
+	sent := channel.sent[0]
+	if sent.ImageURL != "https://cdn.example.com/box.png" || sent.Priority != "normal" || sent.TTL == nil || *sent.TTL != ttl {
+		t.Fatalf("template defaults were not passed on: image %q, priority %q, ttl %v", sent.ImageURL, sent.Priority, sent.TTL)
+	}
+	if sent.Link != req.Link {
+		t.Fatalf("link is %q, want the request's own %q", sent.Link, req.Link)
+	}
+}
+
+// failing is a channel whose every send fails with err.
+type failing struct {
+	err   error
+	calls int
+}
+
+func (f *failing) Send(context.Context, models.NotifMessageRequest, *models.User) (string, error) {
+	f.calls++
+	return "", f.err
+}
+
+func TestDeliverFallsBackAfterPermanentFailures(t *testing.T) {
+	c := newConsumer(t)
+	apns := &failing{err: classified(ClassUnregistered, errors.New("token is gone"))}
+	email := &recorder{}
+	c.Channels[models.APNs] = apns
+	c.Channels[models.Email] = email
+	c.Status.Create(status.Record{ID: "n-1"})
+
+	req := models.NotifMessageRequest{
+		ID:       "n-1",
+		UserID:   "u-1",
+		Channels: []models.NotificationType{models.APNs, models.Email},
+	}
+	outcome, err := Deliver(context.Background(), c, req, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outcome.Channel != models.Email || outcome.Index != 1 || len(email.sent) != 1 {
+		t.Fatalf("delivered over %s at %d with %d emails, want one email at 1", outcome.Channel, outcome.Index, len(email.sent))
+	}
+
+	record, _ := c.Status.Get("n-1")
+	if len(record.Channels) != 2 || record.Channels[0].State != status.Failed || record.Channels[1].State != status.Sent {
+		t.Fatalf("attempts are %+v, want apns failed then email sent", record.Channels)
+	}
+	if record.Channels[0].ErrorClass != string(ClassUnregistered) {
+		t.Fatalf("apns failure was recorded as %q", record.Channels[0].ErrorClass)
+	}
+}
+
+func TestDeliverStopsAtTransientFailures(t *testing.T) {
+	c := newConsumer(t)
+	apns := &failing{err: classified(ClassUnavailable, errors.New("apns is down"))}
+	email := &recorder{}
+	c.Channels[models.APNs] = apns
+	c.Channels[models.Email] = email
+
+	req := models.NotifMessageRequest{
+		ID:       "n-1",
+		UserID:   "u-1",
+		Channels: []models.NotificationType{models.APNs, models.Email},
+	}
+	outcome, err := Deliver(context.Background(), c, req, 0, nil)
+	if Classify(err) != ClassUnavailable {
+		t.Fatalf("Deliver returned %v, want the apns failure", err)
+	}
+	if outcome.Index != 0 || len(email.sent) != 0 {
+		t.Fatalf("stopped at %d after %d emails, want to retry apns first", outcome.Index, len(email.sent))
+	}
+
+	// A retry resumes from the channel that failed.
+	apns.err = nil
+	if outcome, err = Deliver(context.Background(), c, req, outcome.Index, nil); err != nil || outcome.Channel != models.APNs {
+		t.Fatalf("retry delivered over %s, %v", outcome.Channel, err)
+	}
+	if apns.calls != 2 || len(email.sent) != 0 {
+		t.Fatalf("apns was called %d times and email %d, want 2 and 0", apns.calls, len(email.sent))
+	}
+}
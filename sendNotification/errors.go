@@ -0,0 +1,182 @@
+package sendNotification
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"firebase.google.com/go/v4/errorutils"
+	"firebase.google.com/go/v4/messaging"
+)
+
+// ErrorClass groups delivery failures by what the caller should do about them.
+type ErrorClass string
+
+const (
+	ClassInvalidPayload  ErrorClass = "invalid_payload"
+	ClassInvalidArgument ErrorClass = "invalid_argument"
+	ClassNoToken         ErrorClass = "no_token"
+	ClassOptedOut        ErrorClass = "opted_out"
+	ClassNoRecipient     ErrorClass = "no_recipient"
+	ClassNoChannel       ErrorClass = "channel_unavailable"
+	ClassUnregistered    ErrorClass = "unregistered"
+	ClassSenderMismatch  ErrorClass = "sender_id_mismatch"
+	ClassAuth            ErrorClass = "third_party_auth"
+	ClassQuotaExceeded   ErrorClass = "quota_exceeded"
+	ClassUnavailable     ErrorClass = "unavailable"
+	ClassInternal        ErrorClass = "internal"
+	ClassUpstream        ErrorClass = "upstream"
+	ClassTimeout         ErrorClass = "timeout"
+	ClassUnknown         ErrorClass = "unknown"
+)
+
+// Permanent reports whether retrying cannot succeed. Workers send these
+// straight to the dead letter queue.
+func (c ErrorClass) Permanent() bool {
+	switch c {
+	case ClassInvalidPayload, ClassInvalidArgument, ClassNoToken, ClassOptedOut, ClassNoRecipient, ClassNoChannel, ClassUnregistered, ClassSenderMismatch, ClassAuth:
+		return true
+	default:
+		return false
+	}
+}
+
+// HTTPStatus is the status the synchronous send endpoint responds with.
+func (c ErrorClass) HTTPStatus() int {
+	switch c {
+	case ClassInvalidPayload, ClassNoToken, ClassOptedOut, ClassNoRecipient, ClassNoChannel:
+		return http.StatusUnprocessableEntity
+	case ClassInvalidArgument:
+		return http.StatusBadRequest
+	case ClassUnregistered:
+		return http.StatusGone
+	case ClassSenderMismatch:
+		return http.StatusForbidden
+	case ClassQuotaExceeded:
+		return http.StatusTooManyRequests
+	case ClassUnavailable:
+		return http.StatusServiceUnavailable
+	case ClassAuth, ClassInternal, ClassUpstream:
+		return http.StatusBadGateway
+	case ClassTimeout:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// DeliveryError is a classified delivery failure.
+type DeliveryError struct {
+	Class ErrorClass
+	// RetryAfter is how long the provider asked us to back off, if it did.
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *DeliveryError) Error() string {
+	return string(e.Class) + ": " + e.Err.Error()
+}
+
+func (e *DeliveryError) Unwrap() error {
+	return e.Err
+}
+
+func classified(class ErrorClass, err error) error {
+	return &DeliveryError{Class: class, Err: err}
+}
+
+// Classify returns the class of err. Unclassified errors are ClassUnknown and
+// therefore retried.
+func Classify(err error) ErrorClass {
+	if err == nil {
+		return ""
+	}
+
+	var delivery *DeliveryError
+	if errors.As(err, &delivery) {
+		return delivery.Class
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ClassTimeout
+	}
+	return ClassUnknown
+}
+
+func IsPermanent(err error) bool {
+	return Classify(err).Permanent()
+}
+
+// RetryAfter returns the back-off requested by the provider, or 0.
+func RetryAfter(err error) time.Duration {
+	var delivery *DeliveryError
+	if errors.As(err, &delivery) {
+		return delivery.RetryAfter
+	}
+	return 0
+}
+
+// classifyFCM must be given the error exactly as the Firebase client returned
+// it, since the Firebase helpers do not unwrap.
+func classifyFCM(err error) error {
+	delivery := &DeliveryError{Class: ClassUnknown, Err: err}
+
+	switch {
+	case messaging.IsUnregistered(err):
+		delivery.Class = ClassUnregistered
+	case messaging.IsInvalidArgument(err):
+		delivery.Class = ClassInvalidArgument
+	case messaging.IsSenderIDMismatch(err), messaging.IsMismatchedCredential(err):
+		delivery.Class = ClassSenderMismatch
+	case messaging.IsThirdPartyAuthError(err):
+		delivery.Class = ClassAuth
+	case messaging.IsQuotaExceeded(err), messaging.IsMessageRateExceeded(err):
+		delivery.Class = ClassQuotaExceeded
+	case messaging.IsUnavailable(err):
+		delivery.Class = ClassUnavailable
+	case messaging.IsInternal(err):
+		delivery.Class = ClassInternal
+	case errors.Is(err, context.DeadlineExceeded) || errorutils.IsDeadlineExceeded(err):
+		delivery.Class = ClassTimeout
+	}
+
+	if resp := errorutils.HTTPResponse(err); resp != nil {
+		if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil {
+			delivery.RetryAfter = time.Duration(seconds) * time.Second
+		}
+	}
+	return delivery
+}
+
+// ClassifyHTTP classifies a failed response from an HTTP delivery provider
+// such as a webhook endpoint, honouring its Retry-After header.
+func ClassifyHTTP(code int, header http.Header, err error) error {
+	delivery := &DeliveryError{Class: ClassUnknown, Err: err}
+
+	switch {
+	case code == http.StatusBadRequest:
+		delivery.Class = ClassInvalidArgument
+	case code == http.StatusUnauthorized, code == http.StatusForbidden:
+		delivery.Class = ClassAuth
+	case code == http.StatusNotFound, code == http.StatusGone:
+		delivery.Class = ClassUnregistered
+	case code == http.StatusRequestTimeout:
+		delivery.Class = ClassTimeout
+	case code == http.StatusRequestEntityTooLarge:
+		delivery.Class = ClassInvalidPayload
+	case code == http.StatusTooManyRequests:
+		delivery.Class = ClassQuotaExceeded
+	case code == http.StatusInternalServerError:
+		delivery.Class = ClassInternal
+	case code >= 500:
+		delivery.Class = ClassUnavailable
+	case code >= 400:
+		delivery.Class = ClassInvalidArgument
+	}
+
+	if seconds, convErr := strconv.Atoi(header.Get("Retry-After")); convErr == nil {
+		delivery.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return delivery
+}
@@ -0,0 +1,97 @@
+package sendNotification
+
+import (
+	"push_service/payload"
+	"push_service/truncate"
+
+	"firebase.google.com/go/v4/messaging"
+)
+
+// applyLimits shortens the title and body for each platform. The top-level
+// notification gets the most generous limit, and a platform only carries its
+// own copy when it needs a shorter one. It returns the fields it shortened,
+// e.g. apns.body.
+func applyLimits(message *messaging.Message, limits truncate.Limits) []string {
+	if message.Notification == nil {
+		return nil
+	}
+	title, body := message.Notification.Title, message.Notification.Body
+
+	var truncated []string
+	shorten := func(platform, field, text string, max int) string {
+		short, cut := truncate.Text(text, max)
+		if cut {
+			truncated = append(truncated, platform+"."+field)
+		}
+		return short
+	}
+
+	platforms := []struct {
+		name  string
+		limit truncate.Limit
+		apply func(title, body string)
+	}{
+		{"android", limits.Android, func(title, body string) {
+			if message.Android != nil && message.Android.Notification != nil {
+				message.Android.Notification.Title = title
+				message.Android.Notification.Body = body
+			}
+		}},
+		{"apns", limits.APNS, func(title, body string) {
+			if message.APNS != nil && message.APNS.Payload != nil && message.APNS.Payload.Aps != nil {
+				message.APNS.Payload.Aps.Alert = &messaging.ApsAlert{Title: title, Body: body}
+			}
+		}},
+		{"webpush", limits.Webpush, func(title, body string) {
+			if message.Webpush != nil && message.Webpush.Notification != nil {
+				message.Webpush.Notification.Title = title
+				message.Webpush.Notification.Body = body
+			}
+		}},
+	}
+
+	var titles, bodies []int
+	for _, p := range platforms {
+		titles = append(titles, p.limit.Title)
+		bodies = append(bodies, p.limit.Body)
+	}
+	message.Notification.Title, _ = truncate.Text(title, widest(titles))
+	message.Notification.Body, _ = truncate.Text(body, widest(bodies))
+
+	for _, p := range platforms {
+		short := [2]string{
+			shorten(p.name, "title", title, p.limit.Title),
+			shorten(p.name, "body", body, p.limit.Body),
+		}
+		if short != [2]string{message.Notification.Title, message.Notification.Body} {
+			p.apply(short[0], short[1])
+		}
+	}
+	return truncated
+}
+
+// widest returns the largest limit, or 0 when any platform is unlimited.
+func widest(limits []int) int {
+	result := 0
+	for _, limit := range limits {
+		if limit == 0 {
+			return 0
+		}
+		result = max(result, limit)
+	}
+	return result
+}
+
+// fitBody shortens body so the notification fits the FCM payload limit,
+// which APNs also enforces. It gives up when the data and title alone are
+// too large, leaving CheckSize to reject the message.
+func fitBody(data map[string]string, title, body string) (string, bool) {
+	budget := payload.MaxSize - len(title)
+	for key, value := range data {
+		budget -= len(key) + len(value)
+	}
+	if budget >= len(body) || budget < len(truncate.Ellipsis) {
+		return body, false
+	}
+	return truncate.Bytes(body, budget)
+}
@@ -0,0 +1,121 @@
+package sendNotification
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"regexp"
+
+	"push_service/locale"
+	"push_service/models"
+	"push_service/templates"
+)
+
+// DefaultTemplate is used when a request names no template.
+const DefaultTemplate = "welcome_push"
+
+var placeholderPattern = regexp.MustCompile(`{{\s*([a-zA-Z0-9_.-]+)\s*(?:\|\s*([a-z]+)\s*)?}}`)
+
+func templateName(req models.NotifMessageRequest) string {
+	if req.Template != "" {
+		return req.Template
+	}
+	return DefaultTemplate
+}
+
+// requestLocale is the locale asked for by the request, else the user's.
+// Empty means the default locale.
+func requestLocale(req models.NotifMessageRequest, user *models.User) string {
+	if req.Locale != "" {
+		return locale.Normalize(req.Locale)
+	}
+	if user != nil && locale.Valid(user.Locale) {
+		return locale.Normalize(user.Locale)
+	}
+	return ""
+}
+
+// fetchTemplate uses the consumer's template source, falling back to the
+// template service, which only has the default locale.
+func fetchTemplate(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, tag string) (*models.TemplateResponse, error) {
+	if c.Templates != nil {
+		return c.Templates.FetchTemplate(ctx, templateName(req), tag, req.TemplateVersion)
+	}
+	if req.TemplateVersion > 0 {
+		return nil, fmt.Errorf("%w: no local template store to pin version %d", templates.ErrVersionNotFound, req.TemplateVersion)
+	}
+	return c.Upstream.FetchTemplate(ctx, templateName(req))
+}
+
+// templateVariables adds user.name and user.email to the request
+// variables. Variables sent with the request win.
+func templateVariables(req models.NotifMessageRequest, user *models.User) map[string]any {
+	if user == nil {
+		return req.Variables
+	}
+
+	variables := map[string]any{
+		"user.name":  user.Name,
+		"user.email": user.Email,
+	}
+	maps.Copy(variables, req.Variables)
+	return variables
+}
+
+// render replaces {{name}} placeholders with the matching variables and
+// returns a warning for each one it could not fill. Placeholders without a
+// variable are left as they are. A filter formats the value for tag:
+// {{amount|number}}, {{when|date}} and {{when|datetime}}.
+func render(field, text string, variables map[string]any, tag string) (string, []string) {
+	var warnings []string
+	rendered := placeholderPattern.ReplaceAllStringFunc(text, func(placeholder string) string {
+		match := placeholderPattern.FindStringSubmatch(placeholder)
+		name, filter := match[1], match[2]
+		value, ok := variables[name]
+		if !ok || value == nil {
+			warnings = append(warnings, fmt.Sprintf("%s has no value for {{%s}}", field, name))
+			return placeholder
+		}
+		formatted, err := format(value, filter, tag)
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("%s could not format {{%s|%s}}: %v", field, name, filter, err))
+			return fmt.Sprint(value)
+		}
+		return formatted
+	})
+	return rendered, warnings
+}
+
+func format(value any, filter, tag string) (string, error) {
+	switch filter {
+	case "":
+		return fmt.Sprint(value), nil
+	case "number":
+		n, err := locale.ParseNumber(value)
+		if err != nil {
+			return "", fmt.Errorf("%v is not a number", value)
+		}
+		return locale.FormatNumber(tag, n), nil
+	case "date", "datetime":
+		t, err := locale.ParseDate(value)
+		if err != nil {
+			return "", fmt.Errorf("%v is not an RFC 3339 date", value)
+		}
+		return locale.FormatDate(tag, t, filter == "datetime"), nil
+	default:
+		return "", fmt.Errorf("unknown filter %q", filter)
+	}
+}
+
+// formattingLocale is the requested locale when the template is a variant of
+// its language, so pt-BR formatting is used with a pt template. Otherwise
+// values are formatted to match the language of the text they appear in.
+func formattingLocale(requested string, template *models.TemplateResponse) string {
+	if template == nil || template.Locale == "" {
+		return requested
+	}
+	if requested != "" && locale.Language(requested) == locale.Language(template.Locale) {
+		return requested
+	}
+	return template.Locale
+}
@@ -1,19 +1,116 @@
 package sendNotification
 
 import (
+	"cmp"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"push_service/api"
+	"os"
 	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	"push_service/status"
+	"push_service/templates"
+	"strconv"
 
 	"firebase.google.com/go/v4/messaging"
 )
 
-func SendNotification(ctx context.Context, c *models.Consumer, notifMessageRequest models.NotifMessageRequest) error {
-	token, title, body, err := resolveNotificationContent(notifMessageRequest)
+// Rendered is a notification resolved into the FCM message that would be
+// sent, along with anything that deserves a second look.
+type Rendered struct {
+	Message  *messaging.Message
+	Template *models.TemplateResponse
+	Warnings []string
+	// Truncated lists the fields shortened to fit, such as apns.body.
+	Truncated []string
+}
+
+// SendNotification resolves and delivers a notification and returns the FCM
+// message ID. Errors are *DeliveryError values classified for retry handling.
+func SendNotification(ctx context.Context, c *models.Consumer, notifMessageRequest models.NotifMessageRequest) (string, error) {
+	token, user, err := resolveTarget(ctx, c, notifMessageRequest)
+	if err != nil {
+		return "", fmt.Errorf("could not resolve notification target: %w", err)
+	}
+
+	rendered, err := Render(ctx, c, notifMessageRequest, token, user)
 	if err != nil {
-		return fmt.Errorf("could not resolve notification content: %w", err)
+		return "", err
+	}
+	for _, warning := range rendered.Warnings {
+		log.Printf("Notification %s: %s", notifMessageRequest.ID, warning)
+	}
+	if len(rendered.Truncated) > 0 {
+		c.Status.Update(notifMessageRequest.ID, func(r *status.Record) {
+			r.Truncated = rendered.Truncated
+		})
+	}
+	if rendered.Template != nil {
+		// Captures are filtered by the template that was used, which may
+		// be the default one.
+		notifMessageRequest.Template = templateName(notifMessageRequest)
+	}
+
+	return sendMessage(ctx, c, notifMessageRequest, rendered.Message)
+}
+
+// Preview renders req exactly as the consumer would, without sending it.
+// Problems that would stop delivery to the user are reported as warnings
+// rather than errors.
+func Preview(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest) (*Rendered, error) {
+	var (
+		user     *models.User
+		token    string
+		warnings []string
+	)
+
+	if req.PushToken != nil {
+		token = *req.PushToken
+	} else if req.UserID != "" {
+		fetched, err := c.Upstream.FetchUser(ctx, req.UserID)
+		if err != nil {
+			return nil, upstreamError("user", err)
+		}
+		user, token = fetched, fetched.PushToken
+		if !user.Preferences.Push {
+			warnings = append(warnings, "user has disabled push notifications")
+		}
+		if token == "" {
+			warnings = append(warnings, "user has no push token")
+		}
+	}
+
+	rendered, err := Render(ctx, c, req, token, user)
+	if err != nil {
+		return nil, err
+	}
+	rendered.Warnings = append(warnings, rendered.Warnings...)
+	return rendered, nil
+}
+
+// Render builds the FCM message for req addressed to token. When the user is
+// known their fields are available to templates as user.* variables.
+func Render(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, token string, user *models.User) (*Rendered, error) {
+	if req.Kind == models.KindData {
+		return renderDataMessage(c, req, token)
+	}
+
+	title, body, template, warnings, err := resolveNotificationContent(ctx, c, req, user)
+	if err != nil {
+		return nil, fmt.Errorf("could not resolve notification content: %w", err)
+	}
+
+	options := platform.Resolve(req, template)
+	if err := options.Validate(); err != nil {
+		return nil, classified(ClassInvalidPayload, fmt.Errorf("invalid platform options: %w", err))
+	}
+
+	data, err := buildData(c, req)
+	if err != nil {
+		return nil, err
 	}
 
 	message := &messaging.Message{
@@ -21,72 +118,200 @@ func SendNotification(ctx context.Context, c *models.Consumer, notifMessageReque
 			Title: title,
 			Body:  body,
 		},
-		Data:  convertMetaToStringMap(notifMessageRequest.Variables),
-		Token: token,
+		Data:      data,
+		Token:     token,
+		Topic:     req.Topic,
+		Condition: req.Condition,
 	}
+	options.Apply(message)
 
-	if err := sendMessage(ctx, c, message); err != nil {
-		return err
+	// The size is that of the final data, which Apply may have added the
+	// link to.
+	truncated := applyLimits(message, c.Limits)
+	if fitted, cut := fitBody(message.Data, message.Notification.Title, message.Notification.Body); cut {
+		message.Notification.Body = fitted
+		truncated = append(truncated, "body")
+	}
+	if err := payload.CheckSize(message.Data, message.Notification.Title, message.Notification.Body); err != nil {
+		return nil, classified(ClassInvalidPayload, err)
+	}
+	for _, field := range truncated {
+		warnings = append(warnings, fmt.Sprintf("%s was truncated to fit", field))
 	}
 
-	return nil
+	return &Rendered{Message: message, Template: template, Warnings: warnings, Truncated: truncated}, nil
 }
 
-func sendMessage(ctx context.Context, c *models.Consumer, message *messaging.Message) error {
-	_, err := c.Client.Send(ctx, message)
+// renderDataMessage builds only the Data map. No template is resolved
+// because nothing is displayed to the user.
+func renderDataMessage(c *models.Consumer, req models.NotifMessageRequest, token string) (*Rendered, error) {
+	options := platform.Resolve(req, nil)
+	if err := options.Validate(); err != nil {
+		return nil, classified(ClassInvalidPayload, fmt.Errorf("invalid platform options: %w", err))
+	}
+
+	data, err := buildData(c, req)
 	if err != nil {
-		return fmt.Errorf("Error sending message: %v\n", err)
+		return nil, err
+	}
+	if err := payload.CheckSize(data, "", ""); err != nil {
+		return nil, classified(ClassInvalidPayload, err)
+	}
+
+	message := &messaging.Message{
+		Data:      data,
+		Token:     token,
+		Topic:     req.Topic,
+		Condition: req.Condition,
 	}
+	options.ApplyData(message)
+
+	return &Rendered{Message: message}, nil
+}
+
+// IsDryRun reports whether req is only validated, either because it asked
+// for a dry run or because the service runs in sandbox mode.
+func IsDryRun(c *models.Consumer, req models.NotifMessageRequest) bool {
+	return req.DryRun || c.Sandbox
+}
 
-	fmt.Printf("Successfully sent message")
-	return nil
+// SandboxFromEnv reads the global dry-run switch from SANDBOX_MODE.
+func SandboxFromEnv() bool {
+	raw := os.Getenv("SANDBOX_MODE")
+	if raw == "" {
+		return false
+	}
+	sandbox, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("Ignoring invalid SANDBOX_MODE=%q", raw)
+		return false
+	}
+	if sandbox {
+		log.Println("Sandbox mode: notifications are validated with FCM but never delivered")
+	}
+	return sandbox
 }
 
-func convertMetaToStringMap(meta map[string]any) map[string]string {
-	if meta == nil {
-		return nil
+func sendMessage(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, message *messaging.Message) (string, error) {
+	if c.Sender != nil {
+		return c.Sender.Send(ctx, req, message)
+	}
+	if IsDryRun(c, req) {
+		return sendDryRun(ctx, c, req, message)
 	}
 
-	result := make(map[string]string)
-	for key, value := range meta {
-		result[key] = fmt.Sprintf("%v", value)
+	messageID, err := c.Client.Send(ctx, message)
+	if err != nil {
+		return "", fmt.Errorf("Error sending message: %w", classifyFCM(err))
 	}
-	return result
+
+	log.Printf("Successfully sent message %s", messageID)
+	return messageID, nil
 }
 
-func resolveNotificationContent(req models.NotifMessageRequest) (token, title, body string, err error) {
-	if req.PushToken == nil || *req.PushToken == "" {
-		user, err := api.FetchUser(req.UserID)
-		if err != nil {
-			log.Println("Couldn't fetch user ")
-			return "", "", "", fmt.Errorf("Couldn't fetch user: %v", err)
-		}
-		token = user.PushToken
+// sendDryRun validates message with FCM and stores it on the status record
+// so callers can inspect what would have been delivered.
+func sendDryRun(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, message *messaging.Message) (string, error) {
+	if encoded, err := json.Marshal(message); err == nil {
+		c.Status.Update(req.ID, func(r *status.Record) {
+			r.DryRun = true
+			r.Message = encoded
+		})
 	} else {
-		token = *req.PushToken
+		log.Printf("Could not encode dry run message %s: %v", req.ID, err)
 	}
 
-	var template *models.TemplateResponse
+	messageID, err := c.Client.SendDryRun(ctx, message)
+	if err != nil {
+		return "", fmt.Errorf("Dry run rejected by FCM: %w", classifyFCM(err))
+	}
 
+	log.Printf("Dry run of %s accepted by FCM", req.ID)
+	return messageID, nil
+}
+
+// buildData encodes the request variables into the FCM data map. Reserved
+// keys fail permanently since retrying cannot fix them.
+func buildData(c *models.Consumer, req models.NotifMessageRequest) (map[string]string, error) {
+	data, err := payload.Encode(req.Variables, c.DataMode)
+	if err != nil {
+		return nil, classified(ClassInvalidPayload, err)
+	}
+	return data, nil
+}
+
+// resolveTarget returns an empty token for topic and condition broadcasts,
+// which are not addressed to a single user. The user is only returned when
+// it had to be looked up.
+func resolveTarget(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest) (string, *models.User, error) {
+	if req.Topic != "" || req.Condition != "" {
+		return "", nil, nil
+	}
+	if req.PushToken != nil && *req.PushToken != "" {
+		return *req.PushToken, nil, nil
+	}
+
+	user, err := c.Upstream.FetchUser(ctx, req.UserID)
+	if err != nil {
+		log.Println("Couldn't fetch user ")
+		return "", nil, upstreamError("user", err)
+	}
+	if !user.Preferences.Push {
+		return "", nil, classified(ClassOptedOut, errors.New("user has disabled push notifications"))
+	}
+	if user.PushToken == "" {
+		return "", nil, classified(ClassNoToken, errors.New("user has no push token"))
+	}
+	return user.PushToken, user, nil
+}
+
+func resolveNotificationContent(ctx context.Context, c *models.Consumer, req models.NotifMessageRequest, user *models.User) (title, body string, template *models.TemplateResponse, warnings []string, err error) {
+	tag := requestLocale(req, user)
 	if req.Body == nil || req.Title == nil || *req.Body == "" || *req.Title == "" {
-		template, err = api.FetchTemplate("welcome_push")
+		template, err = fetchTemplate(ctx, c, req, tag)
 		if err != nil {
-			log.Println("Couldn't fetch template ")
-			return "", "", "", fmt.Errorf("Couldn't fetch template: %v", err)
+			return "", "", nil, nil, templateError(err)
 		}
 	}
 
+	variables := templateVariables(req, user)
+	tag = formattingLocale(tag, template)
+
 	if req.Body == nil {
-		body = template.Body
+		var problems []string
+		body, problems = render("body", template.Body, variables, tag)
+		warnings = append(warnings, problems...)
 	} else {
 		body = *req.Body
 	}
 
 	if req.Title == nil {
-		title = template.Name
+		var problems []string
+		title, problems = render("title", cmp.Or(template.Title, template.Name), variables, tag)
+		warnings = append(warnings, problems...)
 	} else {
 		title = *req.Title
 	}
 
-	return token, title, body, nil
+	return title, body, template, warnings, nil
+}
+
+// templateError classifies a failed template lookup. A pinned version that
+// does not exist will never appear, while the template service may recover.
+func templateError(err error) error {
+	if errors.Is(err, templates.ErrVersionNotFound) {
+		return classified(ClassInvalidPayload, err)
+	}
+	log.Println("Couldn't fetch template ")
+	return upstreamError("template", err)
+}
+
+// upstreamError classifies a failed lookup in the user or template service.
+// Running out of time is a timeout rather than the service's failure.
+func upstreamError(what string, err error) error {
+	class := ClassUpstream
+	if errors.Is(err, context.DeadlineExceeded) {
+		class = ClassTimeout
+	}
+	return classified(class, fmt.Errorf("Couldn't fetch %s: %w", what, err))
 }
@@ -0,0 +1,38 @@
+package sendNotification
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"testing"
+
+	"push_service/models"
+	"push_service/payload"
+)
+
+func TestRenderFitsTheLinkItAdds(t *testing.T) {
+	c := newConsumer(t)
+	c.Upstream.(*fakeUpstream).templates["order_shipped"] = models.TemplateResponse{
+		Name:  "order_shipped",
+		Title: "Shipped",
+		Body:  strings.Repeat("b", 4080),
+		Link:  "https://app.example.com/orders/42",
+	}
+	// Without variables the link is the only data, in a map Apply creates.
+	req := models.NotifMessageRequest{ID: "n-1", Template: "order_shipped"}
+
+	rendered, err := Render(context.Background(), c, req, "token-1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := rendered.Message
+	if message.Data["link"] != "https://app.example.com/orders/42" {
+		t.Fatalf("data has link %q", message.Data["link"])
+	}
+	if err := payload.CheckSize(message.Data, message.Notification.Title, message.Notification.Body); err != nil {
+		t.Fatalf("rendered message is over the limit: %v", err)
+	}
+	if !slices.Contains(rendered.Truncated, "body") {
+		t.Fatalf("body was not shortened to make room for the link: truncated %v", rendered.Truncated)
+	}
+}
@@ -0,0 +1,154 @@
+package status
+
+import (
+	"encoding/json"
+	"os"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// State is where a notification is in its lifecycle.
+type State string
+
+const (
+	Queued       State = "queued"
+	Processing   State = "processing"
+	Retrying     State = "retrying"
+	Deferred     State = "deferred"
+	Suppressed   State = "suppressed"
+	Sent         State = "sent"
+	Validated    State = "validated" // dry run accepted by FCM
+	Failed       State = "failed"
+	DeadLettered State = "dead_lettered"
+
+	ModeQueued = "queued"
+	ModeSync   = "sync"
+
+	DefaultMaxRecords = 100000
+)
+
+// Record is the tracked state of a single notification.
+type Record struct {
+	ID         string `json:"id"`
+	Mode       string `json:"mode"` // queued or sync
+	State      State  `json:"state"`
+	UserID     string `json:"user_id,omitempty"`
+	Template   string `json:"template,omitempty"`
+	Caller     string `json:"caller,omitempty"`
+	Tenant     string `json:"tenant,omitempty"`
+	Attempts   int    `json:"attempts"`
+	MessageID  string `json:"message_id,omitempty"` // FCM message ID once sent
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	DryRun     bool   `json:"dry_run,omitempty"`
+	// Channel is the channel being tried, or the one that delivered.
+	Channel string `json:"channel,omitempty"`
+	// Channels is every channel attempt in the fallback chain, in order.
+	Channels []ChannelAttempt `json:"channels,omitempty"`
+	// Truncated lists the fields shortened to fit platform limits, such as
+	// apns.body.
+	Truncated []string `json:"truncated,omitempty"`
+	// Message is the FCM message a dry run would have sent.
+	Message   json.RawMessage `json:"message,omitempty" swaggertype:"object"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// ChannelAttempt is the outcome of one channel in the fallback chain.
+type ChannelAttempt struct {
+	Channel    string    `json:"channel"`
+	State      State     `json:"state"` // sent, validated, suppressed or failed
+	MessageID  string    `json:"message_id,omitempty"`
+	Error      string    `json:"error,omitempty"`
+	ErrorClass string    `json:"error_class,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Store keeps notification records in memory. Once it holds max records the
+// oldest ones are evicted.
+//
+// Records are not shared between instances, so the status of a
+// notification is only complete on a single instance, where the API and
+// the workers that process its queue share one Store. With several
+// instances on one broker, a record stays queued wherever it was not
+// processed.
+type Store struct {
+	mu      sync.RWMutex
+	records map[string]*Record
+	order   []string
+	max     int
+}
+
+// NewStoreFromEnv sizes the store from STATUS_MAX_RECORDS.
+func NewStoreFromEnv() *Store {
+	max := DefaultMaxRecords
+	if value, err := strconv.Atoi(os.Getenv("STATUS_MAX_RECORDS")); err == nil && value > 0 {
+		max = value
+	}
+	return NewStore(max)
+}
+
+func NewStore(max int) *Store {
+	return &Store{records: make(map[string]*Record), max: max}
+}
+
+// Create starts tracking record. Records without an ID are ignored.
+func (s *Store) Create(record Record) {
+	if s == nil || record.ID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	record.CreatedAt = now
+	record.UpdatedAt = now
+	if _, ok := s.records[record.ID]; !ok {
+		s.order = append(s.order, record.ID)
+	}
+	s.records[record.ID] = &record
+
+	for len(s.order) > s.max {
+		delete(s.records, s.order[0])
+		s.order = s.order[1:]
+	}
+}
+
+// Update applies fn to the record with id, if it is tracked.
+func (s *Store) Update(id string, fn func(*Record)) {
+	if s == nil || id == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return
+	}
+	fn(record)
+	record.UpdatedAt = time.Now()
+}
+
+// Get returns a copy of the record with id.
+func (s *Store) Get(id string) (Record, bool) {
+	if s == nil {
+		return Record{}, false
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	record, ok := s.records[id]
+	if !ok {
+		return Record{}, false
+	}
+	copied := *record
+	copied.Channels = slices.Clone(record.Channels)
+	copied.Truncated = slices.Clone(record.Truncated)
+	return copied, true
+}
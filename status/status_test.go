@@ -0,0 +1,51 @@
+package status
+
+import "testing"
+
+func TestStoreEvictsTheOldestRecords(t *testing.T) {
+	store := NewStore(2)
+	for _, id := range []string{"a", "b", "c"} {
+		store.Create(Record{ID: id, State: Queued})
+	}
+
+	if _, ok := store.Get("a"); ok {
+		t.Fatal("oldest record was kept over the limit")
+	}
+	for _, id := range []string{"b", "c"} {
+		if _, ok := store.Get(id); !ok {
+			t.Fatalf("record %s was evicted", id)
+		}
+	}
+
+	// Recreating a record does not count it twice.
+	store.Create(Record{ID: "c", State: Queued})
+	if _, ok := store.Get("b"); !ok {
+		t.Fatal("recreating a record evicted another")
+	}
+}
+
+func TestGetReturnsACopy(t *testing.T) {
+	store := NewStore(DefaultMaxRecords)
+	store.Create(Record{ID: "a", State: Queued})
+	store.Update("a", func(r *Record) {
+		r.State = Sent
+		r.Channels = append(r.Channels, ChannelAttempt{Channel: "push", State: Sent})
+	})
+
+	record, _ := store.Get("a")
+	if record.State != Sent || len(record.Channels) != 1 {
+		t.Fatalf("Get returned %+v", record)
+	}
+	record.Channels[0].State = Failed
+	if again, _ := store.Get("a"); again.Channels[0].State != Sent {
+		t.Fatal("changing a returned record changed the store")
+	}
+
+	// Untracked IDs and a nil store are ignored.
+	store.Update("missing", func(r *Record) { t.Fatal("updated an untracked record") })
+	var disabled *Store
+	disabled.Create(Record{ID: "a"})
+	if _, ok := disabled.Get("a"); ok {
+		t.Fatal("nil store returned a record")
+	}
+}
@@ -0,0 +1,60 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	sendNotification "push_service/sendNotification"
+)
+
+// Channel delivers notifications to the user's open streams on every
+// instance. A user without one is a permanent failure, so a policy can
+// fall back to push.
+//
+// Delivery is at most once and best effort: Send succeeds once the event is
+// published, without any stream confirming it. Presence of other instances
+// can be up to three PresenceIntervals old, and an event published to a
+// stream that has just closed is lost. Like every delivered notification it
+// is saved to the user's inbox, where the client finds what it missed when
+// it reconnects.
+type Channel struct {
+	Relay    *Relay
+	DataMode payload.NestedMode
+}
+
+// Send publishes req to the user's streams. A nil error means the event was
+// published, not that a stream received it.
+func (c *Channel) Send(ctx context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	if !c.Relay.Online(req.UserID) {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassNoRecipient, Err: errors.New("user has no open stream")}
+	}
+
+	data, err := payload.Encode(req.Variables, c.DataMode)
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidPayload, Err: err}
+	}
+	opts := platform.Resolve(req, nil)
+	event := models.StreamEvent{
+		ID:        req.ID,
+		UserID:    req.UserID,
+		Image:     opts.ImageURL,
+		Link:      opts.Link,
+		Data:      data,
+		CreatedAt: time.Now().UTC(),
+	}
+	if req.Title != nil {
+		event.Title = *req.Title
+	}
+	if req.Body != nil {
+		event.Body = *req.Body
+	}
+
+	if err := c.Relay.Publish(ctx, event); err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassUnavailable, Err: err}
+	}
+	return req.ID, nil
+}
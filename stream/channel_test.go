@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"push_service/broker"
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+)
+
+func newChannel(t *testing.T) *Channel {
+	t.Helper()
+	b := broker.NewMemory(models.StreamTopology("test"))
+	t.Cleanup(func() { b.Close() })
+	relay := NewRelay(NewHub(), b, "test")
+	go relay.Run(context.Background())
+	return &Channel{Relay: relay}
+}
+
+func TestOfflineUsersFailPermanently(t *testing.T) {
+	channel := newChannel(t)
+
+	_, err := channel.Send(context.Background(), models.NotifMessageRequest{ID: "n-1", UserID: "u-1"}, nil)
+	if sendNotification.Classify(err) != sendNotification.ClassNoRecipient || !sendNotification.IsPermanent(err) {
+		t.Fatalf("offline user returned %v, want a permanent no-recipient failure", err)
+	}
+}
+
+func TestOpenStreamsReceiveTheEvent(t *testing.T) {
+	channel := newChannel(t)
+	session := channel.Relay.Hub.Connect("u-1")
+	defer channel.Relay.Hub.Disconnect(session)
+
+	title, body := "Shipped", "On its way"
+	req := models.NotifMessageRequest{
+		ID:       "n-1",
+		UserID:   "u-1",
+		Title:    &title,
+		Body:     &body,
+		Link:     "https://app.example.com/orders/42",
+		ImageURL: "https://cdn.example.com/box.png",
+	}
+	if _, err := channel.Send(context.Background(), req, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case event := <-session.Events:
+		if event.ID != "n-1" || event.Title != title || event.Body != body || event.Link != req.Link || event.Image != req.ImageURL {
+			t.Fatalf("stream received %+v", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("stream received nothing")
+	}
+}
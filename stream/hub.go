@@ -0,0 +1,114 @@
+package stream
+
+import (
+	"log"
+	"sync"
+
+	"push_service/models"
+
+	"github.com/google/uuid"
+)
+
+// sessionBuffer is how many events a session may fall behind before
+// further events are dropped for it.
+const sessionBuffer = 32
+
+// Session is one open stream of a user on this instance.
+type Session struct {
+	ID     string
+	UserID string
+	Events chan models.StreamEvent
+}
+
+// Hub tracks the sessions open on this instance.
+type Hub struct {
+	mu       sync.RWMutex
+	sessions map[string]map[*Session]struct{} // by user ID
+	changed  chan struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		sessions: make(map[string]map[*Session]struct{}),
+		changed:  make(chan struct{}, 1),
+	}
+}
+
+// Connect opens a session for userID. It must be closed with Disconnect.
+func (h *Hub) Connect(userID string) *Session {
+	session := &Session{
+		ID:     uuid.NewString(),
+		UserID: userID,
+		Events: make(chan models.StreamEvent, sessionBuffer),
+	}
+
+	h.mu.Lock()
+	if h.sessions[userID] == nil {
+		h.sessions[userID] = make(map[*Session]struct{})
+	}
+	h.sessions[userID][session] = struct{}{}
+	h.mu.Unlock()
+
+	h.notify()
+	return session
+}
+
+func (h *Hub) Disconnect(session *Session) {
+	h.mu.Lock()
+	delete(h.sessions[session.UserID], session)
+	if len(h.sessions[session.UserID]) == 0 {
+		delete(h.sessions, session.UserID)
+	}
+	h.mu.Unlock()
+
+	h.notify()
+}
+
+// Deliver hands event to every session of its user and returns how many
+// took it. Sessions whose client is not keeping up miss the event.
+func (h *Hub) Deliver(event models.StreamEvent) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	delivered := 0
+	for session := range h.sessions[event.UserID] {
+		select {
+		case session.Events <- event:
+			delivered++
+		default:
+			log.Printf("[Stream] session %s of user %s is behind, dropping %s", session.ID, session.UserID, event.ID)
+		}
+	}
+	return delivered
+}
+
+// Connected reports whether userID has a session on this instance.
+func (h *Hub) Connected(userID string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.sessions[userID]) > 0
+}
+
+// Users returns the users with a session on this instance.
+func (h *Hub) Users() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	users := make([]string, 0, len(h.sessions))
+	for user := range h.sessions {
+		users = append(users, user)
+	}
+	return users
+}
+
+// Changed signals when users connect or disconnect.
+func (h *Hub) Changed() <-chan struct{} {
+	return h.changed
+}
+
+func (h *Hub) notify() {
+	select {
+	case h.changed <- struct{}{}:
+	default:
+	}
+}
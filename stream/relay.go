@@ -0,0 +1,166 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"push_service/broker"
+	"push_service/models"
+
+	"github.com/google/uuid"
+)
+
+const (
+	kindHeader   = "x-stream-kind"
+	kindEvent    = "event"
+	kindPresence = "presence"
+
+	// PresenceInterval is how often each instance announces its connected
+	// users. Announcements older than three intervals are forgotten, so
+	// the users of a crashed instance count as offline.
+	PresenceInterval = 15 * time.Second
+)
+
+// presence is the body of an announcement.
+type presence struct {
+	Instance string   `json:"instance"`
+	Users    []string `json:"users"`
+}
+
+type announcement struct {
+	users []string
+	at    time.Time
+}
+
+// Relay fans events out to the hubs of every instance through a broker
+// fanout exchange, along with which users each instance has connected.
+type Relay struct {
+	Hub      *Hub
+	Broker   broker.Broker
+	Instance string
+
+	mu     sync.RWMutex
+	remote map[string]announcement // by instance
+}
+
+func NewRelay(hub *Hub, b broker.Broker, instance string) *Relay {
+	return &Relay{Hub: hub, Broker: b, Instance: instance, remote: make(map[string]announcement)}
+}
+
+// InstanceFromEnv names this instance's queue: STREAM_INSTANCE_ID, else the
+// host name with a random suffix so restarts get a fresh queue.
+func InstanceFromEnv() string {
+	if instance := os.Getenv("STREAM_INSTANCE_ID"); instance != "" {
+		return instance
+	}
+	host, _ := os.Hostname()
+	return host + "-" + uuid.NewString()[:8]
+}
+
+// Run delivers the events of every instance to the local hub and announces
+// the local users until the broker closes.
+func (r *Relay) Run(ctx context.Context) error {
+	deliveries, err := r.Broker.Consume(16)
+	if err != nil {
+		return fmt.Errorf("could not consume stream events: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go r.announce(ctx)
+
+	for d := range deliveries {
+		r.handle(d.Message)
+		d.Ack()
+	}
+	return nil
+}
+
+func (r *Relay) handle(msg broker.Message) {
+	switch msg.Headers[kindHeader] {
+	case kindEvent:
+		var event models.StreamEvent
+		if err := json.Unmarshal(msg.Body, &event); err != nil {
+			log.Printf("[Stream] could not decode event %s: %v", msg.ID, err)
+			return
+		}
+		r.Hub.Deliver(event)
+	case kindPresence:
+		var announced presence
+		if err := json.Unmarshal(msg.Body, &announced); err != nil {
+			log.Printf("[Stream] could not decode presence: %v", err)
+			return
+		}
+		if announced.Instance == r.Instance {
+			return
+		}
+		r.mu.Lock()
+		r.remote[announced.Instance] = announcement{users: announced.Users, at: time.Now()}
+		r.mu.Unlock()
+	}
+}
+
+// announce publishes the local users whenever they change and every
+// PresenceInterval.
+func (r *Relay) announce(ctx context.Context) {
+	ticker := time.NewTicker(PresenceInterval)
+	defer ticker.Stop()
+
+	for {
+		body, _ := json.Marshal(presence{Instance: r.Instance, Users: r.Hub.Users()})
+		if _, err := r.Broker.Publish(ctx, broker.Message{
+			ContentType: "application/json",
+			Body:        body,
+			Headers:     map[string]any{kindHeader: kindPresence},
+		}); err != nil {
+			log.Printf("[Stream] could not announce presence: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.Hub.Changed():
+		}
+	}
+}
+
+// Online reports whether userID has a stream open on any instance.
+func (r *Relay) Online(userID string) bool {
+	if r.Hub.Connected(userID) {
+		return true
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, announced := range r.remote {
+		if time.Since(announced.at) < 3*PresenceInterval && slices.Contains(announced.users, userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// Publish sends event to every instance, including this one.
+func (r *Relay) Publish(ctx context.Context, event models.StreamEvent) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("could not encode stream event: %w", err)
+	}
+	confirmation, err := r.Broker.Publish(ctx, broker.Message{
+		ID:          event.ID,
+		ContentType: "application/json",
+		Body:        body,
+		Headers:     map[string]any{kindHeader: kindEvent},
+	})
+	if err != nil {
+		return fmt.Errorf("could not publish stream event: %w", err)
+	}
+	return confirmation.Wait(ctx)
+}
@@ -0,0 +1,116 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+
+	"push_service/locale"
+	"push_service/models"
+	"push_service/upstream"
+)
+
+// Mode selects where templates come from.
+type Mode string
+
+const (
+	// ModeRemote uses only the template service.
+	ModeRemote Mode = "remote"
+	// ModeLocal uses only the local store.
+	ModeLocal Mode = "local"
+	// ModeReadThrough treats the template service as authoritative and
+	// snapshots what it returns. During an outage, and for templates it does
+	// not know, the latest local version is used instead.
+	ModeReadThrough Mode = "read_through"
+)
+
+// Resolver is the models.TemplateSource used by the workers.
+type Resolver struct {
+	Store *Store
+	Mode  Mode
+	// Upstream is the template service client.
+	Upstream models.Upstream
+}
+
+// NewResolverFromEnv reads the mode from TEMPLATE_SOURCE (default
+// read_through).
+func NewResolverFromEnv(store *Store, services models.Upstream) *Resolver {
+	mode := Mode(os.Getenv("TEMPLATE_SOURCE"))
+	switch mode {
+	case ModeRemote, ModeLocal, ModeReadThrough:
+	case "":
+		mode = ModeReadThrough
+	default:
+		log.Printf("Ignoring invalid TEMPLATE_SOURCE=%q", mode)
+		mode = ModeReadThrough
+	}
+	return &Resolver{Store: store, Mode: mode, Upstream: services}
+}
+
+// FetchTemplate returns the template to render, trying each locale in the
+// fallback chain of requested (pt-BR, pt, en). The template service only
+// knows the default locale, so other variants come from the local store
+// unless the mode is remote.
+func (r *Resolver) FetchTemplate(ctx context.Context, name, requested string, version int) (*models.TemplateResponse, error) {
+	if r.Mode != ModeRemote {
+		for _, candidate := range locale.Chain(requested) {
+			if candidate == DefaultLocale {
+				break
+			}
+			template, err := r.local(name, candidate, version)
+			if err == nil {
+				return template, nil
+			}
+			if !errors.Is(err, ErrTemplateNotFound) && !errors.Is(err, ErrVersionNotFound) {
+				return nil, err
+			}
+		}
+	}
+	return r.fetchDefault(ctx, name, version)
+}
+
+// fetchDefault returns the default locale. Pinned versions always come from
+// the local store, since the template service has no versions.
+func (r *Resolver) fetchDefault(ctx context.Context, name string, version int) (*models.TemplateResponse, error) {
+	if version > 0 || r.Mode == ModeLocal {
+		return r.local(name, DefaultLocale, version)
+	}
+
+	template, err := r.Upstream.FetchTemplate(ctx, name)
+	if r.Mode == ModeRemote {
+		if err == nil {
+			template.Locale = DefaultLocale
+		}
+		return template, err
+	}
+
+	if err == nil {
+		if err := r.Store.Remember(name, DefaultLocale, *template); err != nil {
+			log.Printf("Could not snapshot template %s: %v", name, err)
+		}
+		template.Locale = DefaultLocale
+		return template, nil
+	}
+
+	local, localErr := r.local(name, DefaultLocale, 0)
+	if localErr != nil {
+		return nil, err
+	}
+	if !errors.Is(err, upstream.ErrNotFound) {
+		log.Printf("Template service failed, using local version %d of %s: %v", local.Version, name, err)
+	}
+	return local, nil
+}
+
+func (r *Resolver) local(name, tag string, version int) (*models.TemplateResponse, error) {
+	v, err := r.Store.Get(name, tag, version)
+	if err != nil {
+		return nil, fmt.Errorf("local template store: %w", err)
+	}
+	template := v.Template
+	template.Version = v.Version
+	template.Locale = v.Locale
+	return &template, nil
+}
@@ -0,0 +1,273 @@
+package templates
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"push_service/locale"
+	"push_service/models"
+	"push_service/util"
+)
+
+const DefaultLocale = locale.Default
+
+// Source records where a version came from.
+type Source string
+
+const (
+	SourceLocal  Source = "local"
+	SourceRemote Source = "remote" // snapshot of the template service
+)
+
+var (
+	ErrTemplateNotFound = errors.New("template not found")
+	ErrVersionNotFound  = errors.New("template version not found")
+)
+
+// Version is one immutable revision of a template in one locale.
+type Version struct {
+	Name      string                  `json:"name"`
+	Locale    string                  `json:"locale"`
+	Version   int                     `json:"version"`
+	Source    Source                  `json:"source"`
+	Template  models.TemplateResponse `json:"template"`
+	CreatedAt time.Time               `json:"created_at"`
+}
+
+// Summary describes the latest version of a template in one locale.
+type Summary struct {
+	Name      string    `json:"name"`
+	Locale    string    `json:"locale"`
+	Latest    int       `json:"latest_version"`
+	Source    Source    `json:"source"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Store keeps every version of every template. When path is set the
+// versions are persisted to that JSON file on every change.
+type Store struct {
+	mu       sync.RWMutex
+	path     string
+	versions map[string][]Version // by key(name, locale), oldest first
+}
+
+// NewStoreFromEnv loads templates from TEMPLATE_STORE_FILE.
+func NewStoreFromEnv() (*Store, error) {
+	return NewStore(os.Getenv("TEMPLATE_STORE_FILE"))
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, versions: make(map[string][]Version)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func key(name, locale string) string {
+	return name + "\x00" + normalizeLocale(locale)
+}
+
+func normalizeLocale(tag string) string {
+	if tag == "" {
+		return DefaultLocale
+	}
+	return locale.Normalize(tag)
+}
+
+// Create stores template as the next version of name in locale.
+func (s *Store) Create(name, locale string, template models.TemplateResponse) (Version, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(name, locale)
+	previous := s.versions[k]
+	version := s.appendLocked(name, locale, SourceLocal, template)
+	if err := s.save(); err != nil {
+		s.restoreLocked(k, previous)
+		return Version{}, err
+	}
+	return version, nil
+}
+
+// Remember snapshots a template fetched from the template service so it can
+// be served during an outage. Unchanged templates are not stored again.
+func (s *Store) Remember(name, locale string, template models.TemplateResponse) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(name, locale)
+	existing := s.versions[k]
+	template.Name = name
+	if len(existing) > 0 && reflect.DeepEqual(existing[len(existing)-1].Template, template) {
+		return nil
+	}
+	s.appendLocked(name, locale, SourceRemote, template)
+	if err := s.save(); err != nil {
+		s.restoreLocked(k, existing)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) appendLocked(name, locale string, source Source, template models.TemplateResponse) Version {
+	k := key(name, locale)
+	existing := s.versions[k]
+
+	version := Version{
+		Name:      name,
+		Locale:    normalizeLocale(locale),
+		Version:   1,
+		Source:    source,
+		Template:  template,
+		CreatedAt: time.Now(),
+	}
+	if len(existing) > 0 {
+		version.Version = existing[len(existing)-1].Version + 1
+	}
+	version.Template.Name = name
+	version.Template.Version = 0
+	version.Template.Locale = ""
+	s.versions[k] = append(existing, version)
+	return version
+}
+
+// restoreLocked puts back the versions of k as they were before a change
+// that could not be saved, so memory never gets ahead of the file.
+func (s *Store) restoreLocked(k string, versions []Version) {
+	if len(versions) == 0 {
+		delete(s.versions, k)
+		return
+	}
+	s.versions[k] = versions
+}
+
+// Get returns version of name in locale; version 0 is the latest.
+func (s *Store) Get(name, locale string, version int) (Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	existing := s.versions[key(name, locale)]
+	if len(existing) == 0 {
+		return Version{}, fmt.Errorf("%w: %s (%s)", ErrTemplateNotFound, name, normalizeLocale(locale))
+	}
+	if version == 0 {
+		return existing[len(existing)-1], nil
+	}
+	for _, v := range existing {
+		if v.Version == version {
+			return v, nil
+		}
+	}
+	return Version{}, fmt.Errorf("%w: %s (%s) v%d", ErrVersionNotFound, name, normalizeLocale(locale), version)
+}
+
+// Versions returns every version of name in locale, oldest first.
+func (s *Store) Versions(name, locale string) ([]Version, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	existing := s.versions[key(name, locale)]
+	if len(existing) == 0 {
+		return nil, fmt.Errorf("%w: %s (%s)", ErrTemplateNotFound, name, normalizeLocale(locale))
+	}
+	return append([]Version(nil), existing...), nil
+}
+
+// List summarises every template and locale.
+func (s *Store) List() []Summary {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	summaries := make([]Summary, 0, len(s.versions))
+	for _, existing := range s.versions {
+		latest := existing[len(existing)-1]
+		summaries = append(summaries, Summary{
+			Name:      latest.Name,
+			Locale:    latest.Locale,
+			Latest:    latest.Version,
+			Source:    latest.Source,
+			UpdatedAt: latest.CreatedAt,
+		})
+	}
+	slices.SortFunc(summaries, func(a, b Summary) int {
+		if c := strings.Compare(a.Name, b.Name); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Locale, b.Locale)
+	})
+	return summaries
+}
+
+// Delete removes every version of name in locale.
+func (s *Store) Delete(name, locale string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	k := key(name, locale)
+	existing, ok := s.versions[k]
+	if !ok {
+		return fmt.Errorf("%w: %s (%s)", ErrTemplateNotFound, name, normalizeLocale(locale))
+	}
+	delete(s.versions, k)
+	if err := s.save(); err != nil {
+		s.restoreLocked(k, existing)
+		return err
+	}
+	return nil
+}
+
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read template store file: %w", err)
+	}
+
+	var versions []Version
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return fmt.Errorf("failed to parse template store file: %w", err)
+	}
+	for _, v := range versions {
+		k := key(v.Name, v.Locale)
+		s.versions[k] = append(s.versions[k], v)
+	}
+	for _, existing := range s.versions {
+		slices.SortFunc(existing, func(a, b Version) int { return a.Version - b.Version })
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	var versions []Version
+	for _, existing := range s.versions {
+		versions = append(versions, existing...)
+	}
+
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode templates: %w", err)
+	}
+	if err := util.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write template store file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+package templates
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"push_service/models"
+	"push_service/upstream"
+)
+
+func TestStoreKeepsVersionsAcrossRestarts(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, body := range []string{"Hello", "Hi"} {
+		if _, err := store.Create("welcome", "", models.TemplateResponse{Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest, err := reopened.Get("welcome", "en", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latest.Version != 2 || latest.Template.Body != "Hi" {
+		t.Fatalf("latest is v%d %q, want v2 \"Hi\"", latest.Version, latest.Template.Body)
+	}
+	first, err := reopened.Get("welcome", "en", 1)
+	if err != nil || first.Template.Body != "Hello" {
+		t.Fatalf("v1 is %q, %v", first.Template.Body, err)
+	}
+	if _, err := reopened.Get("welcome", "en", 3); !errors.Is(err, ErrVersionNotFound) {
+		t.Fatalf("v3 returned %v", err)
+	}
+}
+
+func TestStoreRollsBackChangesThatCouldNotBeSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "templates.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Create("welcome", "", models.TemplateResponse{Body: "Hello"}); err != nil {
+		t.Fatal(err)
+	}
+	// A directory where the file is renamed to makes every save fail.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := store.Create("welcome", "", models.TemplateResponse{Body: "Hi"}); err == nil {
+		t.Fatal("Create succeeded without saving")
+	}
+	if err := store.Remember("welcome", "", models.TemplateResponse{Body: "Hey"}); err == nil {
+		t.Fatal("Remember succeeded without saving")
+	}
+	if latest, _ := store.Get("welcome", "", 0); latest.Version != 1 {
+		t.Fatalf("unsaved versions are kept: latest is v%d", latest.Version)
+	}
+
+	if _, err := store.Create("goodbye", "", models.TemplateResponse{Body: "Bye"}); err == nil {
+		t.Fatal("Create succeeded without saving")
+	}
+	if _, err := store.Get("goodbye", "", 0); !errors.Is(err, ErrTemplateNotFound) {
+		t.Fatalf("unsaved template is kept: %v", err)
+	}
+
+	if err := store.Delete("welcome", ""); err == nil {
+		t.Fatal("Delete succeeded without saving")
+	}
+	if _, err := store.Get("welcome", "", 0); err != nil {
+		t.Fatalf("unsaved delete removed the template: %v", err)
+	}
+}
+
+// templateService serves one template, or fails every lookup when down.
+type templateService struct {
+	template models.TemplateResponse
+	down     bool
+}
+
+func (s *templateService) FetchUser(context.Context, string) (*models.User, error) {
+	return nil, upstream.ErrNotFound
+}
+
+func (s *templateService) FetchTemplate(_ context.Context, name string) (*models.TemplateResponse, error) {
+	if s.down {
+		return nil, fmt.Errorf("template service unavailable")
+	}
+	if name != s.template.Name {
+		return nil, fmt.Errorf("%w: %s", upstream.ErrNotFound, name)
+	}
+	template := s.template
+	return &template, nil
+}
+
+func (s *templateService) Health() []models.UpstreamHealth { return nil }
+
+func TestReadThroughServesTheSnapshotDuringAnOutage(t *testing.T) {
+	store, _ := NewStore("")
+	service := &templateService{template: models.TemplateResponse{Name: "welcome", Body: "Hello"}}
+	resolver := &Resolver{Store: store, Mode: ModeReadThrough, Upstream: service}
+
+	if _, err := resolver.FetchTemplate(context.Background(), "welcome", "", 0); err != nil {
+		t.Fatal(err)
+	}
+	service.down = true
+	template, err := resolver.FetchTemplate(context.Background(), "welcome", "", 0)
+	if err != nil {
+		t.Fatalf("outage was not covered by the snapshot: %v", err)
+	}
+	if template.Body != "Hello" || template.Version != 1 {
+		t.Fatalf("served v%d %q, want the v1 snapshot", template.Version, template.Body)
+	}
+
+	if _, err := resolver.FetchTemplate(context.Background(), "unknown", "", 0); err == nil {
+		t.Fatal("unknown template was served during an outage")
+	}
+}
@@ -0,0 +1,59 @@
+package truncate
+
+import (
+	"log"
+	"os"
+	"strconv"
+)
+
+// Limit is the number of characters a platform shows for a notification
+// title and body. Zero means no limit.
+type Limit struct {
+	Title int
+	Body  int
+}
+
+// Limits are the per-platform limits applied when rendering.
+type Limits struct {
+	Android Limit
+	APNS    Limit
+	Webpush Limit
+}
+
+// DefaultLimits roughly match what each platform shows on the lock screen
+// before cutting text off itself.
+func DefaultLimits() Limits {
+	return Limits{
+		Android: Limit{Title: 65, Body: 240},
+		APNS:    Limit{Title: 50, Body: 178},
+		Webpush: Limit{Title: 50, Body: 120},
+	}
+}
+
+// LimitsFromEnv overrides the defaults with CONTENT_LIMIT_<PLATFORM>_<FIELD>,
+// e.g. CONTENT_LIMIT_APNS_BODY=150. Set a limit to 0 to disable it.
+func LimitsFromEnv() Limits {
+	limits := DefaultLimits()
+	for prefix, limit := range map[string]*Limit{
+		"CONTENT_LIMIT_ANDROID": &limits.Android,
+		"CONTENT_LIMIT_APNS":    &limits.APNS,
+		"CONTENT_LIMIT_WEBPUSH": &limits.Webpush,
+	} {
+		envLimit(prefix+"_TITLE", &limit.Title)
+		envLimit(prefix+"_BODY", &limit.Body)
+	}
+	return limits
+}
+
+func envLimit(name string, target *int) {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("Ignoring invalid %s=%q", name, raw)
+		return
+	}
+	*target = value
+}
@@ -0,0 +1,118 @@
+package truncate
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Ellipsis marks text that was cut short.
+const Ellipsis = "…"
+
+// Text shortens s to at most max characters, counting grapheme clusters so
+// accents, flags and emoji sequences are never split. It prefers to cut at
+// a word boundary and ends the result with Ellipsis. A max of 0 or less
+// means no limit.
+func Text(s string, max int) (string, bool) {
+	if max <= 0 {
+		return s, false
+	}
+	return cut(s, func(graphemes, _ int) bool { return graphemes <= max })
+}
+
+// Bytes is Text for a limit in UTF-8 bytes, as used by payload size limits.
+func Bytes(s string, max int) (string, bool) {
+	if max <= 0 {
+		return s, false
+	}
+	return cut(s, func(_, bytes int) bool { return bytes <= max })
+}
+
+func cut(s string, fits func(graphemes, bytes int) bool) (string, bool) {
+	clusters := Graphemes(s)
+	if fits(len(clusters), len(s)) {
+		return s, false
+	}
+
+	ellipsis := len(Ellipsis)
+	n, size := 0, 0
+	for n < len(clusters) && fits(n+2, size+len(clusters[n])+ellipsis) {
+		size += len(clusters[n])
+		n++
+	}
+	if !fits(1, ellipsis) {
+		return "", true
+	}
+
+	// Back up to the last space, unless that would lose more than half of
+	// what fits.
+	for i := n; i > n/2; i-- {
+		if isSpace(clusters[i]) {
+			n = i
+			break
+		}
+	}
+
+	kept := strings.TrimRightFunc(strings.Join(clusters[:n], ""), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsPunct(r)
+	})
+	return kept + Ellipsis, true
+}
+
+func isSpace(cluster string) bool {
+	r, _ := utf8.DecodeRuneInString(cluster)
+	return unicode.IsSpace(r)
+}
+
+// Graphemes splits s into user-perceived characters. It covers the cases
+// that come up in notification text: combining marks, variation selectors,
+// emoji modifiers and ZWJ sequences, regional indicator flags and CRLF.
+func Graphemes(s string) []string {
+	var clusters []string
+	start := 0
+	var prev rune = -1
+	regional := 0
+	for i, r := range s {
+		if prev >= 0 && !extends(prev, r, regional) {
+			clusters = append(clusters, s[start:i])
+			start = i
+			regional = 0
+		}
+		if isRegionalIndicator(r) {
+			regional++
+		}
+		prev = r
+	}
+	if start < len(s) {
+		clusters = append(clusters, s[start:])
+	}
+	return clusters
+}
+
+// extends reports whether r belongs to the same cluster as prev.
+func extends(prev, r rune, regional int) bool {
+	switch {
+	case prev == '\r' && r == '\n':
+		return true
+	case prev == zwj:
+		return true
+	case r == zwj, isExtend(r):
+		return true
+	case isRegionalIndicator(prev) && isRegionalIndicator(r):
+		return regional%2 == 1
+	}
+	return false
+}
+
+const zwj = '\u200d' // zero width joiner
+
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Mc) ||
+		(r >= 0xfe00 && r <= 0xfe0f) || // variation selectors
+		(r >= 0x1f3fb && r <= 0x1f3ff) || // emoji skin tone modifiers
+		(r >= 0xe0020 && r <= 0xe007f) // emoji tag sequences
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
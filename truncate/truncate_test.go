@@ -0,0 +1,61 @@
+package truncate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestText(t *testing.T) {
+	tests := []struct {
+		in   string
+		max  int
+		want string
+		cut  bool
+	}{
+		{"short", 10, "short", false},
+		{"unlimited text", 0, "unlimited text", false},
+		{"exactly ten", 11, "exactly ten", false},
+		{"Your order has shipped", 16, "Your order has…", true},
+		{"Supercalifragilistic", 8, "Superca…", true},
+		{"Hello, world", 8, "Hello…", true},
+		{"cafe\u0301 au lait", 5, "cafe\u0301…", true},
+		{"🇧🇷🇵🇹🇺🇸 flags", 3, "🇧🇷🇵🇹…", true},
+		{"👩‍👩‍👧‍👦 family", 2, "👩‍👩‍👧‍👦…", true},
+		{"abc", 1, "…", true},
+	}
+	for _, tt := range tests {
+		got, cut := Text(tt.in, tt.max)
+		if got != tt.want || cut != tt.cut {
+			t.Errorf("Text(%q, %d) = %q, %v, want %q, %v", tt.in, tt.max, got, cut, tt.want, tt.cut)
+		}
+	}
+}
+
+func TestBytes(t *testing.T) {
+	// Each é is two bytes, so only two fit beside the three byte ellipsis.
+	got, cut := Bytes("ééééé", 8)
+	if got != "éé…" || !cut {
+		t.Fatalf("Bytes = %q, %v", got, cut)
+	}
+	if got, cut := Bytes("abc", 2); got != "" || !cut {
+		t.Fatalf("Bytes below the ellipsis size = %q, %v", got, cut)
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	tests := map[string][]string{
+		"abc":      {"a", "b", "c"},
+		"e\u0301x": {"e\u0301", "x"},
+		"\r\n!":    {"\r\n", "!"},
+		"🇧🇷🇵🇹":     {"🇧🇷", "🇵🇹"},
+		"👍🏽!":      {"👍🏽", "!"},
+		"❤️":       {"❤️"},
+		"👩‍💻x":     {"👩‍💻", "x"},
+		"🏴\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F": {"🏴\U000E0067\U000E0062\U000E0065\U000E006E\U000E0067\U000E007F"},
+	}
+	for in, want := range tests {
+		if got := Graphemes(in); !reflect.DeepEqual(got, want) {
+			t.Errorf("Graphemes(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
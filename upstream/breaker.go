@@ -0,0 +1,104 @@
+package upstream
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// BreakerState is the state of a circuit breaker.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// ErrCircuitOpen is returned without calling the upstream while its breaker
+// is open.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// Breaker opens after threshold consecutive failures and rejects calls for
+// cooldown. It then lets a single probe through: success closes it again,
+// failure re-opens it.
+type Breaker struct {
+	mu        sync.Mutex
+	state     BreakerState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+}
+
+func NewBreaker(threshold int, cooldown time.Duration) *Breaker {
+	return &Breaker{state: BreakerClosed, threshold: threshold, cooldown: cooldown}
+}
+
+// Allow reports whether a call may go ahead. Every allowed call must be
+// followed by Record or Release.
+func (b *Breaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.cooldown {
+			return ErrCircuitOpen
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return nil
+	case BreakerHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+		return nil
+	default:
+		return nil
+	}
+}
+
+// Record reports the outcome of an allowed call.
+func (b *Breaker) Record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.probing = false
+	if success {
+		b.state = BreakerClosed
+		b.failures = 0
+		return
+	}
+
+	b.failures++
+	switch {
+	case b.state == BreakerOpen:
+		// A call allowed before the breaker opened. Its cooldown has
+		// already started and is not extended.
+	case b.state == BreakerHalfOpen || b.failures >= b.threshold:
+		b.state = BreakerOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release reports an allowed call that its caller gave up on. It counts
+// neither way, since the upstream had no say in it.
+func (b *Breaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// State returns the current state and consecutive failure count.
+func (b *Breaker) State() (BreakerState, int, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.cooldown {
+		return BreakerHalfOpen, b.failures, b.openedAt
+	}
+	return b.state, b.failures, b.openedAt
+}
@@ -0,0 +1,88 @@
+package upstream
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestBreaker(t *testing.T) {
+	b := NewBreaker(2, 20*time.Millisecond)
+
+	fail := func() {
+		t.Helper()
+		if err := b.Allow(); err != nil {
+			t.Fatalf("Allow = %v", err)
+		}
+		b.Record(false)
+	}
+	assertState := func(want BreakerState) {
+		t.Helper()
+		if state, _, _ := b.State(); state != want {
+			t.Fatalf("state is %s, want %s", state, want)
+		}
+	}
+
+	fail()
+	assertState(BreakerClosed)
+	fail()
+	assertState(BreakerOpen)
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open breaker allowed a call: %v", err)
+	}
+
+	// After the cooldown a single probe goes through, and failing it
+	// re-opens the breaker straight away.
+	time.Sleep(25 * time.Millisecond)
+	assertState(BreakerHalfOpen)
+	fail()
+	assertState(BreakerOpen)
+
+	time.Sleep(25 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe was rejected: %v", err)
+	}
+	if err := b.Allow(); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("second call during the probe was allowed: %v", err)
+	}
+
+	// A released probe frees the slot without counting either way.
+	b.Release()
+	assertState(BreakerHalfOpen)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("probe after Release was rejected: %v", err)
+	}
+	b.Record(true)
+	assertState(BreakerClosed)
+	if _, failures, _ := b.State(); failures != 0 {
+		t.Fatalf("success left %d failures", failures)
+	}
+
+	// Successes in between reset the count.
+	fail()
+	b.Allow()
+	b.Record(true)
+	fail()
+	assertState(BreakerClosed)
+}
+
+func TestBreakerIgnoresLateFailuresWhileOpen(t *testing.T) {
+	b := NewBreaker(1, 30*time.Millisecond)
+
+	// Two calls are in flight when the first failure opens the breaker.
+	b.Allow()
+	b.Allow()
+	b.Record(false)
+	_, _, openedAt := b.State()
+
+	time.Sleep(20 * time.Millisecond)
+	b.Record(false)
+	if _, _, late := b.State(); !late.Equal(openedAt) {
+		t.Fatalf("late failure moved the cooldown from %v to %v", openedAt, late)
+	}
+
+	time.Sleep(15 * time.Millisecond)
+	if err := b.Allow(); err != nil {
+		t.Fatalf("breaker still open after its cooldown: %v", err)
+	}
+}
@@ -0,0 +1,83 @@
+package upstream
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// cache is an LRU cache whose entries also expire after a TTL. Expired
+// entries are still returned, flagged stale, so callers can revalidate them.
+type cache[V any] struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List
+	size    int
+	ttl     time.Duration
+}
+
+type cacheEntry[V any] struct {
+	key     string
+	value   V
+	etag    string
+	expires time.Time
+}
+
+func newCache[V any](size int, ttl time.Duration) *cache[V] {
+	return &cache[V]{entries: make(map[string]*list.Element), order: list.New(), size: size, ttl: ttl}
+}
+
+// get returns the entry for key and whether it is still fresh.
+func (c *cache[V]) get(key string) (entry cacheEntry[V], fresh, ok bool) {
+	if c == nil || c.size <= 0 {
+		return entry, false, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return entry, false, false
+	}
+	c.order.MoveToFront(element)
+	entry = *element.Value.(*cacheEntry[V])
+	return entry, time.Now().Before(entry.expires), true
+}
+
+func (c *cache[V]) put(key string, value V, etag string) {
+	if c == nil || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	entry := &cacheEntry[V]{key: key, value: value, etag: etag, expires: time.Now().Add(c.ttl)}
+	if element, ok := c.entries[key]; ok {
+		element.Value = entry
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(entry)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry[V]).key)
+	}
+}
+
+// touch extends a revalidated entry by another TTL.
+func (c *cache[V]) touch(key string) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if element, ok := c.entries[key]; ok {
+		element.Value.(*cacheEntry[V]).expires = time.Now().Add(c.ttl)
+	}
+}
@@ -0,0 +1,220 @@
+package upstream
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"push_service/models"
+)
+
+// Config tunes the upstream clients.
+type Config struct {
+	UserServiceURL     string
+	TemplateServiceURL string
+
+	Timeout          time.Duration
+	Retries          int
+	BreakerThreshold int
+	BreakerCooldown  time.Duration
+
+	TemplateCacheSize int
+	TemplateCacheTTL  time.Duration
+	UserCacheSize     int
+	UserCacheTTL      time.Duration
+}
+
+// ConfigFromEnv reads USER_SERVICE_URL, TEMPLATE_SERVICE_URL,
+// UPSTREAM_TIMEOUT, UPSTREAM_RETRIES, UPSTREAM_BREAKER_THRESHOLD,
+// UPSTREAM_BREAKER_COOLDOWN, TEMPLATE_CACHE_SIZE, TEMPLATE_CACHE_TTL,
+// USER_CACHE_SIZE and USER_CACHE_TTL. A cache size of 0 disables that cache.
+func ConfigFromEnv() Config {
+	return Config{
+		UserServiceURL:     serviceURL("USER_SERVICE_URL", DefaultUserServiceURL),
+		TemplateServiceURL: serviceURL("TEMPLATE_SERVICE_URL", DefaultTemplateServiceURL),
+
+		Timeout:           envDuration("UPSTREAM_TIMEOUT", 5*time.Second),
+		Retries:           envInt("UPSTREAM_RETRIES", 2),
+		BreakerThreshold:  envInt("UPSTREAM_BREAKER_THRESHOLD", 5),
+		BreakerCooldown:   envDuration("UPSTREAM_BREAKER_COOLDOWN", 30*time.Second),
+		TemplateCacheSize: envInt("TEMPLATE_CACHE_SIZE", 256),
+		TemplateCacheTTL:  envDuration("TEMPLATE_CACHE_TTL", 5*time.Minute),
+		UserCacheSize:     envInt("USER_CACHE_SIZE", 10000),
+		UserCacheTTL:      envDuration("USER_CACHE_TTL", 30*time.Second),
+	}
+}
+
+// retryBaseDelay is the first back-off; each retry doubles it, with full
+// jitter.
+const retryBaseDelay = 100 * time.Millisecond
+
+// client calls one upstream service through a shared connection pool and
+// its own circuit breaker.
+type client struct {
+	name    string
+	baseURL string
+	http    *http.Client
+	breaker *Breaker
+	retries int
+}
+
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+}
+
+// get calls path on the service, retrying network errors, 429s and 5xx
+// responses until ctx is done. Other responses are returned for the caller
+// to interpret.
+func (c *client) get(ctx context.Context, path string, header http.Header) (*response, error) {
+	baseURL := c.baseURL
+
+	for attempt := 0; ; attempt++ {
+		if err := c.breaker.Allow(); err != nil {
+			return nil, fmt.Errorf("%s service [%s]: %w", c.name, baseURL, err)
+		}
+
+		resp, err := c.do(ctx, baseURL+path, header)
+		if ctx.Err() != nil {
+			c.breaker.Release()
+			return nil, fmt.Errorf("%s service [%s]: %w", c.name, baseURL, context.Cause(ctx))
+		}
+		failed := err != nil || resp.status == http.StatusTooManyRequests || resp.status >= 500
+		c.breaker.Record(!failed)
+		if !failed {
+			return resp, nil
+		}
+
+		if err == nil {
+			err = fmt.Errorf("%s service responded with status %d: %s", c.name, resp.status, string(resp.body))
+		}
+		if attempt >= c.retries {
+			return nil, err
+		}
+
+		backoff := retryBaseDelay << attempt
+		delay := rand.N(backoff)
+		log.Printf("%s service call failed (attempt %d), retrying in %s: %v", c.name, attempt+1, delay, err)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%s service [%s]: %w", c.name, baseURL, context.Cause(ctx))
+		}
+	}
+}
+
+func (c *client) do(ctx context.Context, url string, header http.Header) (*response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not make new request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch data from %s service [%s]: %w", c.name, url, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+	return &response{status: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// Services calls the user and template services through a shared
+// connection pool. Each service has its own circuit breaker and cache.
+type Services struct {
+	users         *client
+	templates     *client
+	userCache     *cache[models.User]
+	templateCache *cache[models.TemplateResponse]
+}
+
+func NewServicesFromEnv() *Services {
+	return NewServices(ConfigFromEnv())
+}
+
+func NewServices(config Config) *Services {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.MaxIdleConnsPerHost = 32
+	shared := &http.Client{Timeout: config.Timeout, Transport: transport}
+
+	return &Services{
+		users: &client{
+			name:    "user",
+			baseURL: strings.TrimSuffix(config.UserServiceURL, "/"),
+			http:    shared,
+			breaker: NewBreaker(config.BreakerThreshold, config.BreakerCooldown),
+			retries: config.Retries,
+		},
+		templates: &client{
+			name:    "template",
+			baseURL: strings.TrimSuffix(config.TemplateServiceURL, "/"),
+			http:    shared,
+			breaker: NewBreaker(config.BreakerThreshold, config.BreakerCooldown),
+			retries: config.Retries,
+		},
+		userCache:     newCache[models.User](config.UserCacheSize, config.UserCacheTTL),
+		templateCache: newCache[models.TemplateResponse](config.TemplateCacheSize, config.TemplateCacheTTL),
+	}
+}
+
+// Health reports the circuit breaker of each upstream service.
+func (s *Services) Health() []models.UpstreamHealth {
+	var health []models.UpstreamHealth
+	for _, c := range []*client{s.users, s.templates} {
+		state, failures, openedAt := c.breaker.State()
+		entry := models.UpstreamHealth{Name: c.name, State: string(state), Failures: failures}
+		if state != BreakerClosed {
+			entry.OpenedAt = &openedAt
+		}
+		health = append(health, entry)
+	}
+	return health
+}
+
+// serviceURL returns the base URL in the env variable name, or fallback.
+func serviceURL(name, fallback string) string {
+	if value := os.Getenv(name); value != "" {
+		return strings.TrimSuffix(value, "/")
+	}
+	return fallback
+}
+
+func envInt(name string, fallback int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value < 0 {
+		log.Printf("Ignoring invalid %s=%q", name, raw)
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := time.ParseDuration(raw)
+	if err != nil || value <= 0 {
+		log.Printf("Ignoring invalid %s=%q", name, raw)
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,96 @@
+package upstream
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+
+	"push_service/models"
+)
+
+// ErrNotFound is returned when a service answers 404.
+var ErrNotFound = errors.New("not found")
+
+const (
+	DefaultUserServiceURL     = "https://user-service-yci9.onrender.com/api"
+	DefaultTemplateServiceURL = "https://template-service-mza0.onrender.com"
+)
+
+// FetchUser looks the user up in the user service at USER_SERVICE_URL.
+// Users are cached briefly so bursts for the same user make one call.
+func (s *Services) FetchUser(ctx context.Context, userId string) (*models.User, error) {
+	if entry, fresh, ok := s.userCache.get(userId); ok && fresh {
+		user := entry.value
+		return &user, nil
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", fmt.Sprintf("Bearer %s", os.Getenv("LOGIN_USER_TOKEN")))
+
+	resp, err := s.users.get(ctx, "/v1/users/"+url.PathEscape(userId), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: user service responded with status %d: %s", ErrNotFound, resp.status, string(resp.body))
+	}
+	if resp.status != http.StatusOK {
+		return nil, fmt.Errorf("user service responded with status %d: %s", resp.status, string(resp.body))
+	}
+
+	var user models.UserResponses
+	err = json.Unmarshal(resp.body, &user)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse user JSON: %w", err)
+	}
+
+	s.userCache.put(userId, user.Data, "")
+	return &user.Data, nil
+}
+
+// FetchTemplate looks the template up in the template service at
+// TEMPLATE_SERVICE_URL. Cached templates are revalidated with their ETag
+// once they expire.
+func (s *Services) FetchTemplate(ctx context.Context, template_name string) (*models.TemplateResponse, error) {
+	entry, fresh, cached := s.templateCache.get(template_name)
+	if cached && fresh {
+		template := entry.value
+		return &template, nil
+	}
+
+	header := http.Header{}
+	if cached && entry.etag != "" {
+		header.Set("If-None-Match", entry.etag)
+	}
+
+	resp, err := s.templates.get(ctx, "/templates/name/"+url.PathEscape(template_name), header)
+	if err != nil {
+		return nil, err
+	}
+	if resp.status == http.StatusNotModified && cached {
+		s.templateCache.touch(template_name)
+		template := entry.value
+		return &template, nil
+	}
+	if resp.status == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: template service responded with status %d: %s", ErrNotFound, resp.status, string(resp.body))
+	}
+	if resp.status != http.StatusOK {
+		return nil, fmt.Errorf("template service responded with status %d: %s", resp.status, string(resp.body))
+	}
+
+	var template models.TemplateResponse
+	err = json.Unmarshal(resp.body, &template)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse template JSON: %w", err)
+	}
+
+	log.Printf("template was retrieved successfully")
+	s.templateCache.put(template_name, template, resp.header.Get("ETag"))
+	return &template, nil
+}
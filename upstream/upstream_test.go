@@ -0,0 +1,112 @@
+package upstream
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newServices(t *testing.T, handler http.HandlerFunc, config Config) *Services {
+	t.Helper()
+	server := httptest.NewServer(handler)
+	t.Cleanup(server.Close)
+	config.UserServiceURL, config.TemplateServiceURL = server.URL+"/api", server.URL+"/"
+	if config.Timeout == 0 {
+		config.Timeout = time.Second
+	}
+	if config.BreakerThreshold == 0 {
+		config.BreakerThreshold = 5
+		config.BreakerCooldown = time.Minute
+	}
+	return NewServices(config)
+}
+
+func TestFetchUserCachesAndRetries(t *testing.T) {
+	var calls atomic.Int32
+	services := newServices(t, func(w http.ResponseWriter, r *http.Request) {
+		switch n := calls.Add(1); {
+		case r.URL.Path == "/api/v1/users/missing":
+			http.NotFound(w, r)
+		case n == 1:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			fmt.Fprint(w, `{"success":true,"data":{"name":"Ada","push_token":"token-1"}}`)
+		}
+	}, Config{Retries: 1, UserCacheSize: 10, UserCacheTTL: time.Minute})
+
+	for range 2 {
+		user, err := services.FetchUser(context.Background(), "u-1")
+		if err != nil || user.Name != "Ada" {
+			t.Fatalf("FetchUser = %+v, %v", user, err)
+		}
+	}
+	if n := calls.Load(); n != 2 {
+		t.Fatalf("made %d calls, want a retry and then the cache", n)
+	}
+
+	if _, err := services.FetchUser(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("unknown user: %v", err)
+	}
+}
+
+func TestFetchTemplateRevalidatesWithETag(t *testing.T) {
+	var revalidated atomic.Bool
+	services := newServices(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			revalidated.Store(true)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"name":"welcome","body":"Hi"}`)
+	}, Config{TemplateCacheSize: 10, TemplateCacheTTL: time.Nanosecond})
+
+	for range 2 {
+		template, err := services.FetchTemplate(context.Background(), "welcome")
+		if err != nil || template.Body != "Hi" {
+			t.Fatalf("FetchTemplate = %+v, %v", template, err)
+		}
+	}
+	if !revalidated.Load() {
+		t.Fatal("expired template was fetched again instead of revalidated")
+	}
+}
+
+func TestServicesHaveTheirOwnBreakers(t *testing.T) {
+	services := newServices(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}, Config{BreakerThreshold: 2, BreakerCooldown: time.Minute})
+
+	for range 2 {
+		services.FetchUser(context.Background(), "u-1")
+	}
+	if _, err := services.FetchUser(context.Background(), "u-1"); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("third call after two failures: %v", err)
+	}
+
+	health := services.Health()
+	if len(health) != 2 || health[0].State != string(BreakerOpen) || health[1].State != string(BreakerClosed) {
+		t.Fatalf("Health = %+v", health)
+	}
+}
+
+func TestFetchUserGivesUpWhenTheContextIsDone(t *testing.T) {
+	services := newServices(t, func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, Config{})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := services.FetchUser(ctx, "u-1"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("FetchUser = %v, want the deadline", err)
+	}
+	// Giving up is not the service's fault.
+	if _, failures, _ := services.users.breaker.State(); failures != 0 {
+		t.Fatalf("breaker counted %d failures", failures)
+	}
+}
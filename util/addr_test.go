@@ -0,0 +1,46 @@
+package util
+
+import (
+	"errors"
+	"net/netip"
+	"testing"
+)
+
+func TestPublicAddr(t *testing.T) {
+	for addr, want := range map[string]bool{
+		"93.184.216.34":        true,
+		"2606:2800:220:1::1":   true,
+		"10.1.2.3":             false,
+		"100.64.0.1":           false,
+		"127.0.0.1":            false,
+		"169.254.169.254":      false,
+		"192.0.0.8":            false,
+		"192.0.2.10":           false,
+		"198.18.0.1":           false,
+		"198.51.100.7":         false,
+		"203.0.113.9":          false,
+		"224.0.0.1":            false,
+		"255.255.255.255":      false,
+		"::1":                  false,
+		"::ffff:10.0.0.1":      false,
+		"::ffff:93.184.216.34": true,
+		"64:ff9b::a00:1":       false,
+		"2001:db8::1":          false,
+		"2002:a00:1::1":        false,
+		"fd00::1":              false,
+		"fe80::1":              false,
+	} {
+		if got := PublicAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("PublicAddr(%s) = %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestDialPublic(t *testing.T) {
+	if err := DialPublic("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("public address refused: %v", err)
+	}
+	if err := DialPublic("tcp", "[64:ff9b::7f00:1]:443", nil); !errors.Is(err, ErrNotPublic) {
+		t.Errorf("NAT64 address: %v", err)
+	}
+}
@@ -0,0 +1,120 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"push_service/models"
+	sendNotification "push_service/sendNotification"
+)
+
+// maxResponseBody is how much of a failed response is kept for the error.
+const maxResponseBody = 512
+
+// Channel delivers notifications to the endpoint of the subscriber named in
+// the request as a signed JSON envelope. Failures are classified like FCM
+// errors, so they are retried and dead-lettered the same way.
+type Channel struct {
+	Store *Store
+	HTTP  *http.Client
+}
+
+// NewChannel creates a channel whose client never follows redirects, so a
+// redirect only counts as delivered when the subscriber says so. Unless
+// the store has an allowlist the client only connects to public addresses.
+// It never goes through a proxy, which would make the proxy the address
+// that is checked.
+func NewChannel(store *Store) *Channel {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   store.dialControl,
+	}).DialContext
+
+	return &Channel{
+		Store: store,
+		HTTP: &http.Client{
+			Transport:     transport,
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}
+}
+
+func (c *Channel) Send(ctx context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	subscriber, err := c.Store.Get(req.Subscriber)
+	if errors.Is(err, ErrSubscriberNotFound) {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassNoRecipient, Err: err}
+	}
+	if err != nil {
+		return "", err
+	}
+	// Subscribers loaded from the file predate the current policy.
+	if err := c.Store.CheckURL(subscriber.URL); err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidArgument, Err: err}
+	}
+
+	now := time.Now()
+	body, err := json.Marshal(models.WebhookEnvelope{
+		ID:         req.ID,
+		Type:       models.WebhookTypeNotification,
+		Subscriber: subscriber.Name,
+		CreatedAt:  now.UTC(),
+		UserID:     req.UserID,
+		Template:   req.Template,
+		Locale:     req.Locale,
+		Title:      deref(req.Title),
+		Body:       deref(req.Body),
+		Variables:  req.Variables,
+	})
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidPayload, Err: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, subscriber.Timeout())
+	defer cancel()
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, subscriber.URL, bytes.NewReader(body))
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidArgument, Err: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set(HeaderID, req.ID)
+	httpReq.Header.Set(HeaderTimestamp, strconv.FormatInt(now.Unix(), 10))
+	httpReq.Header.Set(HeaderSignature, Sign(subscriber.Secret, now.Unix(), body))
+
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		class := sendNotification.ClassUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			class = sendNotification.ClassTimeout
+		}
+		return "", &sendNotification.DeliveryError{Class: class, Err: fmt.Errorf("webhook %s: %w", subscriber.Name, err)}
+	}
+	defer resp.Body.Close()
+
+	if subscriber.Succeeded(resp.StatusCode) {
+		io.Copy(io.Discard, resp.Body)
+		return req.ID, nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, maxResponseBody))
+	err = fmt.Errorf("webhook %s responded with status %d: %s", subscriber.Name, resp.StatusCode, snippet)
+	return "", sendNotification.ClassifyHTTP(resp.StatusCode, resp.Header, err)
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
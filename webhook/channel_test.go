@@ -0,0 +1,16 @@
+package webhook
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestChannelIgnoresTheEnvironmentProxy(t *testing.T) {
+	t.Setenv("HTTPS_PROXY", "http://proxy.example.com:3128")
+	store, _ := NewStore("")
+
+	// Through a proxy the dialer would only ever see the proxy's address.
+	if NewChannel(store).HTTP.Transport.(*http.Transport).Proxy != nil {
+		t.Fatal("webhook deliveries go through the environment proxy")
+	}
+}
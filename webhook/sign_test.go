@@ -0,0 +1,43 @@
+package webhook
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	// echo -n '1700000000.{"id":"n-1"}' | openssl dgst -sha256 -hmac whsec_test
+	want := "sha256=" + "2ad863fe8c1f786b16143d2d66e8a91950eea3c3b46bfc117045cf9e84f9b8af"
+	if got := Sign("whsec_test", 1700000000, []byte(`{"id":"n-1"}`)); got != want {
+		t.Fatalf("Sign = %s, want %s", got, want)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"n-1"}`)
+	now := time.Now().Unix()
+	timestamp := strconv.FormatInt(now, 10)
+	signature := Sign("secret", now, body)
+
+	if err := Verify("secret", timestamp, signature, body, time.Minute); err != nil {
+		t.Fatalf("valid delivery: %v", err)
+	}
+
+	old := now - 600
+	tests := map[string][]string{
+		"wrong secret":    {"other", timestamp, signature, string(body)},
+		"changed body":    {"secret", timestamp, signature, `{"id":"n-2"}`},
+		"changed time":    {"secret", strconv.FormatInt(now+1, 10), signature, string(body)},
+		"stale":           {"secret", strconv.FormatInt(old, 10), Sign("secret", old, body), string(body)},
+		"missing prefix":  {"secret", timestamp, signature[len(signaturePrefix):], string(body)},
+		"invalid time":    {"secret", "yesterday", signature, string(body)},
+		"empty signature": {"secret", timestamp, "", string(body)},
+	}
+	for name, tt := range tests {
+		if err := Verify(tt[0], tt[1], tt[2], []byte(tt[3]), time.Minute); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: Verify = %v", name, err)
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package webhook
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"push_service/util"
+)
+
+const (
+	DefaultTimeout = 5 * time.Second
+	MaxTimeout     = 30 * time.Second
+	secretPrefix   = "whsec_"
+)
+
+var ErrSubscriberNotFound = errors.New("webhook subscriber not found")
+
+// Subscriber is an internal consumer that receives notifications at URL.
+// The signing secret is only returned when it is created or rotated.
+type Subscriber struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// TimeoutMs bounds each delivery; DefaultTimeout when 0.
+	TimeoutMs int `json:"timeout_ms,omitempty"`
+	// SuccessStatuses are the responses that count as delivered. Any 2xx
+	// when empty.
+	SuccessStatuses []int     `json:"success_statuses,omitempty"`
+	Secret          string    `json:"-"`
+	SecretHint      string    `json:"secret_hint"`
+	CreatedAt       time.Time `json:"created_at"`
+	UpdatedAt       time.Time `json:"updated_at"`
+}
+
+// Timeout is the delivery deadline of the subscriber.
+func (s *Subscriber) Timeout() time.Duration {
+	if s.TimeoutMs <= 0 {
+		return DefaultTimeout
+	}
+	return min(time.Duration(s.TimeoutMs)*time.Millisecond, MaxTimeout)
+}
+
+// Succeeded reports whether a response with code counts as delivered.
+func (s *Subscriber) Succeeded(code int) bool {
+	if len(s.SuccessStatuses) == 0 {
+		return code >= 200 && code < 300
+	}
+	return slices.Contains(s.SuccessStatuses, code)
+}
+
+// stored is the persisted form of a subscriber, which keeps the secret.
+type stored struct {
+	Subscriber
+	Secret string `json:"secret"`
+}
+
+// Store holds the webhook subscribers. When path is set they are persisted
+// to that JSON file on every change.
+type Store struct {
+	mu          sync.RWMutex
+	path        string
+	subscribers map[string]*Subscriber
+	// AllowedHosts, when set, are the only hosts subscribers may use. See
+	// CheckURL.
+	AllowedHosts []string
+}
+
+// NewStoreFromEnv loads subscribers from WEBHOOK_SUBSCRIBERS_FILE, allowing
+// the hosts in WEBHOOK_ALLOWED_HOSTS.
+func NewStoreFromEnv() (*Store, error) {
+	s, err := NewStore(os.Getenv("WEBHOOK_SUBSCRIBERS_FILE"))
+	if err != nil {
+		return nil, err
+	}
+	s.AllowedHosts = AllowedHostsFromEnv()
+	return s, nil
+}
+
+func NewStore(path string) (*Store, error) {
+	s := &Store{path: path, subscribers: make(map[string]*Subscriber)}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Put creates or updates the subscriber called name. A secret is generated
+// for new subscribers and returned; updates keep the existing one and
+// return an empty secret. URLs that CheckURL refuses are never stored.
+func (s *Store) Put(name string, config Subscriber) (Subscriber, string, error) {
+	if err := s.CheckURL(config.URL); err != nil {
+		return Subscriber{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	existing, ok := s.subscribers[name]
+	var secret string
+	if ok {
+		config.Secret, config.SecretHint, config.CreatedAt = existing.Secret, existing.SecretHint, existing.CreatedAt
+	} else {
+		var err error
+		if secret, err = newSecret(); err != nil {
+			return Subscriber{}, "", err
+		}
+		config.Secret, config.SecretHint, config.CreatedAt = secret, hint(secret), now
+	}
+	config.Name = name
+	config.UpdatedAt = now
+
+	s.subscribers[name] = &config
+	if err := s.save(); err != nil {
+		s.restoreLocked(name, existing)
+		return Subscriber{}, "", err
+	}
+	return config, secret, nil
+}
+
+// Rotate replaces the signing secret of name. Deliveries are signed with
+// the new secret from now on.
+func (s *Store) Rotate(name string) (Subscriber, string, error) {
+	secret, err := newSecret()
+	if err != nil {
+		return Subscriber{}, "", err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.subscribers[name]
+	if !ok {
+		return Subscriber{}, "", fmt.Errorf("%w: %s", ErrSubscriberNotFound, name)
+	}
+	subscriber := *existing
+	subscriber.Secret, subscriber.SecretHint = secret, hint(secret)
+	subscriber.UpdatedAt = time.Now()
+	s.subscribers[name] = &subscriber
+	if err := s.save(); err != nil {
+		// Deliveries keep using the secret the caller already has.
+		s.restoreLocked(name, existing)
+		return Subscriber{}, "", err
+	}
+	return subscriber, secret, nil
+}
+
+// Get returns a copy of the subscriber called name, including its secret.
+func (s *Store) Get(name string) (Subscriber, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscriber, ok := s.subscribers[name]
+	if !ok {
+		return Subscriber{}, fmt.Errorf("%w: %s", ErrSubscriberNotFound, name)
+	}
+	return *subscriber, nil
+}
+
+// List returns every subscriber ordered by name.
+func (s *Store) List() []Subscriber {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	subscribers := make([]Subscriber, 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, *subscriber)
+	}
+	slices.SortFunc(subscribers, func(a, b Subscriber) int { return strings.Compare(a.Name, b.Name) })
+	return subscribers
+}
+
+func (s *Store) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	existing, ok := s.subscribers[name]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrSubscriberNotFound, name)
+	}
+	delete(s.subscribers, name)
+	if err := s.save(); err != nil {
+		s.restoreLocked(name, existing)
+		return err
+	}
+	return nil
+}
+
+// restoreLocked puts back the subscriber called name as it was before a
+// change that could not be saved, so memory never gets ahead of the file.
+// A nil subscriber means there was none.
+func (s *Store) restoreLocked(name string, subscriber *Subscriber) {
+	if subscriber == nil {
+		delete(s.subscribers, name)
+		return
+	}
+	s.subscribers[name] = subscriber
+}
+
+func (s *Store) load() error {
+	if s.path == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read webhook subscribers file: %w", err)
+	}
+
+	var subscribers []stored
+	if err := json.Unmarshal(data, &subscribers); err != nil {
+		return fmt.Errorf("failed to parse webhook subscribers file: %w", err)
+	}
+	for _, entry := range subscribers {
+		subscriber := entry.Subscriber
+		subscriber.Secret = entry.Secret
+		s.subscribers[subscriber.Name] = &subscriber
+	}
+	return nil
+}
+
+// save must be called with s.mu held.
+func (s *Store) save() error {
+	if s.path == "" {
+		return nil
+	}
+
+	subscribers := make([]stored, 0, len(s.subscribers))
+	for _, subscriber := range s.subscribers {
+		subscribers = append(subscribers, stored{Subscriber: *subscriber, Secret: subscriber.Secret})
+	}
+
+	data, err := json.MarshalIndent(subscribers, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not encode webhook subscribers: %w", err)
+	}
+	if err := util.WriteFileAtomic(s.path, data, 0o600); err != nil {
+		return fmt.Errorf("could not write webhook subscribers file: %w", err)
+	}
+	return nil
+}
+
+func newSecret() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("could not generate webhook secret: %w", err)
+	}
+	return secretPrefix + hex.EncodeToString(buf), nil
+}
+
+// hint keeps the last characters of a secret so operators can tell them
+// apart.
+func hint(secret string) string {
+	return "..." + secret[len(secret)-4:]
+}
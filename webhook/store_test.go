@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// breakSave makes every later save of the store at path fail, by putting a
+// directory where the file is renamed to.
+func breakSave(t *testing.T, path string) {
+	t.Helper()
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(path, 0o700); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoreRollsBackChangesThatCouldNotBeSaved(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	store, err := NewStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	created, secret, err := store.Put("orders", Subscriber{URL: "https://hooks.example.com/orders"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	breakSave(t, path)
+
+	if _, _, err := store.Rotate("orders"); err == nil {
+		t.Fatal("Rotate succeeded without saving")
+	}
+	if current, _ := store.Get("orders"); current.Secret != secret {
+		t.Fatal("unsaved rotation left a secret the caller never saw")
+	}
+
+	if _, _, err := store.Put("orders", Subscriber{URL: "https://hooks.example.com/v2"}); err == nil {
+		t.Fatal("update succeeded without saving")
+	}
+	if current, _ := store.Get("orders"); current.URL != created.URL {
+		t.Fatalf("unsaved update changed the URL to %s", current.URL)
+	}
+
+	if _, _, err := store.Put("billing", Subscriber{URL: "https://hooks.example.com/billing"}); err == nil {
+		t.Fatal("create succeeded without saving")
+	}
+	if _, err := store.Get("billing"); !errors.Is(err, ErrSubscriberNotFound) {
+		t.Fatalf("unsaved subscriber is kept: %v", err)
+	}
+
+	if err := store.Delete("orders"); err == nil {
+		t.Fatal("Delete succeeded without saving")
+	}
+	if _, err := store.Get("orders"); err != nil {
+		t.Fatalf("unsaved delete removed the subscriber: %v", err)
+	}
+}
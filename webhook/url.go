@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"syscall"
+
+	"push_service/util"
+)
+
+var ErrURLNotAllowed = errors.New("webhook url not allowed")
+
+// AllowedHostsFromEnv reads WEBHOOK_ALLOWED_HOSTS, a comma separated list of
+// host names that subscribers may point at.
+func AllowedHostsFromEnv() []string {
+	var hosts []string
+	for _, host := range strings.Split(os.Getenv("WEBHOOK_ALLOWED_HOSTS"), ",") {
+		host = strings.ToLower(strings.TrimSpace(host))
+		if host == "" {
+			continue
+		}
+		if strings.ContainsAny(host, "/:@") {
+			log.Printf("Ignoring invalid WEBHOOK_ALLOWED_HOSTS entry %q", host)
+			continue
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts
+}
+
+// CheckURL reports whether notifications may be POSTed to raw. Without an
+// allowlist only https URLs on public addresses are accepted, so a
+// subscriber cannot be pointed at the service's own network. With one, the
+// host must be on it and may then use plain http.
+func (s *Store) CheckURL(raw string) error {
+	target, err := url.Parse(raw)
+	if err != nil || (target.Scheme != "https" && target.Scheme != "http") || target.Hostname() == "" {
+		return fmt.Errorf("%w: %q must be an absolute http or https URL", ErrURLNotAllowed, raw)
+	}
+	if target.User != nil {
+		return fmt.Errorf("%w: %q must not contain credentials", ErrURLNotAllowed, raw)
+	}
+
+	host := strings.ToLower(target.Hostname())
+	if len(s.AllowedHosts) > 0 {
+		if !slices.Contains(s.AllowedHosts, host) {
+			return fmt.Errorf("%w: %s is not in WEBHOOK_ALLOWED_HOSTS", ErrURLNotAllowed, host)
+		}
+		return nil
+	}
+	if target.Scheme != "https" {
+		return fmt.Errorf("%w: %q must use https", ErrURLNotAllowed, raw)
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !util.PublicAddr(addr) {
+		return fmt.Errorf("%w: %s is not a public address", ErrURLNotAllowed, host)
+	}
+	return nil
+}
+
+// dialControl refuses connections to non-public addresses unless there is
+// an allowlist, which catches host names that resolve to internal ones.
+func (s *Store) dialControl(network, address string, conn syscall.RawConn) error {
+	if len(s.AllowedHosts) > 0 {
+		return nil
+	}
+	if err := util.DialPublic(network, address, conn); err != nil {
+		return fmt.Errorf("%w: %v", ErrURLNotAllowed, err)
+	}
+	return nil
+}
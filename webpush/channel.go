@@ -0,0 +1,242 @@
+package webpush
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	"push_service/devices"
+	"push_service/models"
+	"push_service/payload"
+	"push_service/platform"
+	sendNotification "push_service/sendNotification"
+	"push_service/truncate"
+)
+
+const (
+	DefaultTimeout = 10 * time.Second
+	// DefaultTTL is how long push services keep a message for an offline
+	// browser when the request sets no TTL.
+	DefaultTTL = 24 * time.Hour
+)
+
+// topicPattern is what push services accept in the Topic header.
+var topicPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// urgency translates a priority into the Urgency header of RFC 8030, which
+// takes very-low, low, normal or high. Unknown priorities get normal, the
+// header's own default.
+func urgency(priority string) string {
+	switch priority {
+	case platform.PriorityHigh:
+		return "high"
+	default:
+		return "normal"
+	}
+}
+
+// Channel delivers notifications to every browser subscription of the user
+// in the device registry. Subscriptions the push service reports gone are
+// removed.
+type Channel struct {
+	Devices  *devices.Store
+	VAPID    *VAPID
+	HTTP     *http.Client
+	Limit    truncate.Limit
+	DataMode payload.NestedMode
+}
+
+// NewChannelFromEnv reads VAPID_PRIVATE_KEY and VAPID_SUBJECT. It returns
+// nil when VAPID_PRIVATE_KEY is not set, which leaves the channel disabled.
+func NewChannelFromEnv(registry *devices.Store) (*Channel, error) {
+	privateKey := os.Getenv("VAPID_PRIVATE_KEY")
+	if privateKey == "" {
+		return nil, nil
+	}
+	vapid, err := NewVAPID(privateKey, os.Getenv("VAPID_SUBJECT"))
+	if err != nil {
+		return nil, err
+	}
+	return &Channel{
+		Devices: registry,
+		VAPID:   vapid,
+		HTTP:    NewHTTPClient(registry),
+	}, nil
+}
+
+// NewHTTPClient returns a client that only connects to the addresses the
+// registry allows, directly rather than through a proxy, which would make
+// the proxy the address that is checked.
+func NewHTTPClient(registry *devices.Store) *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = (&net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   registry.DialControl,
+	}).DialContext
+	return &http.Client{Timeout: DefaultTimeout, Transport: transport}
+}
+
+// Send pushes to each subscription. It succeeds when any browser accepted
+// the message; otherwise a transient failure wins over permanent ones so
+// the remaining subscriptions are retried.
+func (c *Channel) Send(ctx context.Context, req models.NotifMessageRequest, _ *models.User) (string, error) {
+	subscriptions := c.Devices.List(req.UserID, devices.PlatformWebPush)
+	if len(subscriptions) == 0 {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassNoToken, Err: errors.New("user has no web push subscriptions")}
+	}
+
+	body, opts, err := c.payload(req)
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidPayload, Err: err}
+	}
+
+	var messageID string
+	var permanent, transient error
+	for _, subscription := range subscriptions {
+		location, err := c.push(ctx, subscription, body, opts)
+		switch {
+		case err == nil:
+			if messageID == "" {
+				messageID = location
+			}
+		case sendNotification.IsPermanent(err):
+			permanent = err
+		default:
+			transient = err
+		}
+	}
+
+	if messageID != "" {
+		if transient != nil {
+			log.Printf("Notification %s reached some browsers but not all: %v", req.ID, transient)
+		}
+		return messageID, nil
+	}
+	if transient != nil {
+		return "", transient
+	}
+	return "", permanent
+}
+
+// push encrypts body for one subscription and posts it to its push
+// service. It returns the Location of the created message.
+func (c *Channel) push(ctx context.Context, subscription devices.Device, body []byte, opts platform.Options) (string, error) {
+	// Subscriptions loaded from the file predate the current policy.
+	if err := c.Devices.CheckEndpoint(subscription.Endpoint); err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidArgument, Err: err}
+	}
+	encrypted, err := Encrypt(body, subscription.Keys.P256dh, subscription.Keys.Auth)
+	if err != nil {
+		// The browser registered keys we cannot use, so it can never
+		// receive anything.
+		c.expire(subscription, err)
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassUnregistered, Err: err}
+	}
+	authorization, err := c.VAPID.Authorization(subscription.Endpoint)
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidArgument, Err: err}
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.Endpoint, bytes.NewReader(encrypted))
+	if err != nil {
+		return "", &sendNotification.DeliveryError{Class: sendNotification.ClassInvalidArgument, Err: err}
+	}
+	ttl := DefaultTTL
+	if opts.TTL != nil {
+		ttl = *opts.TTL
+	}
+	httpReq.Header.Set("Authorization", authorization)
+	httpReq.Header.Set("Content-Encoding", "aes128gcm")
+	httpReq.Header.Set("Content-Type", "application/octet-stream")
+	httpReq.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	httpReq.Header.Set("Urgency", urgency(opts.Priority))
+	if topicPattern.MatchString(opts.CollapseKey) {
+		httpReq.Header.Set("Topic", opts.CollapseKey)
+	}
+
+	resp, err := c.HTTP.Do(httpReq)
+	if err != nil {
+		class := sendNotification.ClassUnavailable
+		if errors.Is(err, context.DeadlineExceeded) {
+			class = sendNotification.ClassTimeout
+		}
+		return "", &sendNotification.DeliveryError{Class: class, Err: fmt.Errorf("web push: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		io.Copy(io.Discard, resp.Body)
+		return resp.Header.Get("Location"), nil
+	}
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("push service responded with status %d: %s", resp.StatusCode, snippet)
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone {
+		c.expire(subscription, err)
+	}
+	return "", sendNotification.ClassifyHTTP(resp.StatusCode, resp.Header, err)
+}
+
+func (c *Channel) expire(subscription devices.Device, reason error) {
+	log.Printf("Removing web push subscription %s of user %s: %v", subscription.ID, subscription.UserID, reason)
+	if err := c.Devices.Expire(subscription.ID); err != nil {
+		log.Printf("Failed to remove web push subscription %s: %v", subscription.ID, err)
+	}
+}
+
+// payload builds the JSON the service worker receives, shortening the body
+// when it would not fit in one encrypted record.
+func (c *Channel) payload(req models.NotifMessageRequest) ([]byte, platform.Options, error) {
+	opts := platform.Resolve(req, nil)
+
+	data, err := payload.Encode(req.Variables, c.DataMode)
+	if err != nil {
+		return nil, opts, err
+	}
+	message := models.WebPushPayload{
+		ID:    req.ID,
+		Image: opts.ImageURL,
+		Link:  opts.Link,
+		Tag:   opts.CollapseKey,
+		Badge: opts.Badge,
+		Data:  data,
+	}
+	if req.Kind != models.KindData {
+		message.Title, _ = truncate.Text(deref(req.Title), c.Limit.Title)
+		message.Body, _ = truncate.Text(deref(req.Body), c.Limit.Body)
+	}
+
+	encoded, err := json.Marshal(message)
+	if err != nil {
+		return nil, opts, err
+	}
+	if over := len(encoded) - MaxPlaintext; over > 0 && over < len(message.Body) {
+		message.Body, _ = truncate.Bytes(message.Body, len(message.Body)-over-len(truncate.Ellipsis))
+		if encoded, err = json.Marshal(message); err != nil {
+			return nil, opts, err
+		}
+	}
+	if len(encoded) > MaxPlaintext {
+		return nil, opts, fmt.Errorf("web push payload is %d bytes, the limit is %d", len(encoded), MaxPlaintext)
+	}
+	return encoded, opts, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,20 @@
+package webpush
+
+import (
+	"testing"
+
+	"push_service/platform"
+)
+
+func TestUrgencyIsAlwaysAValidHeaderValue(t *testing.T) {
+	for priority, want := range map[string]string{
+		platform.PriorityHigh:   "high",
+		platform.PriorityNormal: "normal",
+		"":                      "normal",
+		"urgent":                "normal",
+	} {
+		if got := urgency(priority); got != want {
+			t.Errorf("urgency(%q) = %q, want %q", priority, got, want)
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+const (
+	// recordSize is the aes128gcm record size. Payloads are sent as a
+	// single record.
+	recordSize = 4096
+	saltSize   = 16
+	keySize    = 65 // uncompressed P-256 point
+	authSize   = 16
+	tagSize    = 16
+	headerSize = saltSize + 4 + 1 + keySize
+
+	// MaxPlaintext is the largest payload that fits in the 4096 bytes every
+	// push service must accept.
+	MaxPlaintext = recordSize - headerSize - tagSize - 1
+)
+
+// DecodeKey decodes a base64url value from a subscription or VAPID key,
+// with or without padding.
+func DecodeKey(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
+
+func encodeKey(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// Encrypt encrypts plaintext for the subscription with public key p256dh
+// and secret auth, as RFC 8291 describes, in the aes128gcm encoding of
+// RFC 8188.
+func Encrypt(plaintext []byte, p256dh, auth string) ([]byte, error) {
+	if len(plaintext) > MaxPlaintext {
+		return nil, fmt.Errorf("web push payload is %d bytes, the limit is %d", len(plaintext), MaxPlaintext)
+	}
+	uaPublicBytes, err := DecodeKey(p256dh)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid p256dh key: %w", err)
+	}
+	authSecret, err := DecodeKey(auth)
+	if err != nil || len(authSecret) != authSize {
+		return nil, errors.New("invalid auth secret")
+	}
+
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	salt := make([]byte, saltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	shared, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+	asPublic := asPrivate.PublicKey().Bytes()
+	gcm, nonce, err := contentKeys(shared, authSecret, salt, uaPublicBytes, asPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	// A single, and therefore last, record: the content is followed by the
+	// 0x02 delimiter and no padding.
+	record := append(append([]byte(nil), plaintext...), 2)
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, keySize)
+	header = append(header, asPublic...)
+	return gcm.Seal(header, nonce, record, nil), nil
+}
+
+// Decrypt reverses Encrypt for the holder of the subscription's private
+// key, as a browser does.
+func Decrypt(body []byte, uaPrivate *ecdh.PrivateKey, authSecret []byte) ([]byte, error) {
+	if len(body) < headerSize+tagSize+1 {
+		return nil, errors.New("web push body is too short")
+	}
+	salt := body[:saltSize]
+	if idLen := body[saltSize+4]; idLen != keySize {
+		return nil, fmt.Errorf("unexpected key ID length %d", idLen)
+	}
+	asPublicBytes := body[saltSize+5 : headerSize]
+	asPublic, err := ecdh.P256().NewPublicKey(asPublicBytes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sender key: %w", err)
+	}
+
+	shared, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		return nil, err
+	}
+	gcm, nonce, err := contentKeys(shared, authSecret, salt, uaPrivate.PublicKey().Bytes(), asPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	record, err := gcm.Open(nil, nonce, body[headerSize:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt web push body: %w", err)
+	}
+
+	record = bytes.TrimRight(record, "\x00")
+	if len(record) == 0 || record[len(record)-1] != 2 {
+		return nil, errors.New("web push body is not a single final record")
+	}
+	return record[:len(record)-1], nil
+}
+
+// contentKeys derives the content encryption key and nonce from the ECDH
+// shared secret (RFC 8291 section 3.4 and RFC 8188 section 2.2).
+func contentKeys(shared, authSecret, salt, uaPublic, asPublic []byte) (cipher.AEAD, []byte, error) {
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublic...)
+	keyInfo = append(keyInfo, asPublic...)
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, string(keyInfo), 32)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	prk, err := hkdf.Extract(sha256.New, ikm, salt)
+	if err != nil {
+		return nil, nil, err
+	}
+	cek, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce, err := hkdf.Expand(sha256.New, prk, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return gcm, nonce, nil
+}
@@ -0,0 +1,77 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/rand"
+	"testing"
+)
+
+// TestDecryptRFC8291 decrypts the example message of RFC 8291 appendix A,
+// which pins the key derivation Encrypt shares with Decrypt.
+func TestDecryptRFC8291(t *testing.T) {
+	body := mustDecode(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+	uaPrivate, err := ecdh.P256().NewPrivateKey(mustDecode(t, "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	plaintext, err := Decrypt(body, uaPrivate, mustDecode(t, "BTBZMqHH6r4Tts7J_aSIgg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "When I grow up, I want to be a watermelon"; string(plaintext) != want {
+		t.Fatalf("Decrypt = %q, want %q", plaintext, want)
+	}
+}
+
+func TestEncryptRoundTrip(t *testing.T) {
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, authSize)
+	rand.Read(authSecret)
+	p256dh, auth := encodeKey(uaPrivate.PublicKey().Bytes()), encodeKey(authSecret)
+
+	plaintext := bytes.Repeat([]byte("x"), MaxPlaintext)
+	body, err := Encrypt(plaintext, p256dh, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(body) > recordSize {
+		t.Fatalf("largest payload encrypts to %d bytes, over %d", len(body), recordSize)
+	}
+	decrypted, err := Decrypt(body, uaPrivate, authSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(decrypted, plaintext) {
+		t.Fatal("round trip changed the payload")
+	}
+
+	// The salt and sender key are fresh each time.
+	again, _ := Encrypt(plaintext, p256dh, auth)
+	if bytes.Equal(body[:headerSize], again[:headerSize]) {
+		t.Fatal("two encryptions share a salt and sender key")
+	}
+
+	if _, err := Encrypt(append(plaintext, 'x'), p256dh, auth); err == nil {
+		t.Fatal("oversized payload was encrypted")
+	}
+	if _, err := Encrypt(plaintext, p256dh, encodeKey(authSecret[:8])); err == nil {
+		t.Fatal("short auth secret was accepted")
+	}
+	if _, err := Encrypt(plaintext, "not-a-key", auth); err == nil {
+		t.Fatal("invalid p256dh key was accepted")
+	}
+}
+
+func mustDecode(t *testing.T, value string) []byte {
+	t.Helper()
+	decoded, err := DecodeKey(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decoded
+}
@@ -0,0 +1,118 @@
+package webpush
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+const (
+	// vapidLifetime is how long VAPID tokens are valid. Push services
+	// reject tokens that expire more than 24 hours ahead.
+	vapidLifetime = 12 * time.Hour
+	// vapidRefresh is how long before expiry a token is replaced.
+	vapidRefresh = time.Hour
+)
+
+// VAPID identifies the application server to push services (RFC 8292).
+// Browsers subscribe with PublicKey as their applicationServerKey.
+type VAPID struct {
+	// Subject is a mailto: or https: contact for the push service operator.
+	Subject string
+
+	key    *ecdsa.PrivateKey
+	public []byte
+
+	mu     sync.Mutex
+	tokens map[string]vapidToken // by audience
+}
+
+type vapidToken struct {
+	token   string
+	expires time.Time
+}
+
+// NewVAPID loads the base64url encoded private key, as web push libraries
+// generate it.
+func NewVAPID(privateKey, subject string) (*VAPID, error) {
+	raw, err := DecodeKey(privateKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	key, err := ecdh.P256().NewPrivateKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid VAPID private key: %w", err)
+	}
+	if subject == "" {
+		return nil, errors.New("VAPID needs a mailto: or https: subject")
+	}
+
+	// The uncompressed point is 0x04 || X || Y.
+	public := key.PublicKey().Bytes()
+	return &VAPID{
+		Subject: subject,
+		key: &ecdsa.PrivateKey{
+			PublicKey: ecdsa.PublicKey{
+				Curve: elliptic.P256(),
+				X:     new(big.Int).SetBytes(public[1:33]),
+				Y:     new(big.Int).SetBytes(public[33:]),
+			},
+			D: new(big.Int).SetBytes(raw),
+		},
+		public: public,
+		tokens: make(map[string]vapidToken),
+	}, nil
+}
+
+// GenerateVAPIDKey returns a new base64url encoded key pair.
+func GenerateVAPIDKey() (privateKey, publicKey string, err error) {
+	key, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return encodeKey(key.Bytes()), encodeKey(key.PublicKey().Bytes()), nil
+}
+
+// PublicKey is the base64url encoded public key.
+func (v *VAPID) PublicKey() string {
+	return encodeKey(v.public)
+}
+
+// Authorization returns the Authorization header for a push to endpoint.
+// Tokens are scoped to the origin of the push service and reused until
+// shortly before they expire.
+func (v *VAPID) Authorization(endpoint string) (string, error) {
+	target, err := url.Parse(endpoint)
+	if err != nil || target.Host == "" {
+		return "", fmt.Errorf("invalid push endpoint %q", endpoint)
+	}
+	audience := target.Scheme + "://" + target.Host
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	cached, ok := v.tokens[audience]
+	if !ok || time.Until(cached.expires) < vapidRefresh {
+		expires := time.Now().Add(vapidLifetime)
+		token, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"aud": audience,
+			"exp": expires.Unix(),
+			"sub": v.Subject,
+		}).SignedString(v.key)
+		if err != nil {
+			return "", fmt.Errorf("could not sign VAPID token: %w", err)
+		}
+		cached = vapidToken{token: token, expires: expires}
+		v.tokens[audience] = cached
+	}
+	return "vapid t=" + cached.token + ", k=" + v.PublicKey(), nil
+}